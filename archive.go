@@ -0,0 +1,247 @@
+package xplatai
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extracts a .zip or .tar.gz archive into dest, refusing entries that would
+// escape it, also through symlinks extracted earlier. Returns the slash
+// separated paths of the extracted files.
+func extractArchive(archivePath string, dest string) ([]string, error) {
+	err := os.MkdirAll(longPath(dest), 0755)
+	if err != nil {
+		return nil, err
+	}
+	// Entries are checked against where they really land
+	dest, err = filepath.EvalSymlinks(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"):
+		files, err = extractZip(archivePath, dest)
+	case strings.HasSuffix(archivePath, ".tar.gz"), strings.HasSuffix(archivePath, ".tgz"):
+		files, err = extractTarGz(archivePath, dest)
+	default:
+		err = errors.New("unsupported archive format")
+	}
+	if err != nil {
+		return files, fmt.Errorf("extracting %s: %w", archivePath, err)
+	}
+	return files, nil
+}
+
+// Resolves an archive entry name inside dest, following the symlinks already
+// extracted in the directories leading to it. dest must have no symlinks.
+func safeJoin(dest string, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("archive entry has an absolute path: %s", name)
+	}
+
+	fPath := filepath.Join(dest, filepath.FromSlash(name))
+	if !isWithin(dest, fPath) {
+		return "", fmt.Errorf("archive entry escapes destination: %s", name)
+	}
+	if fPath == dest {
+		return fPath, nil
+	}
+
+	parent, err := resolveExisting(filepath.Dir(fPath))
+	if err != nil {
+		return "", err
+	}
+	fPath = filepath.Join(parent, filepath.Base(fPath))
+	if !isWithin(dest, fPath) {
+		return "", fmt.Errorf("archive entry escapes destination through a symlink: %s", name)
+	}
+	return fPath, nil
+}
+
+func isWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Resolves the symlinks in the part of path that exists, the directories not
+// created yet are kept as is.
+func resolveExisting(path string) (string, error) {
+	missing := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(path) == path {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(path), missing)
+		path = filepath.Dir(path)
+	}
+}
+
+// Only keeps the executable bit from the archive, never setuid or world-writable.
+func safeFileMode(mode os.FileMode) os.FileMode {
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+// fPath comes from safeJoin, so its directory has no symlinks and the leading
+// ".." of target go up real directories. A ".." after a name could go up from
+// wherever a symlink points, so it is refused.
+func extractSymlink(dest string, fPath string, target string) error {
+	escapes := filepath.IsAbs(target) || strings.HasPrefix(target, "/") || strings.HasPrefix(target, "\\")
+	named := false
+	for _, elem := range strings.FieldsFunc(target, func(r rune) bool { return r == '/' || r == '\\' }) {
+		switch {
+		case elem == ".." && named:
+			escapes = true
+		case elem != "." && elem != "..":
+			named = true
+		}
+	}
+	if escapes || !isWithin(dest, filepath.Join(filepath.Dir(fPath), filepath.FromSlash(target))) {
+		return fmt.Errorf("archive symlink escapes destination: %s -> %s", fPath, target)
+	}
+
+	err := os.MkdirAll(longPath(filepath.Dir(fPath)), 0755)
+	if err != nil {
+		return err
+	}
+	os.Remove(longPath(fPath))
+	return os.Symlink(target, longPath(fPath))
+}
+
+func extractFile(fPath string, mode os.FileMode, src io.Reader) error {
+	err := os.MkdirAll(longPath(filepath.Dir(fPath)), 0755)
+	if err != nil {
+		return err
+	}
+	// Replace a symlink of the same name rather than write through it
+	if info, err := os.Lstat(longPath(fPath)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(longPath(fPath))
+	}
+
+	dstFile, err := os.OpenFile(longPath(fPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, safeFileMode(mode))
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	_, err = io.Copy(dstFile, src)
+	if err != nil {
+		return err
+	}
+	return dstFile.Close()
+}
+
+func extractZip(archivePath string, dest string) ([]string, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	files := []string{}
+	for _, f := range archive.File {
+		fPath, err := safeJoin(dest, f.Name)
+		if err != nil {
+			return files, err
+		}
+
+		if f.FileInfo().IsDir() {
+			err = os.MkdirAll(longPath(fPath), 0755)
+			if err != nil {
+				return files, err
+			}
+			continue
+		}
+
+		fileInArchive, err := f.Open()
+		if err != nil {
+			return files, err
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := io.ReadAll(fileInArchive)
+			fileInArchive.Close()
+			if err != nil {
+				return files, err
+			}
+			err = extractSymlink(dest, fPath, string(target))
+			if err != nil {
+				return files, err
+			}
+			continue
+		}
+
+		err = extractFile(fPath, f.Mode(), fileInArchive)
+		fileInArchive.Close()
+		if err != nil {
+			return files, err
+		}
+		files = append(files, strings.ReplaceAll(f.Name, "\\", "/"))
+	}
+	return files, nil
+}
+
+func extractTarGz(archivePath string, dest string) ([]string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	files := []string{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return files, err
+		}
+
+		fPath, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return files, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(longPath(fPath), 0755)
+		case tar.TypeReg:
+			err = extractFile(fPath, hdr.FileInfo().Mode(), tr)
+			files = append(files, hdr.Name)
+		case tar.TypeSymlink:
+			err = extractSymlink(dest, fPath, hdr.Linkname)
+		default:
+			// Hard links, devices and the like have no place in a release archive
+			continue
+		}
+		if err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
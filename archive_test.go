@@ -0,0 +1,253 @@
+package xplatai
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name string
+	body string
+	mode os.FileMode
+	// Symlink target, empty for regular files
+	link string
+	dir  bool
+}
+
+// Release-like archive with a binary, a library symlinked to it and a
+// directory entry.
+var releaseEntries = []archiveEntry{
+	{name: "build/", dir: true},
+	{name: "build/bin/llama-server", body: "server", mode: 0755},
+	{name: "build/bin/libllama.so.1", body: "lib", mode: 0644},
+	{name: "build/bin/libllama.so", link: "libllama.so.1"},
+	{name: "build/LICENSE", body: "MIT", mode: 0666},
+}
+
+func writeZipFixture(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch {
+		case e.dir:
+			hdr.SetMode(os.ModeDir | 0755)
+		case e.link != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		default:
+			hdr.SetMode(e.mode)
+		}
+		fw, err := w.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(body))
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGzFixture(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: int64(e.mode), Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		t.Run(ext, func(t *testing.T) {
+			if runtime.GOOS == "windows" {
+				t.Skip("symlinks need developer mode on Windows")
+			}
+			dir := t.TempDir()
+			archivePath := filepath.Join(dir, "release"+ext)
+			if ext == ".zip" {
+				writeZipFixture(t, archivePath, releaseEntries)
+			} else {
+				writeTarGzFixture(t, archivePath, releaseEntries)
+			}
+
+			dest := filepath.Join(dir, "out")
+			files, err := extractArchive(archivePath, dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"build/bin/llama-server", "build/bin/libllama.so.1", "build/LICENSE"}
+			if !slices.Equal(files, want) {
+				t.Errorf("extracted %v, want %v", files, want)
+			}
+
+			b, err := os.ReadFile(filepath.Join(dest, "build", "bin", "libllama.so"))
+			if err != nil || string(b) != "lib" {
+				t.Errorf("symlink reads %q, %v", b, err)
+			}
+			info, err := os.Stat(filepath.Join(dest, "build", "bin", "llama-server"))
+			if err != nil || info.Mode().Perm() != 0755 {
+				t.Errorf("server extracted as %v, %v", info.Mode(), err)
+			}
+			// World-writable bits never survive
+			info, err = os.Stat(filepath.Join(dest, "build", "LICENSE"))
+			if err != nil || info.Mode().Perm()&0022 != 0 {
+				t.Errorf("license extracted as %v, %v", info.Mode(), err)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRefusesEscapes(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry archiveEntry
+	}{
+		{"parent dir", archiveEntry{name: "../evil", body: "x", mode: 0644}},
+		{"nested parent dir", archiveEntry{name: "build/../../evil", body: "x", mode: 0644}},
+		{"absolute path", archiveEntry{name: "/tmp/evil", body: "x", mode: 0644}},
+		{"backslashes", archiveEntry{name: "..\\evil", body: "x", mode: 0644}},
+		{"symlink out", archiveEntry{name: "build/link", link: "../../etc/passwd"}},
+		{"absolute symlink", archiveEntry{name: "build/link", link: "/etc/passwd"}},
+	}
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		for _, tt := range tests {
+			t.Run(ext+" "+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				archivePath := filepath.Join(dir, "release"+ext)
+				if ext == ".zip" {
+					writeZipFixture(t, archivePath, []archiveEntry{tt.entry})
+				} else {
+					writeTarGzFixture(t, archivePath, []archiveEntry{tt.entry})
+				}
+
+				dest := filepath.Join(dir, "out")
+				_, err := extractArchive(archivePath, dest)
+				if err == nil || !strings.Contains(err.Error(), "escapes") && !strings.Contains(err.Error(), "absolute path") {
+					t.Fatalf("got %v, want the entry refused", err)
+				}
+				if _, err := os.Lstat(filepath.Join(dir, "evil")); err == nil {
+					t.Error("entry written outside the destination")
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveRejectsUnknownFormat(t *testing.T) {
+	_, err := extractArchive(filepath.Join(t.TempDir(), "release.rar"), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "unsupported archive format") {
+		t.Errorf("got %v, want unsupported archive format", err)
+	}
+}
+
+// Each symlink on its own stays inside, chained they would lead out.
+func TestExtractArchiveRefusesEscapesThroughSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need developer mode on Windows")
+	}
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+	}{
+		{"link in linked dir", []archiveEntry{
+			{name: "s", link: "."},
+			{name: "s/l", link: ".."},
+			{name: "s/l/evil", body: "x", mode: 0644},
+		}},
+		{"parent of a link", []archiveEntry{
+			{name: "s", link: "."},
+			{name: "l", link: "s/.."},
+			{name: "l/evil", body: "x", mode: 0644},
+		}},
+		{"file in dir linked out", []archiveEntry{
+			{name: "build/bin/", dir: true},
+			{name: "build/s", link: "bin/.."},
+			{name: "build/s/../../evil", body: "x", mode: 0644},
+		}},
+	}
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		for _, tt := range tests {
+			t.Run(ext+" "+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				archivePath := filepath.Join(dir, "release"+ext)
+				if ext == ".zip" {
+					writeZipFixture(t, archivePath, tt.entries)
+				} else {
+					writeTarGzFixture(t, archivePath, tt.entries)
+				}
+
+				_, err := extractArchive(archivePath, filepath.Join(dir, "out"))
+				if err == nil || !strings.Contains(err.Error(), "escapes") {
+					t.Fatalf("got %v, want the entry refused", err)
+				}
+				if _, err := os.Lstat(filepath.Join(dir, "evil")); err == nil {
+					t.Error("entry written outside the destination")
+				}
+			})
+		}
+	}
+}
+
+// Symlinks pointing up real directories, as some releases ship, still work.
+func TestExtractArchiveKeepsSymlinksUpRealDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need developer mode on Windows")
+	}
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "release.tar.gz")
+	writeTarGzFixture(t, archivePath, []archiveEntry{
+		{name: "lib/libllama.so.1", body: "lib", mode: 0644},
+		{name: "bin/libllama.so", link: "../lib/libllama.so.1"},
+	})
+
+	dest := filepath.Join(dir, "out")
+	_, err := extractArchive(archivePath, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dest, "bin", "libllama.so"))
+	if err != nil || string(b) != "lib" {
+		t.Errorf("symlink reads %q, %v", b, err)
+	}
+}
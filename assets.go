@@ -0,0 +1,88 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var archiveExts = []string{".zip", ".tar.gz"}
+
+// Finds the download url of the release asset named assetName plus one of the
+// supported archive extensions. Asks the GitHub API first, then probes the
+// release download urls directly.
+func resolveAssetUrl(ctx context.Context, version string, assetName string) (string, error) {
+	url, err := findReleaseAsset(ctx, version, assetName)
+	if err == nil {
+		return url, nil
+	}
+
+	base := "https://github.com/ggml-org/llama.cpp/releases/download/" + version + "/" + assetName
+	client := http.Client{}
+
+	for _, ext := range archiveExts {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", base+ext, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 400 {
+			return base + ext, nil
+		}
+	}
+	return "", errors.New("could not find release asset " + assetName + " for llama.cpp " + version)
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	Url  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+	// "sha256:<hex>", only reported for recent uploads
+	Digest string `json:"digest"`
+}
+
+func fetchReleaseAssets(ctx context.Context, version string) ([]releaseAsset, error) {
+	release := struct {
+		Assets []releaseAsset `json:"assets"`
+	}{}
+
+	err := githubGet(ctx, "/repos/ggml-org/llama.cpp/releases/tags/"+version, &release)
+	if err != nil {
+		return nil, err
+	}
+	return release.Assets, nil
+}
+
+func findReleaseAsset(ctx context.Context, version string, assetName string) (string, error) {
+	assets, err := fetchReleaseAssets(ctx, version)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ext := range archiveExts {
+		for _, asset := range assets {
+			if asset.Name == assetName+ext {
+				return asset.Url, nil
+			}
+		}
+	}
+	return "", errors.New("release has no asset named " + assetName)
+}
+
+func archiveExt(url string) string {
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(url, ext) {
+			return ext
+		}
+	}
+	return ".zip"
+}
@@ -0,0 +1,122 @@
+package xplatai
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Outcome of one prompt of a batch.
+type CompleteResult struct {
+	Response CompleteResponse
+	Err      error
+}
+
+// Aggregate figures of a batch so far.
+type BatchStats struct {
+	Elapsed time.Duration
+	// Prompts that failed
+	Failed int
+	// Tokens generated across all prompts
+	CompletionTokens int
+	// CompletionTokens per second of wall time
+	TokensPerSec float64
+}
+
+// Slots of the server, from Config.ParallelSlots or else asked to the server.
+// 1 when the server doesn't tell.
+func (x *XpltAI) slotCount(ctx context.Context) int {
+	if x.parallelSlots > 0 {
+		return x.parallelSlots
+	}
+
+	props := struct {
+		TotalSlots int `json:"total_slots"`
+	}{}
+	err := x.doJSON(ctx, "GET", "/props", nil, &props)
+	if err != nil {
+		return 1
+	}
+	return max(props.TotalSlots, 1)
+}
+
+// Completes independent prompts, at most concurrency at a time, and returns
+// their results in input order. A failing prompt only sets its own Err, the
+// returned error is set when the server never became ready or ctx ended.
+// concurrency <= 0 runs as many prompts as the server has slots.
+func (x *XpltAI) CompleteBatch(ctx context.Context, prompts []string, opts GenOptions, concurrency int) ([]CompleteResult, error) {
+	return x.CompleteBatchWithProgress(ctx, prompts, opts, concurrency, nil)
+}
+
+// CompleteBatch calling progress after each prompt finished, with the
+// aggregate stats so far.
+func (x *XpltAI) CompleteBatchWithProgress(ctx context.Context, prompts []string, opts GenOptions, concurrency int, progress func(done int, total int, stats BatchStats)) ([]CompleteResult, error) {
+	results := make([]CompleteResult, len(prompts))
+	if progress == nil {
+		progress = func(int, int, BatchStats) {}
+	}
+
+	// Every prompt waits on the same readiness check instead of each probing
+	// the server on its own
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results, err
+	}
+
+	if concurrency <= 0 {
+		concurrency = x.slotCount(ctx)
+	}
+
+	start := time.Now()
+	stats := BatchStats{}
+	done := 0
+	var mu sync.Mutex
+
+	next := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for range min(concurrency, len(prompts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				resp, err := x.complete(ctx, prompts[i], opts)
+				results[i] = CompleteResult{Response: resp, Err: err}
+
+				mu.Lock()
+				done++
+				if err != nil {
+					stats.Failed++
+				}
+				stats.CompletionTokens += resp.CompletionTokens
+				stats.Elapsed = time.Since(start)
+				stats.TokensPerSec = float64(stats.CompletionTokens) / stats.Elapsed.Seconds()
+				progress(done, len(prompts), stats)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	queued := 0
+feed:
+	for ; queued < len(prompts); queued++ {
+		select {
+		case next <- queued:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if queued < len(prompts) {
+		for i := queued; i < len(prompts); i++ {
+			results[i].Err = ctx.Err()
+		}
+		return results, ctx.Err()
+	}
+	return results, nil
+}
@@ -0,0 +1,203 @@
+package xplatai
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tiny model used when BenchOptions.ModelPath is empty, enough to measure the
+// hardware without a large download.
+const BENCH_MODEL_URL = "https://huggingface.co/ggml-org/models/resolve/main/tinyllamas/stories15M-q4_0.gguf"
+
+type BenchOptions struct {
+	// Local .gguf file to benchmark with, empty downloads BENCH_MODEL_URL
+	ModelPath string
+	// Thread counts to try, empty uses the package default
+	Threads []int
+	// GPU layer counts to try, empty tries CPU only and full offload
+	GPULayers []int
+	// Defaults to 512 prompt and 128 generated tokens
+	PromptTokens int
+	GenTokens    int
+	// Runs the benchmark even when a cached result exists
+	Force bool
+}
+
+type HWBenchResult struct {
+	Fingerprint string     `json:"fingerprint"`
+	Model       string     `json:"model"`
+	Time        time.Time  `json:"time"`
+	Runs        []BenchRun `json:"runs"`
+}
+
+// Speeds measured for one configuration, in tokens per second.
+type BenchRun struct {
+	Threads            int     `json:"threads"`
+	GPULayers          int     `json:"gpu_layers"`
+	PromptTokensPerSec float64 `json:"prompt_tokens_per_sec"`
+	GenTokensPerSec    float64 `json:"gen_tokens_per_sec"`
+}
+
+// Fastest run for generation, the figure that matters most for chat.
+func (r HWBenchResult) Best() (BenchRun, bool) {
+	if len(r.Runs) == 0 {
+		return BenchRun{}, false
+	}
+	return slices.MaxFunc(r.Runs, func(a, b BenchRun) int {
+		return cmp.Compare(a.GenTokensPerSec, b.GenTokensPerSec)
+	}), true
+}
+
+// Measures prompt processing and generation speed with the bundled llama-bench
+// for every thread and GPU layer combination. Results are cached in the
+// install manifest until the binaries are reinstalled.
+func BenchmarkHardware(ctx context.Context, opts BenchOptions) (HWBenchResult, error) {
+	result := HWBenchResult{}
+
+	if len(opts.Threads) == 0 {
+		opts.Threads = []int{DefaultConfig().Threads}
+	}
+	if len(opts.GPULayers) == 0 {
+		opts.GPULayers = []int{0, 999}
+	}
+	if opts.PromptTokens <= 0 {
+		opts.PromptTokens = 512
+	}
+	if opts.GenTokens <= 0 {
+		opts.GenTokens = 128
+	}
+
+	benchPath, err := flavorBinary(pickFlavor(nil), "llama-bench.exe")
+	if err != nil {
+		return result, err
+	}
+
+	manifest, err := readManifest()
+	if err != nil {
+		return result, fmt.Errorf("%w: missing install manifest, run DownloadRequirements", ErrNotInstalled)
+	}
+
+	model := opts.ModelPath
+	if model == "" {
+		model = BENCH_MODEL_URL
+	}
+	fingerprint := benchFingerprint(model, opts)
+
+	if cached, ok := manifest.Benchmarks[fingerprint]; ok && !opts.Force {
+		return cached, nil
+	}
+
+	modelPath := opts.ModelPath
+	if modelPath == "" {
+		modelPath, err = installPath("bench-model.gguf")
+		if err != nil {
+			return result, err
+		}
+		if exists, _ := isPathExist(modelPath); !exists {
+			_, _, err = downloadFile(ctx, BENCH_MODEL_URL, modelPath, "", func(int64, int64) {})
+			if err != nil {
+				os.Remove(modelPath)
+				return result, fmt.Errorf("%w: %w", ErrModelFetch, err)
+			}
+		}
+	}
+
+	proc := exec.CommandContext(ctx, benchPath,
+		"-m", modelPath,
+		"-t", joinInts(opts.Threads),
+		"-ngl", joinInts(opts.GPULayers),
+		"-p", strconv.Itoa(opts.PromptTokens),
+		"-n", strconv.Itoa(opts.GenTokens),
+		"-o", "json",
+	)
+	proc.Dir = filepath.Dir(benchPath)
+
+	out, err := proc.Output()
+	if err != nil {
+		return result, fmt.Errorf("llama-bench: %w", err)
+	}
+
+	result.Runs, err = parseBenchOutput(out)
+	if err != nil {
+		return result, err
+	}
+	result.Fingerprint = fingerprint
+	result.Model = model
+	result.Time = time.Now()
+
+	if manifest.Benchmarks == nil {
+		manifest.Benchmarks = map[string]HWBenchResult{}
+	}
+	manifest.Benchmarks[fingerprint] = result
+	return result, writeManifest(manifest)
+}
+
+// llama-bench reports prompt processing and generation as separate tests,
+// merged here into one run per configuration.
+func parseBenchOutput(out []byte) ([]BenchRun, error) {
+	tests := []struct {
+		Threads   int     `json:"n_threads"`
+		GPULayers int     `json:"n_gpu_layers"`
+		NPrompt   int     `json:"n_prompt"`
+		NGen      int     `json:"n_gen"`
+		AvgTs     float64 `json:"avg_ts"`
+	}{}
+
+	err := json.Unmarshal(out, &tests)
+	if err != nil {
+		return nil, fmt.Errorf("json parsing failure, %w", err)
+	}
+
+	runs := []BenchRun{}
+	for _, t := range tests {
+		i := slices.IndexFunc(runs, func(r BenchRun) bool {
+			return r.Threads == t.Threads && r.GPULayers == t.GPULayers
+		})
+		if i < 0 {
+			runs = append(runs, BenchRun{Threads: t.Threads, GPULayers: t.GPULayers})
+			i = len(runs) - 1
+		}
+
+		if t.NGen > 0 {
+			runs[i].GenTokensPerSec = t.AvgTs
+		} else {
+			runs[i].PromptTokensPerSec = t.AvgTs
+		}
+	}
+	return runs, nil
+}
+
+// Identifies the machine and benchmark settings a result is valid for.
+func benchFingerprint(model string, opts BenchOptions) string {
+	hostname, _ := os.Hostname()
+
+	parts := []string{
+		runtime.GOOS, runtime.GOARCH,
+		strconv.Itoa(runtime.NumCPU()), hostname,
+		model,
+		joinInts(opts.Threads), joinInts(opts.GPULayers),
+		strconv.Itoa(opts.PromptTokens), strconv.Itoa(opts.GenTokens),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func joinInts(values []int) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, strconv.Itoa(v))
+	}
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,164 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("server keeps failing, requests are paused until it recovers")
+
+const DEFAULT_CIRCUIT_COOLDOWN = 10 * time.Second
+
+const (
+	CIRCUIT_CLOSED = iota
+	CIRCUIT_OPEN
+	CIRCUIT_HALF_OPEN
+)
+
+// Fails requests fast once the server failed threshold times in a row. After
+// the cooldown a single probe request goes through, closing the circuit when
+// it succeeds and opening it again when it fails.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if cooldown <= 0 {
+		cooldown = DEFAULT_CIRCUIT_COOLDOWN
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Admits a request, returning whether it is the half-open probe.
+func (x *XpltAI) admitRequest() (bool, error) {
+	b := x.breaker
+	if b == nil {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	switch b.state {
+	case CIRCUIT_CLOSED:
+		b.mu.Unlock()
+		return false, nil
+	case CIRCUIT_OPEN:
+		if x.since(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			return false, ErrCircuitOpen
+		}
+		b.state = CIRCUIT_HALF_OPEN
+		b.probing = true
+		b.mu.Unlock()
+		x.emit(EVENT_CIRCUIT_HALF_OPEN, "")
+		return true, nil
+	}
+
+	if b.probing {
+		b.mu.Unlock()
+		return false, ErrCircuitOpen
+	}
+	b.probing = true
+	b.mu.Unlock()
+	return true, nil
+}
+
+// Records the outcome of an admitted request. Errors the caller caused, or
+// that say nothing about the server's health, leave the breaker as is.
+func (x *XpltAI) recordOutcome(ctx context.Context, probe bool, err error) {
+	b := x.breaker
+	if b == nil {
+		return
+	}
+
+	failed := err != nil
+	if failed && !isServerFailure(ctx, err) {
+		b.mu.Lock()
+		if probe {
+			b.probing = false
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	b.mu.Lock()
+	if probe {
+		b.probing = false
+	}
+	event, detail := "", ""
+
+	switch {
+	case !failed:
+		b.failures = 0
+		if b.state != CIRCUIT_CLOSED && probe {
+			b.state = CIRCUIT_CLOSED
+			event = EVENT_CIRCUIT_CLOSED
+		}
+	case b.state == CIRCUIT_HALF_OPEN && probe:
+		b.state = CIRCUIT_OPEN
+		b.openedAt = x.clock.Now()
+		event, detail = EVENT_CIRCUIT_OPEN, err.Error()
+	case b.state == CIRCUIT_CLOSED:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state = CIRCUIT_OPEN
+			b.openedAt = x.clock.Now()
+			event, detail = EVENT_CIRCUIT_OPEN, strconv.Itoa(b.failures)+" consecutive failures: "+err.Error()
+		}
+	}
+	b.mu.Unlock()
+
+	if event != "" {
+		x.emit(event, x.redactInstallPaths(detail))
+	}
+}
+
+// Whether err comes from the server or the connection to it being unhealthy,
+// rather than from the caller cancelling or sending an invalid request.
+func isServerFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrShuttingDown) ||
+		errors.Is(err, ErrServerBusy) {
+		return false
+	}
+
+	var srvErr *ServerError
+	if errors.As(err, &srvErr) {
+		// 501 reports a feature the server wasn't started with
+		return srvErr.StatusCode >= 500 && srvErr.StatusCode != 501 && !isBusyError(srvErr)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, ErrServerNotReady) ||
+		errors.Is(err, ErrLoadTimeout) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// State of the circuit breaker, one of the CIRCUIT_ constants. Always
+// CIRCUIT_CLOSED when Config.CircuitFailures is 0.
+func (x *XpltAI) CircuitState() int {
+	if x.breaker == nil {
+		return CIRCUIT_CLOSED
+	}
+	x.breaker.mu.Lock()
+	defer x.breaker.mu.Unlock()
+	return x.breaker.state
+}
@@ -0,0 +1,65 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerCooldown(t *testing.T) {
+	clk := newFakeClock()
+	x := &XpltAI{clock: clk, breaker: newCircuitBreaker(2, 10*time.Second)}
+	ctx := context.Background()
+
+	for range 2 {
+		probe, err := x.admitRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		x.recordOutcome(ctx, probe, ErrServerNotReady)
+	}
+	if x.CircuitState() != CIRCUIT_OPEN {
+		t.Fatalf("circuit is %d after 2 failures, want open", x.CircuitState())
+	}
+
+	clk.Advance(9 * time.Second)
+	if _, err := x.admitRequest(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v during the cooldown, want ErrCircuitOpen", err)
+	}
+
+	clk.Advance(time.Second)
+	probe, err := x.admitRequest()
+	if err != nil || !probe {
+		t.Fatalf("got probe %v, %v after the cooldown, want the probe", probe, err)
+	}
+	if _, err := x.admitRequest(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got %v while probing, want ErrCircuitOpen", err)
+	}
+
+	// A failed probe restarts the cooldown
+	x.recordOutcome(ctx, probe, ErrServerNotReady)
+	if _, err := x.admitRequest(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v after a failed probe, want ErrCircuitOpen", err)
+	}
+
+	clk.Advance(10 * time.Second)
+	probe, _ = x.admitRequest()
+	x.recordOutcome(ctx, probe, nil)
+	if x.CircuitState() != CIRCUIT_CLOSED {
+		t.Errorf("circuit is %d after a successful probe, want closed", x.CircuitState())
+	}
+}
+
+func TestCircuitBreakerIgnoresCallerErrors(t *testing.T) {
+	x := &XpltAI{clock: newFakeClock(), breaker: newCircuitBreaker(1, 0)}
+	ctx := context.Background()
+
+	for _, err := range []error{context.Canceled, ErrShuttingDown, &ServerError{StatusCode: 400, Message: "bad request"}} {
+		probe, _ := x.admitRequest()
+		x.recordOutcome(ctx, probe, err)
+	}
+	if x.CircuitState() != CIRCUIT_CLOSED {
+		t.Errorf("circuit is %d after caller errors, want closed", x.CircuitState())
+	}
+}
@@ -0,0 +1,43 @@
+package xplatai
+
+import (
+	"context"
+	"math"
+)
+
+// Turns MaxTokensFraction into a concrete MaxTokens. prompt renders the
+// prompt as the server will see it.
+func (x *XpltAI) resolveMaxTokens(ctx context.Context, opts GenOptions, promptTokens func() ([]int, error)) (GenOptions, error) {
+	if opts.MaxTokensFraction == 0 {
+		return opts, nil
+	}
+
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		return opts, err
+	}
+
+	tokens, err := promptTokens()
+	if err != nil {
+		return opts, err
+	}
+
+	// The server reports the context of a single slot, which already accounts
+	// for parallel slots splitting it
+	nCtx, err := x.contextSize(ctx)
+	if err != nil {
+		return opts, err
+	}
+
+	remaining := nCtx - len(tokens)
+	if remaining <= 0 {
+		return opts, &ServerError{
+			Type:    "exceed_context_size_error",
+			Message: "prompt fills the whole context size",
+		}
+	}
+
+	opts.MaxTokens = max(int(math.Floor(float64(remaining)*opts.MaxTokensFraction)), 1)
+	opts.MaxTokensFraction = 0
+	return opts, nil
+}
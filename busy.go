@@ -0,0 +1,74 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const DEFAULT_BUSY_ATTEMPTS = 4
+
+// Longest a busy retry waits for a slot to free up.
+const busySlotWait = 10 * time.Second
+
+// Runs do again while the server rejects it for lack of a free slot or KV
+// cache space, up to the retry policy's busy attempts. do reports whether
+// its request may be repeated.
+func (x *XpltAI) retryBusy(ctx context.Context, do func() (bool, error)) error {
+	retry := x.settingsFrom(ctx).retry
+	attempts := retry.BusyAttempts
+	if attempts <= 0 {
+		attempts = DEFAULT_BUSY_ATTEMPTS
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := do()
+
+		var srvErr *ServerError
+		if !errors.As(err, &srvErr) || !isBusyError(srvErr) {
+			return err
+		}
+		x.stats.busyRejections.Add(1)
+		recordUsage(ctx, Usage{})
+
+		if !retryable || attempt >= attempts {
+			return fmt.Errorf("%w: %w", ErrServerBusy, err)
+		}
+
+		err = x.waitFreeSlot(ctx, retry.Backoff)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Waits until /slots shows an idle slot. Servers started with --no-slots
+// don't tell, backoff is slept instead.
+func (x *XpltAI) waitFreeSlot(ctx context.Context, backoff time.Duration) error {
+	deadline := x.clock.Now().Add(busySlotWait)
+
+	for {
+		slots := []struct {
+			IsProcessing bool `json:"is_processing"`
+		}{}
+		err := x.doJSONOnce(ctx, "GET", "/slots", nil, &slots)
+		if err != nil {
+			return x.sleep(ctx, max(backoff, 500*time.Millisecond))
+		}
+
+		for _, slot := range slots {
+			if !slot.IsProcessing {
+				return nil
+			}
+		}
+		if x.clock.Now().After(deadline) {
+			return nil
+		}
+
+		err = x.sleep(ctx, 100*time.Millisecond)
+		if err != nil {
+			return err
+		}
+	}
+}
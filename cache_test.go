@@ -0,0 +1,86 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Server recording the cache_prompt field of each chat request, reporting
+// cache_n tokens taken from its cache.
+type cacheServer struct {
+	mu     sync.Mutex
+	fields []any
+}
+
+func (s *cacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/chat/completions" {
+		w.Write([]byte(`{}`))
+		return
+	}
+	body := map[string]any{}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.mu.Lock()
+	field, ok := body["cache_prompt"]
+	if !ok {
+		field = "unset"
+	}
+	s.fields = append(s.fields, field)
+	s.mu.Unlock()
+
+	if body["stream"] == true {
+		w.Write([]byte(`data: {"choices":[{"index":0,"delta":{"content":"ok"},"finish_reason":"stop"}],"timings":{"cache_n":42}}` + "\n\ndata: [DONE]\n\n"))
+		return
+	}
+	w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"timings":{"cache_n":42}}`))
+}
+
+func TestCachePromptIsSent(t *testing.T) {
+	stub := &cacheServer{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+	msgs := []Message{{Role: "user", Content: "complete this"}}
+
+	for _, opts := range []GenOptions{{}, {CachePrompt: Ptr(true)}, {CachePrompt: Ptr(false)}} {
+		resp, err := x.ChatDetailed(msgs, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.CachedTokens != 42 {
+			t.Errorf("got %d cached tokens, want 42", resp.CachedTokens)
+		}
+	}
+	resp, err := x.ChatDetailedStream(msgs, GenOptions{CachePrompt: Ptr(true)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CachedTokens != 42 {
+		t.Errorf("streamed reply got %d cached tokens, want 42", resp.CachedTokens)
+	}
+
+	want := []any{"unset", true, false, true}
+	if len(stub.fields) != len(want) {
+		t.Fatalf("got cache_prompt fields %v, want %v", stub.fields, want)
+	}
+	for i := range want {
+		if stub.fields[i] != want[i] {
+			t.Errorf("request %d sent cache_prompt %v, want %v", i, stub.fields[i], want[i])
+		}
+	}
+}
+
+func TestCacheReuseArg(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Port = "18080"
+	if _, ok := argValue(resolvedArgs(t, cfg), "--cache-reuse"); ok {
+		t.Error("--cache-reuse passed without CacheReuse")
+	}
+
+	cfg.CacheReuse = 256
+	if n, _ := argValue(resolvedArgs(t, cfg), "--cache-reuse"); n != "256" {
+		t.Errorf("got --cache-reuse %q, want 256", n)
+	}
+}
@@ -0,0 +1,151 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	CAP_EMBEDDINGS = "embeddings"
+	CAP_RERANK     = "rerank"
+	CAP_SLOTS      = "slots"
+	CAP_TOOLS      = "tools"
+	CAP_VISION     = "vision"
+	CAP_INFILL     = "infill"
+)
+
+var ErrCapabilityMissing = errors.New("server lacks capability")
+
+type Caps struct {
+	SupportsEmbeddings bool
+	SupportsRerank     bool
+	SupportsSlots      bool
+	SupportsTools      bool
+	SupportsVision     bool
+	SupportsInfill     bool
+}
+
+func (c Caps) Has(name string) bool {
+	switch name {
+	case CAP_EMBEDDINGS:
+		return c.SupportsEmbeddings
+	case CAP_RERANK:
+		return c.SupportsRerank
+	case CAP_SLOTS:
+		return c.SupportsSlots
+	case CAP_TOOLS:
+		return c.SupportsTools
+	case CAP_VISION:
+		return c.SupportsVision
+	case CAP_INFILL:
+		return c.SupportsInfill
+	}
+	return false
+}
+
+type capsCache struct {
+	mu   sync.Mutex
+	caps *Caps
+}
+
+// Probes the server for optional features, the result is cached for the
+// lifetime of the handle.
+func (x *XpltAI) Capabilities() (Caps, error) {
+	return x.capabilities(context.Background())
+}
+
+func (x *XpltAI) capabilities(ctx context.Context) (Caps, error) {
+	x.caps.mu.Lock()
+	defer x.caps.mu.Unlock()
+
+	if x.caps.caps != nil {
+		return *x.caps.caps, nil
+	}
+
+	caps, err := x.probeCapabilities(ctx)
+	if err != nil {
+		return caps, err
+	}
+	x.caps.caps = &caps
+	return caps, nil
+}
+
+func (x *XpltAI) probeCapabilities(ctx context.Context) (Caps, error) {
+	caps := Caps{}
+
+	props := struct {
+		ChatTemplate string `json:"chat_template"`
+		Modalities   struct {
+			Vision bool `json:"vision"`
+		} `json:"modalities"`
+	}{}
+
+	err := x.doJSON(ctx, "GET", "/props", nil, &props)
+	if err != nil {
+		return caps, err
+	}
+	caps.SupportsVision = props.Modalities.Vision
+	caps.SupportsTools = strings.Contains(props.ChatTemplate, "tool")
+
+	probes := []struct {
+		supported *bool
+		method    string
+		endpoint  string
+		data      any
+	}{
+		{&caps.SupportsEmbeddings, "POST", "/embedding", map[string]any{"content": "a"}},
+		{&caps.SupportsRerank, "POST", "/rerank", map[string]any{"query": "a", "documents": []string{"a"}}},
+		{&caps.SupportsSlots, "GET", "/slots", nil},
+		{&caps.SupportsInfill, "POST", "/infill", map[string]any{"input_prefix": "", "input_suffix": "", "n_predict": 0}},
+	}
+
+	for _, p := range probes {
+		err := x.doJSON(ctx, p.method, p.endpoint, p.data, nil)
+		if err == nil {
+			*p.supported = true
+			continue
+		}
+		if !isCapabilityMissing(err) {
+			return caps, err
+		}
+	}
+	return caps, nil
+}
+
+// Not found, not implemented, or a feature the model itself lacks.
+func isCapabilityMissing(err error) bool {
+	var srvErr *ServerError
+	if !errors.As(err, &srvErr) {
+		return false
+	}
+	switch srvErr.StatusCode {
+	case 404, 501:
+		return true
+	case 400:
+		return strings.Contains(strings.ToLower(srvErr.Message), "not supported")
+	}
+	return false
+}
+
+// Fails with ErrCapabilityMissing when the server lacks the named capability.
+func (x *XpltAI) requireCapability(ctx context.Context, name string) error {
+	caps, err := x.capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if !caps.Has(name) {
+		return fmt.Errorf("%w: %s", ErrCapabilityMissing, name)
+	}
+	return nil
+}
+
+// Maps a raw not found / not implemented response to ErrCapabilityMissing.
+func capabilityError(err error, name string) error {
+	if err != nil && isCapabilityMissing(err) {
+		return fmt.Errorf("%w: %s", ErrCapabilityMissing, name)
+	}
+	return err
+}
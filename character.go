@@ -0,0 +1,60 @@
+package xplatai
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/wAIfu-DEV/Xplat-AI/charcard"
+)
+
+// System prompt of characters whose card has none, SillyTavern's default.
+const DEFAULT_CHARACTER_PROMPT = "Write {{char}}'s next reply in a fictional chat between {{char}} and {{user}}."
+
+// Starts the session over as the character of card. The system prompt is
+// built from the card's prompt, description, personality, scenario and
+// example dialogs, and the greeting opens the history. The card's character
+// book becomes the session's lorebook. {{user}} expands to
+// charcard.DEFAULT_USER_NAME, see ApplyCharacterAs.
+func (s *Session) ApplyCharacter(card *charcard.Card) {
+	s.ApplyCharacterAs(card, "")
+}
+
+// ApplyCharacter with {{user}} expanding to user.
+func (s *Session) ApplyCharacterAs(card *charcard.Card, user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := []Message{{Role: "system", Content: characterPrompt(card, user)}}
+	if card.Data.FirstMes != "" {
+		msgs = append(msgs, Message{Role: "assistant", Content: card.Expand(card.Data.FirstMes, user)})
+	}
+
+	s.messages = slices.Clip(msgs)
+	s.alternatives = map[int][]string{}
+	s.lore = LorebookFromCard(card, user)
+	s.metadata["character"] = card.Data.Name
+}
+
+func characterPrompt(card *charcard.Card, user string) string {
+	d := card.Data
+
+	prompt := DEFAULT_CHARACTER_PROMPT
+	if d.SystemPrompt != "" {
+		prompt = strings.ReplaceAll(d.SystemPrompt, "{{original}}", DEFAULT_CHARACTER_PROMPT)
+	}
+
+	parts := []string{prompt}
+	if d.Description != "" {
+		parts = append(parts, d.Description)
+	}
+	if d.Personality != "" {
+		parts = append(parts, "{{char}}'s personality: "+d.Personality)
+	}
+	if d.Scenario != "" {
+		parts = append(parts, "Scenario: "+d.Scenario)
+	}
+	if examples := card.Examples(); len(examples) > 0 {
+		parts = append(parts, "Example dialogue:\n"+strings.Join(examples, "\n\n"))
+	}
+	return card.Expand(strings.Join(parts, "\n\n"), user)
+}
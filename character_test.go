@@ -0,0 +1,87 @@
+package xplatai
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wAIfu-DEV/Xplat-AI/charcard"
+)
+
+func TestCharacterPrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		data charcard.Data
+		user string
+		want string
+	}{
+		{
+			"default prompt",
+			charcard.Data{Name: "Aqua"},
+			"",
+			"Write Aqua's next reply in a fictional chat between Aqua and User.",
+		},
+		{
+			"all parts",
+			charcard.Data{
+				Name:        "Aqua",
+				Description: "{{char}} is a goddess.",
+				Personality: "loud",
+				Scenario:    "{{user}} meets {{char}} in Axel.",
+				MesExample:  "<START>\n{{user}}: hi\n{{char}}: hello\n<START>\n<USER>: bye",
+			},
+			"Kazuma",
+			"Write Aqua's next reply in a fictional chat between Aqua and Kazuma.\n\n" +
+				"Aqua is a goddess.\n\n" +
+				"Aqua's personality: loud\n\n" +
+				"Scenario: Kazuma meets Aqua in Axel.\n\n" +
+				"Example dialogue:\nKazuma: hi\nAqua: hello\n\nKazuma: bye",
+		},
+		{
+			"card prompt",
+			charcard.Data{Name: "Aqua", SystemPrompt: "You are {{char}}."},
+			"Kazuma",
+			"You are Aqua.",
+		},
+		{
+			"card prompt with original",
+			charcard.Data{Name: "Aqua", SystemPrompt: "{{original}} Never break character.", Scenario: "Axel"},
+			"Kazuma",
+			"Write Aqua's next reply in a fictional chat between Aqua and Kazuma. Never break character.\n\nScenario: Axel",
+		},
+	}
+	for _, tt := range tests {
+		got := characterPrompt(&charcard.Card{Data: tt.data}, tt.user)
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyCharacterStartsOver(t *testing.T) {
+	s := (&XpltAI{}).NewSession("old prompt", GenOptions{})
+	s.Conversation.messages = append(s.Conversation.messages, Message{Role: "user", Content: "hi"})
+
+	card := &charcard.Card{Data: charcard.Data{
+		Name:         "Aqua",
+		SystemPrompt: "{{original}}",
+		FirstMes:     "Hello {{user}}!",
+		CharacterBook: &charcard.Book{Entries: []charcard.BookEntry{
+			{Keys: []string{"Axel"}, Content: "A town.", Enabled: true},
+		}},
+	}}
+	s.ApplyCharacterAs(card, "Kazuma")
+
+	want := []Message{
+		{Role: "system", Content: "Write Aqua's next reply in a fictional chat between Aqua and Kazuma."},
+		{Role: "assistant", Content: "Hello Kazuma!"},
+	}
+	if got := s.Messages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got history %+v, want %+v", got, want)
+	}
+	if s.Lorebook() == nil {
+		t.Error("character book not applied")
+	}
+	if s.Metadata()["character"] != "Aqua" {
+		t.Errorf("got metadata %v", s.Metadata())
+	}
+}
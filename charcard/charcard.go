@@ -0,0 +1,256 @@
+// Package charcard reads SillyTavern character cards, as JSON or embedded in
+// a PNG, see xplatai.Session.ApplyCharacter. V2 cards are read in full, V1
+// cards and the V2 fields of V3 cards are read too.
+package charcard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	SPEC_V1 = "chara_card_v1"
+	SPEC_V2 = "chara_card_v2"
+	SPEC_V3 = "chara_card_v3"
+)
+
+// Name {{user}} expands to when none is given.
+const DEFAULT_USER_NAME = "User"
+
+var ErrNoCard = errors.New("png holds no character card")
+
+// Largest text chunk read, cards with big character books stay well under it.
+const maxTextChunk = 8 << 20
+
+type Card struct {
+	Spec        string `json:"spec"`
+	SpecVersion string `json:"spec_version"`
+	Data        Data   `json:"data"`
+}
+
+type Data struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Personality string `json:"personality"`
+	Scenario    string `json:"scenario"`
+	// Greeting the character opens the chat with
+	FirstMes string `json:"first_mes"`
+	// Example dialogs, each starting with a <START> line
+	MesExample string `json:"mes_example"`
+
+	CreatorNotes string `json:"creator_notes,omitempty"`
+	// Replaces the default system prompt, {{original}} stands for it
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// Instructions sent after the history
+	PostHistoryInstructions string   `json:"post_history_instructions,omitempty"`
+	AlternateGreetings      []string `json:"alternate_greetings,omitempty"`
+	CharacterBook           *Book    `json:"character_book,omitempty"`
+
+	Tags             []string       `json:"tags,omitempty"`
+	Creator          string         `json:"creator,omitempty"`
+	CharacterVersion string         `json:"character_version,omitempty"`
+	Extensions       map[string]any `json:"extensions,omitempty"`
+}
+
+// Lore entries the character carries, see xplatai.LorebookFromCard.
+type Book struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Latest messages scanned for keys
+	ScanDepth int `json:"scan_depth,omitempty"`
+	// Tokens the triggered entries may take in the prompt
+	TokenBudget int `json:"token_budget,omitempty"`
+	// Whether triggered entries' content is scanned for keys too
+	RecursiveScanning bool           `json:"recursive_scanning,omitempty"`
+	Extensions        map[string]any `json:"extensions,omitempty"`
+	Entries           []BookEntry    `json:"entries"`
+}
+
+type BookEntry struct {
+	Keys    []string `json:"keys"`
+	Content string   `json:"content"`
+	Enabled bool     `json:"enabled"`
+	// Entries with a lower order are inserted first
+	InsertionOrder int    `json:"insertion_order"`
+	CaseSensitive  bool   `json:"case_sensitive,omitempty"`
+	Name           string `json:"name,omitempty"`
+	// Entries with a lower priority are dropped first when over budget
+	Priority int    `json:"priority,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	// Triggers only when one of SecondaryKeys matches too
+	Selective     bool     `json:"selective,omitempty"`
+	SecondaryKeys []string `json:"secondary_keys,omitempty"`
+	// Always inserted, keys or not
+	Constant bool `json:"constant,omitempty"`
+	// "before_char" or "after_char"
+	Position   string         `json:"position,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Entries are enabled unless the card says otherwise.
+func (e *BookEntry) UnmarshalJSON(data []byte) error {
+	type entry BookEntry
+	parsed := entry{Enabled: true}
+	err := json.Unmarshal(data, &parsed)
+	if err != nil {
+		return err
+	}
+	*e = BookEntry(parsed)
+	return nil
+}
+
+// Parses a card from its JSON.
+func Parse(data []byte) (*Card, error) {
+	card := &Card{}
+	err := json.Unmarshal(data, card)
+	if err != nil {
+		return nil, fmt.Errorf("json parsing failure, %w", err)
+	}
+
+	// V1 cards hold the fields at the top level
+	if card.Spec == "" {
+		card.Spec = SPEC_V1
+		err := json.Unmarshal(data, &card.Data)
+		if err != nil {
+			return nil, fmt.Errorf("json parsing failure, %w", err)
+		}
+	}
+	if card.Data.Name == "" {
+		return nil, errors.New("character card has no name")
+	}
+	return card, nil
+}
+
+// Reads a card as JSON or PNG, told apart by content.
+func Read(r io.Reader) (*Card, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(pngSignature))
+	if bytes.Equal(head, pngSignature) {
+		return ReadPNG(br)
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func Load(path string) (*Card, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Reads the card a PNG holds base64 encoded in a tEXt chunk, "ccv3" is
+// preferred over "chara" when a card holds both.
+func ReadPNG(r io.Reader) (*Card, error) {
+	signature := make([]byte, len(pngSignature))
+	_, err := io.ReadFull(r, signature)
+	if err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("not a png file")
+	}
+
+	texts := map[string]string{}
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, fmt.Errorf("png parsing failure, %w", err)
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		kind := string(header[4:])
+		if length > 1<<31-1 {
+			return nil, errors.New("png parsing failure, invalid chunk length")
+		}
+		if kind == "IEND" {
+			break
+		}
+
+		// Only the text chunks are kept, image data is skipped
+		if kind != "tEXt" {
+			_, err := io.CopyN(io.Discard, r, int64(length)+4)
+			if err != nil {
+				return nil, fmt.Errorf("png parsing failure, %w", err)
+			}
+			continue
+		}
+
+		if length > maxTextChunk {
+			return nil, errors.New("png parsing failure, text chunk too large")
+		}
+		chunk := make([]byte, length+4)
+		_, err = io.ReadFull(r, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("png parsing failure, %w", err)
+		}
+		data, crc := chunk[:length], binary.BigEndian.Uint32(chunk[length:])
+		if crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, data) != crc {
+			return nil, errors.New("png parsing failure, corrupted text chunk")
+		}
+		keyword, text, ok := bytes.Cut(data, []byte{0})
+		if ok {
+			texts[string(keyword)] = string(text)
+		}
+	}
+
+	for _, keyword := range []string{"ccv3", "chara"} {
+		encoded, ok := texts[keyword]
+		if !ok {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("base64 decoding failure, %w", err)
+		}
+		return Parse(data)
+	}
+	return nil, ErrNoCard
+}
+
+var (
+	macros        = regexp.MustCompile(`(?i){{char}}|{{user}}|<bot>|<user>`)
+	exampleStarts = regexp.MustCompile(`(?i)<start>`)
+)
+
+// Replaces the {{char}} and {{user}} macros, and their <BOT> and <USER> forms,
+// with the names. An empty user is DEFAULT_USER_NAME.
+func (c *Card) Expand(text string, user string) string {
+	if user == "" {
+		user = DEFAULT_USER_NAME
+	}
+	return macros.ReplaceAllStringFunc(text, func(m string) string {
+		switch strings.ToLower(m) {
+		case "{{char}}", "<bot>":
+			return c.Data.Name
+		}
+		return user
+	})
+}
+
+// Example dialogs of MesExample, one per <START> block, macros left as is.
+func (c *Card) Examples() []string {
+	examples := []string{}
+	for _, block := range exampleStarts.Split(c.Data.MesExample, -1) {
+		block = strings.TrimSpace(block)
+		if block != "" {
+			examples = append(examples, block)
+		}
+	}
+	return examples
+}
@@ -0,0 +1,199 @@
+package charcard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	v1Card = `{"name":"Aqua","description":"A goddess.","personality":"loud","scenario":"Axel","first_mes":"Hi!","mes_example":"<START>\n{{user}}: hi"}`
+	v2Card = `{"spec":"chara_card_v2","spec_version":"2.0","data":{"name":"Aqua","description":"A goddess.","first_mes":"Hi!","system_prompt":"{{original}} Stay in character.","tags":["goddess"],"character_book":{"entries":[{"keys":["Axel"],"content":"A town."},{"keys":["Eris"],"content":"A goddess.","enabled":false}]}}}`
+	v3Card = `{"spec":"chara_card_v3","spec_version":"3.0","data":{"name":"Megumin","description":"An archwizard.","group_only_greetings":["Explosion!"],"assets":[]}}`
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		wantSpec string
+		wantName string
+		wantErr  bool
+	}{
+		{"v1", v1Card, SPEC_V1, "Aqua", false},
+		{"v2", v2Card, SPEC_V2, "Aqua", false},
+		{"v3", v3Card, SPEC_V3, "Megumin", false},
+		{"no name", `{"spec":"chara_card_v2","data":{"description":"nobody"}}`, "", "", true},
+		{"not json", `Aqua`, "", "", true},
+	}
+	for _, tt := range tests {
+		card, err := Parse([]byte(tt.json))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed %+v, want an error", tt.name, card)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if card.Spec != tt.wantSpec || card.Data.Name != tt.wantName {
+			t.Errorf("%s: got spec %q named %q, want %q named %q", tt.name, card.Spec, card.Data.Name, tt.wantSpec, tt.wantName)
+		}
+	}
+}
+
+func TestParseReadsAllFields(t *testing.T) {
+	v1, err := Parse([]byte(v1Card))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Data.Personality != "loud" || v1.Data.Scenario != "Axel" || v1.Data.FirstMes != "Hi!" {
+		t.Errorf("v1 fields not read: %+v", v1.Data)
+	}
+
+	v2, err := Parse([]byte(v2Card))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := v2.Data.CharacterBook
+	if book == nil || len(book.Entries) != 2 {
+		t.Fatalf("got book %+v, want two entries", book)
+	}
+	// Enabled unless the card says otherwise
+	if !book.Entries[0].Enabled || book.Entries[1].Enabled {
+		t.Errorf("got entries enabled %v and %v, want true and false", book.Entries[0].Enabled, book.Entries[1].Enabled)
+	}
+	if !slices.Equal(v2.Data.Tags, []string{"goddess"}) {
+		t.Errorf("got tags %q", v2.Data.Tags)
+	}
+}
+
+type pngChunk struct {
+	kind string
+	data []byte
+	// Written instead of the chunk's checksum when set
+	badCrc bool
+}
+
+func textChunk(keyword string, card string) pngChunk {
+	encoded := base64.StdEncoding.EncodeToString([]byte(card))
+	return pngChunk{kind: "tEXt", data: []byte(keyword + "\x00" + encoded)}
+}
+
+func buildPNG(chunks ...pngChunk) []byte {
+	buf := bytes.Buffer{}
+	buf.Write(pngSignature)
+	chunks = append([]pngChunk{{kind: "IHDR", data: make([]byte, 13)}}, chunks...)
+	chunks = append(chunks, pngChunk{kind: "IEND"})
+	for _, c := range chunks {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c.data)))
+		buf.WriteString(c.kind)
+		buf.Write(c.data)
+		crc := crc32.Update(crc32.ChecksumIEEE([]byte(c.kind)), crc32.IEEETable, c.data)
+		if c.badCrc {
+			crc++
+		}
+		binary.Write(&buf, binary.BigEndian, crc)
+	}
+	return buf.Bytes()
+}
+
+func TestReadPNG(t *testing.T) {
+	badCrc := textChunk("chara", v2Card)
+	badCrc.badCrc = true
+
+	tests := []struct {
+		name     string
+		png      []byte
+		wantName string
+		wantErr  string
+	}{
+		{"chara", buildPNG(textChunk("chara", v2Card)), "Aqua", ""},
+		{"ccv3", buildPNG(textChunk("ccv3", v3Card)), "Megumin", ""},
+		{"ccv3 preferred", buildPNG(textChunk("chara", v2Card), textChunk("ccv3", v3Card)), "Megumin", ""},
+		{"other text", buildPNG(pngChunk{kind: "tEXt", data: []byte("Software\x00paint")}, textChunk("chara", v1Card)), "Aqua", ""},
+		{"bad crc", buildPNG(badCrc), "", "corrupted text chunk"},
+		{"no card", buildPNG(pngChunk{kind: "tEXt", data: []byte("Software\x00paint")}), "", ErrNoCard.Error()},
+		{"too large", buildPNG(pngChunk{kind: "tEXt", data: make([]byte, maxTextChunk+1)}), "", "text chunk too large"},
+		{"truncated", buildPNG(textChunk("chara", v2Card))[:40], "", "png parsing failure"},
+		{"not a png", []byte(v2Card), "", "not a png file"},
+	}
+	for _, tt := range tests {
+		card, err := ReadPNG(bytes.NewReader(tt.png))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if card.Data.Name != tt.wantName {
+			t.Errorf("%s: got %q, want %q", tt.name, card.Data.Name, tt.wantName)
+		}
+	}
+}
+
+func TestReadTellsPNGFromJSON(t *testing.T) {
+	card, err := Read(bytes.NewReader(buildPNG(textChunk("chara", v2Card))))
+	if err != nil || card.Data.Name != "Aqua" {
+		t.Errorf("png: got %v, %v", card, err)
+	}
+	card, err = Read(strings.NewReader(v3Card))
+	if err != nil || card.Data.Name != "Megumin" {
+		t.Errorf("json: got %v, %v", card, err)
+	}
+	_, err = Read(bytes.NewReader(buildPNG()))
+	if !errors.Is(err, ErrNoCard) {
+		t.Errorf("got %v, want ErrNoCard", err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	card := &Card{Data: Data{Name: "Aqua"}}
+	tests := []struct {
+		text string
+		user string
+		want string
+	}{
+		{"{{char}} greets {{user}}.", "Kazuma", "Aqua greets Kazuma."},
+		{"{{Char}} greets {{USER}}.", "Kazuma", "Aqua greets Kazuma."},
+		{"<BOT> greets <user>.", "Kazuma", "Aqua greets Kazuma."},
+		{"<bot>: hi {{user}}", "", "Aqua: hi " + DEFAULT_USER_NAME},
+		{"{{original}} and {{char", "Kazuma", "{{original}} and {{char"},
+	}
+	for _, tt := range tests {
+		got := card.Expand(tt.text, tt.user)
+		if got != tt.want {
+			t.Errorf("Expand(%q, %q) = %q, want %q", tt.text, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestExamples(t *testing.T) {
+	tests := []struct {
+		mesExample string
+		want       []string
+	}{
+		{"", []string{}},
+		{"<START>\n{{user}}: hi\n{{char}}: hello", []string{"{{user}}: hi\n{{char}}: hello"}},
+		{"<START>\nfirst\n<start>\nsecond\n<START>\n", []string{"first", "second"}},
+		{"no start line\n<START>\nsecond", []string{"no start line", "second"}},
+	}
+	for _, tt := range tests {
+		card := &Card{Data: Data{MesExample: tt.mesExample}}
+		got := card.Examples()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Examples of %q = %q, want %q", tt.mesExample, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package xplatai
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Prompt format rendered on the client, for models whose chat template is
+// missing or wrong and for completions, see Session.SetChatFormat and
+// ChatFormatByName for the common ones. Every message is written as the
+// prefix, content and suffix of its role.
+type ChatFormat struct {
+	// Written once before the messages
+	Start     string     `json:"start,omitempty"`
+	System    RoleFormat `json:"system"`
+	User      RoleFormat `json:"user"`
+	Assistant RoleFormat `json:"assistant"`
+	// End the model's turn, sent as stop strings
+	Stop []string `json:"stop,omitempty"`
+}
+
+type RoleFormat struct {
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// Renders the session's prompts with format instead of the server's chat
+// template, nil goes back to the server's. Trimming still measures the
+// server's template, which is close for most formats.
+func (s *Session) SetChatFormat(format *ChatFormat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if format != nil {
+		f := *format
+		f.Stop = slices.Clone(f.Stop)
+		format = &f
+	}
+	s.format = format
+}
+
+func (s *Session) ChatFormat() *ChatFormat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.format == nil {
+		return nil
+	}
+	f := *s.format
+	f.Stop = slices.Clone(f.Stop)
+	return &f
+}
+
+// Renders messages, ending with the assistant prefix for the model to reply.
+// Messages of other roles, like tool results, are written as user messages.
+func (f ChatFormat) Render(messages []Message) string {
+	b := strings.Builder{}
+	b.WriteString(f.Start)
+	for _, m := range messages {
+		role := f.role(m.Role)
+		b.WriteString(role.Prefix)
+		b.WriteString(m.Content)
+		b.WriteString(role.Suffix)
+	}
+	b.WriteString(f.Assistant.Prefix)
+	return b.String()
+}
+
+func (f ChatFormat) role(role string) RoleFormat {
+	switch role {
+	case "system":
+		return f.System
+	case "assistant":
+		return f.Assistant
+	}
+	return f.User
+}
+
+// Renders chat requests with format and generates through /completion. Only
+// one reply is generated, GenOptions.N and Tools don't apply.
+func (x *XpltAI) chatFormatted(ctx context.Context, format ChatFormat, req chatRequest) (ChatResponse, error) {
+	resp, err := x.doComplete(ctx, completionRequest{
+		endpoint: "/completion",
+		prompt:   format.Render(req.messages),
+		onDelta:  req.onDelta,
+	}, format.Options(req.opts))
+
+	return ChatResponse{
+		RequestId:        newRequestId(),
+		Content:          resp.Content,
+		RawContent:       resp.RawContent,
+		FinishReason:     resp.FinishReason,
+		Timing:           resp.Timing,
+		Backend:          resp.Backend,
+		CachedTokens:     resp.CachedTokens,
+		ServerTimings:    resp.ServerTimings,
+		PromptTokens:     resp.PromptTokens,
+		CompletionTokens: resp.CompletionTokens,
+		AttemptsUsage:    resp.AttemptsUsage,
+		TotalUsage:       resp.TotalUsage,
+		Logprobs:         resp.Logprobs,
+		Prompt:           resp.Prompt,
+		MaxTokens:        resp.MaxTokens,
+	}, err
+}
@@ -0,0 +1,80 @@
+package xplatai
+
+import (
+	"errors"
+	"strings"
+)
+
+// Constrains the reply to exactly one of choices and returns it with the
+// casing of choices. No choice may be a prefix of another.
+func (x *XpltAI) ChatChoice(messages []Message, choices []string) (string, error) {
+	if len(choices) == 0 {
+		return "", errors.New("no choices given")
+	}
+
+	longest := 0
+	for i, a := range choices {
+		if a == "" {
+			return "", errors.New("choices cannot be empty strings")
+		}
+		for _, b := range choices[i+1:] {
+			la, lb := strings.ToLower(a), strings.ToLower(b)
+			if strings.HasPrefix(la, lb) || strings.HasPrefix(lb, la) {
+				return "", errors.New("ambiguous choices: " + a + " and " + b)
+			}
+		}
+		longest = max(longest, len(a))
+	}
+
+	opts := GenOptions{
+		Grammar:     choiceGrammar(choices),
+		Temperature: Ptr(0.0),
+		// A token holds at least one byte, plus the end of generation
+		MaxTokens: longest + 1,
+		// The grammar ends the reply, stop strings could only cut a choice short
+		Stop: []string{},
+	}
+
+	resp, err := x.ChatDetailed(messages, opts)
+	if err != nil {
+		return "", err
+	}
+
+	for _, choice := range choices {
+		if strings.EqualFold(resp.RawContent, choice) {
+			return choice, nil
+		}
+	}
+	return "", errors.New("reply matched none of the choices: " + resp.RawContent)
+}
+
+// GBNF grammar matching exactly one of choices.
+func choiceGrammar(choices []string) string {
+	alts := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		alts = append(alts, gbnfString(choice))
+	}
+	return "root ::= " + strings.Join(alts, " | ")
+}
+
+func gbnfString(s string) string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
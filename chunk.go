@@ -0,0 +1,309 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Part of a document, see SplitSentences, SplitMarkdown and SplitTokens.
+type Chunk struct {
+	Text string
+	// Byte offsets of Text in the document
+	Start int
+	End   int
+	// Titles of the sections the chunk is in, outermost first. Only set by
+	// SplitMarkdown.
+	Headers []string
+}
+
+type ChunkOptions struct {
+	// Largest chunk, in bytes, or in tokens for SplitTokens. A single word
+	// larger than that is a chunk of its own.
+	Size int
+	// Whole sentences at the end of a chunk repeated at the start of the
+	// next, up to this size
+	Overlap int
+}
+
+func (o ChunkOptions) validate() error {
+	if o.Size <= 0 {
+		return errors.New("chunk size must be positive")
+	}
+	if o.Overlap < 0 || o.Overlap >= o.Size {
+		return errors.New("chunk overlap must be in [0, Size)")
+	}
+	return nil
+}
+
+// Splits text into chunks of whole sentences, a sentence larger than a chunk
+// is split between words. Sentences end at ., ! and ? followed by a space,
+// and at blank lines.
+func SplitSentences(text string, opts ChunkOptions) ([]Chunk, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+	return groupSpans(text, sentenceSpans(text, 0, len(text)), opts), nil
+}
+
+// SplitSentences measuring chunks in tokens of the server's tokenizer. Tokens
+// are counted per sentence, chunks can be a few tokens off where sentences
+// meet.
+func (x *XpltAI) SplitTokens(text string, opts ChunkOptions) ([]Chunk, error) {
+	return x.SplitTokensContext(context.Background(), text, opts)
+}
+
+func (x *XpltAI) SplitTokensContext(ctx context.Context, text string, opts ChunkOptions) ([]Chunk, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	spans := sentenceSpans(text, 0, len(text))
+	counts := make([]int, 0, len(spans))
+	units := []span{}
+	for _, s := range spans {
+		n, err := x.countTextTokens(ctx, text[s.start:s.end])
+		if err != nil {
+			return nil, err
+		}
+		if n <= opts.Size {
+			units = append(units, s)
+			counts = append(counts, n)
+			continue
+		}
+		for _, w := range wordSpans(text, s) {
+			n, err := x.countTextTokens(ctx, text[w.start:w.end])
+			if err != nil {
+				return nil, err
+			}
+			units = append(units, w)
+			counts = append(counts, n)
+		}
+	}
+	return groupUnits(text, units, counts, opts), nil
+}
+
+var markdownHeader = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+
+// Splits markdown into its sections, each chunk holding the titles of the
+// sections it is in. Sections larger than Size are split like
+// SplitSentences, 0 keeps them whole. Headers in fenced code blocks are left
+// alone, sections without text besides their header are skipped.
+func SplitMarkdown(text string, opts ChunkOptions) ([]Chunk, error) {
+	if opts.Size != 0 {
+		err := opts.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunks := []Chunk{}
+	headers := []string{}
+	// Headers of the section being read and where its body starts
+	sectionHeaders := []string{}
+	start, bodyStart := 0, 0
+
+	flush := func(end int) {
+		body := strings.TrimSpace(text[bodyStart:end])
+		if body == "" {
+			return
+		}
+		section := trimSpan(text, span{start, end})
+		if opts.Size == 0 || section.end-section.start <= opts.Size {
+			chunks = append(chunks, Chunk{
+				Text:    text[section.start:section.end],
+				Start:   section.start,
+				End:     section.end,
+				Headers: sectionHeaders,
+			})
+			return
+		}
+		for _, c := range groupSpans(text, sentenceSpans(text, section.start, section.end), opts) {
+			c.Headers = sectionHeaders
+			chunks = append(chunks, c)
+		}
+	}
+
+	fence := ""
+	for offset := 0; offset < len(text); {
+		line, _, _ := strings.Cut(text[offset:], "\n")
+		lineEnd := offset + len(line)
+		next := min(lineEnd+1, len(text))
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+		default:
+			m := markdownHeader.FindStringSubmatch(strings.TrimRight(line, "\r"))
+			if m == nil {
+				break
+			}
+			flush(offset)
+			level := len(m[1])
+			headers = append(headers[:min(level-1, len(headers))], m[2])
+			sectionHeaders = append([]string{}, headers...)
+			start, bodyStart = offset, next
+		}
+		offset = next
+	}
+	flush(len(text))
+	return chunks, nil
+}
+
+// Byte range of a document.
+type span struct {
+	start int
+	end   int
+}
+
+func trimSpan(text string, s span) span {
+	s.start += len(text[s.start:s.end]) - len(strings.TrimLeftFunc(text[s.start:s.end], unicode.IsSpace))
+	s.end = s.start + len(strings.TrimRightFunc(text[s.start:s.end], unicode.IsSpace))
+	return s
+}
+
+func isSentenceEnd(r rune) bool {
+	return r == '.' || r == '!' || r == '?' || r == '。' || r == '！' || r == '？'
+}
+
+// Closing quotes and brackets a sentence can end with after its punctuation.
+func isSentenceClose(r rune) bool {
+	return strings.ContainsRune("\"')]}»”’", r)
+}
+
+// Sentences of text[start:end], without the whitespace around them.
+func sentenceSpans(text string, start int, end int) []span {
+	spans := []span{}
+	sentence := -1
+	cut := func(at int) {
+		s := trimSpan(text, span{sentence, at})
+		if s.end > s.start {
+			spans = append(spans, s)
+		}
+		sentence = -1
+	}
+
+	for i := start; i < end; {
+		r, size := utf8.DecodeRuneInString(text[i:end])
+		if sentence < 0 {
+			if !unicode.IsSpace(r) {
+				sentence = i
+			}
+			i += size
+			continue
+		}
+
+		if r == '\n' && strings.HasPrefix(strings.TrimLeft(text[i+1:end], " \t\r"), "\n") {
+			cut(i)
+			i += size
+			continue
+		}
+
+		if isSentenceEnd(r) {
+			j := i + size
+			for j < end {
+				next, n := utf8.DecodeRuneInString(text[j:end])
+				if !isSentenceEnd(next) && !isSentenceClose(next) {
+					break
+				}
+				j += n
+			}
+			next, _ := utf8.DecodeRuneInString(text[j:end])
+			// CJK punctuation needs no space after it
+			if j == end || unicode.IsSpace(next) || r >= 0x3000 {
+				cut(j)
+				i = j
+				continue
+			}
+		}
+		i += size
+	}
+	if sentence >= 0 {
+		cut(end)
+	}
+	return spans
+}
+
+func wordSpans(text string, s span) []span {
+	words := []span{}
+	word := -1
+	for i, r := range text[s.start:s.end] {
+		if unicode.IsSpace(r) {
+			if word >= 0 {
+				words = append(words, span{word, s.start + i})
+				word = -1
+			}
+		} else if word < 0 {
+			word = s.start + i
+		}
+	}
+	if word >= 0 {
+		words = append(words, span{word, s.end})
+	}
+	return words
+}
+
+// Groups sentences into chunks measured in bytes, splitting the sentences
+// larger than a chunk between words.
+func groupSpans(text string, spans []span, opts ChunkOptions) []Chunk {
+	units := []span{}
+	for _, s := range spans {
+		if s.end-s.start <= opts.Size {
+			units = append(units, s)
+		} else {
+			units = append(units, wordSpans(text, s)...)
+		}
+	}
+	return groupUnits(text, units, nil, opts)
+}
+
+// Fills chunks with as many units as fit, starting each chunk with the last
+// units of the previous one up to opts.Overlap. Sizes are the byte range the
+// units cover, or the sum of counts when set.
+func groupUnits(text string, units []span, counts []int, opts ChunkOptions) []Chunk {
+	size := func(i int, j int) int {
+		if counts == nil {
+			return units[j].end - units[i].start
+		}
+		n := 0
+		for k := i; k <= j; k++ {
+			n += counts[k]
+		}
+		return n
+	}
+
+	chunks := []Chunk{}
+	for i := 0; i < len(units); {
+		j := i
+		for j+1 < len(units) && size(i, j+1) <= opts.Size {
+			j++
+		}
+		chunks = append(chunks, Chunk{
+			Text:  text[units[i].start:units[j].end],
+			Start: units[i].start,
+			End:   units[j].end,
+		})
+		if j == len(units)-1 {
+			break
+		}
+
+		// Repeat the last units, always moving forward and leaving room for
+		// the next unit
+		next := j + 1
+		for next-1 > i && size(next-1, j) <= opts.Overlap && size(next-1, j+1) <= opts.Size {
+			next--
+		}
+		i = next
+	}
+	return chunks
+}
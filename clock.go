@@ -0,0 +1,61 @@
+package xplatai
+
+import (
+	"context"
+	"time"
+)
+
+// Time source of the health polling, retry, hedging, circuit breaker, install
+// lock and previous server waits, swapped for a fake clock to test them
+// without waiting.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) clockTimer
+}
+
+type clockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) clockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (x *XpltAI) since(start time.Time) time.Duration {
+	return x.clock.Now().Sub(start)
+}
+
+// Sleeps for d on the handle's clock, returning early with the context's
+// error when it is done first.
+func (x *XpltAI) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-x.clock.After(d):
+		return nil
+	}
+}
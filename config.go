@@ -0,0 +1,229 @@
+package xplatai
+
+import "time"
+
+const (
+	NUMA_NONE       = ""
+	NUMA_DISTRIBUTE = "distribute"
+	NUMA_ISOLATE    = "isolate"
+	NUMA_NUMACTL    = "numactl"
+)
+
+// Chat templates built into llama.cpp, see Config.ChatTemplate.
+const (
+	CHAT_TEMPLATE_CHATML     = "chatml"
+	CHAT_TEMPLATE_LLAMA2     = "llama2"
+	CHAT_TEMPLATE_LLAMA3     = "llama3"
+	CHAT_TEMPLATE_MISTRAL_V7 = "mistral-v7"
+	CHAT_TEMPLATE_GEMMA      = "gemma"
+	CHAT_TEMPLATE_PHI3       = "phi3"
+	CHAT_TEMPLATE_VICUNA     = "vicuna"
+	CHAT_TEMPLATE_ZEPHYR     = "zephyr"
+)
+
+type Config struct {
+	// Hugging Face reference, local .gguf path or alias registered with RegisterModel
+	HFModel string
+	Port    string
+
+	// Checked before the model is downloaded or loaded, nil allows every model.
+	// Set it with SetDefaults to also cover PreFetchModel.
+	ModelPolicy ModelPolicy
+
+	// Context size in tokens, 0 uses the model's setting or the server default.
+	ContextSize int
+
+	// Binary flavor to launch, see InstalledFlavors. nil picks the fastest
+	// installed one. Falls back to the CPU flavor when it fails to start.
+	Hardware *HostHardware
+
+	// Fails NewWithConfig with ErrContextTooLarge when ContextSize exceeds the
+	// context the model was trained with, instead of clamping it.
+	StrictContext bool
+
+	// RoPE frequency scaling factor, extends the usable context beyond the
+	// training context. 0 keeps the model's setting.
+	RopeScale float64
+
+	// Threads used for generation, 0 uses the package default.
+	Threads int
+
+	// Layers offloaded to the GPU, nil offloads all of them and 0 runs on the
+	// CPU only.
+	GPULayers *int
+
+	// Arguments passed to llama-server after the ones derived from the other
+	// fields, for options the package doesn't cover. Later arguments override
+	// earlier ones.
+	ExtraArgs []string
+
+	// Working directory of the spawned server, relative paths in ExtraArgs and
+	// SlotSavePath resolve against it. Empty uses the current directory.
+	WorkDir string
+
+	// Number of requests the server processes in parallel, each slot gets
+	// ContextSize / ParallelSlots tokens of context. 0 uses the server default.
+	ParallelSlots int
+
+	// Chat and completion requests sent to the server at once, the others wait
+	// in line instead of being rejected as busy. 0 uses ParallelSlots when set
+	// and doesn't limit otherwise. Hedged duplicates don't count.
+	MaxConcurrentRequests int
+
+	// Continuous batching, nil enables it when ParallelSlots > 1 and leaves the
+	// server default otherwise.
+	ContinuousBatching *bool
+
+	// NUMA strategy, one of the NUMA_ constants. Only useful on multi-socket
+	// CPU machines.
+	Numa string
+
+	// Threads serving http requests, 0 uses the server default.
+	ThreadsHTTP int
+
+	// Minimum chunk size in tokens the server tries to reuse from its cache
+	// through KV shifting when a prompt only partially matches, 0 disables it.
+	// Check CachedTokens on detailed results to confirm reuse happens.
+	CacheReuse int
+
+	// Scheduling priority of the spawned server, from -20 (highest) to 19
+	// (lowest) like nice(1). Mapped to priority classes on Windows.
+	Nice int
+
+	// Cores the spawned server may run on, empty allows all of them. Not
+	// enforced on macOS, which has no affinity API.
+	CPUAffinity []int
+
+	// Caps the memory of the spawned server, 0 means unlimited. Limits the
+	// address space on Linux, which GPU drivers reserve plenty of, and the
+	// committed memory on Windows. Not enforced on macOS.
+	MemoryLimitBytes int64
+
+	// Kills a server left running by a previous process instead of waiting for
+	// it to exit. Servers running the same model on the same port are adopted
+	// either way.
+	TakeOver bool
+
+	// Lets installs and New stop a server spawned from the install dir when it
+	// holds binaries an upgrade replaces, which Windows refuses to overwrite.
+	// Otherwise the upgrade waits for the server to exit, see ErrUpgradePending.
+	// Set it with SetDefaults to also cover DownloadRequirements and ExecutePlan.
+	StopForUpgrade bool
+
+	// Interface the spawned server listens on, defaults to 127.0.0.1.
+	// Requests are sent to it unless it is a wildcard address.
+	BindHost string
+
+	// Loopback address requests use when BindHost is a wildcard address or
+	// localhost. Empty tries both 127.0.0.1 and ::1 and keeps whichever answers.
+	LoopbackHost string
+
+	// Renders chat templates with the model's own Jinja template instead of
+	// the built-in ones, required for tool calling.
+	Jinja bool
+
+	// Chat template replacing the model's own, by name of a llama.cpp
+	// built-in template like CHAT_TEMPLATE_CHATML, or as Jinja source when
+	// Jinja is set. See Session.SetChatFormat to change it per session.
+	ChatTemplate string
+	// File holding the Jinja source of the chat template, mutually exclusive
+	// with ChatTemplate.
+	ChatTemplateFile string
+
+	// Serves embeddings, see Embed. Embedding models usually can't chat.
+	Embeddings bool
+
+	// Disables the web UI llama-server serves by default.
+	DisableWebUI bool
+
+	// Path prefix the server endpoints live under, defaults to "/".
+	BasePath string
+
+	// Longest time a request waits for the server to become available before
+	// failing with ErrServerNotReady. 0 fails immediately when not ready.
+	FirstRequestWait time.Duration
+
+	// Makes NewWithConfig wait up to this long for the model to load, then
+	// generate a token so the first request doesn't pay for loading. The
+	// server is killed when either fails. 0 returns as soon as the server
+	// spawned.
+	Warmup time.Duration
+
+	// Applied to every request, request options and presets override it.
+	DefaultGenOptions GenOptions
+
+	// Retries of requests that got no answer, see SetRetryPolicy to change it
+	// at runtime. The zero value disables retries.
+	Retry RetryPolicy
+
+	// Directory llama-server saves slot prompt caches to, Shutdown saves every
+	// slot there before terminating the server.
+	SlotSavePath string
+
+	// Streaming replies are journaled to this directory while generating so
+	// they can be recovered with RecoverJournal after a crash. Empty disables it.
+	JournalDir string
+
+	// Applied in order to the content of every chat and completion result,
+	// streamed replies only once fully assembled. See the built-ins in
+	// postprocess.go.
+	PostProcessors []func(string) string
+
+	// Attaches the rendered prompt to detailed results. Prompts can be large
+	// and contain sensitive data, only enable it for debugging.
+	DebugPrompts bool
+
+	// Fails the first request with ErrOptionIgnored when it sets options the
+	// server doesn't know, catching version skew with older llama.cpp builds.
+	StrictOptions bool
+
+	// Sends a second identical request when a non-streaming request got no
+	// response after this long, keeping whichever finishes first. Only used
+	// with ParallelSlots > 1, for requests with a Seed or marked Idempotent.
+	// 0 disables hedging.
+	HedgeAfter time.Duration
+
+	// Opens the circuit breaker after this many consecutive requests failed
+	// on the server or the connection to it, failing further requests with
+	// ErrCircuitOpen until a probe request succeeds. Cancelled and invalid
+	// requests don't count. 0 disables the breaker.
+	CircuitFailures int
+
+	// Time the breaker stays open before letting a probe request through, 0
+	// uses DEFAULT_CIRCUIT_COOLDOWN.
+	CircuitCooldown time.Duration
+
+	// Caps each chat request to this many tokens and continues preempted
+	// replies once other conversations had their turn. 0 disables slicing.
+	TimeSlice int
+
+	// Largest response body, or streamed reply text, a request accepts before
+	// failing with ErrResponseTooLarge. 0 uses DEFAULT_MAX_RESPONSE_BYTES.
+	MaxResponseBytes int64
+
+	// Largest prompt CompleteFrom reads before failing with ErrPromptTooLarge.
+	// 0 uses DEFAULT_MAX_PROMPT_BYTES.
+	MaxPromptBytes int64
+
+	// Keeps the complete response body on detailed chat results, up to this
+	// many bytes. 0 disables it.
+	MaxRawResponseBytes int
+
+	// Applied to prompts, replies and paths before they are written to
+	// journals or embedded in events and errors, nil keeps them as is. See
+	// HashingRedactor.
+	Redactor Redactor
+
+	// Called on server lifecycle changes, see the EVENT_ constants.
+	OnLifecycle func(LifecycleEvent)
+
+	// Bandwidth cap for the models NewWithConfig downloads, 0 keeps the
+	// package-wide cap of SetMaxDownloadBytesPerSec. Other handles and
+	// downloads are not affected.
+	MaxDownloadBytesPerSec int64
+
+	// Replace the real clock and server process in tests, nil uses the real
+	// ones.
+	clock   clock
+	newProc procFactory
+}
@@ -0,0 +1,93 @@
+package xplatai
+
+import "slices"
+
+// Adjusts the configuration New starts the server with.
+type Option func(*Config)
+
+func WithThreads(n int) Option {
+	return func(cfg *Config) {
+		cfg.Threads = n
+	}
+}
+
+// Context size in tokens, see Config.ContextSize.
+func WithContextSize(tokens int) Option {
+	return func(cfg *Config) {
+		cfg.ContextSize = tokens
+	}
+}
+
+// Requests the server processes at once, see Config.ParallelSlots. Requests
+// beyond that wait in line, see Config.MaxConcurrentRequests.
+func WithParallelSlots(n int) Option {
+	return func(cfg *Config) {
+		cfg.ParallelSlots = n
+	}
+}
+
+// Layers offloaded to the GPU, 0 runs on the CPU only.
+func WithGPULayers(n int) Option {
+	return func(cfg *Config) {
+		cfg.GPULayers = Ptr(n)
+	}
+}
+
+// Arguments passed to llama-server after the ones the package sets, see
+// Config.ExtraArgs.
+func WithExtraArgs(args ...string) Option {
+	return func(cfg *Config) {
+		cfg.ExtraArgs = append(cfg.ExtraArgs, args...)
+	}
+}
+
+// Working directory of the spawned server, see Config.WorkDir.
+func WithWorkDir(dir string) Option {
+	return func(cfg *Config) {
+		cfg.WorkDir = dir
+	}
+}
+
+// Serves embeddings, see Config.Embeddings.
+func WithEmbeddings() Option {
+	return func(cfg *Config) {
+		cfg.Embeddings = true
+	}
+}
+
+// Renders chat templates with the model's own Jinja template, see
+// Config.Jinja.
+func WithJinja() Option {
+	return func(cfg *Config) {
+		cfg.Jinja = true
+	}
+}
+
+// Chat template replacing the model's own, see Config.ChatTemplate.
+func WithChatTemplate(template string) Option {
+	return func(cfg *Config) {
+		cfg.ChatTemplate = template
+	}
+}
+
+// Retry policy of the handle's requests, see Config.Retry.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(cfg *Config) {
+		cfg.Retry = policy
+	}
+}
+
+// Applies any other configuration change.
+func WithConfig(update func(cfg *Config)) Option {
+	return Option(update)
+}
+
+func applyOptions(cfg Config, opts []Option) Config {
+	cfg.ExtraArgs = slices.Clone(cfg.ExtraArgs)
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&cfg)
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,218 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Chat history bound to a handle.
+type Conversation struct {
+	ai *XpltAI
+
+	mu       sync.Mutex
+	messages []Message
+	// Replies replaced by Regenerate, by message index
+	alternatives map[int][]string
+	branches     []*Conversation
+	// Length of the parent history the conversation branched from
+	branchedAt int
+	// Turns the history into the messages sent, nil sends it as is. Called
+	// with mu held.
+	prepare func(ctx context.Context, msgs []Message, opts GenOptions) ([]Message, error)
+	// Generates the replies, nil uses the server's chat template. Called with
+	// mu held.
+	generate func(ctx context.Context, req chatRequest) (ChatResponse, error)
+}
+
+func (x *XpltAI) NewConversation(systemPrompt string) *Conversation {
+	c := &Conversation{ai: x, alternatives: map[int][]string{}}
+	if systemPrompt != "" {
+		c.messages = append(c.messages, Message{Role: "system", Content: systemPrompt})
+	}
+	return c
+}
+
+func (c *Conversation) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.messages)
+}
+
+// Sends a user message and appends the reply to the history.
+func (c *Conversation) Say(text string, opts GenOptions) (ChatResponse, error) {
+	return c.SayContext(context.Background(), text, opts)
+}
+
+// Say that stops generating when ctx is done, closing the request so the
+// server frees the slot. The history is then left unchanged.
+func (c *Conversation) SayContext(ctx context.Context, text string, opts GenOptions) (ChatResponse, error) {
+	return c.say(ctx, text, opts, nil)
+}
+
+// Streams the reply when onDelta is set.
+func (c *Conversation) say(ctx context.Context, text string, opts GenOptions, onDelta func(string)) (ChatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msgs := append(slices.Clone(c.messages), Message{Role: "user", Content: text})
+
+	prompt, err := c.prompt(ctx, msgs, opts)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	resp, err := c.chat(ctx, chatRequest{key: c, messages: prompt, opts: opts, onDelta: onDelta})
+	if err != nil {
+		return resp, err
+	}
+
+	c.messages = append(msgs, Message{Role: "assistant", Content: resp.Content})
+	return resp, nil
+}
+
+func (c *Conversation) prompt(ctx context.Context, msgs []Message, opts GenOptions) ([]Message, error) {
+	if c.prepare == nil {
+		return msgs, nil
+	}
+	return c.prepare(ctx, msgs, opts)
+}
+
+func (c *Conversation) chat(ctx context.Context, req chatRequest) (ChatResponse, error) {
+	if c.generate == nil {
+		return c.ai.doChat(ctx, req)
+	}
+	return c.generate(ctx, req)
+}
+
+// Generates a new reply to the last user message, replacing the last reply.
+// The replaced reply is kept in Alternatives.
+func (c *Conversation) Regenerate(opts GenOptions) (ChatResponse, error) {
+	return c.RegenerateContext(context.Background(), opts)
+}
+
+// Regenerate that stops generating when ctx is done, keeping the last reply.
+func (c *Conversation) RegenerateContext(ctx context.Context, opts GenOptions) (ChatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := len(c.messages) - 1
+	if last < 0 || c.messages[last].Role != "assistant" {
+		return ChatResponse{}, errors.New("conversation does not end with a reply")
+	}
+
+	prompt, err := c.prompt(ctx, c.messages[:last], opts)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	resp, err := c.chat(ctx, chatRequest{key: c, messages: prompt, opts: opts})
+	if err != nil {
+		return resp, err
+	}
+
+	// Branches may share the history, never modify it in place
+	c.alternatives[last] = append(c.alternatives[last], c.messages[last].Content)
+	c.messages = append(slices.Clone(c.messages[:last]), Message{Role: "assistant", Content: resp.Content})
+	return resp, nil
+}
+
+// Replies Regenerate replaced at message index, oldest first.
+func (c *Conversation) Alternatives(index int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.alternatives[index])
+}
+
+// Starts a new conversation from the first fromIndex messages, leaving this
+// one untouched. fromIndex is clamped to the history. The branch shares the
+// history until either one changes, and is scheduled as a conversation of its
+// own since its cached prompt diverges.
+func (c *Conversation) Branch(fromIndex int) *Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fromIndex = min(max(fromIndex, 0), len(c.messages))
+
+	b := &Conversation{
+		ai:           c.ai,
+		messages:     slices.Clip(c.messages[:fromIndex]),
+		alternatives: map[int][]string{},
+		branchedAt:   fromIndex,
+	}
+	for i, alts := range c.alternatives {
+		if i < fromIndex {
+			b.alternatives[i] = slices.Clone(alts)
+		}
+	}
+
+	c.branches = append(c.branches, b)
+	return b
+}
+
+// Conversations branched from this one, in creation order.
+func (c *Conversation) Branches() []*Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.branches)
+}
+
+type conversationState struct {
+	Messages     []Message           `json:"messages"`
+	Alternatives map[int][]string    `json:"alternatives,omitempty"`
+	BranchedAt   int                 `json:"branched_at,omitempty"`
+	Branches     []conversationState `json:"branches,omitempty"`
+}
+
+func (c *Conversation) state() conversationState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stateLocked()
+}
+
+func (c *Conversation) stateLocked() conversationState {
+	s := conversationState{
+		Messages:     slices.Clone(c.messages),
+		Alternatives: c.alternatives,
+		BranchedAt:   c.branchedAt,
+	}
+	for _, b := range c.branches {
+		s.Branches = append(s.Branches, b.state())
+	}
+	return s
+}
+
+// Serializes the history with its alternatives and branches, restore it with
+// LoadConversation.
+func (c *Conversation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.state())
+}
+
+// Restores a conversation serialized with json.Marshal.
+func (x *XpltAI) LoadConversation(data []byte) (*Conversation, error) {
+	s := conversationState{}
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("json parsing failure, %w", err)
+	}
+	return x.restoreConversation(s), nil
+}
+
+func (x *XpltAI) restoreConversation(s conversationState) *Conversation {
+	c := &Conversation{
+		ai:           x,
+		messages:     s.Messages,
+		alternatives: s.Alternatives,
+		branchedAt:   s.BranchedAt,
+	}
+	if c.alternatives == nil {
+		c.alternatives = map[int][]string{}
+	}
+	for _, b := range s.Branches {
+		c.branches = append(c.branches, x.restoreConversation(b))
+	}
+	return c
+}
@@ -0,0 +1,67 @@
+package xplatai
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	defaultsMut    sync.RWMutex
+	handlesCreated atomic.Bool
+)
+
+var defaultConfig = Config{
+	HFModel:          DEFAULT_HF_MODEL,
+	Threads:          6,
+	FirstRequestWait: DEFAULT_FIRST_REQUEST_WAIT,
+	// Stop is left unset so the model family's stops are used
+	DefaultGenOptions: GenOptions{
+		MaxTokens: 150,
+	},
+}
+
+// Returns a copy of the package defaults, used by New and to fill unset
+// fields in NewWithConfig.
+func DefaultConfig() Config {
+	defaultsMut.RLock()
+	defer defaultsMut.RUnlock()
+
+	cfg := defaultConfig
+	cfg.DefaultGenOptions.Stop = slices.Clone(cfg.DefaultGenOptions.Stop)
+	return cfg
+}
+
+// Overrides the package defaults, must be called before any handle is created.
+func SetDefaults(cfg Config) error {
+	if handlesCreated.Load() {
+		return errors.New("defaults cannot change once a handle was created")
+	}
+
+	if cfg.HFModel == "" {
+		return errors.New("default model is empty")
+	}
+	if cfg.Threads <= 0 {
+		return errors.New("default thread count must be positive")
+	}
+	if cfg.FirstRequestWait < 0 {
+		return errors.New("default first request wait is negative")
+	}
+	if cfg.DefaultGenOptions.MaxTokens <= 0 {
+		return errors.New("default max tokens must be positive")
+	}
+	if cfg.DefaultGenOptions.Preset != "" {
+		if _, ok := PresetOptions(cfg.DefaultGenOptions.Preset); !ok {
+			return errors.New("unknown preset: " + cfg.DefaultGenOptions.Preset)
+		}
+	}
+
+	cfg.DefaultGenOptions.Stop = slices.Clone(cfg.DefaultGenOptions.Stop)
+
+	defaultsMut.Lock()
+	defer defaultsMut.Unlock()
+
+	defaultConfig = cfg
+	return nil
+}
@@ -0,0 +1,76 @@
+package xplatai
+
+import (
+	"slices"
+	"testing"
+)
+
+// Lets the test change the package defaults, restoring them afterwards.
+func resetDefaults(t *testing.T) {
+	t.Helper()
+	saved := DefaultConfig()
+	created := handlesCreated.Load()
+	handlesCreated.Store(false)
+	t.Cleanup(func() {
+		handlesCreated.Store(false)
+		err := SetDefaults(saved)
+		if err != nil {
+			t.Error(err)
+		}
+		handlesCreated.Store(created)
+	})
+}
+
+func TestNewUsesOverriddenDefaultModel(t *testing.T) {
+	fakeInstall(t)
+	resetDefaults(t)
+
+	defaults := DefaultConfig()
+	defaults.HFModel = "other/model-GGUF:Q8_0"
+	err := SetDefaults(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	procs := &fakeProcs{}
+	x, err := New("", "18080", func(cfg *Config) {
+		cfg.clock = newFakeClock()
+		cfg.newProc = procs.new
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	args := procs.last().args
+	i := slices.Index(args, "-hf")
+	if i < 0 || i+1 >= len(args) || args[i+1] != "other/model-GGUF:Q8_0" {
+		t.Errorf("server started with %v, want -hf other/model-GGUF:Q8_0", args)
+	}
+
+	if SetDefaults(defaults) == nil {
+		t.Error("defaults changed after a handle was created")
+	}
+}
+
+func TestSetDefaultsValidates(t *testing.T) {
+	resetDefaults(t)
+
+	tests := []struct {
+		name  string
+		apply func(cfg *Config)
+	}{
+		{"empty model", func(cfg *Config) { cfg.HFModel = "" }},
+		{"no threads", func(cfg *Config) { cfg.Threads = 0 }},
+		{"negative wait", func(cfg *Config) { cfg.FirstRequestWait = -1 }},
+		{"no max tokens", func(cfg *Config) { cfg.DefaultGenOptions.MaxTokens = 0 }},
+		{"unknown preset", func(cfg *Config) { cfg.DefaultGenOptions.Preset = "nope" }},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		tt.apply(&cfg)
+		if SetDefaults(cfg) == nil {
+			t.Errorf("%s accepted", tt.name)
+		}
+	}
+}
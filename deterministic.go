@@ -0,0 +1,35 @@
+package xplatai
+
+import "sync/atomic"
+
+// Seed requests use in deterministic mode when they don't set one.
+const DETERMINISTIC_SEED = 42
+
+var deterministic atomic.Bool
+
+// Makes generation reproducible for tests and regression suites: requests
+// sample greedily (temperature 0) with a fixed seed and without reusing the
+// prompt cache, and handles created afterwards run a single slot, as batching
+// requests together changes the numerics. Takes effect immediately for
+// requests.
+func SetDeterministic(enabled bool) {
+	deterministic.Store(enabled)
+}
+
+func Deterministic() bool {
+	return deterministic.Load()
+}
+
+// Pins the options that make replies depend on more than the prompt.
+func pinDeterministic(opts GenOptions) GenOptions {
+	if !deterministic.Load() {
+		return opts
+	}
+
+	opts.Temperature = Ptr(0.0)
+	opts.CachePrompt = Ptr(false)
+	if opts.Seed == nil {
+		opts.Seed = Ptr(DETERMINISTIC_SEED)
+	}
+	return opts
+}
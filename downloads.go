@@ -0,0 +1,306 @@
+package xplatai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every download goes through a cache in the install dir keyed by url and
+// expected digest, so fetching an asset again needs no network and
+// interrupted downloads resume. Entries without a digest are revalidated
+// with their ETag when the server is reachable.
+const downloadCacheDirName = "downloads"
+
+type downloadMeta struct {
+	Url  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+	// Set once the download completed
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+func downloadCachePath(url string, digest string) (string, error) {
+	key := url
+	if digest != "" {
+		key += "\x00" + strings.ToLower(digest)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return installPath(downloadCacheDirName, hex.EncodeToString(sum[:16]))
+}
+
+func readDownloadMeta(entryPath string) (downloadMeta, bool) {
+	meta := downloadMeta{}
+	b, err := os.ReadFile(entryPath + ".json")
+	if err != nil {
+		return meta, false
+	}
+	return meta, json.Unmarshal(b, &meta) == nil
+}
+
+func writeDownloadMeta(entryPath string, meta downloadMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(entryPath+".json", b, 0644)
+}
+
+// Downloads url to dst, returning its size and sha256. digest is the expected
+// sha256, empty when unknown. progress is called with the bytes downloaded so
+// far and the total size, -1 when unknown.
+func downloadFile(ctx context.Context, url string, dst string, digest string, progress func(done int64, total int64)) (int64, string, error) {
+	entryPath, err := downloadCachePath(url, digest)
+	if err != nil {
+		return 0, "", err
+	}
+	err = os.MkdirAll(filepath.Dir(entryPath), 0755)
+	if err != nil {
+		return 0, "", err
+	}
+
+	meta, ok := readDownloadMeta(entryPath)
+	info, statErr := os.Stat(entryPath)
+	cached := ok && meta.SHA256 != "" && statErr == nil && info.Size() == meta.Size
+	if cached && digest == "" && meta.ETag != "" {
+		// Nothing else tells whether the content changed since
+		changed, err := contentChanged(ctx, url, meta.ETag)
+		if err != nil && ctx.Err() != nil {
+			return 0, "", err
+		}
+		// Unreachable servers leave the cached copy as the best there is
+		cached = err != nil || !changed
+	}
+	if cached && (digest == "" || strings.EqualFold(meta.SHA256, digest)) {
+		// Purges go by last use
+		now := time.Now()
+		os.Chtimes(entryPath, now, now)
+		progress(meta.Size, meta.Size)
+	} else {
+		meta, err = fetchToCache(ctx, url, entryPath, progress)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if digest != "" && !strings.EqualFold(meta.SHA256, digest) {
+		os.Remove(entryPath)
+		os.Remove(entryPath + ".json")
+		return 0, "", fmt.Errorf("%s: checksum mismatch, expected %s, got %s", url, digest, meta.SHA256)
+	}
+	return meta.Size, meta.SHA256, linkOrCopy(entryPath, dst)
+}
+
+// Asks the server whether url still has the content tagged etag.
+func contentChanged(ctx context.Context, url string, etag string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("If-None-Match", etag)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	// The body is only read once it changed, by fetchToCache
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return true, nil
+}
+
+// Downloads url into the cache entry, resuming a previous partial download
+// when the server still serves the same content.
+func fetchToCache(ctx context.Context, url string, entryPath string, progress func(done int64, total int64)) (downloadMeta, error) {
+	partPath := entryPath + ".part"
+
+	os.Remove(entryPath)
+	meta, _ := readDownloadMeta(entryPath)
+	if meta.Url != url {
+		meta = downloadMeta{Url: url}
+	}
+
+	offset := int64(0)
+	if info, err := os.Stat(partPath); err == nil && meta.ETag != "" {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return meta, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		req.Header.Set("If-Range", meta.ETag)
+	}
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return meta, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	total := resp.ContentLength
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+		if total >= 0 {
+			total += offset
+		}
+	case resp.StatusCode < 300:
+		// The content changed or the server can't resume, start over
+		flags |= os.O_TRUNC
+		offset = 0
+	default:
+		errMsg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			os.Remove(partPath)
+		}
+		return meta, errors.New(string(errMsg))
+	}
+
+	meta = downloadMeta{Url: url, ETag: resp.Header.Get("ETag")}
+	err = writeDownloadMeta(entryPath, meta)
+	if err != nil {
+		return meta, err
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+
+	buff := make([]byte, 32*1024)
+	body := contextDownloadLimiter(ctx).reader(resp.Body)
+	done := offset
+
+	for {
+		n, err := body.Read(buff)
+		if n > 0 {
+			_, err2 := f.Write(buff[:n])
+			if err2 != nil {
+				return meta, err2
+			}
+			done += int64(n)
+			progress(done, total)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return meta, err
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return meta, err
+	}
+
+	// Hash the whole file, a resumed download only streamed its tail
+	meta.Size, meta.SHA256, err = hashFile(partPath)
+	if err != nil {
+		return meta, err
+	}
+	err = os.Rename(partPath, entryPath)
+	if err != nil {
+		return meta, err
+	}
+	return meta, writeDownloadMeta(entryPath, meta)
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Hard links keep large models from taking twice the space, copies cover
+// file systems without them.
+func linkOrCopy(src string, dst string) error {
+	os.Remove(dst)
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Deletes cached downloads not used for olderThan, 0 deletes all of them.
+// Installed binaries and models are unaffected.
+func PurgeDownloadCache(olderThan time.Duration) error {
+	dirPath, err := installPath(downloadCacheDirName)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		entryPath := filepath.Join(dirPath, strings.TrimSuffix(e.Name(), ".part"))
+		err = os.Remove(filepath.Join(dirPath, e.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		os.Remove(entryPath + ".json")
+	}
+	return nil
+}
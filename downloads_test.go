@@ -0,0 +1,243 @@
+package xplatai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var downloadContent = bytes.Repeat([]byte("0123456789abcdef"), 8*1024)
+
+func downloadDigest() string {
+	sum := sha256.Sum256(downloadContent)
+	return hex.EncodeToString(sum[:])
+}
+
+// Server serving downloadContent with an ETag and range support. The first
+// cutFirst requests are cut off half way through.
+func assetServer(requests *atomic.Int32, ranges *atomic.Int32, cutFirst int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if n <= cutFirst {
+			w.Header().Set("Content-Length", strconv.Itoa(len(downloadContent)))
+			w.Write(downloadContent[:len(downloadContent)/2])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "asset.bin", time.Time{}, bytes.NewReader(downloadContent))
+	}))
+}
+
+func downloadTo(t *testing.T, url string, name string, digest string) error {
+	t.Helper()
+	dst := filepath.Join(t.TempDir(), name)
+	_, sum, err := downloadFile(context.Background(), url, dst, digest, func(int64, int64) {})
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, downloadContent) || sum != downloadDigest() {
+		t.Fatalf("got %d bytes hashing to %s, want the asset", len(b), sum)
+	}
+	return nil
+}
+
+func TestSecondDownloadNeedsNoNetwork(t *testing.T) {
+	t.Chdir(t.TempDir())
+	requests, ranges := atomic.Int32{}, atomic.Int32{}
+	srv := assetServer(&requests, &ranges, 0)
+	url := srv.URL + "/asset.bin"
+
+	for _, digest := range []string{downloadDigest(), ""} {
+		err := downloadTo(t, url, "first.bin", digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing to connect to anymore, not even to revalidate
+	srv.Close()
+	for _, digest := range []string{downloadDigest(), ""} {
+		err := downloadTo(t, url, "second.bin", digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want 2", requests.Load())
+	}
+}
+
+// Server serving version of its content, tagged with the version.
+func versionedServer(version *atomic.Int32, notModified *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := strconv.Itoa(int(version.Load()))
+		etag := `"v` + v + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("version " + v))
+	}))
+}
+
+func TestDownloadWithoutDigestRevalidates(t *testing.T) {
+	t.Chdir(t.TempDir())
+	version, notModified := atomic.Int32{}, atomic.Int32{}
+	version.Store(1)
+	srv := versionedServer(&version, &notModified)
+	defer srv.Close()
+
+	download := func() string {
+		t.Helper()
+		dst := filepath.Join(t.TempDir(), "asset.bin")
+		_, _, err := downloadFile(context.Background(), srv.URL, dst, "", func(int64, int64) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if got := download(); got != "version 1" {
+		t.Fatalf("got %q", got)
+	}
+	if got := download(); got != "version 1" || notModified.Load() != 1 {
+		t.Errorf("got %q after %d revalidations, want the cached copy checked once", got, notModified.Load())
+	}
+
+	version.Store(2)
+	if got := download(); got != "version 2" {
+		t.Errorf("got %q once the content changed, want version 2", got)
+	}
+}
+
+func TestDigestIsPartOfCacheKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+	requests, ranges := atomic.Int32{}, atomic.Int32{}
+	srv := assetServer(&requests, &ranges, 0)
+	url := srv.URL + "/asset.bin"
+
+	err := downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another digest for the same url is another entry
+	err = downloadTo(t, url, "asset.bin", "00")
+	if err == nil {
+		t.Fatal("download with the wrong checksum succeeded")
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want the other digest fetched", requests.Load())
+	}
+
+	// The mismatch left the entry of the right digest alone
+	srv.Close()
+	err = downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterruptedDownloadResumes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	requests, ranges := atomic.Int32{}, atomic.Int32{}
+	srv := assetServer(&requests, &ranges, 1)
+	defer srv.Close()
+	url := srv.URL + "/asset.bin"
+
+	err := downloadTo(t, url, "asset.bin", downloadDigest())
+	if err == nil {
+		t.Fatal("cut off download succeeded")
+	}
+	err = downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 || ranges.Load() != 1 {
+		t.Errorf("%d requests with %d ranges, want the second to resume", requests.Load(), ranges.Load())
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	requests, ranges := atomic.Int32{}, atomic.Int32{}
+	srv := assetServer(&requests, &ranges, 0)
+	defer srv.Close()
+	url := srv.URL + "/asset.bin"
+
+	err := downloadTo(t, url, "asset.bin", "00")
+	if err == nil {
+		t.Fatal("download with the wrong checksum succeeded")
+	}
+
+	// The bad entry is gone, not served from the cache
+	err = downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want 2", requests.Load())
+	}
+}
+
+func TestPurgeDownloadCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	requests, ranges := atomic.Int32{}, atomic.Int32{}
+	srv := assetServer(&requests, &ranges, 0)
+	defer srv.Close()
+	url := srv.URL + "/asset.bin"
+
+	err := downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries used recently are kept
+	err = PurgeDownloadCache(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("%d requests, want the entry kept by the purge", requests.Load())
+	}
+
+	err = PurgeDownloadCache(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = downloadTo(t, url, "asset.bin", downloadDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want the entry purged", requests.Load())
+	}
+}
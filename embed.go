@@ -0,0 +1,63 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Embeds texts with the loaded model, one vector per text in input order.
+// Needs a server started with Config.Embeddings, fails with
+// ErrCapabilityMissing otherwise.
+func (x *XpltAI) Embed(texts []string) ([][]float32, error) {
+	return x.EmbedContext(context.Background(), texts)
+}
+
+func (x *XpltAI) EmbedContext(ctx context.Context, texts []string) (vectors [][]float32, err error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	ctx, end, err := x.beginRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = end(err) }()
+
+	_, err = x.waitReady(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]any{
+		"input":           texts,
+		"encoding_format": "float",
+	}
+	respData := struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}{}
+
+	err = x.doJSON(ctx, "POST", "/v1/embeddings", data, &respData)
+	if isCapabilityMissing(err) {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCapabilityMissing, CAP_EMBEDDINGS, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	vectors = make([][]float32, len(texts))
+	for _, d := range respData.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, errors.New("json parsing failure, invalid index field")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for _, v := range vectors {
+		if v == nil {
+			return nil, errors.New("json parsing failure, missing embedding")
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,92 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Anything that can serve chat and completion requests, implemented by XpltAI
+// and by the combinators of this package.
+type Engine interface {
+	ChatDetailed(messages []Message, opts GenOptions) (ChatResponse, error)
+	CompleteDetailed(prompt string, opts GenOptions) (CompleteResponse, error)
+}
+
+const (
+	BACKEND_PRIMARY   = "primary"
+	BACKEND_SECONDARY = "secondary"
+)
+
+// Decides whether a failed request is retried on the secondary engine.
+type FallbackPolicy func(err error) bool
+
+// Fails over when the primary can't be reached, isn't ready or has a server
+// side failure. Rejected requests and cancellations are returned as is since
+// the secondary would fail the same way.
+func DefaultFallbackPolicy(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrServerNotReady) ||
+		errors.Is(err, ErrShuttingDown) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var srvErr *ServerError
+	if errors.As(err, &srvErr) {
+		return srvErr.StatusCode >= 500 && ClassifyError(err) != FAILURE_REQUEST_TOO_LONG
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+type fallbackEngine struct {
+	primary   Engine
+	secondary Engine
+	policy    FallbackPolicy
+}
+
+// Serves requests from primary, retrying them on secondary when policy
+// allows it. Results are annotated with the backend that served them.
+func Fallback(primary Engine, secondary Engine, policy FallbackPolicy) Engine {
+	if policy == nil {
+		policy = DefaultFallbackPolicy
+	}
+	return &fallbackEngine{primary: primary, secondary: secondary, policy: policy}
+}
+
+func (f *fallbackEngine) ChatDetailed(messages []Message, opts GenOptions) (ChatResponse, error) {
+	resp, err := f.primary.ChatDetailed(messages, opts)
+	if err == nil {
+		resp.Backend = BACKEND_PRIMARY
+		return resp, nil
+	}
+	if !f.policy(err) {
+		return resp, err
+	}
+
+	resp, err = f.secondary.ChatDetailed(messages, opts)
+	resp.Backend = BACKEND_SECONDARY
+	return resp, err
+}
+
+func (f *fallbackEngine) CompleteDetailed(prompt string, opts GenOptions) (CompleteResponse, error) {
+	resp, err := f.primary.CompleteDetailed(prompt, opts)
+	if err == nil {
+		resp.Backend = BACKEND_PRIMARY
+		return resp, nil
+	}
+	if !f.policy(err) {
+		return resp, err
+	}
+
+	resp, err = f.secondary.CompleteDetailed(prompt, opts)
+	resp.Backend = BACKEND_SECONDARY
+	return resp, err
+}
@@ -0,0 +1,183 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var (
+	ErrNotInstalled        = errors.New("could not find llama.cpp binaries, run DownloadRequirements to fix")
+	ErrUnsupportedPlatform = errors.New("unsupported platform")
+	ErrServerNotReady      = errors.New("timed out while waiting for llama.cpp")
+	ErrLoadTimeout         = errors.New("time out")
+	ErrModelFetch          = errors.New("failed to fetch model")
+	ErrResponseTooLarge    = errors.New("response exceeds the maximum response size")
+	ErrPromptTooLarge      = errors.New("prompt exceeds the maximum prompt size")
+	ErrServerBusy          = errors.New("server has no free slot or kv cache space")
+)
+
+// Error reported by llama-server, either as a json error object or a raw body.
+type ServerError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+const (
+	STAGE_RESOLVE = "resolve config"
+	STAGE_LOCATE  = "locate binaries"
+	// Fetches models pinned to a revision, checks local models exist
+	STAGE_MODEL = "check model cache"
+	STAGE_SPAWN = "spawn server"
+)
+
+// Wraps an error from NewWithConfig with the construction stage that failed.
+type StageError struct {
+	Stage string
+	Err   error
+	// Every stage up to and including the failed one
+	Timings []PhaseTiming
+}
+
+func (e *StageError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+type FailureClass int
+
+const (
+	FAILURE_UNKNOWN FailureClass = iota
+	FAILURE_NOT_INSTALLED
+	FAILURE_MODEL_NOT_DOWNLOADED
+	FAILURE_STARTING
+	FAILURE_OUT_OF_MEMORY
+	FAILURE_REQUEST_TOO_LONG
+	FAILURE_QUARANTINED
+	FAILURE_BUSY
+	FAILURE_INSTALLING
+	FAILURE_UPGRADE_PENDING
+	FAILURE_SHUTTING_DOWN
+)
+
+var failureNames = map[FailureClass]string{
+	FAILURE_UNKNOWN:              "unknown error",
+	FAILURE_NOT_INSTALLED:        "engine not installed",
+	FAILURE_MODEL_NOT_DOWNLOADED: "model not downloaded",
+	FAILURE_STARTING:             "engine starting, try again",
+	FAILURE_OUT_OF_MEMORY:        "out of memory",
+	FAILURE_REQUEST_TOO_LONG:     "request too long",
+	FAILURE_QUARANTINED:          "engine blocked by antivirus",
+	FAILURE_BUSY:                 "engine busy, try again",
+	FAILURE_INSTALLING:           "engine being installed, try again",
+	FAILURE_UPGRADE_PENDING:      "engine update pending, restart to apply",
+	FAILURE_SHUTTING_DOWN:        "engine shutting down",
+}
+
+func (c FailureClass) String() string {
+	name, ok := failureNames[c]
+	if !ok {
+		return failureNames[FAILURE_UNKNOWN]
+	}
+	return name
+}
+
+// Maps any error returned by the package to a user-facing failure class,
+// errors that can't be classified map to FAILURE_UNKNOWN.
+func ClassifyError(err error) FailureClass {
+	if err == nil {
+		return FAILURE_UNKNOWN
+	}
+
+	switch {
+	case errors.Is(err, ErrBinaryQuarantined):
+		return FAILURE_QUARANTINED
+	case errors.Is(err, ErrNotInstalled),
+		errors.Is(err, ErrToolMissing):
+		return FAILURE_NOT_INSTALLED
+	case errors.Is(err, ErrInstallLocked):
+		return FAILURE_INSTALLING
+	case errors.Is(err, ErrUpgradePending):
+		return FAILURE_UPGRADE_PENDING
+	case errors.Is(err, ErrShuttingDown):
+		return FAILURE_SHUTTING_DOWN
+	case errors.Is(err, ErrServerBusy),
+		errors.Is(err, ErrServerRunning):
+		return FAILURE_BUSY
+	case errors.Is(err, ErrPromptTooLarge),
+		errors.Is(err, ErrContextTooLarge):
+		return FAILURE_REQUEST_TOO_LONG
+	case errors.Is(err, ErrModelFetch),
+		errors.Is(err, ErrQuantNotFound):
+		return FAILURE_MODEL_NOT_DOWNLOADED
+	case errors.Is(err, ErrServerNotReady),
+		errors.Is(err, ErrLoadTimeout),
+		errors.Is(err, ErrCircuitOpen),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return FAILURE_STARTING
+	}
+
+	var srvErr *ServerError
+	if errors.As(err, &srvErr) {
+		return classifyServerError(srvErr)
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && !errors.Is(err, context.Canceled) {
+		return FAILURE_STARTING
+	}
+
+	if isOutOfMemoryMessage(err.Error()) {
+		return FAILURE_OUT_OF_MEMORY
+	}
+	return FAILURE_UNKNOWN
+}
+
+func classifyServerError(err *ServerError) FailureClass {
+	msg := strings.ToLower(err.Message)
+
+	switch {
+	case err.Type == "exceed_context_size_error",
+		strings.Contains(msg, "context size"),
+		strings.Contains(msg, "input is too large"),
+		strings.Contains(msg, "too many tokens"):
+		return FAILURE_REQUEST_TOO_LONG
+	case isBusyError(err):
+		return FAILURE_BUSY
+	case isOutOfMemoryMessage(msg):
+		return FAILURE_OUT_OF_MEMORY
+	case err.StatusCode == 503,
+		strings.Contains(msg, "loading model"):
+		return FAILURE_STARTING
+	}
+	return FAILURE_UNKNOWN
+}
+
+func isOutOfMemoryMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "out of memory") ||
+		strings.Contains(msg, "failed to allocate") ||
+		strings.Contains(msg, "unable to allocate")
+}
+
+// Rejections that go away once other requests complete and free their slot
+// or KV cache cells.
+func isBusyError(err *ServerError) bool {
+	msg := strings.ToLower(err.Message)
+	return strings.Contains(msg, "kv cache is full") ||
+		strings.Contains(msg, "failed to find free space in the kv cache") ||
+		strings.Contains(msg, "failed to find a memory slot") ||
+		strings.Contains(msg, "no free slot") ||
+		strings.Contains(msg, "no slot available") ||
+		strings.Contains(msg, "all slots are busy")
+}
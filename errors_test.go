@@ -0,0 +1,117 @@
+package xplatai
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Class of every exported error of the package, wrapped like callers get them.
+var errorClasses = map[string]struct {
+	err   error
+	class FailureClass
+}{
+	"ErrNotInstalled":        {ErrNotInstalled, FAILURE_NOT_INSTALLED},
+	"ErrToolMissing":         {ErrToolMissing, FAILURE_NOT_INSTALLED},
+	"ErrUnsupportedPlatform": {ErrUnsupportedPlatform, FAILURE_UNKNOWN},
+	"ErrGitHubRateLimited":   {ErrGitHubRateLimited, FAILURE_UNKNOWN},
+	"ErrInstallLocked":       {ErrInstallLocked, FAILURE_INSTALLING},
+	"ErrUpgradePending":      {ErrUpgradePending, FAILURE_UPGRADE_PENDING},
+	"ErrBinaryQuarantined":   {ErrBinaryQuarantined, FAILURE_QUARANTINED},
+	"ErrModelFetch":          {ErrModelFetch, FAILURE_MODEL_NOT_DOWNLOADED},
+	"ErrQuantNotFound":       {ErrQuantNotFound, FAILURE_MODEL_NOT_DOWNLOADED},
+	"ErrModelRejected":       {ErrModelRejected, FAILURE_UNKNOWN},
+	"ErrServerNotReady":      {ErrServerNotReady, FAILURE_STARTING},
+	"ErrLoadTimeout":         {ErrLoadTimeout, FAILURE_STARTING},
+	"ErrCircuitOpen":         {ErrCircuitOpen, FAILURE_STARTING},
+	"ErrServerBusy":          {ErrServerBusy, FAILURE_BUSY},
+	"ErrServerRunning":       {ErrServerRunning, FAILURE_BUSY},
+	"ErrShuttingDown":        {ErrShuttingDown, FAILURE_SHUTTING_DOWN},
+	"ErrPromptTooLarge":      {ErrPromptTooLarge, FAILURE_REQUEST_TOO_LONG},
+	"ErrContextTooLarge":     {ErrContextTooLarge, FAILURE_REQUEST_TOO_LONG},
+	"ErrResponseTooLarge":    {ErrResponseTooLarge, FAILURE_UNKNOWN},
+	"ErrCapabilityMissing":   {ErrCapabilityMissing, FAILURE_UNKNOWN},
+	"ErrOptionIgnored":       {ErrOptionIgnored, FAILURE_UNKNOWN},
+	"ErrInvalidJSON":         {ErrInvalidJSON, FAILURE_UNKNOWN},
+	"ErrInterrupted":         {ErrInterrupted, FAILURE_UNKNOWN},
+	"ErrToolRoundLimit":      {ErrToolRoundLimit, FAILURE_UNKNOWN},
+	"ErrToolNotAllowed":      {ErrToolNotAllowed, FAILURE_UNKNOWN},
+	"ErrToolExists":          {ErrToolExists, FAILURE_UNKNOWN},
+}
+
+func TestClassifyErrorCoversEveryError(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	declared := []string{}
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if strings.HasPrefix(name.Name, "Err") {
+						declared = append(declared, name.Name)
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(declared)
+
+	listed := slices.Sorted(maps.Keys(errorClasses))
+	if !slices.Equal(declared, listed) {
+		t.Fatalf("errors declared %v, classified %v", declared, listed)
+	}
+
+	for name, c := range errorClasses {
+		wrapped := fmt.Errorf("%w: details", c.err)
+		if got := ClassifyError(wrapped); got != c.class {
+			t.Errorf("%s classified as %q, want %q", name, got, c.class)
+		}
+		if got := ClassifyError(&StageError{Stage: STAGE_SPAWN, Err: c.err}); got != c.class {
+			t.Errorf("%s in a StageError classified as %q, want %q", name, got, c.class)
+		}
+	}
+}
+
+func TestClassifyServerErrors(t *testing.T) {
+	tests := []struct {
+		err   *ServerError
+		class FailureClass
+	}{
+		{&ServerError{StatusCode: 400, Type: "exceed_context_size_error", Message: "request exceeds the available context size"}, FAILURE_REQUEST_TOO_LONG},
+		{&ServerError{StatusCode: 500, Message: "input is too large to process. increase the physical batch size"}, FAILURE_REQUEST_TOO_LONG},
+		{&ServerError{StatusCode: 503, Message: "Loading model"}, FAILURE_STARTING},
+		{&ServerError{StatusCode: 500, Message: "failed to find free space in the KV cache"}, FAILURE_BUSY},
+		{&ServerError{StatusCode: 500, Message: "CUDA error: out of memory"}, FAILURE_OUT_OF_MEMORY},
+		{&ServerError{StatusCode: 500, Message: "unexpected"}, FAILURE_UNKNOWN},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.class {
+			t.Errorf("%q classified as %q, want %q", tt.err.Message, got, tt.class)
+		}
+	}
+	if ClassifyError(errors.New("ggml: failed to allocate buffer")) != FAILURE_OUT_OF_MEMORY {
+		t.Error("allocation failure not classified as out of memory")
+	}
+}
@@ -0,0 +1,119 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_SPAWNED = "spawned"
+	// NewWithConfig reused a server left running by a previous process
+	EVENT_ADOPTED = "adopted"
+	EVENT_READY   = "ready"
+	EVENT_CLOSED  = "closed"
+	// The preferred binary flavor failed and the CPU flavor is used instead
+	EVENT_DOWNGRADE = "downgrade"
+	// A startup stage completed or failed, see StartupTimings
+	EVENT_PHASE = "phase"
+	// The circuit breaker opened, let a probe request through or closed again,
+	// see Config.CircuitFailures
+	EVENT_CIRCUIT_OPEN      = "circuit_open"
+	EVENT_CIRCUIT_HALF_OPEN = "circuit_half_open"
+	EVENT_CIRCUIT_CLOSED    = "circuit_closed"
+)
+
+type LifecycleEvent struct {
+	Type   string
+	Time   time.Time
+	Detail string
+	// Set on EVENT_PHASE
+	Phase *PhaseTiming
+}
+
+func (x *XpltAI) emit(eventType string, detail string) {
+	if x.onLifecycle == nil {
+		return
+	}
+	x.onLifecycle(LifecycleEvent{Type: eventType, Time: time.Now(), Detail: detail})
+}
+
+// Version of the JSON event schema, bumped on any incompatible change.
+const JSON_EVENT_VERSION = 1
+
+// One JSON object per line, fields not relevant to an event type are omitted:
+//
+//	{"v":1,"type":"download","done":123,"total":456}
+//	{"v":1,"type":"model_fetch","done":123,"total":456}
+//	{"v":1,"type":"load","phase":"Loading","fraction":0.5}
+//	{"v":1,"type":"token","text":"Hello"}
+//	{"v":1,"type":"lifecycle","event":"ready","detail":"","time":"2006-01-02T15:04:05Z"}
+type jsonEvent struct {
+	V        int        `json:"v"`
+	Type     string     `json:"type"`
+	Done     *int64     `json:"done,omitempty"`
+	Total    *int64     `json:"total,omitempty"`
+	Phase    string     `json:"phase,omitempty"`
+	Fraction *float64   `json:"fraction,omitempty"`
+	Text     *string    `json:"text,omitempty"`
+	Event    string     `json:"event,omitempty"`
+	Detail   *string    `json:"detail,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+}
+
+// Writes progress, load, token and lifecycle callbacks as JSON lines, its
+// methods can be passed directly as the package's callbacks.
+type JSONEventWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+func NewJSONEventWriter(w io.Writer) *JSONEventWriter {
+	return &JSONEventWriter{w: w}
+}
+
+func (j *JSONEventWriter) write(ev jsonEvent) {
+	ev.V = JSON_EVENT_VERSION
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.err != nil {
+		return
+	}
+	_, j.err = j.w.Write(append(b, '\n'))
+}
+
+// First write error encountered, events are dropped after it.
+func (j *JSONEventWriter) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+func (j *JSONEventWriter) Download(done int64, total int64) {
+	j.write(jsonEvent{Type: "download", Done: &done, Total: &total})
+}
+
+func (j *JSONEventWriter) ModelFetch(done int64, total int64) {
+	j.write(jsonEvent{Type: "model_fetch", Done: &done, Total: &total})
+}
+
+func (j *JSONEventWriter) Load(phase string, fraction float64) {
+	j.write(jsonEvent{Type: "load", Phase: phase, Fraction: &fraction})
+}
+
+func (j *JSONEventWriter) Token(text string) {
+	j.write(jsonEvent{Type: "token", Text: &text})
+}
+
+func (j *JSONEventWriter) Lifecycle(ev LifecycleEvent) {
+	j.write(jsonEvent{Type: "lifecycle", Event: ev.Type, Detail: &ev.Detail, Time: &ev.Time})
+}
@@ -0,0 +1,66 @@
+package xplatai
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Exact lines host apps parse, changing any of them needs a JSON_EVENT_VERSION
+// bump.
+func TestJSONEventSchema(t *testing.T) {
+	out := bytes.Buffer{}
+	j := NewJSONEventWriter(&out)
+
+	j.Download(123, 456)
+	j.Download(0, -1)
+	j.ModelFetch(7, 8)
+	j.Load(PHASE_LOADING, 0.5)
+	j.Load(PHASE_STARTING, 0)
+	j.Token("Hello \"world\"\n")
+	j.Token("")
+	j.Lifecycle(LifecycleEvent{Type: EVENT_READY, Time: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)})
+	j.Lifecycle(LifecycleEvent{Type: EVENT_DOWNGRADE, Detail: "cuda -> cpu", Time: time.Date(2006, 1, 2, 15, 4, 5, 500, time.FixedZone("", 3600))})
+
+	want := []string{
+		`{"v":1,"type":"download","done":123,"total":456}`,
+		`{"v":1,"type":"download","done":0,"total":-1}`,
+		`{"v":1,"type":"model_fetch","done":7,"total":8}`,
+		`{"v":1,"type":"load","phase":"` + PHASE_LOADING + `","fraction":0.5}`,
+		`{"v":1,"type":"load","phase":"` + PHASE_STARTING + `","fraction":0}`,
+		`{"v":1,"type":"token","text":"Hello \"world\"\n"}`,
+		`{"v":1,"type":"token","text":""}`,
+		`{"v":1,"type":"lifecycle","event":"ready","detail":"","time":"2006-01-02T15:04:05Z"}`,
+		`{"v":1,"type":"lifecycle","event":"downgrade","detail":"cuda -\u003e cpu","time":"2006-01-02T15:04:05.0000005+01:00"}`,
+	}
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(got), len(want), out.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d\ngot  %s\nwant %s", i, got[i], want[i])
+		}
+	}
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("disk full")
+}
+
+func TestJSONEventWriterStopsAfterError(t *testing.T) {
+	w := &failingWriter{}
+	j := NewJSONEventWriter(w)
+	j.Token("a")
+	j.Token("b")
+	if w.writes != 1 || j.Err() == nil {
+		t.Errorf("%d writes, error %v, want 1 write and the error kept", w.writes, j.Err())
+	}
+}
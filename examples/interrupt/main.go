@@ -0,0 +1,98 @@
+// Terminal chat that stops the reply on Ctrl+C and keeps the session going,
+// a second Ctrl+C within the grace period shuts the handle down. Runs against
+// a fake server streaming a slow reply, -demo interrupts it automatically.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	xplatai "github.com/wAIfu-DEV/Xplat-AI"
+)
+
+func fakeServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, word := range strings.Fields("this reply is long and slow so there is plenty of time to interrupt it") {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(150 * time.Millisecond):
+			}
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", word+" ")
+			flusher.Flush()
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	})
+	return httptest.NewServer(mux)
+}
+
+func main() {
+	demo := flag.Bool("demo", false, "send the prompt \"hello\" and interrupt the reply")
+	flag.Parse()
+
+	in := io.Reader(os.Stdin)
+	if *demo {
+		in = strings.NewReader("hello\n")
+	}
+	err := run(in, os.Stdout, *demo)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+// Chats with the fake server until in runs out. In demo mode every reply gets
+// a Ctrl+C a second after it starts.
+func run(in io.Reader, out io.Writer, demo bool) error {
+	srv := fakeServer()
+	defer srv.Close()
+
+	ai, err := xplatai.NewRemote(srv.URL)
+	if err != nil {
+		return err
+	}
+	defer ai.Close()
+
+	input := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !input.Scan() {
+			return nil
+		}
+
+		ctx, stop := ai.WithInterrupt(context.Background())
+		if demo {
+			time.AfterFunc(time.Second, func() {
+				self, _ := os.FindProcess(os.Getpid())
+				self.Signal(os.Interrupt)
+			})
+		}
+
+		resp, err := ai.ChatDetailedStreamContext(ctx, []xplatai.Message{{Role: "user", Content: input.Text()}}, xplatai.GenOptions{}, func(token string) {
+			fmt.Fprint(out, token)
+		})
+		stop()
+		fmt.Fprintln(out)
+
+		if err != nil {
+			return err
+		}
+		if resp.FinishReason == xplatai.FINISH_CANCELLED {
+			fmt.Fprintln(out, "[interrupted]")
+		}
+	}
+}
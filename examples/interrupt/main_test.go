@@ -0,0 +1,39 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const fullReply = "this reply is long and slow so there is plenty of time to interrupt it"
+
+func TestRunCompletesReply(t *testing.T) {
+	out := strings.Builder{}
+	err := run(strings.NewReader("hi\n"), &out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), fullReply) || strings.Contains(out.String(), "[interrupted]") {
+		t.Errorf("got output %q, want the full reply", out.String())
+	}
+}
+
+// Each Ctrl+C stops the reply being streamed and the chat goes on.
+func TestRunInterruptsReplies(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("os.Interrupt can't be sent on windows")
+	}
+
+	out := strings.Builder{}
+	err := run(strings.NewReader("hello\nagain\n"), &out, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "[interrupted]") != 2 {
+		t.Errorf("got output %q, want both replies interrupted", out.String())
+	}
+	if strings.Contains(out.String(), fullReply) || !strings.Contains(out.String(), "this reply") {
+		t.Errorf("got output %q, want partial replies", out.String())
+	}
+}
@@ -0,0 +1,311 @@
+package xplatai
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clock that only moves when told to, see advanceNext and runUntil.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+type fakeTimer struct {
+	clk *fakeClock
+	at  time.Time
+	ch  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clk: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.waiters = append(c.waiters, t)
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clk.mu.Lock()
+	defer t.clk.mu.Unlock()
+
+	i := slices.Index(t.clk.waiters, t)
+	if i < 0 {
+		return false
+	}
+	t.clk.waiters = slices.Delete(t.clk.waiters, i, i+1)
+	return true
+}
+
+// Moves the clock forward by d, firing the timers due by then.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+func (c *fakeClock) fire() {
+	kept := c.waiters[:0]
+	for _, t := range c.waiters {
+		if t.at.After(c.now) {
+			kept = append(kept, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.waiters = kept
+}
+
+// Moves the clock to the earliest pending timer and fires it, returning
+// false when no timer is pending.
+func (c *fakeClock) advanceNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.waiters) == 0 {
+		return false
+	}
+	next := c.waiters[0].at
+	for _, t := range c.waiters[1:] {
+		if t.at.Before(next) {
+			next = t.at
+		}
+	}
+	if next.After(c.now) {
+		c.now = next
+	}
+	c.fire()
+	return true
+}
+
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Blocks until n timers are pending, failing the test after a few seconds
+// of real time.
+func (c *fakeClock) waitPending(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.pending() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", c.pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Runs f in the background, firing every timer it waits on right away
+// until it returns. Returns the fake time f took.
+func (c *fakeClock) runUntil(t *testing.T, f func()) time.Duration {
+	t.Helper()
+	start := c.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		select {
+		case <-done:
+			return c.Now().Sub(start)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the fake clock to be used")
+		}
+		if !c.advanceNext() {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+}
+
+// Server process that never runs anything. It exits when killed, signaled,
+// or told to with exit.
+type fakeProc struct {
+	path   string
+	args   []string
+	dir    string
+	stderr io.Writer
+	limits procLimits
+	pid    int
+
+	startErr error
+
+	mu      sync.Mutex
+	started bool
+	exited  bool
+	signals []os.Signal
+	exitErr error
+	done    chan struct{}
+}
+
+func (p *fakeProc) Start() error {
+	if p.startErr != nil {
+		return p.startErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = true
+	return nil
+}
+
+func (p *fakeProc) Wait() error {
+	<-p.done
+	return p.exitErr
+}
+
+func (p *fakeProc) Kill() error {
+	p.exit(errors.New("signal: killed"))
+	return nil
+}
+
+func (p *fakeProc) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	p.signals = append(p.signals, sig)
+	p.mu.Unlock()
+	p.exit(errors.New("signal: " + sig.String()))
+	return nil
+}
+
+func (p *fakeProc) Pid() int {
+	return p.pid
+}
+
+// Makes the process exit with err, like a crash when it wasn't asked to.
+func (p *fakeProc) exit(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return
+	}
+	p.exited = true
+	p.exitErr = err
+	close(p.done)
+}
+
+func (p *fakeProc) running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.started && !p.exited
+}
+
+// Process factory handing out fake processes and keeping track of them.
+type fakeProcs struct {
+	mu    sync.Mutex
+	procs []*fakeProc
+	// Returned by Start of the processes created from now on
+	startErr error
+	// Pid of the processes created from now on, when set
+	pid int
+}
+
+func (f *fakeProcs) new(path string, args []string, dir string, stderr io.Writer, limits procLimits) procHandle {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := &fakeProc{
+		path:     path,
+		args:     args,
+		dir:      dir,
+		stderr:   stderr,
+		limits:   limits,
+		startErr: f.startErr,
+		// Above the largest pid Linux hands out, so no real process has it
+		pid:  1<<22 + 1 + len(f.procs),
+		done: make(chan struct{}),
+	}
+	if f.pid != 0 {
+		p.pid = f.pid
+	}
+	f.procs = append(f.procs, p)
+	return p
+}
+
+func (f *fakeProcs) last() *fakeProc {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.procs) == 0 {
+		return nil
+	}
+	return f.procs[len(f.procs)-1]
+}
+
+// Processes started and not exited yet.
+func (f *fakeProcs) running() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, p := range f.procs {
+		if p.running() {
+			n++
+		}
+	}
+	return n
+}
+
+// Moves the test to an empty directory with a fake install of the CPU
+// flavor, for NewWithConfig to find.
+func fakeInstall(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	serverPath, err := flavorPath(cpuFlavor(), "llama-server.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(serverPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(serverPath, []byte("fake"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Config spawning a fake process with a fake clock, the model is never
+// downloaded.
+func fakeConfig(clk *fakeClock, procs *fakeProcs) Config {
+	cfg := DefaultConfig()
+	cfg.HFModel = "test/model-GGUF:Q4_K_M"
+	cfg.Port = "18080"
+	cfg.clock = clk
+	cfg.newProc = procs.new
+	return cfg
+}
@@ -0,0 +1,108 @@
+package xplatai
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// Preference order when Config.Hardware is unset, fastest first.
+var flavorOrder = []HostHardware{HW_CUDA, HW_RADEON, HW_VULKAN, HW_CPU, HW_NONE}
+
+// Hardware of the release build for this platform, HW_NONE for the generic
+// build of platforms without dedicated CPU builds.
+func platformHardware(hardware HostHardware) HostHardware {
+	switch runtime.GOOS {
+	case "windows":
+		if hardware == HW_NONE {
+			return HW_CPU
+		}
+		return hardware
+	case "linux":
+		if hardware == HW_VULKAN {
+			return HW_VULKAN
+		}
+	}
+	return HW_NONE
+}
+
+// The flavor New falls back to when the preferred one fails.
+func cpuFlavor() HostHardware {
+	return platformHardware(HW_NONE)
+}
+
+// Each flavor is installed to its own subdirectory of the install dir.
+func flavorName(hardware HostHardware) string {
+	if name := hwNames[hardware]; name != "" {
+		return name
+	}
+	return "default"
+}
+
+func flavorPath(hardware HostHardware, elem ...string) (string, error) {
+	return installPath(append([]string{flavorName(hardware)}, elem...)...)
+}
+
+// Flavors with an installed server binary, in preference order.
+func InstalledFlavors() []HostHardware {
+	flavors := []HostHardware{}
+	for _, hw := range flavorOrder {
+		serverPath, err := flavorPath(hw, "llama-server.exe")
+		if err != nil {
+			continue
+		}
+		if exists, _ := isPathExist(serverPath); exists {
+			flavors = append(flavors, hw)
+		}
+	}
+	return flavors
+}
+
+// The preferred flavor if set, otherwise the best installed one.
+func pickFlavor(preferred *HostHardware) HostHardware {
+	if preferred != nil {
+		return platformHardware(*preferred)
+	}
+	if installed := InstalledFlavors(); len(installed) > 0 {
+		return installed[0]
+	}
+	return cpuFlavor()
+}
+
+// Path of a binary of the flavor. Installs made before flavors existed kept
+// their binaries at the root of the install dir.
+func flavorBinary(hardware HostHardware, name string) (string, error) {
+	binPath, err := flavorPath(hardware, name)
+	if err != nil {
+		return "", err
+	}
+	if exists, _ := isPathExist(binPath); exists {
+		return binPath, nil
+	}
+
+	legacyPath, err := installPath(name)
+	if err != nil {
+		return "", err
+	}
+	if exists, _ := isPathExist(legacyPath); exists {
+		return legacyPath, nil
+	}
+
+	if qErr := quarantineError(hardware, name, nil); qErr != nil {
+		return "", qErr
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotInstalled, binPath)
+}
+
+// Records the failed flavor and the fallback, reported by ModelInfo and as
+// an EVENT_DOWNGRADE lifecycle event.
+func (x *XpltAI) downgrade(from HostHardware, to HostHardware, err error) {
+	msg := fmt.Sprintf("%s flavor failed, falling back to %s: %v", flavorName(from), flavorName(to), err)
+	msg = x.redactInstallPaths(msg)
+	x.warnings = append(x.warnings, msg)
+	x.emit(EVENT_DOWNGRADE, msg)
+}
+
+func relInstallPath(elem ...string) string {
+	return filepath.ToSlash(filepath.Join(elem...))
+}
@@ -0,0 +1,84 @@
+package xplatai
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+const (
+	FORMAT_ALPACA = "alpaca"
+	FORMAT_CHATML = "chatml"
+	FORMAT_VICUNA = "vicuna"
+	// Pygmalion-2 and other Metharme fine-tunes, the default model included
+	FORMAT_METHARME  = "metharme"
+	FORMAT_PYGMALION = FORMAT_METHARME
+)
+
+var formatsMut sync.RWMutex
+
+var formats = map[string]ChatFormat{
+	FORMAT_ALPACA: {
+		System:    RoleFormat{Suffix: "\n\n"},
+		User:      RoleFormat{Prefix: "### Instruction:\n", Suffix: "\n\n"},
+		Assistant: RoleFormat{Prefix: "### Response:\n", Suffix: "\n\n"},
+		Stop:      []string{"### Instruction:"},
+	},
+	FORMAT_CHATML: {
+		System:    RoleFormat{Prefix: "<|im_start|>system\n", Suffix: "<|im_end|>\n"},
+		User:      RoleFormat{Prefix: "<|im_start|>user\n", Suffix: "<|im_end|>\n"},
+		Assistant: RoleFormat{Prefix: "<|im_start|>assistant\n", Suffix: "<|im_end|>\n"},
+		Stop:      []string{"<|im_end|>", "<|im_start|>"},
+	},
+	FORMAT_VICUNA: {
+		System:    RoleFormat{Suffix: "\n\n"},
+		User:      RoleFormat{Prefix: "USER: ", Suffix: "\n"},
+		Assistant: RoleFormat{Prefix: "ASSISTANT: ", Suffix: "</s>\n"},
+		Stop:      []string{"USER:"},
+	},
+	FORMAT_METHARME: {
+		System:    RoleFormat{Prefix: "<|system|>"},
+		User:      RoleFormat{Prefix: "<|user|>"},
+		Assistant: RoleFormat{Prefix: "<|model|>"},
+		Stop:      []string{"<|user|>", "<|system|>"},
+	},
+}
+
+// Registers a custom format, replacing any format with the same name.
+func RegisterChatFormat(name string, format ChatFormat) error {
+	if name == "" {
+		return errors.New("format name is empty")
+	}
+
+	formatsMut.Lock()
+	defer formatsMut.Unlock()
+
+	format.Stop = slices.Clone(format.Stop)
+	formats[name] = format
+	return nil
+}
+
+func ChatFormatByName(name string) (ChatFormat, bool) {
+	formatsMut.RLock()
+	defer formatsMut.RUnlock()
+
+	format, ok := formats[name]
+	format.Stop = slices.Clone(format.Stop)
+	return format, ok
+}
+
+// Renders a single exchange, for completions. An empty system prompt is left
+// out.
+func (f ChatFormat) Prompt(system string, user string) string {
+	messages := []Message{}
+	if system != "" {
+		messages = append(messages, Message{Role: "system", Content: system})
+	}
+	return f.Render(append(messages, Message{Role: "user", Content: user}))
+}
+
+// Returns opts stopping at the end of the model's turn.
+func (f ChatFormat) Options(opts GenOptions) GenOptions {
+	opts.Stop = slices.Concat(opts.Stop, f.Stop)
+	return opts
+}
@@ -0,0 +1,167 @@
+package xplatai
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	_GGUF_UINT8 uint32 = iota
+	_GGUF_INT8
+	_GGUF_UINT16
+	_GGUF_INT16
+	_GGUF_UINT32
+	_GGUF_INT32
+	_GGUF_FLOAT32
+	_GGUF_BOOL
+	_GGUF_STRING
+	_GGUF_ARRAY
+	_GGUF_UINT64
+	_GGUF_INT64
+	_GGUF_FLOAT64
+)
+
+// Sizes of the fixed size GGUF value types
+var ggufSizes = map[uint32]int64{
+	_GGUF_UINT8: 1, _GGUF_INT8: 1, _GGUF_BOOL: 1,
+	_GGUF_UINT16: 2, _GGUF_INT16: 2,
+	_GGUF_UINT32: 4, _GGUF_INT32: 4, _GGUF_FLOAT32: 4,
+	_GGUF_UINT64: 8, _GGUF_INT64: 8, _GGUF_FLOAT64: 8,
+}
+
+// Reads the string, string array and integer metadata of a .gguf file
+// header, other values are skipped.
+func readGGUFMetadata(path string) (map[string][]string, error) {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	header := struct {
+		Magic       [4]byte
+		Version     uint32
+		TensorCount uint64
+		KVCount     uint64
+	}{}
+	err = binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != "GGUF" {
+		return nil, errors.New("not a gguf file: " + path)
+	}
+	if header.Version < 2 {
+		return nil, fmt.Errorf("unsupported gguf version %d", header.Version)
+	}
+
+	meta := map[string][]string{}
+
+	for range header.KVCount {
+		key, err := readGGUFString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var valueType uint32
+		err = binary.Read(r, binary.LittleEndian, &valueType)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := readGGUFValue(r, valueType)
+		if err != nil {
+			return nil, fmt.Errorf("gguf key %s: %w", key, err)
+		}
+		if values != nil {
+			meta[key] = values
+		}
+	}
+	return meta, nil
+}
+
+// Returns the strings held by the value, nil for other types.
+func readGGUFValue(r *bufio.Reader, valueType uint32) ([]string, error) {
+	switch valueType {
+	case _GGUF_STRING:
+		s, err := readGGUFString(r)
+		return []string{s}, err
+
+	case _GGUF_ARRAY:
+		var elemType uint32
+		var count uint64
+		err := binary.Read(r, binary.LittleEndian, &elemType)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(r, binary.LittleEndian, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		if size, ok := ggufSizes[elemType]; ok {
+			_, err = r.Discard(int(size * int64(count)))
+			return nil, err
+		}
+		if elemType != _GGUF_STRING {
+			return nil, fmt.Errorf("unsupported gguf array type %d", elemType)
+		}
+
+		values := make([]string, 0, min(count, 1024))
+		for range count {
+			s, err := readGGUFString(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+
+	// Integers are kept in decimal form, other scalars are skipped
+	switch valueType {
+	case _GGUF_UINT32, _GGUF_INT32:
+		var n int32
+		err := binary.Read(r, binary.LittleEndian, &n)
+		if valueType == _GGUF_UINT32 {
+			return []string{strconv.FormatUint(uint64(uint32(n)), 10)}, err
+		}
+		return []string{strconv.FormatInt(int64(n), 10)}, err
+	case _GGUF_UINT64, _GGUF_INT64:
+		var n int64
+		err := binary.Read(r, binary.LittleEndian, &n)
+		if valueType == _GGUF_UINT64 {
+			return []string{strconv.FormatUint(uint64(n), 10)}, err
+		}
+		return []string{strconv.FormatInt(n, 10)}, err
+	}
+
+	size, ok := ggufSizes[valueType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported gguf value type %d", valueType)
+	}
+	_, err := r.Discard(int(size))
+	return nil, err
+}
+
+func readGGUFString(r *bufio.Reader) (string, error) {
+	var n uint64
+	err := binary.Read(r, binary.LittleEndian, &n)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<24 {
+		return "", errors.New("gguf string too long")
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return string(b), err
+}
@@ -0,0 +1,134 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrGitHubRateLimited = errors.New("github api rate limit exceeded")
+
+// Returned when the GitHub API rate limits the package. Downloads of the
+// pinned version fall back to urls that need no API access.
+type GitHubRateLimitError struct {
+	// When the limit resets, zero when GitHub didn't say
+	Reset time.Time
+}
+
+func (e *GitHubRateLimitError) Error() string {
+	if e.Reset.IsZero() {
+		return ErrGitHubRateLimited.Error()
+	}
+	return fmt.Sprintf("%s, resets at %s", ErrGitHubRateLimited, e.Reset.Format(time.RFC3339))
+}
+
+func (e *GitHubRateLimitError) Is(target error) bool {
+	return target == ErrGitHubRateLimited
+}
+
+// Longest Retry-After the client waits out before giving up.
+const githubMaxRetryAfter = 30 * time.Second
+
+var github = struct {
+	mu    sync.Mutex
+	token string
+	// Successful responses by path, kept for the process lifetime
+	cache map[string][]byte
+}{cache: map[string][]byte{}}
+
+// Authenticates GitHub API requests, which raises the rate limit. Defaults to
+// the GITHUB_TOKEN environment variable.
+func SetGitHubToken(token string) {
+	github.mu.Lock()
+	defer github.mu.Unlock()
+	github.token = token
+}
+
+func githubToken() string {
+	github.mu.Lock()
+	defer github.mu.Unlock()
+
+	if github.token != "" {
+		return github.token
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// Gets path from the GitHub API and decodes the json response into out.
+func githubGet(ctx context.Context, path string, out any) error {
+	github.mu.Lock()
+	body, ok := github.cache[path]
+	github.mu.Unlock()
+
+	if !ok {
+		var err error
+		body, err = githubFetch(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		github.mu.Lock()
+		github.cache[path] = body
+		github.mu.Unlock()
+	}
+	return json.Unmarshal(body, out)
+}
+
+func githubFetch(ctx context.Context, path string) ([]byte, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token := githubToken(); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode < 300 {
+			return body, nil
+		}
+
+		limited := resp.StatusCode == 429 ||
+			(resp.StatusCode == 403 && (resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""))
+		if !limited {
+			return nil, errors.New("github api: " + resp.Status)
+		}
+
+		// Secondary limits ask to retry after a short while
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err == nil && attempt < 3 && time.Duration(retryAfter)*time.Second <= githubMaxRetryAfter {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(retryAfter) * time.Second):
+			}
+			continue
+		}
+
+		rlErr := &GitHubRateLimitError{}
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			rlErr.Reset = time.Unix(reset, 0)
+		}
+		return nil, rlErr
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/wAIfu-DEV/Xplat-AI
 
 go 1.24.6
+
+require github.com/mattn/go-sqlite3 v1.14.52
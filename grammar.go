@@ -0,0 +1,47 @@
+package xplatai
+
+// Built-in GBNF grammars for GenOptions.Grammar.
+const (
+	// Any json value, objects and arrays included
+	GRAMMAR_JSON = `root   ::= value
+value  ::= object | array | string | number | ("true" | "false" | "null") ws
+
+object ::=
+  "{" ws (
+            string ":" ws value
+    ("," ws string ":" ws value)*
+  )? "}" ws
+
+array  ::=
+  "[" ws (
+            value
+    ("," ws value)*
+  )? "]" ws
+
+string ::=
+  "\"" (
+    [^"\\\x7F\x00-\x1F] |
+    "\\" (["\\bfnrt] | "u" [0-9a-fA-F]{4})
+  )* "\"" ws
+
+number ::= ("-"? ([0-9] | [1-9] [0-9]{0,15})) ("." [0-9]+)? ([eE] [-+]? [0-9] [1-9]{0,15})? ws
+
+ws ::= | " " | "\n" [ \t]{0,20}`
+
+	// "yes" or "no", lowercase
+	GRAMMAR_YES_NO = `root ::= "yes" | "no"`
+
+	// A "- " bulleted list, one item per line
+	GRAMMAR_LIST = `root ::= item+
+item ::= "- " [^\n]+ "\n"`
+
+	// A "1. " numbered list, one item per line
+	GRAMMAR_NUMBERED_LIST = `root ::= item+
+item ::= [0-9]+ ". " [^\n]+ "\n"`
+)
+
+// Options constraining the output to a GBNF grammar, either one of the
+// GRAMMAR_ constants or a custom one.
+func WithGrammar(grammar string) GenOptions {
+	return GenOptions{Grammar: grammar}
+}
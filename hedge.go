@@ -0,0 +1,89 @@
+package xplatai
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Counters of a handle since it was created.
+type Stats struct {
+	// Second requests sent because the first was slow, see Config.HedgeAfter
+	HedgesFired int64
+	// Hedges that finished before the request they duplicated
+	HedgesWon int64
+	// Requests the server rejected for lack of a free slot or KV cache space,
+	// retried or not
+	BusyRejections int64
+	// Requests that had to wait for Config.MaxConcurrentRequests
+	Queued int64
+}
+
+type handleStats struct {
+	hedgesFired    atomic.Int64
+	hedgesWon      atomic.Int64
+	busyRejections atomic.Int64
+	queued         atomic.Int64
+}
+
+func (x *XpltAI) Stats() Stats {
+	return Stats{
+		HedgesFired:    x.stats.hedgesFired.Load(),
+		HedgesWon:      x.stats.hedgesWon.Load(),
+		BusyRejections: x.stats.busyRejections.Load(),
+		Queued:         x.stats.queued.Load(),
+	}
+}
+
+// Duplicating a request costs tokens, only requests replying the same either
+// way are hedged, and only when a free slot can take the duplicate.
+func (x *XpltAI) canHedge(opts GenOptions) bool {
+	return x.hedgeAfter > 0 && x.parallelSlots > 1 && (opts.Seed != nil || opts.Idempotent)
+}
+
+// Runs call, and a second time when the first run takes longer than the
+// handle's hedge delay. Returns the first successful result and cancels the
+// other run.
+func hedge[T any](x *XpltAI, ctx context.Context, opts GenOptions, call func(ctx context.Context) (T, error)) (T, error) {
+	if !x.canHedge(opts) {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		value T
+		err   error
+		hedge bool
+	}
+	results := make(chan outcome, 2)
+	run := func(hedge bool) {
+		value, err := call(ctx)
+		results <- outcome{value, err, hedge}
+	}
+
+	go run(false)
+	pending := 1
+
+	timer := x.clock.NewTimer(x.hedgeAfter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C():
+			x.stats.hedgesFired.Add(1)
+			pending++
+			go run(true)
+		case r := <-results:
+			pending--
+			// The other run may still succeed
+			if r.err != nil && pending > 0 {
+				continue
+			}
+			if r.hedge && r.err == nil {
+				x.stats.hedgesWon.Add(1)
+			}
+			return r.value, r.err
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package xplatai
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgeFiresAfterDelay(t *testing.T) {
+	clk := newFakeClock()
+	x := &XpltAI{clock: clk, hedgeAfter: time.Second, parallelSlots: 2}
+
+	calls := atomic.Int32{}
+	done := make(chan string, 1)
+	go func() {
+		value, _ := hedge(x, context.Background(), GenOptions{Idempotent: true}, func(ctx context.Context) (string, error) {
+			if calls.Add(1) == 1 {
+				// The slow request only ends once the hedge won
+				<-ctx.Done()
+				return "", ctx.Err()
+			}
+			return "hedge", nil
+		})
+		done <- value
+	}()
+
+	clk.waitPending(t, 1)
+	if calls.Load() > 1 {
+		t.Fatal("hedge fired before its delay")
+	}
+	clk.Advance(time.Second)
+
+	if value := <-done; value != "hedge" {
+		t.Errorf("got %q, want the hedge's result", value)
+	}
+	stats := x.Stats()
+	if stats.HedgesFired != 1 || stats.HedgesWon != 1 {
+		t.Errorf("got %+v, want one hedge fired and won", stats)
+	}
+}
+
+func TestHedgeSkipsNonIdempotentRequests(t *testing.T) {
+	x := &XpltAI{clock: newFakeClock(), hedgeAfter: time.Second, parallelSlots: 2}
+	if x.canHedge(GenOptions{}) {
+		t.Error("requests without a seed are hedged")
+	}
+}
@@ -0,0 +1,263 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQuantNotFound = errors.New("quant not found in the model repository")
+
+// A model downloaded by FetchModel.
+type CachedModel struct {
+	Repo     string `json:"repo"`
+	Quant    string `json:"quant,omitempty"`
+	Revision string `json:"revision"`
+	// Commit the revision resolved to when the model was fetched
+	Commit string `json:"commit"`
+	File   string `json:"file"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+const modelCacheDirName = "models"
+
+var modelCacheMut sync.Mutex
+
+func modelCachePath(elem ...string) (string, error) {
+	return installPath(append([]string{modelCacheDirName}, elem...)...)
+}
+
+func readModelCache() ([]CachedModel, error) {
+	cachePath, err := modelCachePath("cache.json")
+	if err != nil {
+		return nil, err
+	}
+
+	models := []CachedModel{}
+
+	b, err := os.ReadFile(cachePath)
+	if os.IsNotExist(err) {
+		return models, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &models)
+	if err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func writeModelCache(models []CachedModel) error {
+	cachePath, err := modelCachePath("cache.json")
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(models, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cachePath, b, 0644)
+}
+
+// Models downloaded by FetchModel, with the commit each one was fetched at.
+func ListCachedModels() ([]CachedModel, error) {
+	modelCacheMut.Lock()
+	defer modelCacheMut.Unlock()
+
+	return readModelCache()
+}
+
+// The cached model fetched for spec, if any.
+func cachedModel(spec ModelSpec) (CachedModel, bool) {
+	modelCacheMut.Lock()
+	models, err := readModelCache()
+	modelCacheMut.Unlock()
+	if err != nil {
+		return CachedModel{}, false
+	}
+
+	repo, quant := splitHFRef(spec)
+	for _, m := range models {
+		if m.Repo == repo && strings.EqualFold(m.Quant, quant) && (m.Revision == spec.Revision || m.Commit == spec.Revision) {
+			if exists, _ := isPathExist(m.Path); exists {
+				return m, true
+			}
+		}
+	}
+	return CachedModel{}, false
+}
+
+func splitHFRef(spec ModelSpec) (string, string) {
+	repo, quant, _ := strings.Cut(spec.HFRef(), ":")
+	return repo, quant
+}
+
+// Downloads a Hugging Face model at the revision the reference pins, e.g.
+// "user/repo:Q4_K_M@v1.0", or at main when it pins none. Models already
+// fetched at that revision are not downloaded again.
+func FetchModel(ref string, progress func(done int64, total int64)) (CachedModel, error) {
+	spec, err := ResolveModel(ref)
+	if err != nil {
+		return CachedModel{}, err
+	}
+	if spec.IsLocal() {
+		return CachedModel{}, errors.New("model reference is a local file: " + ref)
+	}
+	if progress == nil {
+		progress = func(int64, int64) {}
+	}
+	return fetchModel(context.Background(), spec, progress)
+}
+
+func fetchModel(ctx context.Context, spec ModelSpec, progress func(done int64, total int64)) (CachedModel, error) {
+	if m, ok := cachedModel(spec); ok {
+		return m, nil
+	}
+
+	repo, quant := splitHFRef(spec)
+	revision := spec.Revision
+	if revision == "" {
+		revision = "main"
+	}
+
+	commit, file, err := resolveRevision(repo, quant, revision)
+	if err != nil {
+		return CachedModel{}, err
+	}
+
+	dirPath, err := modelCachePath(strings.ReplaceAll(repo, "/", "_"), commit)
+	if err != nil {
+		return CachedModel{}, err
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return CachedModel{}, err
+	}
+
+	// Downloads to a temporary name so interrupted downloads aren't mistaken
+	// for complete ones
+	modelPath := filepath.Join(dirPath, filepath.Base(file))
+	url := "https://huggingface.co/" + repo + "/resolve/" + commit + "/" + file
+	size, sha, err := downloadFile(ctx, url, modelPath+".part", "", progress)
+	if err != nil {
+		os.Remove(modelPath + ".part")
+		return CachedModel{}, fmt.Errorf("%w: %w", ErrModelFetch, err)
+	}
+	err = os.Rename(modelPath+".part", modelPath)
+	if err != nil {
+		return CachedModel{}, err
+	}
+
+	m := CachedModel{
+		Repo:     repo,
+		Quant:    quant,
+		Revision: revision,
+		Commit:   commit,
+		File:     file,
+		Path:     modelPath,
+		Size:     size,
+		SHA256:   sha,
+	}
+
+	modelCacheMut.Lock()
+	defer modelCacheMut.Unlock()
+
+	models, err := readModelCache()
+	if err != nil {
+		return m, err
+	}
+	models = slices.DeleteFunc(models, func(c CachedModel) bool {
+		return c.Repo == m.Repo && strings.EqualFold(c.Quant, m.Quant) && c.Revision == m.Revision
+	})
+	return m, writeModelCache(append(models, m))
+}
+
+// Resolves revision to a commit and finds the file of quant at that commit.
+func resolveRevision(repo string, quant string, revision string) (string, string, error) {
+	info := struct {
+		Sha      string `json:"sha"`
+		Siblings []struct {
+			Rfilename string `json:"rfilename"`
+		} `json:"siblings"`
+	}{}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get("https://huggingface.co/api/models/" + repo + "/revision/" + revision)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrModelFetch, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", "", fmt.Errorf("%w: %s at revision %s: %s", ErrModelFetch, repo, revision, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return "", "", fmt.Errorf("json parsing failure, %w", err)
+	}
+
+	files := []string{}
+	for _, s := range info.Siblings {
+		files = append(files, s.Rfilename)
+	}
+	file, err := pickQuantFile(files, quant)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s at revision %s", err, repo, revision)
+	}
+	return info.Sha, file, nil
+}
+
+// Picks the .gguf file of quant, defaulting to Q4_K_M like llama-server, then
+// to the only .gguf file of the repository.
+func pickQuantFile(files []string, quant string) (string, error) {
+	ggufs := []string{}
+	for _, f := range files {
+		// Multimodal projectors ship alongside the model
+		if strings.HasSuffix(strings.ToLower(f), ".gguf") && !strings.Contains(strings.ToLower(f), "mmproj") {
+			ggufs = append(ggufs, f)
+		}
+	}
+
+	want := quant
+	if want == "" {
+		want = "Q4_K_M"
+	}
+	for _, f := range ggufs {
+		if strings.Contains(strings.ToUpper(filepath.Base(f)), strings.ToUpper(want)) {
+			return f, nil
+		}
+	}
+	if quant == "" && len(ggufs) == 1 {
+		return ggufs[0], nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrQuantNotFound, want)
+}
+
+// llama-server's -hf flag always fetches from the main branch, models pinned
+// to a revision are fetched directly and run from the local file instead.
+func pinRevision(ctx context.Context, spec ModelSpec) (ModelSpec, error) {
+	if spec.Revision == "" || spec.IsLocal() {
+		return spec, nil
+	}
+
+	m, err := fetchModel(ctx, spec, func(int64, int64) {})
+	if err != nil {
+		return spec, err
+	}
+	spec.Source = m.Path
+	spec.Quant = ""
+	return spec, nil
+}
@@ -0,0 +1,36 @@
+package xplatai
+
+import (
+	"context"
+	"fmt"
+)
+
+// Generates the text between prefix and suffix, e.g. the code at a cursor.
+// Needs a model trained for fill-in-the-middle, fails with
+// ErrCapabilityMissing otherwise. The content is returned untrimmed.
+func (x *XpltAI) Infill(prefix string, suffix string, maxTokens int) (string, error) {
+	resp, err := x.InfillContext(context.Background(), prefix, suffix, GenOptions{MaxTokens: maxTokens})
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
+
+func (x *XpltAI) InfillContext(ctx context.Context, prefix string, suffix string, opts GenOptions) (CompleteResponse, error) {
+	req := completionRequest{
+		endpoint: "/infill",
+		prompt:   "",
+		extra: map[string]any{
+			"input_prefix": prefix,
+			"input_suffix": suffix,
+		},
+		extraText: prefix + suffix,
+		raw:       true,
+	}
+
+	resp, err := x.doComplete(ctx, req, opts)
+	if isCapabilityMissing(err) {
+		return resp, fmt.Errorf("%w: %s: %w", ErrCapabilityMissing, CAP_INFILL, err)
+	}
+	return resp, err
+}
@@ -0,0 +1,114 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInstallLocked = errors.New("another process is installing llama.cpp")
+
+// Download progress reports this total while waiting for another process to
+// finish installing.
+const PROGRESS_INSTALL_LOCKED int64 = -2
+
+const (
+	installLockFileName       = "install.lock"
+	DEFAULT_INSTALL_LOCK_WAIT = 10 * time.Minute
+)
+
+var installLockWait atomic.Int64
+
+func init() {
+	installLockWait.Store(int64(DEFAULT_INSTALL_LOCK_WAIT))
+}
+
+// Longest time an install waits for one running in another process before
+// failing with ErrInstallLocked. 0 fails immediately.
+func SetInstallLockWait(d time.Duration) {
+	installLockWait.Store(int64(max(d, 0)))
+}
+
+// Returned by tryLockFile when another process holds the lock.
+var errLockHeld = errors.New("lock held")
+
+// Advisory lock on a file in the install dir, held for the whole install. The
+// OS releases it when the holding process dies, so a lock file left behind by
+// a crash is simply taken over.
+type installLock struct {
+	f *os.File
+}
+
+func acquireInstallLock(ctx context.Context, clk clock, progress func(done int64, total int64)) (*installLock, error) {
+	dirPath, err := installPath()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dirPath, installLockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := clk.Now().Add(time.Duration(installLockWait.Load()))
+
+	for {
+		err = tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLockHeld) {
+			f.Close()
+			return nil, err
+		}
+
+		if clk.Now().After(deadline) {
+			holder := readLockHolder(f)
+			f.Close()
+			return nil, fmt.Errorf("%w: %s", ErrInstallLocked, holder)
+		}
+
+		if progress != nil {
+			progress(0, PROGRESS_INSTALL_LOCKED)
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-clk.After(500 * time.Millisecond):
+		}
+	}
+
+	// Identifies the holder for whoever has to wait
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+" "+clk.Now().UTC().Format(time.RFC3339)), 0)
+	return &installLock{f: f}, nil
+}
+
+func (l *installLock) release() {
+	l.f.Truncate(0)
+	unlockFile(l.f)
+	l.f.Close()
+}
+
+func readLockHolder(f *os.File) string {
+	b := make([]byte, 128)
+	n, _ := f.ReadAt(b, 0)
+
+	pid, since, ok := strings.Cut(strings.TrimSpace(string(b[:n])), " ")
+	if !ok {
+		return "held by an unknown process"
+	}
+	return "held by pid " + pid + " since " + since
+}
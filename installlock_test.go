@@ -0,0 +1,36 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInstallLockWaitsOnClock(t *testing.T) {
+	t.Chdir(t.TempDir())
+	SetInstallLockWait(2 * time.Second)
+	defer SetInstallLockWait(DEFAULT_INSTALL_LOCK_WAIT)
+
+	held, err := acquireInstallLock(context.Background(), realClock{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.release()
+
+	clk := newFakeClock()
+	waits := 0
+	elapsed := clk.runUntil(t, func() {
+		_, err = acquireInstallLock(context.Background(), clk, func(done int64, total int64) {
+			if total == PROGRESS_INSTALL_LOCKED {
+				waits++
+			}
+		})
+	})
+	if !errors.Is(err, ErrInstallLocked) {
+		t.Fatalf("got %v, want ErrInstallLocked", err)
+	}
+	if elapsed != 2500*time.Millisecond || waits != 5 {
+		t.Errorf("gave up after %v and %d waits, want 2.5s and 5", elapsed, waits)
+	}
+}
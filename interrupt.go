@@ -0,0 +1,66 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Finish reason of replies stopped by WithInterrupt.
+const FINISH_CANCELLED = "cancelled"
+
+// Window after an interrupt in which a second one shuts the handle down.
+const INTERRUPT_GRACE = 2 * time.Second
+
+var ErrInterrupted = errors.New("interrupted")
+
+// Returns a context cancelled on Ctrl+C instead of the process exiting. A
+// streaming reply cancelled this way returns the text generated so far with
+// FinishReason FINISH_CANCELLED and no error. A second Ctrl+C within
+// INTERRUPT_GRACE shuts the handle down, terminating the server. Call stop
+// once the request is done to restore the default signal handling.
+func (x *XpltAI) WithInterrupt(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt)
+	done := make(chan struct{})
+
+	go func() {
+		var grace <-chan time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case <-grace:
+				grace = nil
+			case <-sigs:
+				if grace != nil {
+					shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), INTERRUPT_GRACE)
+					x.Shutdown(shutdownCtx)
+					cancelShutdown()
+					return
+				}
+				cancel(ErrInterrupted)
+				grace = time.After(INTERRUPT_GRACE)
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	stop := func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+			cancel(nil)
+		})
+	}
+	return ctx, stop
+}
+
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
@@ -0,0 +1,139 @@
+package xplatai
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	journalExt           = ".journal"
+	journalFlushInterval = 200 * time.Millisecond
+)
+
+// A journal file holds a json header line with the request messages followed
+// by the raw generated text. Writes are buffered and flushed periodically,
+// never synced.
+type journal struct {
+	redactor  Redactor
+	path      string
+	f         *os.File
+	w         *bufio.Writer
+	lastFlush time.Time
+}
+
+type journalHeader struct {
+	Id       string    `json:"id"`
+	Messages []Message `json:"messages"`
+}
+
+// Journals hold what redactor returns, recovering redacted text is only
+// useful with redactors that keep it readable.
+func openJournal(dir string, id string, messages []Message, prefill string, redactor Redactor) (*journal, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := make([]Message, len(messages))
+	for i, m := range messages {
+		redacted[i] = Message{Role: m.Role, Content: redactor.RedactPrompt(m.Content)}
+	}
+
+	header, err := json.Marshal(journalHeader{Id: id, Messages: redacted})
+	if err != nil {
+		return nil, err
+	}
+
+	jPath := filepath.Join(dir, id+journalExt)
+	f, err := os.OpenFile(jPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{redactor: redactor, path: jPath, f: f, w: bufio.NewWriter(f), lastFlush: time.Now()}
+	j.w.Write(header)
+	j.w.WriteByte('\n')
+	j.w.WriteString(redactor.RedactResponse(prefill))
+	j.w.Flush()
+	return j, nil
+}
+
+func (j *journal) append(text string) {
+	j.w.WriteString(j.redactor.RedactResponse(text))
+	if time.Since(j.lastFlush) >= journalFlushInterval {
+		j.w.Flush()
+		j.lastFlush = time.Now()
+	}
+}
+
+// Completed requests remove their journal, failed ones keep it for recovery.
+func (j *journal) finish(completed bool) {
+	j.w.Flush()
+	j.f.Close()
+	if completed {
+		os.Remove(j.path)
+	}
+}
+
+// Ids of the journals left behind by interrupted streaming requests.
+func (x *XpltAI) ListJournals() ([]string, error) {
+	if x.journalDir == "" {
+		return nil, errors.New("journaling is disabled")
+	}
+
+	entries, err := os.ReadDir(x.journalDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), journalExt); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Returns the text generated before the request was interrupted and the
+// messages it was sent with, pass both to ContinueChat to resume it.
+func (x *XpltAI) RecoverJournal(id string) (string, []Message, error) {
+	if x.journalDir == "" {
+		return "", nil, errors.New("journaling is disabled")
+	}
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", nil, errors.New("invalid journal id: " + id)
+	}
+
+	b, err := os.ReadFile(filepath.Join(x.journalDir, id+journalExt))
+	if err != nil {
+		return "", nil, err
+	}
+
+	headerLine, text, ok := strings.Cut(string(b), "\n")
+	if !ok {
+		return "", nil, errors.New("journal is missing its header: " + id)
+	}
+
+	header := journalHeader{}
+	err = json.Unmarshal([]byte(headerLine), &header)
+	if err != nil {
+		return "", nil, err
+	}
+	return text, header.Messages, nil
+}
+
+// Deletes a journal once the application recovered or discarded it.
+func (x *XpltAI) RemoveJournal(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return errors.New("invalid journal id: " + id)
+	}
+	return os.Remove(filepath.Join(x.journalDir, id+journalExt))
+}
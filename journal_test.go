@@ -0,0 +1,63 @@
+package xplatai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFailedStreamKeepsJournal(t *testing.T) {
+	srv := streamServer([]string{
+		`{"choices":[{"index":0,"delta":{"content":"Once upon"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":" a time"}}]}`,
+		`{"error":{"code":500,"message":"slot crashed","type":"server_error"}}`,
+	})
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+	x.journalDir = t.TempDir()
+
+	messages := []Message{{Role: "user", Content: "tell me a story"}}
+	_, err := x.ChatDetailedStream(messages, GenOptions{}, func(string) {})
+	if err == nil {
+		t.Fatal("stream didn't fail")
+	}
+
+	ids, err := x.ListJournals()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("got journals %v and %v, want the failed request's", ids, err)
+	}
+	text, recovered, err := x.RecoverJournal(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Once upon a time" || !reflect.DeepEqual(recovered, messages) {
+		t.Errorf("recovered %q and %v, want the partial reply and the request messages", text, recovered)
+	}
+
+	err = x.RemoveJournal(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _ = x.ListJournals()
+	if len(ids) != 0 {
+		t.Errorf("got journals %v after removing it", ids)
+	}
+}
+
+func TestCompletedStreamRemovesJournal(t *testing.T) {
+	srv := streamServer([]string{
+		`{"choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	})
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+	x.journalDir = t.TempDir()
+
+	_, err := x.ChatDetailedStream([]Message{{Role: "user", Content: "hi"}}, GenOptions{}, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := x.ListJournals()
+	if err != nil || len(ids) != 0 {
+		t.Errorf("got journals %v and %v, want none", ids, err)
+	}
+}
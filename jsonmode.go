@@ -0,0 +1,20 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidJSON = errors.New("reply is not valid json")
+
+// Options making chat replies a json object. A reply that still fails to
+// parse is generated once more, then returned with ErrInvalidJSON. Streamed
+// replies already reached the caller and are not generated again.
+func WithJSONOutput() GenOptions {
+	return GenOptions{JSONOutput: true}
+}
+
+func validJSONReply(content string) bool {
+	return json.Valid([]byte(strings.TrimSpace(content)))
+}
@@ -0,0 +1,35 @@
+package xplatai
+
+import "errors"
+
+// Highest cpu index CPUAffinity accepts.
+const maxAffinityCPUs = 1024
+
+// Wraps the errors applying limits, which no other binary flavor would fix.
+var errLimits = errors.New("could not limit the server's resources")
+
+// Which of the Config resource limits the platform enforces.
+type limitSupport struct {
+	nice        bool
+	cpuAffinity bool
+	memoryLimit bool
+}
+
+// Reports each limit cfg sets, skipped when the platform can't enforce it.
+func checkLimits(report *PreflightReport, cfg Config) {
+	support := supportedLimits()
+
+	add := func(name string, set bool, enforced bool) {
+		switch {
+		case !set:
+			report.add(name, CHECK_SKIP, "not set")
+		case !enforced:
+			report.add(name, CHECK_SKIP, "not enforced on this platform")
+		default:
+			report.add(name, CHECK_PASS, "")
+		}
+	}
+	add("nice", cfg.Nice != 0, support.nice)
+	add("cpu affinity", len(cfg.CPUAffinity) > 0, support.cpuAffinity)
+	add("memory limit", cfg.MemoryLimitBytes > 0, support.memoryLimit)
+}
@@ -0,0 +1,70 @@
+package xplatai
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const _RLIM_INFINITY = ^uint64(0)
+
+func supportedLimits() limitSupport {
+	return limitSupport{nice: true, cpuAffinity: true, memoryLimit: true}
+}
+
+// Starts cmd with the limits in place from its first instruction. Priority
+// and affinity are inherited from the forking thread, the memory limit is set
+// by a shell that execs the server, RLIMIT_AS of this process must not change.
+func startLimited(cmd *exec.Cmd, limits procLimits) error {
+	if !limits.isSet() {
+		return cmd.Start()
+	}
+
+	if limits.memoryLimitBytes > 0 {
+		var current syscall.Rlimit
+		err := syscall.Getrlimit(syscall.RLIMIT_AS, &current)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errLimits, err)
+		}
+		// The shell would fail after the fork, where it can't be reported
+		if current.Max != _RLIM_INFINITY && uint64(limits.memoryLimitBytes) > current.Max {
+			return fmt.Errorf("%w: memory limit above the hard limit of %d bytes", errLimits, current.Max)
+		}
+		kib := strconv.FormatInt(max(limits.memoryLimitBytes/1024, 1), 10)
+		cmd.Args = append([]string{"/bin/sh", "-c", `ulimit -v ` + kib + ` && exec "$0" "$@"`, cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = "/bin/sh"
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		// Never unlocked, the thread exits with the goroutine instead of
+		// going back to the scheduler with the server's priority and affinity
+		runtime.LockOSThread()
+		errc <- startFromThread(cmd, limits)
+	}()
+	return <-errc
+}
+
+func startFromThread(cmd *exec.Cmd, limits procLimits) error {
+	if limits.nice != 0 {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), limits.nice)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errLimits, err)
+		}
+	}
+
+	if len(limits.cpuAffinity) > 0 {
+		mask := [maxAffinityCPUs / 64]uint64{}
+		for _, cpu := range limits.cpuAffinity {
+			mask[cpu/64] |= 1 << (cpu % 64)
+		}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+		if errno != 0 {
+			return fmt.Errorf("%w: %w", errLimits, errno)
+		}
+	}
+	return cmd.Start()
+}
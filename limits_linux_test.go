@@ -0,0 +1,62 @@
+package xplatai
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStartLimitedAppliesLimitsBeforeExec(t *testing.T) {
+	// Prints the limits the shell was exec'd with, before it could change them
+	cmd := exec.Command("/bin/sh", "-c", "ulimit -v; grep Cpus_allowed_list /proc/self/status; nice")
+	out := bytes.Buffer{}
+	cmd.Stdout = &out
+	err := startLimited(cmd, procLimits{nice: 7, cpuAffinity: []int{0}, memoryLimitBytes: 1 << 30})
+	if errors.Is(err, syscall.EINVAL) {
+		t.Skip("cpu 0 not available to this process")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{"1048576", "Cpus_allowed_list:\t0", "7"}
+	if len(lines) != len(want) {
+		t.Fatalf("got output %q", out.String())
+	}
+	for i := range want {
+		if strings.TrimSpace(lines[i]) != want[i] {
+			t.Errorf("got %q, want %q", lines[i], want[i])
+		}
+	}
+}
+
+func TestStartLimitedLeavesCallerUnlimited(t *testing.T) {
+	before := syscall.Rlimit{}
+	syscall.Getrlimit(syscall.RLIMIT_AS, &before)
+	niceBefore, _ := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+
+	cmd := exec.Command("/bin/true")
+	err := startLimited(cmd, procLimits{nice: 7, memoryLimitBytes: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+
+	after := syscall.Rlimit{}
+	syscall.Getrlimit(syscall.RLIMIT_AS, &after)
+	if after != before {
+		t.Errorf("memory limit of the caller changed from %+v to %+v", before, after)
+	}
+	niceAfter, _ := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if niceAfter != niceBefore {
+		t.Errorf("priority of the caller changed from %d to %d", niceBefore, niceAfter)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux && !windows
+
+package xplatai
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// Only the priority can be set before exec, affinity and memory limits are
+// left unenforced.
+func supportedLimits() limitSupport {
+	return limitSupport{nice: true}
+}
+
+// Starts cmd through nice(1) so it never runs at the default priority.
+// setpriority on this process would change it for every thread.
+func startLimited(cmd *exec.Cmd, limits procLimits) error {
+	if limits.nice == 0 {
+		return cmd.Start()
+	}
+
+	// nice(1) runs the command anyway when it can't raise the priority
+	if limits.nice < 0 && os.Geteuid() != 0 {
+		return fmt.Errorf("%w: %w", errLimits, syscall.EPERM)
+	}
+	nicePath, err := exec.LookPath("nice")
+	if err != nil {
+		return fmt.Errorf("%w: %w", errLimits, err)
+	}
+	cmd.Args = append([]string{nicePath, "-n", strconv.Itoa(limits.nice), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = nicePath
+	return cmd.Start()
+}
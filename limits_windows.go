@@ -0,0 +1,151 @@
+//go:build windows
+
+package xplatai
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	ntdll = syscall.NewLazyDLL("ntdll.dll")
+
+	procNtResumeProcess          = ntdll.NewProc("NtResumeProcess")
+	procSetProcessAffinityMask   = kernel32.NewProc("SetProcessAffinityMask")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+)
+
+const (
+	_PROCESS_SET_QUOTA       = 0x0100
+	_PROCESS_SET_INFORMATION = 0x0200
+	_PROCESS_TERMINATE       = 0x0001
+	_PROCESS_SUSPEND_RESUME  = 0x0800
+
+	_CREATE_SUSPENDED = 0x0004
+
+	_IDLE_PRIORITY_CLASS         = 0x0040
+	_BELOW_NORMAL_PRIORITY_CLASS = 0x4000
+	_ABOVE_NORMAL_PRIORITY_CLASS = 0x8000
+	_HIGH_PRIORITY_CLASS         = 0x0080
+
+	_JobObjectExtendedLimitInformation = 9
+	_JOB_OBJECT_LIMIT_PROCESS_MEMORY   = 0x0100
+)
+
+type jobBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type jobExtendedLimitInformation struct {
+	BasicLimitInformation jobBasicLimitInformation
+	IoInfo                [6]uint64
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+func supportedLimits() limitSupport {
+	return limitSupport{nice: true, cpuAffinity: true, memoryLimit: true}
+}
+
+// Nice levels mapped onto the nearest priority class, REALTIME is never used.
+func priorityClass(nice int) uint32 {
+	switch {
+	case nice <= -10:
+		return _HIGH_PRIORITY_CLASS
+	case nice < 0:
+		return _ABOVE_NORMAL_PRIORITY_CLASS
+	case nice < 10:
+		return _BELOW_NORMAL_PRIORITY_CLASS
+	}
+	return _IDLE_PRIORITY_CLASS
+}
+
+// Starts cmd suspended and lets it run once the limits are in place, the
+// priority class is set at creation.
+func startLimited(cmd *exec.Cmd, limits procLimits) error {
+	if !limits.isSet() {
+		return cmd.Start()
+	}
+
+	flags := uint32(_CREATE_SUSPENDED)
+	if limits.nice != 0 {
+		flags |= priorityClass(limits.nice)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: flags}
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = limitSuspended(cmd.Process.Pid, limits)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("%w: %w", errLimits, err)
+	}
+	return nil
+}
+
+// Applies the affinity and memory limits to the suspended process and
+// resumes it.
+func limitSuspended(pid int, limits procLimits) error {
+	h, err := syscall.OpenProcess(_PROCESS_SET_INFORMATION|_PROCESS_SET_QUOTA|_PROCESS_TERMINATE|_PROCESS_SUSPEND_RESUME, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(h)
+
+	if len(limits.cpuAffinity) > 0 {
+		mask := uintptr(0)
+		for _, cpu := range limits.cpuAffinity {
+			// Processes outside processor groups are limited to the first 64 cores
+			if cpu < int(unsafe.Sizeof(mask))*8 {
+				mask |= 1 << cpu
+			}
+		}
+		r, _, err := procSetProcessAffinityMask.Call(uintptr(h), mask)
+		if r == 0 {
+			return err
+		}
+	}
+
+	if limits.memoryLimitBytes > 0 {
+		job, _, err := procCreateJobObjectW.Call(0, 0)
+		if job == 0 {
+			return err
+		}
+		// The job lives on as long as the server is assigned to it
+		defer syscall.CloseHandle(syscall.Handle(job))
+
+		info := jobExtendedLimitInformation{ProcessMemoryLimit: uintptr(limits.memoryLimitBytes)}
+		info.BasicLimitInformation.LimitFlags = _JOB_OBJECT_LIMIT_PROCESS_MEMORY
+		r, _, err := procSetInformationJobObject.Call(job, _JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+		if r == 0 {
+			return err
+		}
+		r, _, err = procAssignProcessToJobObject.Call(job, uintptr(h))
+		if r == 0 {
+			return err
+		}
+	}
+
+	status, _, _ := procNtResumeProcess.Call(uintptr(h))
+	if status != 0 {
+		return fmt.Errorf("could not resume the server, status 0x%x", status)
+	}
+	return nil
+}
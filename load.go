@@ -0,0 +1,190 @@
+package xplatai
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PHASE_STARTING = "Starting"
+	PHASE_LOADING  = "Loading"
+	PHASE_READY    = "Ready"
+)
+
+var (
+	percentRegex = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+	layersRegex  = regexp.MustCompile(`(\d+)\s*/\s*(\d+)\s+layers`)
+)
+
+// Scans llama-server stderr for model loading progress.
+type loadTracker struct {
+	mu       sync.Mutex
+	partial  []byte
+	fraction float64
+	loading  bool
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{fraction: -1}
+}
+
+func (t *loadTracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexAny(t.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		t.parseLine(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *loadTracker) parseLine(line string) {
+	if strings.Contains(line, "llama_model_load") || strings.Contains(line, "llama_model_loader") {
+		t.loading = true
+	}
+
+	if !strings.Contains(line, "load_tensors") {
+		return
+	}
+	t.loading = true
+
+	if m := percentRegex.FindStringSubmatch(line); m != nil {
+		pct, err := strconv.ParseFloat(m[1], 64)
+		if err == nil {
+			t.setFraction(pct / 100)
+		}
+		return
+	}
+
+	if m := layersRegex.FindStringSubmatch(line); m != nil {
+		done, err1 := strconv.Atoi(m[1])
+		total, err2 := strconv.Atoi(m[2])
+		if err1 == nil && err2 == nil && total > 0 {
+			t.setFraction(float64(done) / float64(total))
+		}
+	}
+}
+
+func (t *loadTracker) setFraction(f float64) {
+	f = min(max(f, 0), 1)
+	// Progress never goes backwards, lines can be reported out of order
+	if f > t.fraction {
+		t.fraction = f
+	}
+}
+
+func (t *loadTracker) state() (loading bool, fraction float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.loading, t.fraction
+}
+
+type ProbeMode int32
+
+// How requests check the server is ready before being sent.
+const (
+	// Probe /health until the first request succeeds, then assume the server
+	// stays up. Remote handles probe again after a request fails to connect.
+	PROBE_ONCE ProbeMode = iota
+	// Never probe, requests are sent right away.
+	PROBE_OFF
+	// Probe /health before every request, for remote handles behind a load
+	// balancer where each request may reach a different replica.
+	PROBE_PER_REQUEST
+)
+
+func (x *XpltAI) SetProbeMode(mode ProbeMode) {
+	x.probeMode.Store(int32(mode))
+}
+
+// Probes every candidate host until one answers and keeps using that one, the
+// first answer locks the choice in.
+func (x *XpltAI) healthStatus(ctx context.Context) (int, error) {
+	if len(x.hosts) == 1 || x.hostLocked.Load() {
+		return x.probeHealth(ctx, x.url("/health"))
+	}
+
+	var lastErr error
+	for idx := range x.hosts {
+		status, err := x.probeHealth(ctx, x.hostUrl(idx, "/health"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if x.hostLocked.CompareAndSwap(false, true) {
+			x.hostIdx.Store(int32(idx))
+		}
+		return status, nil
+	}
+	return 0, lastErr
+}
+
+func (x *XpltAI) probeHealth(ctx context.Context, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Blocks until the server reports ready or ctx is done. progress is called on
+// every phase or fraction change, fraction is negative when it can't be estimated.
+func (x *XpltAI) WaitUntilLoadedProgress(ctx context.Context, progress func(phase string, fraction float64)) error {
+	lastPhase := ""
+	lastFraction := 0.0
+
+	report := func(phase string, fraction float64) {
+		if progress == nil || (phase == lastPhase && fraction == lastFraction) {
+			return
+		}
+		lastPhase = phase
+		lastFraction = fraction
+		progress(phase, fraction)
+	}
+
+	report(PHASE_STARTING, 0)
+
+	for {
+		status, err := x.healthStatus(ctx)
+		if err == nil && status < 500 {
+			x.recordLoad(nil)
+			report(PHASE_READY, 1)
+			x.emit(EVENT_READY, "")
+			return nil
+		}
+
+		loading, fraction := false, -1.0
+		if x.loads != nil {
+			loading, fraction = x.loads.state()
+		}
+
+		// /health answers with 503 while the model is being loaded
+		if loading || err == nil {
+			report(PHASE_LOADING, fraction)
+		}
+
+		err = x.sleep(ctx, 250*time.Millisecond)
+		if err != nil {
+			x.recordLoad(err)
+			return err
+		}
+	}
+}
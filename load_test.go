@@ -0,0 +1,187 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Handle sending its requests to srv, on the fake clock.
+func newTestHandle(t *testing.T, srv *httptest.Server, clk clock) *XpltAI {
+	t.Helper()
+	x, err := NewRemote(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.clock = clk
+	x.settings.Store(&requestSettings{})
+	return x
+}
+
+// Server whose /health answers 503 until ready is set.
+func healthServer(ready *atomic.Bool, probes *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		if probes != nil {
+			probes.Add(1)
+		}
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+}
+
+func TestWaitReadyPollsUntilHealthy(t *testing.T) {
+	probes := atomic.Int32{}
+	ready := atomic.Bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probes.Add(1) > 3 {
+			ready.Store(true)
+		}
+		if !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.firstRequestWait = time.Minute
+
+	var waited time.Duration
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		waited, err = x.waitReady(context.Background())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probes.Load() != 4 {
+		t.Errorf("%d health probes, want 4", probes.Load())
+	}
+	if elapsed != 750*time.Millisecond || waited != elapsed {
+		t.Errorf("waited %v for %v of polling, want 750ms", waited, elapsed)
+	}
+}
+
+func TestWaitReadyTimesOut(t *testing.T) {
+	ready := atomic.Bool{}
+	srv := healthServer(&ready, nil)
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.firstRequestWait = 2 * time.Second
+
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		_, err = x.waitReady(context.Background())
+	})
+	if !errors.Is(err, ErrServerNotReady) {
+		t.Fatalf("got %v, want ErrServerNotReady", err)
+	}
+	if elapsed != 2*time.Second {
+		t.Errorf("gave up after %v, want 2s", elapsed)
+	}
+}
+
+func TestWaitUntilLoadedReportsSlowLoad(t *testing.T) {
+	fakeInstall(t)
+	ready := atomic.Bool{}
+	srv := healthServer(&ready, nil)
+	defer srv.Close()
+
+	clk := newFakeClock()
+	procs := &fakeProcs{}
+	x, err := NewWithConfig(fakeConfig(clk, procs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	x.hosts = []string{srv.URL}
+
+	type report struct {
+		phase    string
+		fraction float64
+	}
+	reports := []report{}
+	done := make(chan error, 1)
+	go func() {
+		done <- x.WaitUntilLoadedProgress(context.Background(), func(phase string, fraction float64) {
+			reports = append(reports, report{phase, fraction})
+		})
+	}()
+
+	stderr := procs.last().stderr
+	clk.waitPending(t, 1)
+	fmt.Fprintln(stderr, "llama_model_loader: loaded meta data with 23 key-value pairs")
+	clk.Advance(250 * time.Millisecond)
+	clk.waitPending(t, 1)
+	fmt.Fprintln(stderr, "load_tensors: 16/32 layers")
+	clk.Advance(250 * time.Millisecond)
+	clk.waitPending(t, 1)
+	ready.Store(true)
+	clk.Advance(250 * time.Millisecond)
+
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []report{{PHASE_STARTING, 0}, {PHASE_LOADING, -1}, {PHASE_LOADING, 0.5}, {PHASE_READY, 1}}
+	if !slices.Equal(reports, want) {
+		t.Errorf("got reports %v, want %v", reports, want)
+	}
+}
+
+func TestCrashedServerFailsRequests(t *testing.T) {
+	fakeInstall(t)
+	ready := atomic.Bool{}
+	ready.Store(true)
+	srv := healthServer(&ready, nil)
+
+	clk := newFakeClock()
+	procs := &fakeProcs{}
+	cfg := fakeConfig(clk, procs)
+	cfg.FirstRequestWait = 5 * time.Second
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.hosts = []string{srv.URL}
+	x.SetProbeMode(PROBE_PER_REQUEST)
+
+	_, err = x.waitReady(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The process dies and takes its port with it
+	procs.last().exit(errors.New("exit status 139"))
+	srv.Close()
+
+	elapsed := clk.runUntil(t, func() {
+		_, err = x.waitReady(context.Background())
+	})
+	if !errors.Is(err, ErrServerNotReady) || ClassifyError(err) != FAILURE_STARTING {
+		t.Fatalf("got %v, want ErrServerNotReady", err)
+	}
+	if elapsed != 5*time.Second {
+		t.Errorf("gave up after %v, want 5s", elapsed)
+	}
+
+	x.Close()
+	if procs.running() != 0 {
+		t.Error("process still running after Close")
+	}
+}
@@ -0,0 +1,57 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Bias that bans a token outright.
+var LOGIT_BAN = math.Inf(-1)
+
+// Token ids biased by each string of biases, for GenOptions.LogitBias. Words
+// are usually tokenized differently mid-sentence, the variant with a leading
+// space is biased as well. Every token of a string gets its bias, so banning
+// a multi-token word also bans its pieces.
+func (x *XpltAI) LogitBiasFor(biases map[string]float64) (map[int]float64, error) {
+	ctx := context.Background()
+	out := map[int]float64{}
+
+	for text, bias := range biases {
+		if text == "" {
+			return nil, errors.New("logit bias text is empty")
+		}
+
+		variants := []string{text}
+		if !strings.HasPrefix(text, " ") {
+			variants = append(variants, " "+text)
+		}
+
+		for _, v := range variants {
+			tokens, err := x.tokenize(ctx, v, false)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range tokens {
+				out[t] = bias
+			}
+		}
+	}
+	return out, nil
+}
+
+// Encodes biases in the object form both completion endpoints accept, bans
+// become false since json has no infinity.
+func encodeLogitBias(biases map[int]float64) map[string]any {
+	out := make(map[string]any, len(biases))
+	for token, bias := range biases {
+		if math.IsInf(bias, -1) {
+			out[strconv.Itoa(token)] = false
+		} else {
+			out[strconv.Itoa(token)] = bias
+		}
+	}
+	return out
+}
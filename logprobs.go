@@ -0,0 +1,36 @@
+package xplatai
+
+import "encoding/json"
+
+// Log probability of a generated token, see GenOptions.Logprobs.
+type TokenLogprob struct {
+	Id      int     `json:"id"`
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	// Most likely tokens at this position, most likely first. Empty on
+	// alternatives themselves.
+	Top []TokenLogprob `json:"top_logprobs,omitempty"`
+}
+
+// Logprobs of an OpenAI-compatible choice, nil when absent.
+type choiceLogprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+// Reads completion_probabilities from a /completion response.
+func completionLogprobs(jsonData map[string]any) []TokenLogprob {
+	raw, ok := jsonData["completion_probabilities"]
+	if !ok {
+		return nil
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	probs := []TokenLogprob{}
+	if json.Unmarshal(b, &probs) != nil {
+		return nil
+	}
+	return probs
+}
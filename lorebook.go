@@ -0,0 +1,234 @@
+package xplatai
+
+import (
+	"cmp"
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wAIfu-DEV/Xplat-AI/charcard"
+)
+
+// Where the lore of an entry goes relative to the system prompt.
+const (
+	LORE_BEFORE_CHAR = "before_char"
+	LORE_AFTER_CHAR  = "after_char"
+)
+
+// Latest messages scanned for keys when Lorebook.ScanDepth is 0.
+const DEFAULT_LORE_SCAN_DEPTH = 2
+
+// Lore entries inserted into a session's system prompt when the latest
+// messages mention their keys, following SillyTavern world info. See
+// Session.SetLorebook.
+type Lorebook struct {
+	Entries []LoreEntry `json:"entries"`
+	// Latest messages scanned for keys, 0 is DEFAULT_LORE_SCAN_DEPTH
+	ScanDepth int `json:"scan_depth,omitempty"`
+	// Tokens the inserted entries may take, 0 is a quarter of the context
+	TokenBudget int `json:"token_budget,omitempty"`
+	// Whether inserted entries' content is scanned for keys too
+	Recursive bool `json:"recursive,omitempty"`
+}
+
+type LoreEntry struct {
+	// Matched as text, or as a regular expression when written /pattern/flags
+	Keys []string `json:"keys"`
+	// When set, one of them must match along with Keys
+	SecondaryKeys []string `json:"secondary_keys,omitempty"`
+	Content       string   `json:"content"`
+	// Inserted whatever the messages mention
+	Constant      bool `json:"constant,omitempty"`
+	CaseSensitive bool `json:"case_sensitive,omitempty"`
+	Disabled      bool `json:"disabled,omitempty"`
+	// Entries with a lower order are inserted first
+	Order int `json:"order,omitempty"`
+	// Entries with a lower priority are left out first when over budget
+	Priority int `json:"priority,omitempty"`
+	// LORE_BEFORE_CHAR or LORE_AFTER_CHAR, empty is before
+	Position string `json:"position,omitempty"`
+}
+
+// Lorebook of the card's character book, nil when it has none. Macros in the
+// entries expand with user as the user's name.
+func LorebookFromCard(card *charcard.Card, user string) *Lorebook {
+	book := card.Data.CharacterBook
+	if book == nil {
+		return nil
+	}
+
+	lore := &Lorebook{
+		ScanDepth:   book.ScanDepth,
+		TokenBudget: book.TokenBudget,
+		Recursive:   book.RecursiveScanning,
+	}
+	for _, e := range book.Entries {
+		entry := LoreEntry{
+			Keys:          e.Keys,
+			Content:       card.Expand(e.Content, user),
+			Constant:      e.Constant,
+			CaseSensitive: e.CaseSensitive,
+			Disabled:      !e.Enabled,
+			Order:         e.InsertionOrder,
+			Priority:      e.Priority,
+			Position:      e.Position,
+		}
+		if e.Selective {
+			entry.SecondaryKeys = e.SecondaryKeys
+		}
+		lore.Entries = append(lore.Entries, entry)
+	}
+	return lore
+}
+
+// Inserts the session's lore into the prompt, nil removes it.
+func (s *Session) SetLorebook(book *Lorebook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lore = book
+}
+
+func (s *Session) Lorebook() *Lorebook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lore
+}
+
+// Entries triggered by the latest messages, in no particular order.
+func (b *Lorebook) triggered(msgs []Message) []LoreEntry {
+	depth := b.ScanDepth
+	if depth <= 0 {
+		depth = DEFAULT_LORE_SCAN_DEPTH
+	}
+	scanned := []string{}
+	for _, m := range msgs[max(len(msgs)-depth, 0):] {
+		if m.Role != "system" {
+			scanned = append(scanned, m.Content)
+		}
+	}
+	text := strings.Join(scanned, "\n")
+
+	done := make([]bool, len(b.Entries))
+	entries := []LoreEntry{}
+	for {
+		added := []string{}
+		for i, e := range b.Entries {
+			if done[i] || e.Disabled {
+				continue
+			}
+			if e.Constant || e.matches(text) {
+				done[i] = true
+				entries = append(entries, e)
+				added = append(added, e.Content)
+			}
+		}
+		if !b.Recursive || len(added) == 0 {
+			return entries
+		}
+		// Only the newly inserted lore can trigger more entries
+		text = strings.Join(added, "\n")
+	}
+}
+
+func (e LoreEntry) matches(text string) bool {
+	if !matchesAny(e.Keys, text, e.CaseSensitive) {
+		return false
+	}
+	return len(e.SecondaryKeys) == 0 || matchesAny(e.SecondaryKeys, text, e.CaseSensitive)
+}
+
+var regexKey = regexp.MustCompile(`^/(.+)/([a-z]*)$`)
+
+func matchesAny(keys []string, text string, caseSensitive bool) bool {
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		if m := regexKey.FindStringSubmatch(key); m != nil {
+			pattern := m[1]
+			if strings.Contains(m[2], "i") || !caseSensitive {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err == nil && re.MatchString(text) {
+				return true
+			}
+			continue
+		}
+
+		if caseSensitive && strings.Contains(text, key) {
+			return true
+		}
+		if !caseSensitive && strings.Contains(strings.ToLower(text), strings.ToLower(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds the lore msgs trigger to their system prompt, within the book's token
+// budget. The highest priority entries are kept, then inserted by order.
+func (x *XpltAI) injectLore(ctx context.Context, msgs []Message, book *Lorebook) ([]Message, error) {
+	if book == nil || len(msgs) == 0 {
+		return msgs, nil
+	}
+	entries := book.triggered(msgs)
+	if len(entries) == 0 {
+		return msgs, nil
+	}
+
+	budget := book.TokenBudget
+	if budget <= 0 {
+		_, err := x.waitReady(ctx)
+		if err != nil {
+			return msgs, err
+		}
+		nCtx, err := x.contextSize(ctx)
+		if err != nil {
+			return msgs, err
+		}
+		budget = nCtx / 4
+	}
+
+	slices.SortStableFunc(entries, func(a, b LoreEntry) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	kept := []LoreEntry{}
+	for _, e := range entries {
+		n, err := x.countTextTokens(ctx, e.Content)
+		if err != nil {
+			return msgs, err
+		}
+		if n > budget {
+			continue
+		}
+		budget -= n
+		kept = append(kept, e)
+	}
+	slices.SortStableFunc(kept, func(a, b LoreEntry) int {
+		return cmp.Compare(a.Order, b.Order)
+	})
+
+	before, after := []string{}, []string{}
+	for _, e := range kept {
+		if e.Position == LORE_AFTER_CHAR {
+			after = append(after, e.Content)
+		} else {
+			before = append(before, e.Content)
+		}
+	}
+
+	parts := before
+	rest := msgs
+	if msgs[0].Role == "system" {
+		parts = append(parts, msgs[0].Content)
+		rest = msgs[1:]
+	}
+	parts = append(parts, after...)
+
+	system := Message{Role: "system", Content: strings.Join(parts, "\n\n")}
+	return slices.Concat([]Message{system}, rest), nil
+}
@@ -0,0 +1,148 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Memories recalled per message when Memory.TopK is 0.
+const DEFAULT_MEMORY_TOP_K = 3
+
+// Past exchanges of sessions, recalled into their prompt when relevant to the
+// new message, see Session.SetMemory. A memory shared by several sessions
+// lets a character remember across them.
+type Memory struct {
+	embedder *XpltAI
+	store    MemoryStore
+
+	// Memories recalled per message, 0 is DEFAULT_MEMORY_TOP_K
+	TopK int
+	// Lowest cosine similarity of recalled memories, 0 recalls the best ones
+	// however unrelated
+	MinScore float64
+}
+
+// Where a Memory keeps its embedded texts.
+type MemoryStore interface {
+	Add(ctx context.Context, text string, vector []float32) error
+	// The k texts most similar to vector, best first
+	Search(ctx context.Context, vector []float32, k int) ([]MemoryHit, error)
+}
+
+type MemoryHit struct {
+	Text string
+	// Cosine similarity to the query
+	Score float64
+}
+
+// Memory embedding with this handle, which needs Config.Embeddings. nil store
+// keeps the memories in memory until the process exits, see OpenVectorIndex
+// to keep them across runs.
+func (x *XpltAI) NewMemory(store MemoryStore) *Memory {
+	if store == nil {
+		store = NewVectorIndex()
+	}
+	return &Memory{embedder: x, store: store}
+}
+
+func (m *Memory) Remember(text string) error {
+	return m.RememberContext(context.Background(), text)
+}
+
+func (m *Memory) RememberContext(ctx context.Context, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("memory text is empty")
+	}
+	vectors, err := m.embedder.EmbedContext(ctx, []string{text})
+	if err != nil {
+		return err
+	}
+	return m.store.Add(ctx, text, vectors[0])
+}
+
+// The memories most similar to query, best first, at most TopK and none
+// below MinScore.
+func (m *Memory) Recall(query string) ([]MemoryHit, error) {
+	return m.RecallContext(context.Background(), query)
+}
+
+func (m *Memory) RecallContext(ctx context.Context, query string) ([]MemoryHit, error) {
+	return m.recall(ctx, query, nil)
+}
+
+// Recall leaving out the texts of skip, searching further to still return
+// TopK memories.
+func (m *Memory) recall(ctx context.Context, query string, skip map[string]bool) ([]MemoryHit, error) {
+	k := m.TopK
+	if k <= 0 {
+		k = DEFAULT_MEMORY_TOP_K
+	}
+
+	vectors, err := m.embedder.EmbedContext(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	hits, err := m.store.Search(ctx, vectors[0], k+len(skip))
+	if err != nil {
+		return nil, err
+	}
+
+	recalled := []MemoryHit{}
+	for _, hit := range hits {
+		if len(recalled) == k || hit.Score < m.MinScore {
+			break
+		}
+		if !skip[hit.Text] {
+			recalled = append(recalled, hit)
+		}
+	}
+	return recalled, nil
+}
+
+// Text a session remembers an exchange as.
+func exchangeText(user string, reply string) string {
+	return "User: " + user + "\nAssistant: " + reply
+}
+
+// Recalls memories relevant to the last message of msgs into their system
+// prompt. Exchanges still in sent, the messages the prompt would hold
+// without memories, are not recalled.
+func (m *Memory) inject(ctx context.Context, msgs []Message, sent []Message) ([]Message, error) {
+	if len(msgs) == 0 {
+		return msgs, nil
+	}
+
+	skip := map[string]bool{}
+	for i := 1; i < len(sent); i++ {
+		if sent[i-1].Role == "user" && sent[i].Role == "assistant" {
+			skip[exchangeText(sent[i-1].Content, sent[i].Content)] = true
+		}
+	}
+
+	hits, err := m.recall(ctx, msgs[len(msgs)-1].Content, skip)
+	if err != nil || len(hits) == 0 {
+		return msgs, err
+	}
+
+	lines := []string{"Memories of earlier conversations:"}
+	for _, hit := range hits {
+		lines = append(lines, hit.Text)
+	}
+	memories := strings.Join(lines, "\n\n")
+
+	if msgs[0].Role != "system" {
+		return slices.Concat([]Message{{Role: "system", Content: memories}}, msgs), nil
+	}
+	system := Message{Role: "system", Content: msgs[0].Content + "\n\n" + memories}
+	return slices.Concat([]Message{system}, msgs[1:]), nil
+}
+
+// Recalls memories into the session's prompt and remembers every exchange
+// Send completes, nil stops both.
+func (s *Session) SetMemory(m *Memory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memory = m
+}
@@ -0,0 +1,99 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+var ErrContextTooLarge = errors.New("context size exceeds the model's training context")
+
+type ModelInfo struct {
+	Model string
+	// Context of a single slot as the server allocated it
+	ContextSize int
+	// Context the model was trained with, 0 when unknown
+	TrainContextSize int
+	// Adjustments made to the configuration, e.g. a clamped context size
+	Warnings []string
+}
+
+// Training context length of a local .gguf model.
+func ggufTrainContext(path string) (int, error) {
+	meta, err := readGGUFMetadata(path)
+	if err != nil {
+		return 0, err
+	}
+
+	arch := meta["general.architecture"]
+	if len(arch) == 0 {
+		return 0, errors.New("gguf file has no architecture")
+	}
+	values := meta[arch[0]+".context_length"]
+	if len(values) == 0 {
+		return 0, errors.New("gguf file has no context length")
+	}
+	return strconv.Atoi(values[0])
+}
+
+// Clamps the context size of local models to their training context, unless
+// RoPE scaling extends it. Returns a warning when it clamped.
+func negotiateContext(cfg Config, spec ModelSpec) (Config, string, error) {
+	if cfg.ContextSize <= 0 || cfg.RopeScale > 0 || !spec.IsLocal() {
+		return cfg, "", nil
+	}
+
+	nCtxTrain, err := ggufTrainContext(spec.Source)
+	if err != nil || nCtxTrain <= 0 || cfg.ContextSize <= nCtxTrain {
+		return cfg, "", nil
+	}
+
+	if cfg.StrictContext {
+		return cfg, "", fmt.Errorf("%w: %d > %d", ErrContextTooLarge, cfg.ContextSize, nCtxTrain)
+	}
+
+	warning := fmt.Sprintf("context size %d exceeds the training context %d, clamped", cfg.ContextSize, nCtxTrain)
+	cfg.ContextSize = nCtxTrain
+	return cfg, warning, nil
+}
+
+// Reports the model the server runs and the context it really uses.
+func (x *XpltAI) ModelInfo() (ModelInfo, error) {
+	ctx := context.Background()
+	info := ModelInfo{Model: x.model, Warnings: slices.Clone(x.warnings)}
+
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		return info, err
+	}
+
+	info.ContextSize, err = x.contextSize(ctx)
+	if err != nil {
+		return info, err
+	}
+
+	models := struct {
+		Data []struct {
+			Id   string `json:"id"`
+			Meta struct {
+				NCtxTrain int `json:"n_ctx_train"`
+			} `json:"meta"`
+		} `json:"data"`
+	}{}
+	err = x.doJSON(ctx, "GET", "/v1/models", nil, &models)
+	if err == nil && len(models.Data) > 0 {
+		info.TrainContextSize = models.Data[0].Meta.NCtxTrain
+		if info.Model == "" {
+			info.Model = models.Data[0].Id
+		}
+	}
+
+	// Hugging Face models can only be checked once the server loaded them
+	if info.TrainContextSize > 0 && info.ContextSize > info.TrainContextSize && !x.ropeScaled {
+		info.Warnings = append(info.Warnings, fmt.Sprintf(
+			"context size %d exceeds the training context %d", info.ContextSize, info.TrainContextSize))
+	}
+	return info, nil
+}
@@ -0,0 +1,84 @@
+package xplatai
+
+import (
+	"context"
+	"time"
+)
+
+// Body of an OpenAI text completion request, unset fields are left out.
+type OpenAICompletionRequest struct {
+	Model            string   `json:"model,omitempty"`
+	Prompt           string   `json:"prompt"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	N                *int     `json:"n,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Echo             bool     `json:"echo,omitempty"`
+	Logprobs         *int     `json:"logprobs,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	User             string   `json:"user,omitempty"`
+}
+
+type OpenAICompletionResponse struct {
+	Id      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   OpenAIUsage              `json:"usage"`
+}
+
+type OpenAICompletionChoice struct {
+	Text         string          `json:"text"`
+	Index        int             `json:"index"`
+	Logprobs     *OpenAILogprobs `json:"logprobs"`
+	FinishReason string          `json:"finish_reason"`
+}
+
+type OpenAILogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Sends req as is to the OpenAI-compatible /v1/completions route, without
+// applying the handle's default options.
+func (x *XpltAI) CompleteOpenAI(req OpenAICompletionRequest) (OpenAICompletionResponse, error) {
+	return x.completeOpenAI(context.Background(), req)
+}
+
+func (x *XpltAI) completeOpenAI(ctx context.Context, req OpenAICompletionRequest) (result OpenAICompletionResponse, err error) {
+	ctx, end, err := x.beginRequest(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer func() { err = end(err) }()
+
+	ctx = x.captureSettings(ctx)
+
+	timing := RequestTiming{}
+	timing.Wait, err = x.waitReady(ctx)
+	if err != nil {
+		return result, &RequestError{Timing: timing, Err: err}
+	}
+
+	genStart := time.Now()
+	err = x.doJSON(ctx, "POST", "/v1/completions", req, &result)
+	timing.Generation = time.Since(genStart)
+	if err != nil {
+		return result, &RequestError{Timing: timing, Err: err}
+	}
+
+	x.isConn.Store(true)
+	return result, nil
+}
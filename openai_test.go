@@ -0,0 +1,171 @@
+package xplatai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Response of llama-server's /v1/completions to an echo request with
+// logprobs, as captured.
+const openAICompletionFixture = `{"id":"cmpl-8f2d1c","object":"text_completion","created":1718000000,"model":"gpt-3.5-turbo","choices":[{"text":"The sky is blue","index":0,"logprobs":{"tokens":["The"," sky"," is"," blue"],"token_logprobs":[-0.5,-1.25,-0.125,-2],"top_logprobs":[{"The":-0.5},{" sea":-1.5," sky":-1.25},{" is":-0.125},{" blue":-2," grey":-2.5}],"text_offset":[0,3,7,10]},"finish_reason":"length"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+
+// Server recording the body of the last /v1/completions request and
+// answering with resp.
+func openAIServer(status int, resp string, body *[]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/completions" {
+			return
+		}
+		*body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, resp)
+	}))
+}
+
+func TestCompleteOpenAIRequestFields(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name string
+		req  OpenAICompletionRequest
+		want string
+	}{
+		{
+			name: "minimal",
+			req:  OpenAICompletionRequest{Prompt: "The sky is"},
+			want: `{"prompt":"The sky is"}`,
+		},
+		{
+			name: "every field",
+			req: OpenAICompletionRequest{
+				Model:            "gpt-3.5-turbo-instruct",
+				Prompt:           "The sky is",
+				MaxTokens:        intPtr(16),
+				Temperature:      floatPtr(0.7),
+				TopP:             floatPtr(0.9),
+				N:                intPtr(2),
+				Stop:             []string{"\n", "."},
+				Echo:             true,
+				Logprobs:         intPtr(3),
+				PresencePenalty:  floatPtr(0.5),
+				FrequencyPenalty: floatPtr(-0.5),
+				Seed:             intPtr(42),
+				User:             "user-1",
+			},
+			want: `{"model":"gpt-3.5-turbo-instruct","prompt":"The sky is","max_tokens":16,"temperature":0.7,"top_p":0.9,"n":2,"stop":["\n","."],"echo":true,"logprobs":3,"presence_penalty":0.5,"frequency_penalty":-0.5,"seed":42,"user":"user-1"}`,
+		},
+		{
+			// Zero values that are set are sent, not mistaken for defaults
+			name: "set zero values",
+			req:  OpenAICompletionRequest{Prompt: "", MaxTokens: intPtr(0), Temperature: floatPtr(0), Logprobs: intPtr(0), Seed: intPtr(0)},
+			want: `{"prompt":"","max_tokens":0,"temperature":0,"logprobs":0,"seed":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			srv := openAIServer(http.StatusOK, openAICompletionFixture, &body)
+			defer srv.Close()
+			x := newTestHandle(t, srv, realClock{})
+
+			_, err := x.CompleteOpenAI(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(bytes.TrimSpace(body)); got != tt.want {
+				t.Errorf("sent\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteOpenAIResponseFields(t *testing.T) {
+	var body []byte
+	srv := openAIServer(http.StatusOK, openAICompletionFixture, &body)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	got, err := x.CompleteOpenAI(OpenAICompletionRequest{Prompt: "The sky is", Echo: true, Logprobs: new(int)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OpenAICompletionResponse{
+		Id:      "cmpl-8f2d1c",
+		Object:  "text_completion",
+		Created: 1718000000,
+		Model:   "gpt-3.5-turbo",
+		Choices: []OpenAICompletionChoice{{
+			Text:  "The sky is blue",
+			Index: 0,
+			Logprobs: &OpenAILogprobs{
+				Tokens:        []string{"The", " sky", " is", " blue"},
+				TokenLogprobs: []float64{-0.5, -1.25, -0.125, -2},
+				TopLogprobs: []map[string]float64{
+					{"The": -0.5},
+					{" sky": -1.25, " sea": -1.5},
+					{" is": -0.125},
+					{" blue": -2, " grey": -2.5},
+				},
+				TextOffset: []int{0, 3, 7, 10},
+			},
+			FinishReason: "length",
+		}},
+		Usage: OpenAIUsage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// No field of the fixture is dropped or renamed on the way through
+	out, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != openAICompletionFixture {
+		t.Errorf("marshaled back to\n%s\nwant\n%s", out, openAICompletionFixture)
+	}
+}
+
+func TestCompleteOpenAIWithoutLogprobs(t *testing.T) {
+	var body []byte
+	srv := openAIServer(http.StatusOK, `{"id":"cmpl-1","object":"text_completion","created":1,"model":"m","choices":[{"text":" blue","index":0,"logprobs":null,"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`, &body)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	got, err := x.CompleteOpenAI(OpenAICompletionRequest{Prompt: "The sky is"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Choices) != 1 || got.Choices[0].Logprobs != nil || got.Choices[0].Text != " blue" {
+		t.Errorf("got choices %+v, want a single one without logprobs", got.Choices)
+	}
+}
+
+// Errors go through the same layer as the native routes.
+func TestCompleteOpenAIServerError(t *testing.T) {
+	var body []byte
+	srv := openAIServer(http.StatusBadRequest, `{"error":{"code":400,"message":"the request exceeds the available context size, try increasing it","type":"exceed_context_size_error"}}`, &body)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	_, err := x.CompleteOpenAI(OpenAICompletionRequest{Prompt: "The sky is"})
+	var reqErr *RequestError
+	var srvErr *ServerError
+	if !errors.As(err, &reqErr) || !errors.As(err, &srvErr) {
+		t.Fatalf("got %v, want a RequestError wrapping a ServerError", err)
+	}
+	if srvErr.StatusCode != http.StatusBadRequest || srvErr.Type != "exceed_context_size_error" {
+		t.Errorf("got %+v, want the decoded error object", srvErr)
+	}
+	if ClassifyError(err) != FAILURE_REQUEST_TOO_LONG {
+		t.Errorf("classified as %v, want %v", ClassifyError(err), FAILURE_REQUEST_TOO_LONG)
+	}
+}
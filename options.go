@@ -0,0 +1,361 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Per-request generation settings. Nil or zero fields are left to the preset,
+// then to the server defaults.
+type GenOptions struct {
+	Preset string `json:"preset,omitempty"`
+
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// Caps generation to this fraction of the context left after the prompt,
+	// in (0, 1]. Mutually exclusive with MaxTokens.
+	MaxTokensFraction float64 `json:"max_tokens_fraction,omitempty"`
+
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+
+	// GBNF grammar the output must match, e.g. one of the GRAMMAR_ constants
+	Grammar string `json:"grammar,omitempty"`
+
+	// Makes chat replies a json object through the server's response_format,
+	// see WithJSONOutput.
+	JSONOutput bool `json:"json_output,omitempty"`
+	// Json schema the output must match, see JSONSchemaFor. Takes precedence
+	// over JSONOutput for what the server generates.
+	JSONSchema json.RawMessage `json:"json_schema,omitempty"`
+
+	// Added to the logits of the tokens, by token id. Negative values make a
+	// token less likely, LOGIT_BAN forbids it. See LogitBiasFor to bias text.
+	LogitBias map[int]float64 `json:"logit_bias,omitempty"`
+
+	// Reuse the KV cache of a previous request sharing a prompt prefix, nil
+	// keeps the server default (enabled).
+	CachePrompt *bool `json:"cache_prompt,omitempty"`
+
+	// Tools the model may call, see ChatWithTools. Needs
+	// Config.Jinja.
+	Tools []ToolDefinition `json:"tools,omitempty"`
+
+	// Returns the log probability of every generated token along with this
+	// many of the most likely alternatives, in Logprobs on the response. 0
+	// returns none.
+	Logprobs int `json:"logprobs,omitempty"`
+
+	// Number of replies to generate for a chat request, returned in Choices on
+	// the response. 0 or 1 generates one. Completions always generate one.
+	N int `json:"n,omitempty"`
+
+	// Sampling seed, a fixed seed makes replies reproducible.
+	Seed *int `json:"seed,omitempty"`
+
+	// Marks the request as safe to send twice, allowing Config.HedgeAfter to
+	// hedge it without a fixed Seed.
+	Idempotent bool `json:"idempotent,omitempty"`
+
+	// Stops generating once this much time passed and returns the text so far
+	// with FinishReason FINISH_TIME_BUDGET. Requests stream under the hood when
+	// set. Stop strings and MaxTokens still apply, whichever triggers first.
+	TimeBudget time.Duration `json:"time_budget,omitempty"`
+
+	// Whether tokenizing prompt text adds the model's BOS token, nil keeps the
+	// endpoint default: added by completions, not by Tokenize. Completions
+	// send the prompt as tokens when set.
+	AddBOS *bool `json:"add_bos,omitempty"`
+
+	// Whether special tokens written in prompt text, like <|im_start|>, are
+	// parsed as such rather than as plain text. nil keeps the server default
+	// (parsed). Completions send the prompt as tokens when set.
+	ParseSpecial *bool `json:"parse_special,omitempty"`
+
+	// Keeps special tokens the model generates in completion content, which
+	// the server leaves out. Costs an extra request to render the tokens.
+	ReturnSpecial bool `json:"return_special,omitempty"`
+}
+
+const (
+	PRESET_PRECISE  = "precise"
+	PRESET_BALANCED = "balanced"
+	PRESET_CREATIVE = "creative"
+	PRESET_ROLEPLAY = "roleplay"
+)
+
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+var presetsMut sync.RWMutex
+
+// Tuned relative to llama.cpp defaults (temp 0.8, top_k 40, top_p 0.95, min_p 0.05)
+var presets = map[string]GenOptions{
+	PRESET_PRECISE: {
+		Temperature:   Ptr(0.2),
+		TopK:          Ptr(20),
+		TopP:          Ptr(0.9),
+		MinP:          Ptr(0.1),
+		RepeatPenalty: Ptr(1.0),
+	},
+	PRESET_BALANCED: {
+		Temperature:   Ptr(0.8),
+		TopK:          Ptr(40),
+		TopP:          Ptr(0.95),
+		MinP:          Ptr(0.05),
+		RepeatPenalty: Ptr(1.0),
+	},
+	PRESET_CREATIVE: {
+		Temperature:   Ptr(1.1),
+		TopK:          Ptr(0),
+		TopP:          Ptr(0.98),
+		MinP:          Ptr(0.05),
+		RepeatPenalty: Ptr(1.05),
+	},
+	PRESET_ROLEPLAY: {
+		Temperature:   Ptr(0.9),
+		TopK:          Ptr(0),
+		TopP:          Ptr(1.0),
+		MinP:          Ptr(0.1),
+		RepeatPenalty: Ptr(1.1),
+	},
+}
+
+// Registers a custom preset, replacing any preset with the same name.
+func RegisterPreset(name string, opts GenOptions) error {
+	if name == "" {
+		return errors.New("preset name is empty")
+	}
+	if opts.Preset != "" {
+		return errors.New("presets cannot reference other presets")
+	}
+
+	presetsMut.Lock()
+	defer presetsMut.Unlock()
+
+	presets[name] = opts
+	return nil
+}
+
+func PresetOptions(name string) (GenOptions, bool) {
+	presetsMut.RLock()
+	defer presetsMut.RUnlock()
+
+	opts, ok := presets[name]
+	return opts, ok
+}
+
+// Options based on the named preset, fields set on the result override it.
+func WithPreset(name string) GenOptions {
+	return GenOptions{Preset: name}
+}
+
+// Options with a fixed sampling seed, replies to the same request are then
+// identical as long as the server runs a single slot, see SetDeterministic.
+func WithSeed(seed int) GenOptions {
+	return GenOptions{Seed: Ptr(seed)}
+}
+
+// Returns base with every field set in override layered on top.
+func mergeGenOptions(base GenOptions, override GenOptions) GenOptions {
+	if override.Preset != "" {
+		base.Preset = override.Preset
+	}
+	// Each way of capping tokens replaces the other
+	if override.MaxTokens > 0 {
+		base.MaxTokens = override.MaxTokens
+		base.MaxTokensFraction = 0
+	}
+	if override.MaxTokensFraction != 0 {
+		base.MaxTokensFraction = override.MaxTokensFraction
+		base.MaxTokens = 0
+	}
+	if override.Temperature != nil {
+		base.Temperature = override.Temperature
+	}
+	if override.TopK != nil {
+		base.TopK = override.TopK
+	}
+	if override.TopP != nil {
+		base.TopP = override.TopP
+	}
+	if override.MinP != nil {
+		base.MinP = override.MinP
+	}
+	if override.RepeatPenalty != nil {
+		base.RepeatPenalty = override.RepeatPenalty
+	}
+	if override.Stop != nil {
+		base.Stop = override.Stop
+	}
+	if override.Grammar != "" {
+		base.Grammar = override.Grammar
+	}
+	if override.JSONOutput {
+		base.JSONOutput = true
+	}
+	if len(override.JSONSchema) > 0 {
+		base.JSONSchema = override.JSONSchema
+	}
+	if override.LogitBias != nil {
+		base.LogitBias = override.LogitBias
+	}
+	if override.CachePrompt != nil {
+		base.CachePrompt = override.CachePrompt
+	}
+	if override.Tools != nil {
+		base.Tools = override.Tools
+	}
+	if override.Logprobs > 0 {
+		base.Logprobs = override.Logprobs
+	}
+	if override.N > 0 {
+		base.N = override.N
+	}
+	if override.Seed != nil {
+		base.Seed = override.Seed
+	}
+	if override.Idempotent {
+		base.Idempotent = true
+	}
+	if override.TimeBudget > 0 {
+		base.TimeBudget = override.TimeBudget
+	}
+	if override.AddBOS != nil {
+		base.AddBOS = override.AddBOS
+	}
+	if override.ParseSpecial != nil {
+		base.ParseSpecial = override.ParseSpecial
+	}
+	if override.ReturnSpecial {
+		base.ReturnSpecial = true
+	}
+	return base
+}
+
+// Layers the handle defaults, the preset and the request options, in that order.
+func resolveGenOptions(base GenOptions, opts GenOptions) (GenOptions, error) {
+	for _, o := range []GenOptions{base, opts} {
+		err := o.validateMaxTokens()
+		if err != nil {
+			return opts, err
+		}
+	}
+
+	presetName := opts.Preset
+	if presetName == "" {
+		presetName = base.Preset
+	}
+	if presetName != "" {
+		preset, ok := PresetOptions(presetName)
+		if !ok {
+			return opts, errors.New("unknown preset: " + presetName)
+		}
+		base = mergeGenOptions(base, preset)
+	}
+	opts = mergeGenOptions(base, opts)
+
+	defaults := DefaultConfig().DefaultGenOptions
+	if opts.MaxTokens <= 0 && opts.MaxTokensFraction == 0 {
+		opts.MaxTokens = defaults.MaxTokens
+	}
+	if opts.Stop == nil && defaults.Stop != nil {
+		opts.Stop = slices.Clone(defaults.Stop)
+	}
+	return opts, nil
+}
+
+func (o GenOptions) validateMaxTokens() error {
+	if o.MaxTokensFraction == 0 {
+		return nil
+	}
+	if o.MaxTokens > 0 {
+		return errors.New("MaxTokens and MaxTokensFraction are mutually exclusive")
+	}
+	if o.MaxTokensFraction < 0 || o.MaxTokensFraction > 1 {
+		return fmt.Errorf("MaxTokensFraction must be in (0, 1], got %v", o.MaxTokensFraction)
+	}
+	return nil
+}
+
+// Request body a GenOptions is written into, see GenOptions.apply.
+type endpointKind int
+
+const (
+	// /completion and the other llama.cpp endpoints
+	_ENDPOINT_NATIVE endpointKind = iota
+	// /v1/chat/completions and the other OpenAI-compatible endpoints
+	_ENDPOINT_OPENAI
+)
+
+// Writes the options into a request body for the endpoints of kind, which
+// name some fields differently and only support some of them.
+func (o GenOptions) apply(data map[string]any, kind endpointKind) {
+	openai := kind == _ENDPOINT_OPENAI
+	if openai {
+		data["max_tokens"] = o.MaxTokens
+	} else {
+		data["n_predict"] = o.MaxTokens
+	}
+	data["stop"] = o.Stop
+
+	if o.Temperature != nil {
+		data["temperature"] = *o.Temperature
+	}
+	if o.TopK != nil {
+		data["top_k"] = *o.TopK
+	}
+	if o.TopP != nil {
+		data["top_p"] = *o.TopP
+	}
+	if o.MinP != nil {
+		data["min_p"] = *o.MinP
+	}
+	if o.RepeatPenalty != nil {
+		data["repeat_penalty"] = *o.RepeatPenalty
+	}
+	if o.Grammar != "" {
+		data["grammar"] = o.Grammar
+	}
+	if len(o.JSONSchema) > 0 {
+		data["json_schema"] = o.JSONSchema
+	} else if o.JSONOutput && openai {
+		data["response_format"] = map[string]string{"type": "json_object"}
+	}
+	if len(o.LogitBias) > 0 {
+		data["logit_bias"] = encodeLogitBias(o.LogitBias)
+	}
+	if o.CachePrompt != nil {
+		data["cache_prompt"] = *o.CachePrompt
+	}
+	if o.Seed != nil {
+		data["seed"] = *o.Seed
+	}
+	if o.N > 1 && openai {
+		data["n"] = o.N
+	}
+	if o.Logprobs > 0 {
+		// The OpenAI-compatible endpoints use OpenAI's fields
+		if openai {
+			data["logprobs"] = true
+			data["top_logprobs"] = o.Logprobs
+		} else {
+			data["n_probs"] = o.Logprobs
+		}
+	}
+	if len(o.Tools) > 0 {
+		tools := []map[string]any{}
+		for _, t := range o.Tools {
+			tools = append(tools, map[string]any{"type": "function", "function": t})
+		}
+		data["tools"] = tools
+	}
+}
@@ -0,0 +1,35 @@
+package xplatai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyPerEndpoint(t *testing.T) {
+	opts := GenOptions{MaxTokens: 32, N: 2, Logprobs: 3, JSONOutput: true}
+	tests := []struct {
+		kind endpointKind
+		want map[string]any
+	}{
+		{_ENDPOINT_NATIVE, map[string]any{
+			"n_predict": 32,
+			"stop":      []string(nil),
+			"n_probs":   3,
+		}},
+		{_ENDPOINT_OPENAI, map[string]any{
+			"max_tokens":      32,
+			"stop":            []string(nil),
+			"n":               2,
+			"logprobs":        true,
+			"top_logprobs":    3,
+			"response_format": map[string]string{"type": "json_object"},
+		}},
+	}
+	for _, tt := range tests {
+		data := map[string]any{}
+		opts.apply(data, tt.kind)
+		if !reflect.DeepEqual(data, tt.want) {
+			t.Errorf("endpoint %d: got %v, want %v", tt.kind, data, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package xplatai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Paths longer than this get the extended-length prefix on Windows, leaving
+// headroom below MAX_PATH (260) for file names appended by llama.cpp.
+const longPathThreshold = 240
+
+// Adds the \\?\ prefix to long absolute Windows paths so they aren't limited
+// by MAX_PATH. No-op on other platforms.
+func longPath(p string) string {
+	if runtime.GOOS != "windows" || len(p) <= longPathThreshold || !filepath.IsAbs(p) {
+		return p
+	}
+	if strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+
+	p = filepath.Clean(p)
+	if strings.HasPrefix(p, `\\`) {
+		return `\\?\UNC\` + p[2:]
+	}
+	return `\\?\` + p
+}
+
+func installDir() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("could not determine working directory: %w", err)
+	}
+	return filepath.Join(cwd, "llamacpp"), nil
+}
+
+// Absolute path of a file in the install dir, long path prefixed if needed.
+func installPath(elem ...string) (string, error) {
+	dir, err := installDir()
+	if err != nil {
+		return "", err
+	}
+	return longPath(filepath.Join(append([]string{dir}, elem...)...)), nil
+}
@@ -0,0 +1,18 @@
+package xplatai
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLongPathOnlyPrefixesOnWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("covered by the Windows tests")
+	}
+	p := filepath.Join(t.TempDir(), strings.Repeat("モデル-", 60), "model.gguf")
+	if got := longPath(p); got != p {
+		t.Errorf("longPath changed %q to %q", p, got)
+	}
+}
@@ -0,0 +1,47 @@
+package xplatai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPathPrefixes(t *testing.T) {
+	long := strings.Repeat("d", longPathThreshold)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`C:\short\model.gguf`, `C:\short\model.gguf`},
+		{`relative\` + long, `relative\` + long},
+		{`C:\` + long, `\\?\C:\` + long},
+		{`C:\` + long + `\..\x`, `\\?\C:\x`},
+		{`\\server\share\` + long, `\\?\UNC\server\share\` + long},
+		{`\\?\C:\` + long, `\\?\C:\` + long},
+	}
+	for _, tt := range tests {
+		if got := longPath(tt.path); got != tt.want {
+			t.Errorf("longPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// Nested unicode directories well past MAX_PATH, like a model cache under a
+// deep user profile.
+func TestLongUnicodePathRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for len(dir) < 400 {
+		dir = filepath.Join(dir, "モデル-キャッシュ-😀-données")
+	}
+	fPath := filepath.Join(dir, "ggml-モデル.bin")
+
+	err := extractFile(fPath, 0644, strings.NewReader("weights"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(longPath(fPath))
+	if err != nil || string(b) != "weights" {
+		t.Fatalf("read back %q, %v", b, err)
+	}
+}
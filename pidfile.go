@@ -0,0 +1,184 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrServerRunning = errors.New("a server spawned by another process is still running")
+
+// One pid file per port, several handles can each run a server
+const (
+	pidFilePrefix = "server-"
+	pidFileExt    = ".pid"
+)
+
+// How long New waits for a previous server to exit, or to die once killed.
+const previousServerWait = 15 * time.Second
+
+// Identifies the server spawned from the install dir. The start times guard
+// against pids being reused by unrelated processes.
+type pidFile struct {
+	Pid       int    `json:"pid"`
+	StartTime string `json:"start_time"`
+	Port      string `json:"port"`
+	Model     string `json:"model"`
+	Url       string `json:"url"`
+	// Process that spawned the server
+	Owner      int    `json:"owner"`
+	OwnerStart string `json:"owner_start"`
+}
+
+var selfStartTime = sync.OnceValue(func() string {
+	start, _ := processStartTime(os.Getpid())
+	return start
+})
+
+func pidFilePath(port string) (string, error) {
+	return installPath(pidFilePrefix + port + pidFileExt)
+}
+
+func readPidFile(port string) (pidFile, bool) {
+	pfPath, err := pidFilePath(port)
+	if err != nil {
+		return pidFile{}, false
+	}
+	return readPidFileAt(pfPath)
+}
+
+func readPidFileAt(pfPath string) (pidFile, bool) {
+	pf := pidFile{}
+	b, err := os.ReadFile(pfPath)
+	if err != nil {
+		return pf, false
+	}
+	return pf, json.Unmarshal(b, &pf) == nil
+}
+
+// Pid files of every port.
+func readPidFiles() []pidFile {
+	dirPath, err := installPath()
+	if err != nil {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dirPath, pidFilePrefix+"*"+pidFileExt))
+
+	pfs := []pidFile{}
+	for _, pfPath := range paths {
+		if pf, ok := readPidFileAt(pfPath); ok {
+			pfs = append(pfs, pf)
+		}
+	}
+	return pfs
+}
+
+// Records a server spawned by this process.
+func writePidFile(pf pidFile) error {
+	pfPath, err := pidFilePath(pf.Port)
+	if err != nil {
+		return err
+	}
+
+	pf.Owner = os.Getpid()
+	pf.OwnerStart = selfStartTime()
+	b, err := json.Marshal(pf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pfPath, b, 0644)
+}
+
+// Removes the pid file of port if it still describes pid.
+func removePidFile(port string, pid int) {
+	pf, ok := readPidFile(port)
+	if !ok || pf.Pid != pid {
+		return
+	}
+	if pfPath, err := pidFilePath(port); err == nil {
+		os.Remove(pfPath)
+	}
+}
+
+// Whether the server was spawned by a handle of this process, which owns it.
+func (pf pidFile) ownedBySelf() bool {
+	return pf.Owner == os.Getpid() && pf.OwnerStart == selfStartTime()
+}
+
+// Whether the process recorded in pf is still the one that wrote it.
+func (pf pidFile) alive() bool {
+	if pf.Pid <= 0 {
+		return false
+	}
+	start, err := processStartTime(pf.Pid)
+	return err == nil && start == pf.StartTime
+}
+
+func (pf pidFile) healthy() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", pf.Url+"/health", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 500
+}
+
+func (pf pidFile) waitExit(clk clock, timeout time.Duration) bool {
+	deadline := clk.Now().Add(timeout)
+	for pf.alive() {
+		if clk.Now().After(deadline) {
+			return false
+		}
+		<-clk.After(100 * time.Millisecond)
+	}
+	return true
+}
+
+// Deals with a server left running on the port by a previous process.
+// Returns it when it can be adopted, otherwise makes sure it is gone before a
+// new one spawns. Servers of other handles of this process are left alone.
+func (x *XpltAI) handlePreviousServer(cfg Config, model string) (*pidFile, error) {
+	pf, ok := readPidFile(cfg.Port)
+	if !ok {
+		return nil, nil
+	}
+
+	if !pf.alive() {
+		removePidFile(pf.Port, pf.Pid)
+		return nil, nil
+	}
+
+	if pf.ownedBySelf() {
+		return nil, fmt.Errorf("port %s is used by another handle", cfg.Port)
+	}
+
+	if pf.Model == model && pf.Url == x.hosts[0] && pf.healthy() {
+		return &pf, nil
+	}
+
+	if cfg.TakeOver {
+		proc, err := os.FindProcess(pf.Pid)
+		if err == nil {
+			proc.Kill()
+		}
+	}
+
+	if !pf.waitExit(x.clock, previousServerWait) {
+		return nil, fmt.Errorf("%w: pid %d", ErrServerRunning, pf.Pid)
+	}
+	removePidFile(pf.Port, pf.Pid)
+	return nil, nil
+}
@@ -0,0 +1,282 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// Stands in for a server left running by a previous process when started by
+// startPreviousServer.
+func TestPreviousServerHelper(t *testing.T) {
+	if os.Getenv("XPLATAI_PREVIOUS_SERVER") == "" {
+		t.Skip("helper process")
+	}
+	time.Sleep(time.Minute)
+}
+
+// Starts a process that runs until killed, returning its pid and a channel
+// closed once it exited.
+func startPreviousServer(t *testing.T) (int, <-chan struct{}) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPreviousServerHelper$")
+	cmd.Env = append(os.Environ(), "XPLATAI_PREVIOUS_SERVER=1")
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+	return cmd.Process.Pid, exited
+}
+
+// Writes pf as left by another process, writePidFile records this one.
+func writeTestPidFile(t *testing.T, pf pidFile) {
+	t.Helper()
+	pfPath, err := pidFilePath(pf.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(pfPath, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pidFileExists(t *testing.T, port string) bool {
+	t.Helper()
+	_, ok := readPidFile(port)
+	return ok
+}
+
+func exitedWithin(exited <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-exited:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestStalePidFileIsRemoved(t *testing.T) {
+	fakeInstall(t)
+	writeTestPidFile(t, pidFile{Pid: 1<<22 + 999, StartTime: "1", Port: "18080", Model: "test/model-GGUF:Q4_K_M"})
+
+	procs := &fakeProcs{}
+	x, err := NewWithConfig(fakeConfig(newFakeClock(), procs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	if pidFileExists(t, "18080") {
+		t.Error("stale pid file kept")
+	}
+	if procs.running() != 1 {
+		t.Errorf("%d servers running, want a fresh one", procs.running())
+	}
+}
+
+// A pid handed to an unrelated process is neither adopted nor killed, even
+// with TakeOver.
+func TestReusedPidIsLeftAlone(t *testing.T) {
+	fakeInstall(t)
+	pid, exited := startPreviousServer(t)
+	writeTestPidFile(t, pidFile{Pid: pid, StartTime: "not its start time", Port: "18080", Model: "test/model-GGUF:Q4_K_M"})
+
+	procs := &fakeProcs{}
+	cfg := fakeConfig(newFakeClock(), procs)
+	cfg.TakeOver = true
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	if x.adoptedPid != 0 || procs.running() != 1 {
+		t.Errorf("adopted pid %d with %d servers spawned, want a fresh server", x.adoptedPid, procs.running())
+	}
+	if pidFileExists(t, "18080") {
+		t.Error("pid file of a reused pid kept")
+	}
+	if exitedWithin(exited, 100*time.Millisecond) {
+		t.Error("unrelated process killed")
+	}
+}
+
+func TestHealthyPreviousServerIsAdopted(t *testing.T) {
+	fakeInstall(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	pid, exited := startPreviousServer(t)
+	start, err := processStartTime(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPidFile(t, pidFile{Pid: pid, StartTime: start, Port: port, Model: "test/model-GGUF:Q4_K_M", Url: "http://127.0.0.1:" + port})
+
+	procs := &fakeProcs{}
+	cfg := fakeConfig(newFakeClock(), procs)
+	cfg.Port = port
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.adoptedPid != pid || len(procs.procs) != 0 {
+		t.Fatalf("adopted pid %d with %d servers spawned, want %d and none", x.adoptedPid, len(procs.procs), pid)
+	}
+
+	// The adopted server is owned by the handle from now on
+	x.Close()
+	if !exitedWithin(exited, 5*time.Second) {
+		t.Error("adopted server still running after Close")
+	}
+	if pidFileExists(t, port) {
+		t.Error("pid file kept after the adopted server was closed")
+	}
+}
+
+func TestLivePreviousServerIsWaitedFor(t *testing.T) {
+	fakeInstall(t)
+	pid, exited := startPreviousServer(t)
+	start, err := processStartTime(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPidFile(t, pidFile{Pid: pid, StartTime: start, Port: "18080", Model: "other/model-GGUF:Q8_0"})
+
+	clk := newFakeClock()
+	procs := &fakeProcs{}
+	var x *XpltAI
+	elapsed := clk.runUntil(t, func() {
+		x, err = NewWithConfig(fakeConfig(clk, procs))
+	})
+	if x != nil || !errors.Is(err, ErrServerRunning) {
+		t.Fatalf("got %v, want ErrServerRunning", err)
+	}
+	if elapsed < previousServerWait || len(procs.procs) != 0 {
+		t.Errorf("gave up after %v with %d servers spawned, want %v and none", elapsed, len(procs.procs), previousServerWait)
+	}
+	if exitedWithin(exited, 100*time.Millisecond) {
+		t.Error("previous server killed without TakeOver")
+	}
+}
+
+func TestTakeOverKillsPreviousServer(t *testing.T) {
+	fakeInstall(t)
+	pid, exited := startPreviousServer(t)
+	start, err := processStartTime(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPidFile(t, pidFile{Pid: pid, StartTime: start, Port: "18080", Model: "other/model-GGUF:Q8_0"})
+
+	clk := newFakeClock()
+	procs := &fakeProcs{}
+	cfg := fakeConfig(clk, procs)
+	cfg.TakeOver = true
+	var x *XpltAI
+	clk.runUntil(t, func() {
+		x, err = NewWithConfig(cfg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	if !exitedWithin(exited, 5*time.Second) {
+		t.Error("previous server still running")
+	}
+	if procs.running() != 1 || pidFileExists(t, "18080") {
+		t.Errorf("%d servers running, want a fresh one and the old pid file gone", procs.running())
+	}
+}
+
+// Handles of one process each record their server, and never wait for, kill
+// or adopt the server of another.
+func TestHandlesOfOneProcessKeepTheirServers(t *testing.T) {
+	fakeInstall(t)
+	clk := newFakeClock()
+	// Real pids get pid files, the fakes stand in for servers of this process
+	procs := &fakeProcs{pid: os.Getpid()}
+
+	var first, second *XpltAI
+	var firstErr, secondErr error
+	elapsed := clk.runUntil(t, func() {
+		first, firstErr = NewWithConfig(fakeConfig(clk, procs))
+		cfg := fakeConfig(clk, procs)
+		cfg.Port = "18081"
+		cfg.TakeOver = true
+		second, secondErr = NewWithConfig(cfg)
+	})
+	if firstErr != nil || secondErr != nil {
+		t.Fatalf("got %v and %v", firstErr, secondErr)
+	}
+	defer first.Close()
+
+	if elapsed != 0 || procs.running() != 2 {
+		t.Errorf("took %v with %d servers running, want no wait and two", elapsed, procs.running())
+	}
+	if !pidFileExists(t, "18080") || !pidFileExists(t, "18081") {
+		t.Fatal("want a pid file per port")
+	}
+
+	second.Close()
+	if !pidFileExists(t, "18080") || pidFileExists(t, "18081") {
+		t.Error("want only the pid file of the closed handle removed")
+	}
+	if !procs.procs[0].running() {
+		t.Error("server of the first handle stopped")
+	}
+}
+
+func TestSecondHandleOnSamePortFailsFast(t *testing.T) {
+	fakeInstall(t)
+	clk := newFakeClock()
+	procs := &fakeProcs{pid: os.Getpid()}
+
+	var first, second *XpltAI
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		first, err = NewWithConfig(fakeConfig(clk, procs))
+		if err != nil {
+			return
+		}
+		cfg := fakeConfig(clk, procs)
+		cfg.TakeOver = true
+		second, err = NewWithConfig(cfg)
+	})
+	if first == nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	if second != nil || err == nil || errors.Is(err, ErrServerRunning) {
+		t.Fatalf("got %v, want the port reported in use", err)
+	}
+	if elapsed != 0 || procs.running() != 1 {
+		t.Errorf("took %v with %d servers running, want no wait and one", elapsed, procs.running())
+	}
+	if pf, ok := readPidFile("18080"); !ok || !pf.ownedBySelf() {
+		t.Error("pid file of the first handle lost")
+	}
+}
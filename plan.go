@@ -0,0 +1,151 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Exact assets of an install, resolved once with ResolveDownloadPlan and
+// executed on any number of machines with ExecutePlan.
+type Plan struct {
+	Version string `json:"version"`
+	// Flavor the plan installs
+	Hardware HostHardware `json:"hardware"`
+	OS       string       `json:"os"`
+	Arch     string       `json:"arch"`
+	Assets   []PlanAsset  `json:"assets"`
+}
+
+type PlanAsset struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Resolves the assets installing llama.cpp version on this platform would
+// download. version defaults to the pinned release. Assets the GitHub API has
+// no digest for are downloaded once to compute it.
+func ResolveDownloadPlan(hardware HostHardware, version string) (Plan, error) {
+	if version == "" {
+		version = lcp_VERSION
+	}
+
+	plan := Plan{Version: version, Hardware: platformHardware(hardware), OS: runtime.GOOS, Arch: runtime.GOARCH}
+
+	assetName, err := releaseAssetName(hardware, version)
+	if err != nil {
+		return plan, err
+	}
+
+	assets, err := fetchReleaseAssets(context.Background(), version)
+	if err != nil {
+		return plan, err
+	}
+
+	var found *releaseAsset
+	for _, ext := range archiveExts {
+		for i := range assets {
+			if assets[i].Name == assetName+ext {
+				found = &assets[i]
+				break
+			}
+		}
+		if found != nil {
+			break
+		}
+	}
+	if found == nil {
+		return plan, errors.New("release has no asset named " + assetName)
+	}
+
+	asset := PlanAsset{
+		Name:   found.Name,
+		Url:    found.Url,
+		Size:   found.Size,
+		SHA256: strings.TrimPrefix(found.Digest, "sha256:"),
+	}
+
+	if asset.SHA256 == "" {
+		tmp, err := os.MkdirTemp("", "xplatai-plan")
+		if err != nil {
+			return plan, err
+		}
+		defer os.RemoveAll(tmp)
+
+		asset.Size, asset.SHA256, err = downloadFile(context.Background(), asset.Url, filepath.Join(tmp, asset.Name), "", func(int64, int64) {})
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	plan.Assets = append(plan.Assets, asset)
+	return plan, nil
+}
+
+// Installs exactly the assets of plan, failing on any size or checksum
+// mismatch instead of falling back to another asset.
+func ExecutePlan(ctx context.Context, plan Plan, progress func(done int64, total int64)) error {
+	if plan.OS != runtime.GOOS || plan.Arch != runtime.GOARCH {
+		return fmt.Errorf("%w: plan is for %s/%s", ErrUnsupportedPlatform, plan.OS, plan.Arch)
+	}
+	if len(plan.Assets) == 0 {
+		return errors.New("plan has no assets")
+	}
+
+	total := int64(0)
+	for _, asset := range plan.Assets {
+		if asset.Url == "" || asset.SHA256 == "" || asset.Size <= 0 {
+			return errors.New("plan asset is incomplete: " + asset.Name)
+		}
+		if filepath.Base(asset.Name) != asset.Name || !strings.HasSuffix(asset.Name, archiveExt(asset.Name)) {
+			return errors.New("plan asset has an invalid name: " + asset.Name)
+		}
+		total += asset.Size
+	}
+
+	lock, err := acquireInstallLock(ctx, realClock{}, progress)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	dirPath, err := prepareStagingDir(plan.Hardware)
+	if err != nil {
+		return err
+	}
+
+	previous := int64(0)
+	staged := stagedInstall{Version: plan.Version}
+	for _, asset := range plan.Assets {
+		archivePath := filepath.Join(dirPath, asset.Name)
+
+		size, sum, err := downloadFile(ctx, asset.Url, archivePath, asset.SHA256, func(done int64, _ int64) {
+			if progress != nil {
+				progress(previous+done, total)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if size != asset.Size {
+			return fmt.Errorf("%s: expected %d bytes, got %d", asset.Name, asset.Size, size)
+		}
+		if !strings.EqualFold(sum, asset.SHA256) {
+			return fmt.Errorf("%s: checksum mismatch, expected %s, got %s", asset.Name, asset.SHA256, sum)
+		}
+		previous += size
+
+		files, err := extractArchive(archivePath, dirPath)
+		if err != nil {
+			return err
+		}
+		staged.Files = append(staged.Files, files...)
+	}
+	return activateFlavor(realClock{}, plan.Hardware, staged, DefaultConfig().StopForUpgrade)
+}
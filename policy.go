@@ -0,0 +1,113 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrModelRejected = errors.New("model rejected by policy")
+
+// Decides whether a model may be used, a non-nil error rejects it.
+type ModelPolicy func(spec ModelSpec, meta ModelMetadata) error
+
+// Metadata a ModelPolicy decides on, from the Hugging Face API for remote
+// models and the file header for local .gguf files.
+type ModelMetadata struct {
+	Tags    []string
+	License string
+	// Tagged not-for-all-audiences
+	NSFW bool
+	// False when the metadata couldn't be fetched, e.g. while offline
+	Available bool
+}
+
+// Allows only models whose source is in sources.
+func AllowModels(sources ...string) ModelPolicy {
+	return func(spec ModelSpec, meta ModelMetadata) error {
+		if slices.Contains(sources, hfRepo(spec.Source)) || slices.Contains(sources, spec.Source) {
+			return nil
+		}
+		return errors.New(spec.Source + " is not allowed")
+	}
+}
+
+// Rejects models tagged not-for-all-audiences, and models without metadata
+// when requireMetadata is set.
+func DenyNSFW(requireMetadata bool) ModelPolicy {
+	return func(spec ModelSpec, meta ModelMetadata) error {
+		if meta.NSFW {
+			return errors.New(spec.Source + " is not for all audiences")
+		}
+		if requireMetadata && !meta.Available {
+			return errors.New("could not get the metadata of " + spec.Source)
+		}
+		return nil
+	}
+}
+
+// Runs policy on spec, nil policies allow every model.
+func checkModelPolicy(policy ModelPolicy, spec ModelSpec) error {
+	if policy == nil {
+		return nil
+	}
+
+	err := policy(spec, fetchModelMetadata(spec))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrModelRejected, err)
+	}
+	return nil
+}
+
+func hfRepo(source string) string {
+	repo, _, _ := strings.Cut(source, ":")
+	return repo
+}
+
+func fetchModelMetadata(spec ModelSpec) ModelMetadata {
+	meta := ModelMetadata{}
+
+	if spec.IsLocal() {
+		values, err := readGGUFMetadata(spec.Source)
+		if err != nil {
+			return meta
+		}
+		meta.Tags = values["general.tags"]
+		if license := values["general.license"]; len(license) > 0 {
+			meta.License = license[0]
+		}
+		meta.Available = true
+	} else {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get("https://huggingface.co/api/models/" + hfRepo(spec.Source))
+		if err != nil {
+			return meta
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return meta
+		}
+
+		info := struct {
+			Tags     []string `json:"tags"`
+			CardData struct {
+				License string `json:"license"`
+			} `json:"cardData"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&info)
+		if err != nil {
+			return meta
+		}
+		meta.Tags = info.Tags
+		meta.License = info.CardData.License
+		meta.Available = true
+	}
+
+	meta.NSFW = slices.Contains(meta.Tags, "not-for-all-audiences")
+	return meta
+}
@@ -0,0 +1,72 @@
+package xplatai
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func (x *XpltAI) postProcess(text string) string {
+	for _, process := range x.postProcessors {
+		text = process(text)
+	}
+	return text
+}
+
+// Removes a leading "name:" the model writes before its reply.
+func StripPrefix(name string) func(string) string {
+	prefix := strings.ToLower(name) + ":"
+	return func(text string) string {
+		trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+		if strings.HasPrefix(strings.ToLower(trimmed), prefix) {
+			return strings.TrimLeftFunc(trimmed[len(prefix):], unicode.IsSpace)
+		}
+		return text
+	}
+}
+
+// Cuts the text after its last complete sentence, for replies that hit the
+// token limit mid-sentence. Text without any sentence end is left untouched.
+func TrimIncompleteSentence(text string) string {
+	trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+
+	end := strings.LastIndexAny(trimmed, ".!?…")
+	if end < 0 {
+		return text
+	}
+
+	_, size := utf8.DecodeRuneInString(trimmed[end:])
+	end += size
+
+	// Keep closing quotes, brackets and emphasis attached to the sentence
+	for end < len(trimmed) {
+		r, size := utf8.DecodeRuneInString(trimmed[end:])
+		if !strings.ContainsRune("\"')]*”’", r) {
+			break
+		}
+		end += size
+	}
+	return trimmed[:end]
+}
+
+// Replaces runs of spaces and tabs with a single space and keeps at most one
+// blank line between paragraphs.
+func CollapseWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if blank || len(out) == 0 {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,228 @@
+package xplatai
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	CHECK_PASS = "pass"
+	CHECK_FAIL = "fail"
+	// The check doesn't apply, or can't run on this platform
+	CHECK_SKIP = "skip"
+)
+
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type PreflightReport struct {
+	Checks []PreflightCheck `json:"checks"`
+	// Arguments the server would be spawned with, empty when the config is invalid
+	Args []string `json:"args,omitempty"`
+}
+
+// True when no check failed.
+func (r PreflightReport) Passed() bool {
+	for _, c := range r.Checks {
+		if c.Status == CHECK_FAIL {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *PreflightReport) add(name string, status string, message string) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Status: status, Message: message})
+}
+
+// Runs the checks NewWithConfig does without spawning a server, writing to
+// disk or touching other processes. The returned error joins the errors of
+// the failed checks.
+func Preflight(cfg Config) (PreflightReport, error) {
+	report := PreflightReport{}
+	errs := []error{}
+
+	fail := func(name string, err error) {
+		report.add(name, CHECK_FAIL, err.Error())
+		errs = append(errs, err)
+	}
+
+	cfg, spec, err := resolveConfig(cfg)
+	if err != nil {
+		fail("config", err)
+		return report, errors.Join(errs...)
+	}
+	report.add("config", CHECK_PASS, "")
+
+	flavor := pickFlavor(cfg.Hardware)
+	if _, err := flavorBinary(flavor, "llama-server.exe"); err == nil {
+		report.add("binaries", CHECK_PASS, flavorName(flavor)+" flavor")
+	} else if _, cpuErr := flavorBinary(cpuFlavor(), "llama-server.exe"); flavor != cpuFlavor() && cpuErr == nil {
+		report.add("binaries", CHECK_PASS, fmt.Sprintf("%v, would fall back to the %s flavor", err, flavorName(cpuFlavor())))
+	} else {
+		fail("binaries", err)
+	}
+
+	err = checkModelPolicy(cfg.ModelPolicy, spec)
+	if err != nil {
+		fail("model policy", err)
+	} else {
+		report.add("model policy", CHECK_PASS, "")
+	}
+
+	if spec.IsLocal() {
+		if exists, _ := isPathExist(spec.Source); exists {
+			report.add("model", CHECK_PASS, spec.Source)
+		} else {
+			fail("model", fmt.Errorf("model file not found: %s", spec.Source))
+		}
+	} else if spec.Revision != "" {
+		repo, quant := splitHFRef(spec)
+		if _, ok := cachedModel(spec); ok {
+			report.add("model", CHECK_PASS, spec.HFRef()+"@"+spec.Revision+" is cached")
+		} else if _, file, err := resolveRevision(repo, quant, spec.Revision); err == nil {
+			report.add("model", CHECK_PASS, file+" at "+spec.Revision+" is reachable")
+		} else {
+			fail("model", err)
+		}
+	} else if isHFModelCached(spec) {
+		report.add("model", CHECK_PASS, spec.HFRef()+" is cached")
+	} else if fetchModelMetadata(spec).Available {
+		report.add("model", CHECK_PASS, spec.HFRef()+" is reachable")
+	} else {
+		fail("model", fmt.Errorf("%s is neither cached nor reachable", spec.HFRef()))
+	}
+
+	err = checkPortFree(cfg)
+	if err != nil {
+		fail("port", err)
+	} else {
+		report.add("port", CHECK_PASS, "")
+	}
+
+	cfg, ctxWarning, err := negotiateContext(cfg, spec)
+	if err != nil {
+		fail("context", err)
+	} else {
+		report.add("context", CHECK_PASS, ctxWarning)
+	}
+
+	checkMemory(&report, spec, fail)
+	checkLimits(&report, cfg)
+
+	port, err := strconv.Atoi(cfg.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		fail("arguments", fmt.Errorf("invalid port: %q", cfg.Port))
+	} else {
+		report.add("arguments", CHECK_PASS, "")
+	}
+	report.Args = serverArgs(cfg, spec)
+
+	return report, errors.Join(errs...)
+}
+
+// Fails when something other than an adoptable server holds the port.
+func checkPortFree(cfg Config) error {
+	url := serverUrls(cfg.BindHost, cfg.LoopbackHost, cfg.Port)[0]
+	if pf, ok := readPidFile(cfg.Port); ok && pf.alive() {
+		if pf.ownedBySelf() {
+			return fmt.Errorf("port %s is used by another handle", cfg.Port)
+		}
+		if pf.Url == url && pf.healthy() {
+			return nil
+		}
+		if !cfg.TakeOver {
+			return fmt.Errorf("%w: pid %d", ErrServerRunning, pf.Pid)
+		}
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(strings.Trim(cfg.BindHost, "[]"), cfg.Port))
+	if err != nil {
+		return fmt.Errorf("port %s is not available: %w", cfg.Port, err)
+	}
+	return ln.Close()
+}
+
+// Whether llama-server already downloaded the model to its cache, which
+// names files after the repository with slashes replaced.
+func isHFModelCached(spec ModelSpec) bool {
+	cacheDir := os.Getenv("LLAMA_CACHE")
+	if cacheDir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return false
+		}
+		cacheDir = filepath.Join(userCache, "llama.cpp")
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return false
+	}
+
+	prefix := strings.ReplaceAll(hfRepo(spec.Source), "/", "_") + "_"
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), ".gguf") {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares the size of local models to the available memory, which is only
+// known on Linux.
+func checkMemory(report *PreflightReport, spec ModelSpec, fail func(string, error)) {
+	if !spec.IsLocal() {
+		report.add("memory", CHECK_SKIP, "model size unknown until downloaded")
+		return
+	}
+	info, err := os.Stat(spec.Source)
+	if err != nil {
+		report.add("memory", CHECK_SKIP, "model size unknown")
+		return
+	}
+
+	available, ok := availableMemory()
+	if !ok {
+		report.add("memory", CHECK_SKIP, "available memory unknown on "+runtime.GOOS)
+		return
+	}
+	if info.Size() > available {
+		fail("memory", fmt.Errorf("model needs %d bytes, %d available", info.Size(), available))
+		return
+	}
+	report.add("memory", CHECK_PASS, "")
+}
+
+func availableMemory() (int64, bool) {
+	if runtime.GOOS != "linux" {
+		return 0, false
+	}
+
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package xplatai
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Opaque value identifying when pid started, differs for a reused pid.
+func processStartTime(pid int) (string, error) {
+	if runtime.GOOS == "linux" {
+		b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+		if err != nil {
+			return "", err
+		}
+
+		// The command name may contain spaces, fields restart after its ')'
+		stat := string(b)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 20 {
+			return "", errors.New("unexpected /proc stat format")
+		}
+		if fields[0] == "Z" {
+			return "", errors.New("process is a zombie")
+		}
+		return fields[19], nil
+	}
+
+	out, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+	start := strings.TrimSpace(string(out))
+	if start == "" {
+		return "", errors.New("no such process")
+	}
+	return start, nil
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package xplatai
+
+import (
+	"strconv"
+	"syscall"
+)
+
+const _PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+
+// Opaque value identifying when pid started, differs for a reused pid.
+func processStartTime(pid int) (string, error) {
+	h, err := syscall.OpenProcess(_PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+
+	var exitCode uint32
+	err = syscall.GetExitCodeProcess(h, &exitCode)
+	if err != nil {
+		return "", err
+	}
+	// STILL_ACTIVE
+	if exitCode != 259 {
+		return "", syscall.ERROR_PROC_NOT_FOUND
+	}
+
+	var creation, exit, kernel, user syscall.Filetime
+	err = syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(creation.Nanoseconds(), 10), nil
+}
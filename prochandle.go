@@ -0,0 +1,66 @@
+package xplatai
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// The spawned server process, swapped for a fake process to simulate crashes
+// and slow loads in tests.
+type procHandle interface {
+	Start() error
+	Wait() error
+	Kill() error
+	Signal(sig os.Signal) error
+	Pid() int
+}
+
+// Resource limits the process starts with, see Config.Nice, CPUAffinity and
+// MemoryLimitBytes.
+type procLimits struct {
+	nice             int
+	cpuAffinity      []int
+	memoryLimitBytes int64
+}
+
+func (l procLimits) isSet() bool {
+	return l.nice != 0 || len(l.cpuAffinity) > 0 || l.memoryLimitBytes > 0
+}
+
+// Creates the server process running in dir, stderr receives its log output.
+type procFactory func(path string, args []string, dir string, stderr io.Writer, limits procLimits) procHandle
+
+type execProc struct {
+	cmd    *exec.Cmd
+	limits procLimits
+}
+
+func newExecProc(path string, args []string, dir string, stderr io.Writer, limits procLimits) procHandle {
+	cmd := exec.Command(path, args...)
+	cmd.Dir = dir
+	cmd.Stderr = stderr
+	return &execProc{cmd: cmd, limits: limits}
+}
+
+// Fails with errLimits when the limits can't be applied, the process is not
+// left running then.
+func (p *execProc) Start() error {
+	return startLimited(p.cmd, p.limits)
+}
+
+func (p *execProc) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *execProc) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func (p *execProc) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *execProc) Pid() int {
+	return p.cmd.Process.Pid
+}
@@ -0,0 +1,260 @@
+package xplatai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Completes a prompt read from r, e.g. a large document. The prompt is
+// encoded into the request as it is read, without building a string of it.
+// Fails with ErrPromptTooLarge past Config.MaxPromptBytes.
+func (x *XpltAI) CompleteFrom(r io.Reader, opts GenOptions) (CompleteResponse, error) {
+	prompt, err := readJSONString(r, x.promptLimit())
+	if err != nil {
+		return CompleteResponse{}, err
+	}
+	return x.complete(context.Background(), prompt, opts)
+}
+
+func (x *XpltAI) promptLimit() int64 {
+	if x.maxPromptBytes > 0 {
+		return x.maxPromptBytes
+	}
+	return DEFAULT_MAX_PROMPT_BYTES
+}
+
+// Wraps r to turn \r\n and lone \r line endings into \n.
+func NormalizeNewlines(r io.Reader) io.Reader {
+	return &newlineReader{r: bufio.NewReader(r)}
+}
+
+type newlineReader struct {
+	r *bufio.Reader
+}
+
+func (n *newlineReader) Read(p []byte) (int, error) {
+	for i := range p {
+		c, err := n.r.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		if c == '\r' {
+			if next, _ := n.r.Peek(1); len(next) == 1 && next[0] == '\n' {
+				n.r.ReadByte()
+			}
+			c = '\n'
+		}
+		p[i] = c
+	}
+	return len(p), nil
+}
+
+// Reads r into an encoded json string, invalid UTF-8 is replaced.
+func readJSONString(r io.Reader, limit int64) (json.RawMessage, error) {
+	reader := bufio.NewReader(&cappedReader{r: r, remaining: limit})
+	buf := bytes.Buffer{}
+	buf.WriteByte('"')
+
+	for {
+		c, _, err := reader.ReadRune()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, ErrResponseTooLarge) {
+			return nil, ErrPromptTooLarge
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(c)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c == '\t':
+			buf.WriteString(`\t`)
+		case c < 0x20:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hexDigits[c>>4])
+			buf.WriteByte(hexDigits[c&0xf])
+		default:
+			buf.WriteRune(c)
+		}
+	}
+
+	buf.WriteByte('"')
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// Decodes a prompt passed to complete.
+func promptText(prompt any) (string, error) {
+	switch p := prompt.(type) {
+	case string:
+		return p, nil
+	case json.RawMessage:
+		text := ""
+		err := json.Unmarshal(p, &text)
+		return text, err
+	}
+	return "", errors.New("invalid prompt type")
+}
+
+type SummarizeOptions struct {
+	// Placed before each chunk, defaults to asking for a summary.
+	Instruction string
+
+	// Tokens reserved for each chunk summary, defaults to 512.
+	OutputTokens int
+
+	// Options of each summarization call, MaxTokens is set to OutputTokens.
+	GenOptions GenOptions
+}
+
+type SummaryResult struct {
+	// Summaries of the chunks, in order, separated by blank lines
+	Content string
+	// One response per chunk, with its usage and timings
+	Chunks []CompleteResponse
+}
+
+// Summarizes a document too large for the context by splitting it into
+// chunks, preferably on paragraph boundaries, and summarizing them one after
+// the other. Chunks fill the context minus the instruction and OutputTokens.
+func (x *XpltAI) SummarizeFrom(r io.Reader, opts SummarizeOptions) (SummaryResult, error) {
+	ctx := context.Background()
+	result := SummaryResult{}
+
+	if opts.Instruction == "" {
+		opts.Instruction = "Summarize the following text."
+	}
+	if opts.OutputTokens <= 0 {
+		opts.OutputTokens = 512
+	}
+
+	b, err := io.ReadAll(&cappedReader{r: r, remaining: x.promptLimit()})
+	if errors.Is(err, ErrResponseTooLarge) {
+		return result, ErrPromptTooLarge
+	} else if err != nil {
+		return result, err
+	}
+	text := strings.ToValidUTF8(string(b), string(utf8.RuneError))
+
+	_, err = x.waitReady(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	nCtx, err := x.contextSize(ctx)
+	if err != nil {
+		return result, err
+	}
+	overhead, err := x.tokenize(ctx, summaryPrompt(opts.Instruction, ""), true)
+	if err != nil {
+		return result, err
+	}
+	budget := nCtx - len(overhead) - opts.OutputTokens
+	if budget <= 0 {
+		return result, errors.New("context size is too small for the output tokens")
+	}
+
+	// Chunks are split by size, measuring how many bytes a token takes in
+	// this document keeps them within the token budget
+	tokens, err := x.tokenize(ctx, text, false)
+	if err != nil {
+		return result, err
+	}
+	bytesPerToken := float64(len(text)) / float64(max(len(tokens), 1))
+	// Leave headroom, token density varies across the document
+	chunkBytes := max(int(float64(budget)*bytesPerToken*0.9), 1)
+
+	genOpts := opts.GenOptions
+	genOpts.MaxTokens = opts.OutputTokens
+	genOpts.MaxTokensFraction = 0
+
+	summaries := []string{}
+	for _, chunk := range splitChunks(text, chunkBytes) {
+		resp, err := x.complete(ctx, summaryPrompt(opts.Instruction, chunk), genOpts)
+		result.Chunks = append(result.Chunks, resp)
+		if err != nil {
+			return result, err
+		}
+		summaries = append(summaries, resp.Content)
+	}
+
+	result.Content = strings.Join(summaries, "\n\n")
+	return result, nil
+}
+
+func summaryPrompt(instruction string, chunk string) string {
+	return instruction + "\n\n" + chunk + "\n\nSummary:"
+}
+
+// Splits text into chunks of at most size bytes, on paragraph boundaries when
+// possible, then on lines, then on spaces.
+func splitChunks(text string, size int) []string {
+	chunks := []string{}
+	for _, part := range splitOn(text, size, []string{"\n\n", "\n", " "}) {
+		if strings.TrimSpace(part) != "" {
+			chunks = append(chunks, part)
+		}
+	}
+	return chunks
+}
+
+func splitOn(text string, size int, seps []string) []string {
+	if len(text) <= size {
+		return []string{text}
+	}
+	if len(seps) == 0 {
+		return splitRunes(text, size)
+	}
+
+	chunks := []string{}
+	current := ""
+	for _, piece := range strings.SplitAfter(text, seps[0]) {
+		if len(current)+len(piece) <= size {
+			current += piece
+			continue
+		}
+		if current != "" {
+			chunks = append(chunks, current)
+			current = ""
+		}
+		if len(piece) > size {
+			chunks = append(chunks, splitOn(piece, size, seps[1:])...)
+		} else {
+			current = piece
+		}
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// Splits at size bytes without cutting a character in two.
+func splitRunes(text string, size int) []string {
+	chunks := []string{}
+	for len(text) > size {
+		cut := size
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	return append(chunks, text)
+}
@@ -0,0 +1,319 @@
+package xplatai
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ProxyOptions struct {
+	// Clients must send it as a bearer token, empty disables authentication.
+	APIKey string
+
+	// Tokens each client may spend in total, 0 means unlimited. Clients are
+	// told apart by their remote address, max_tokens of their requests is
+	// capped to what they have left and held until the reply is in.
+	TokenBudget int
+}
+
+// Limits on reading requests, replies stream for as long as they take.
+const (
+	proxyReadHeaderTimeout = 10 * time.Second
+	proxyReadTimeout       = time.Minute
+)
+
+type proxy struct {
+	ai   *XpltAI
+	opts ProxyOptions
+
+	mu    sync.Mutex
+	spent map[string]int
+}
+
+// Serves an OpenAI-compatible endpoint on addr that only exposes
+// /v1/chat/completions and /v1/models of the managed instance. Requests get
+// the handle's default options and stop strings for any field they don't set.
+// Close the returned server to stop it.
+func (x *XpltAI) Proxy(addr string, opts ProxyOptions) (io.Closer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &proxy{ai: x, opts: opts, spent: map[string]int{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", p.handleChat)
+	mux.HandleFunc("GET /v1/models", p.handleModels)
+
+	srv := &http.Server{
+		Handler:           p.authorize(mux),
+		ReadHeaderTimeout: proxyReadHeaderTimeout,
+		ReadTimeout:       proxyReadTimeout,
+	}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+func (p *proxy) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get("Authorization"))
+		if p.opts.APIKey != "" && subtle.ConstantTimeCompare(given, []byte("Bearer "+p.opts.APIKey)) != 1 {
+			writeProxyError(w, http.StatusUnauthorized, "authentication_error", "invalid api key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientId(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Tokens client may still spend, false when budgets are unlimited.
+func (p *proxy) remaining(client string) (int, bool) {
+	if p.opts.TokenBudget <= 0 {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.opts.TokenBudget - p.spent[client], true
+}
+
+// Caps max_tokens of the request to what client has left and holds that
+// many tokens until settle. False when the budget is exhausted.
+func (p *proxy) reserve(client string, data map[string]any) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	left := p.opts.TokenBudget - p.spent[client]
+	if left <= 0 {
+		return 0, false
+	}
+	reserved := capMaxTokens(data, left)
+	p.spent[client] += reserved
+	return reserved, true
+}
+
+// Charges the tokens a request used in place of those it reserved.
+func (p *proxy) settle(client string, reserved int, used int) {
+	p.mu.Lock()
+	p.spent[client] += used - reserved
+	p.mu.Unlock()
+}
+
+// Same error shape llama-server uses, so clients parse both the same way.
+func writeProxyError(w http.ResponseWriter, status int, errType string, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    status,
+			"type":    errType,
+			"message": msg,
+		},
+	})
+}
+
+func writeRequestError(w http.ResponseWriter, err error) {
+	var srvErr *ServerError
+	switch {
+	case errors.As(err, &srvErr) && srvErr.StatusCode >= 400:
+		writeProxyError(w, srvErr.StatusCode, srvErr.Type, srvErr.Message)
+	case errors.Is(err, ErrServerNotReady), errors.Is(err, ErrShuttingDown):
+		writeProxyError(w, http.StatusServiceUnavailable, "unavailable_error", err.Error())
+	default:
+		writeProxyError(w, http.StatusBadGateway, "server_error", err.Error())
+	}
+}
+
+func (p *proxy) handleModels(w http.ResponseWriter, r *http.Request) {
+	ctx, end, err := p.ai.beginRequest(r.Context())
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	ctx = p.ai.captureSettings(ctx)
+
+	_, err = p.ai.waitReady(ctx)
+	if err != nil {
+		writeRequestError(w, end(err))
+		return
+	}
+
+	resp, err := p.ai.send(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		writeRequestError(w, end(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	end(err)
+}
+
+func (p *proxy) handleChat(w http.ResponseWriter, r *http.Request) {
+	client := clientId(r)
+	remaining, limited := p.remaining(client)
+	if limited && remaining <= 0 {
+		writeProxyError(w, http.StatusTooManyRequests, "rate_limit_error", "token budget exhausted")
+		return
+	}
+
+	data := map[string]any{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, p.ai.promptLimit())).Decode(&data)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProxyError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", ErrPromptTooLarge.Error())
+		return
+	}
+	if err != nil {
+		writeProxyError(w, http.StatusBadRequest, "invalid_request_error", "json parsing failure, "+err.Error())
+		return
+	}
+
+	ctx, end, err := p.ai.beginRequest(r.Context())
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	ctx = p.ai.captureSettings(ctx)
+
+	opts, err := p.ai.resolveOptions(ctx, GenOptions{})
+	if err != nil {
+		writeRequestError(w, end(err))
+		return
+	}
+
+	// Fields set by the client take precedence over the handle defaults
+	defaults := map[string]any{}
+	opts.apply(defaults, _ENDPOINT_OPENAI)
+	for k, v := range defaults {
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+	// Held before forwarding so concurrent requests can't overspend
+	reserved := 0
+	if limited {
+		var ok bool
+		reserved, ok = p.reserve(client, data)
+		if !ok {
+			end(nil)
+			writeProxyError(w, http.StatusTooManyRequests, "rate_limit_error", "token budget exhausted")
+			return
+		}
+	}
+	used := 0
+	defer func() {
+		p.settle(client, reserved, used)
+	}()
+
+	release, err := p.ai.acquireSlot(ctx)
+	if err != nil {
+		writeRequestError(w, end(err))
+		return
+	}
+	defer release()
+
+	_, err = p.ai.waitReady(ctx)
+	if err != nil {
+		writeRequestError(w, end(err))
+		return
+	}
+
+	resp, err := p.ai.send(ctx, "POST", "/v1/chat/completions", data)
+	if err != nil {
+		writeRequestError(w, end(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+
+	used, err = copyCountingUsage(w, resp.Body)
+	end(err)
+}
+
+// Lowers the generation limit of a request to at most limit tokens, a
+// missing or unlimited max_tokens is set to limit. Returns the limit the
+// request ends up with.
+func capMaxTokens(data map[string]any, limit int) int {
+	capKey := func(key string) int {
+		n := -1
+		switch v := data[key].(type) {
+		case float64:
+			n = int(v)
+		case int:
+			// Set from the handle defaults
+			n = v
+		}
+		if n <= 0 || n > limit {
+			data[key] = limit
+			return limit
+		}
+		return n
+	}
+	capped := capKey("max_tokens")
+	// Newer name of the same field
+	if _, ok := data["max_completion_tokens"]; ok {
+		capped = max(capped, capKey("max_completion_tokens"))
+	}
+	return capped
+}
+
+// Copies the response line by line, flushing after each one so server sent
+// events reach the client as they are generated, and returns the total token
+// usage it reported.
+func copyCountingUsage(w http.ResponseWriter, body io.Reader) (int, error) {
+	flusher, _ := w.(http.Flusher)
+	reader := bufio.NewReader(body)
+	tokens := 0
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			_, werr := w.Write(line)
+			if werr != nil {
+				return tokens, werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			payload, _ := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+			tokens = max(tokens, usageTokens(bytes.TrimSpace(payload)))
+		}
+		if err == io.EOF {
+			return tokens, nil
+		} else if err != nil {
+			return tokens, err
+		}
+	}
+}
+
+func usageTokens(payload []byte) int {
+	if !bytes.Contains(payload, []byte(`"usage"`)) {
+		return 0
+	}
+	usage := struct {
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}{}
+	json.Unmarshal(payload, &usage)
+	return usage.Usage.TotalTokens
+}
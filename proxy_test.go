@@ -0,0 +1,208 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Backend answering every chat completion with usage of 60 tokens, recording
+// the max_tokens each request asked for.
+type proxyBackend struct {
+	mu        sync.Mutex
+	maxTokens []float64
+	// Closed to let requests through, nil answers right away
+	hold chan struct{}
+}
+
+func (b *proxyBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/chat/completions" {
+		w.Write([]byte(`{}`))
+		return
+	}
+	data := map[string]any{}
+	json.NewDecoder(r.Body).Decode(&data)
+	b.mu.Lock()
+	n, _ := data["max_tokens"].(float64)
+	b.maxTokens = append(b.maxTokens, n)
+	b.mu.Unlock()
+	if b.hold != nil {
+		<-b.hold
+	}
+	w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{"total_tokens":60}}`))
+}
+
+func (b *proxyBackend) received() []float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]float64{}, b.maxTokens...)
+}
+
+// Starts a proxy in front of backend, returning its url.
+func startProxy(t *testing.T, backend http.Handler, opts ProxyOptions, setup func(x *XpltAI)) string {
+	t.Helper()
+	srv := httptest.NewServer(backend)
+	t.Cleanup(srv.Close)
+	x := newTestHandle(t, srv, realClock{})
+	if setup != nil {
+		setup(x)
+	}
+
+	// Proxy doesn't report the port it got, find a free one first
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p, err := x.Proxy(addr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return "http://" + addr
+}
+
+func proxyChat(t *testing.T, url string, key string, body string) int {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url+"/v1/chat/completions", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestProxyChecksAPIKey(t *testing.T) {
+	url := startProxy(t, &proxyBackend{}, ProxyOptions{APIKey: "secret"}, nil)
+	body := `{"messages":[{"role":"user","content":"hi"}]}`
+
+	for _, key := range []string{"", "secre", "secret2", "Secret"} {
+		if status := proxyChat(t, url, key, body); status != http.StatusUnauthorized {
+			t.Errorf("key %q got status %d, want 401", key, status)
+		}
+	}
+	if status := proxyChat(t, url, "secret", body); status != http.StatusOK {
+		t.Errorf("valid key got status %d", status)
+	}
+}
+
+func TestProxyCapsMaxTokensToBudget(t *testing.T) {
+	backend := &proxyBackend{}
+	url := startProxy(t, backend, ProxyOptions{TokenBudget: 100}, nil)
+
+	for _, body := range []string{
+		`{"messages":[],"max_tokens":500}`,
+		`{"messages":[],"max_tokens":-1}`,
+	} {
+		if status := proxyChat(t, url, "", body); status != http.StatusOK {
+			t.Fatalf("got status %d", status)
+		}
+	}
+	if status := proxyChat(t, url, "", `{"messages":[],"max_tokens":10}`); status != http.StatusTooManyRequests {
+		t.Errorf("got status %d past the budget, want 429", status)
+	}
+
+	got := backend.received()
+	if len(got) != 2 || got[0] != 100 || got[1] != 40 {
+		t.Errorf("backend got max_tokens %v, want [100 40]", got)
+	}
+}
+
+// Requests in flight hold their max_tokens, so concurrent ones can't spend
+// the budget twice.
+func TestProxyReservesBudgetOfRequestsInFlight(t *testing.T) {
+	backend := &proxyBackend{hold: make(chan struct{})}
+	url := startProxy(t, backend, ProxyOptions{TokenBudget: 100}, nil)
+
+	done := make(chan int, 2)
+	for range 2 {
+		go func() {
+			done <- proxyChat(t, url, "", `{"messages":[],"max_tokens":50}`)
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backend.received()) < 2 {
+		if time.Now().After(deadline) {
+			close(backend.hold)
+			t.Fatal("requests never reached the backend")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if status := proxyChat(t, url, "", `{"messages":[],"max_tokens":10}`); status != http.StatusTooManyRequests {
+		t.Errorf("got status %d with the budget held, want 429", status)
+	}
+	close(backend.hold)
+	for range 2 {
+		if status := <-done; status != http.StatusOK {
+			t.Errorf("got status %d", status)
+		}
+	}
+	if got := backend.received(); len(got) != 2 {
+		t.Errorf("backend got max_tokens %v, want two requests", got)
+	}
+}
+
+func TestProxyRefusesLargeBodies(t *testing.T) {
+	backend := &proxyBackend{}
+	url := startProxy(t, backend, ProxyOptions{}, func(x *XpltAI) {
+		x.maxPromptBytes = 64
+	})
+
+	body := `{"messages":[{"role":"user","content":"` + strings.Repeat("a", 64) + `"}]}`
+	if status := proxyChat(t, url, "", body); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want 413", status)
+	}
+	if len(backend.received()) != 0 {
+		t.Error("large request forwarded")
+	}
+}
+
+func TestProxyWaitsForQueueSlot(t *testing.T) {
+	backend := &proxyBackend{hold: make(chan struct{})}
+	url := startProxy(t, backend, ProxyOptions{}, func(x *XpltAI) {
+		x.queue = make(chan struct{}, 1)
+	})
+
+	done := make(chan int, 2)
+	for range 2 {
+		go func() {
+			done <- proxyChat(t, url, "", `{"messages":[]}`)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backend.received()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no request reached the backend")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The second request waits in the queue
+	time.Sleep(50 * time.Millisecond)
+	if n := len(backend.received()); n != 1 {
+		t.Fatalf("%d requests reached the backend with one slot", n)
+	}
+
+	close(backend.hold)
+	for range 2 {
+		if status := <-done; status != http.StatusOK {
+			t.Errorf("got status %d", status)
+		}
+	}
+	if n := len(backend.received()); n != 2 {
+		t.Errorf("%d requests reached the backend, want 2", n)
+	}
+}
@@ -0,0 +1,172 @@
+package xplatai
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrToolMissing = errors.New("the installed llama.cpp release lacks the tool")
+
+// Lines of tool output kept to explain a failure
+const toolOutputTail = 10
+
+// llama-quantize prints "[  12/ 291] tensor name ..." for every tensor
+var quantizeProgressRe = regexp.MustCompile(`^\[\s*(\d+)/\s*(\d+)\]`)
+
+// Path of a llama.cpp tool in the flavor New would launch. Fails with
+// ErrToolMissing when the flavor is installed but its release has no such
+// tool.
+func toolBinary(name string) (string, error) {
+	flavor := pickFlavor(nil)
+
+	toolPath, err := flavorBinary(flavor, name)
+	if errors.Is(err, ErrNotInstalled) {
+		if _, serverErr := flavorBinary(flavor, "llama-server.exe"); serverErr == nil {
+			return "", fmt.Errorf("%w: %s", ErrToolMissing, strings.TrimSuffix(name, ".exe"))
+		}
+	}
+	return toolPath, err
+}
+
+// Runs a llama.cpp tool, passing each line it prints to onLine. Errors
+// include the last lines of output.
+func runTool(ctx context.Context, name string, args []string, onLine func(line string)) error {
+	toolPath, err := toolBinary(name)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	proc := exec.CommandContext(ctx, toolPath, args...)
+	proc.Dir = filepath.Dir(toolPath)
+	proc.Stdout = pw
+	proc.Stderr = pw
+
+	err = proc.Start()
+	if err != nil {
+		pw.Close()
+		return err
+	}
+
+	done := make(chan []string)
+	go func() {
+		tail := []string{}
+		sc := bufio.NewScanner(pr)
+		for sc.Scan() {
+			line := sc.Text()
+			onLine(line)
+			tail = append(tail, line)
+			if len(tail) > toolOutputTail {
+				tail = tail[1:]
+			}
+		}
+		io.Copy(io.Discard, pr)
+		done <- tail
+	}()
+
+	err = proc.Wait()
+	pw.Close()
+	tail := <-done
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		tool := strings.TrimSuffix(name, ".exe")
+		return fmt.Errorf("%s: %w\n%s", tool, err, strings.Join(tail, "\n"))
+	}
+	return nil
+}
+
+// Runs a tool writing outPath through a temporary file, only moved into
+// place once it holds a valid GGUF header.
+func writeGGUFWithTool(ctx context.Context, name string, outPath string, args func(tmpPath string) []string, onLine func(line string)) error {
+	// Tools run from the install dir
+	outPath, err := filepath.Abs(outPath)
+	if err != nil {
+		return err
+	}
+	tmpPath := outPath + ".part"
+	os.Remove(longPath(tmpPath))
+
+	err = runTool(ctx, name, args(tmpPath), onLine)
+	if err == nil {
+		_, err = readGGUFMetadata(tmpPath)
+		if err != nil {
+			err = fmt.Errorf("%s produced an invalid file: %w", strings.TrimSuffix(name, ".exe"), err)
+		}
+	}
+	if err == nil {
+		err = os.Rename(longPath(tmpPath), longPath(outPath))
+	}
+	if err != nil {
+		os.Remove(longPath(tmpPath))
+	}
+	return err
+}
+
+// Re-quantizes a model, e.g. an F16 download to Q4_K_M so it fits in memory.
+// progress is called with the fraction of tensors done, may be nil.
+func QuantizeModel(ctx context.Context, inPath string, outPath string, quant string, progress func(float64)) error {
+	if quant == "" {
+		return errors.New("quantization type is empty")
+	}
+	inPath, err := filepath.Abs(inPath)
+	if err != nil {
+		return err
+	}
+	_, err = readGGUFMetadata(inPath)
+	if err != nil {
+		return err
+	}
+	if progress == nil {
+		progress = func(float64) {}
+	}
+
+	args := func(tmpPath string) []string {
+		return []string{inPath, tmpPath, strings.ToUpper(quant), strconv.Itoa(DefaultConfig().Threads)}
+	}
+	err = writeGGUFWithTool(ctx, "llama-quantize.exe", outPath, args, func(line string) {
+		m := quantizeProgressRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			return
+		}
+		done, _ := strconv.Atoi(m[1])
+		total, _ := strconv.Atoi(m[2])
+		if total > 0 {
+			progress(float64(done) / float64(total))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	progress(1)
+	return nil
+}
+
+// Merges a model split into shards, e.g. model-00001-of-00003.gguf, into a
+// single file. firstShard is the first shard, the others are found next to it.
+func MergeShards(ctx context.Context, firstShard string, outPath string) error {
+	firstShard, err := filepath.Abs(firstShard)
+	if err != nil {
+		return err
+	}
+	_, err = readGGUFMetadata(firstShard)
+	if err != nil {
+		return err
+	}
+
+	args := func(tmpPath string) []string {
+		return []string{"--merge", firstShard, tmpPath}
+	}
+	return writeGGUFWithTool(ctx, "llama-gguf-split.exe", outPath, args, func(string) {})
+}
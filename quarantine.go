@@ -0,0 +1,147 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+)
+
+var ErrBinaryQuarantined = errors.New("llama.cpp binaries were removed or blocked by antivirus software, " +
+	"restore them from quarantine and add an exclusion for the install directory, or call UnblockBinaries " +
+	"if Windows blocked them as downloaded from the internet")
+
+const manifestFileName = "manifest.json"
+
+// Windows error codes for files blocked or deleted by antivirus software
+const (
+	_ERROR_VIRUS_INFECTED syscall.Errno = 225
+	_ERROR_VIRUS_DELETED  syscall.Errno = 226
+)
+
+type installManifest struct {
+	// Installed llama.cpp version by flavor directory
+	Flavors map[string]string `json:"flavors"`
+	// Installed files relative to the install dir, slash separated
+	Files []string `json:"files"`
+	// Installs waiting for the running server to release the binaries they
+	// replace, by flavor directory
+	Staged map[string]stagedInstall `json:"staged,omitempty"`
+	// Benchmark results by hardware fingerprint
+	Benchmarks map[string]HWBenchResult `json:"benchmarks,omitempty"`
+}
+
+func readManifest() (installManifest, error) {
+	manifest := installManifest{}
+
+	manifestPath, err := installPath(manifestFileName)
+	if err != nil {
+		return manifest, err
+	}
+
+	b, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, json.Unmarshal(b, &manifest)
+}
+
+func writeManifest(manifest installManifest) error {
+	manifestPath, err := installPath(manifestFileName)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, b, 0644)
+}
+
+// Returns ErrBinaryQuarantined when the flavor's binary was installed but is
+// now gone, or when err says antivirus software blocked it. Returns nil
+// otherwise.
+func quarantineError(hardware HostHardware, name string, err error) error {
+	binPath, pathErr := flavorPath(hardware, name)
+	if pathErr != nil {
+		return nil
+	}
+
+	var errno syscall.Errno
+	if runtime.GOOS == "windows" && errors.As(err, &errno) &&
+		(errno == _ERROR_VIRUS_INFECTED || errno == _ERROR_VIRUS_DELETED) {
+		return fmt.Errorf("%w: %w", ErrBinaryQuarantined, err)
+	}
+
+	// Blocked rather than removed, Windows marks files downloaded from the
+	// internet with a Zone.Identifier stream
+	if errors.Is(err, os.ErrPermission) && hasZoneIdentifier(binPath) {
+		return fmt.Errorf("%w: %w", ErrBinaryQuarantined, err)
+	}
+
+	if exists, _ := isPathExist(binPath); exists {
+		return nil
+	}
+
+	manifest, mErr := readManifest()
+	if mErr != nil {
+		return nil
+	}
+	if slices.Contains(manifest.Files, relInstallPath(flavorName(hardware), name)) {
+		return fmt.Errorf("%w: %s is missing", ErrBinaryQuarantined, binPath)
+	}
+	return nil
+}
+
+func hasZoneIdentifier(path string) bool {
+	if runtime.GOOS != "windows" {
+		return false
+	}
+	_, err := os.Stat(path + ":Zone.Identifier")
+	return err == nil
+}
+
+// Removes the "downloaded from the internet" mark from every installed file,
+// like Unblock-File does. No-op on other platforms.
+func UnblockBinaries() error {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+
+	manifest, err := readManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		fPath, err := installPath(filepath.FromSlash(file))
+		if err != nil {
+			return err
+		}
+		err = os.Remove(fPath + ":Zone.Identifier")
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Like NewWithConfig, but unblocks the binaries and tries once more when
+// they were blocked.
+func NewWithConfigUnblocked(cfg Config) (*XpltAI, error) {
+	xai, err := NewWithConfig(cfg)
+	if !errors.Is(err, ErrBinaryQuarantined) {
+		return xai, err
+	}
+
+	unblockErr := UnblockBinaries()
+	if unblockErr != nil {
+		return nil, errors.Join(err, unblockErr)
+	}
+	return NewWithConfig(cfg)
+}
@@ -0,0 +1,33 @@
+package xplatai
+
+import "context"
+
+// Waits for a place among the generation requests in flight, see
+// Config.MaxConcurrentRequests. The returned func gives it back.
+func (x *XpltAI) acquireSlot(ctx context.Context) (func(), error) {
+	if x.queue == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case x.queue <- struct{}{}:
+	default:
+		x.stats.queued.Add(1)
+		select {
+		case x.queue <- struct{}{}:
+		case <-x.drainingCh():
+			return nil, ErrShuttingDown
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// Both may have been ready, queued requests never run once draining
+		select {
+		case <-x.drainingCh():
+			<-x.queue
+			return nil, ErrShuttingDown
+		default:
+		}
+	}
+	return func() { <-x.queue }, nil
+}
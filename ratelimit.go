@@ -0,0 +1,119 @@
+package xplatai
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Token bucket shared by the downloads it limits.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// Limits downloads made without a limiter of their own in their context.
+var downloadLimiter = &rateLimiter{}
+
+// Caps the bandwidth used by all downloads, takes effect immediately even for
+// downloads already in progress. 0 or less means unlimited. Handles created
+// with Config.MaxDownloadBytesPerSec use their own cap instead.
+func SetMaxDownloadBytesPerSec(n int64) {
+	downloadLimiter.setRate(n)
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	l := &rateLimiter{}
+	l.setRate(rate)
+	return l
+}
+
+type downloadLimiterKey struct{}
+
+// Limits the downloads made with the returned context to l.
+func withDownloadLimiter(ctx context.Context, l *rateLimiter) context.Context {
+	return context.WithValue(ctx, downloadLimiterKey{}, l)
+}
+
+func contextDownloadLimiter(ctx context.Context) *rateLimiter {
+	l, ok := ctx.Value(downloadLimiterKey{}).(*rateLimiter)
+	if !ok {
+		return downloadLimiter
+	}
+	return l
+}
+
+func (l *rateLimiter) setRate(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = max(n, 0)
+	l.tokens = min(l.tokens, float64(l.rate))
+	l.last = time.Now()
+}
+
+func (l *rateLimiter) currentRate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Blocks until n bytes worth of tokens were consumed.
+func (l *rateLimiter) wait(n int) {
+	need := float64(n)
+
+	for need > 0 {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return
+		}
+
+		now := time.Now()
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+		l.last = now
+
+		take := min(need, l.tokens)
+		l.tokens -= take
+		need -= take
+
+		var sleep time.Duration
+		if need > 0 {
+			sleep = time.Duration(need / float64(l.rate) * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		// Sleep in short slices so rate changes are picked up mid-wait
+		if sleep > 0 {
+			time.Sleep(min(sleep, 100*time.Millisecond))
+		}
+	}
+}
+
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, l: l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// Keep reads small relative to the rate so throughput stays smooth
+	if rate := lr.l.currentRate(); rate > 0 {
+		chunk := int(max(rate/10, 512))
+		if len(p) > chunk {
+			p = p[:chunk]
+		}
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		lr.l.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,35 @@
+package xplatai
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewWithConfigKeepsDownloadCapToItself(t *testing.T) {
+	fakeInstall(t)
+	SetMaxDownloadBytesPerSec(0)
+
+	cfg := fakeConfig(newFakeClock(), &fakeProcs{})
+	cfg.MaxDownloadBytesPerSec = 1000
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	if rate := downloadLimiter.currentRate(); rate != 0 {
+		t.Errorf("package-wide cap changed to %d", rate)
+	}
+}
+
+func TestDownloadLimiterFromContext(t *testing.T) {
+	if contextDownloadLimiter(context.Background()) != downloadLimiter {
+		t.Error("downloads without a limiter don't use the package-wide one")
+	}
+
+	l := newRateLimiter(1000)
+	ctx := withDownloadLimiter(context.Background(), l)
+	if contextDownloadLimiter(ctx) != l || l.currentRate() != 1000 {
+		t.Error("downloads don't use the limiter of their context")
+	}
+}
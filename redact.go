@@ -0,0 +1,68 @@
+package xplatai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Rewrites text before the package writes it to disk or puts it in events
+// and errors, e.g. to keep user prompts out of journals.
+type Redactor interface {
+	// Prompts and messages sent to the model
+	RedactPrompt(text string) string
+	// Generated text and response bodies
+	RedactResponse(text string) string
+	// File system paths
+	RedactPath(path string) string
+}
+
+type noRedaction struct{}
+
+func (noRedaction) RedactPrompt(text string) string   { return text }
+func (noRedaction) RedactResponse(text string) string { return text }
+func (noRedaction) RedactPath(path string) string     { return path }
+
+// Replaces text with a salted hash, equal inputs still give equal outputs so
+// artifacts can be correlated without revealing their content.
+type HashingRedactor struct {
+	Salt string
+}
+
+func (h HashingRedactor) hash(text string) string {
+	if text == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(h.Salt + text))
+	return "[redacted:" + hex.EncodeToString(sum[:8]) + "]"
+}
+
+func (h HashingRedactor) RedactPrompt(text string) string   { return h.hash(text) }
+func (h HashingRedactor) RedactResponse(text string) string { return h.hash(text) }
+func (h HashingRedactor) RedactPath(path string) string     { return h.hash(path) }
+
+func (x *XpltAI) redact() Redactor {
+	if x.redactor == nil {
+		return noRedaction{}
+	}
+	return x.redactor
+}
+
+// Redacts the body a server error embeds.
+func (x *XpltAI) responseError(resp *http.Response, body []byte) error {
+	err := responseError(resp, body)
+	if srvErr, ok := err.(*ServerError); ok {
+		srvErr.Message = x.redact().RedactResponse(srvErr.Message)
+	}
+	return err
+}
+
+// Redacts the install dir in messages embedding binary paths.
+func (x *XpltAI) redactInstallPaths(msg string) string {
+	dir, err := installDir()
+	if err != nil || x.redactor == nil {
+		return msg
+	}
+	return strings.ReplaceAll(msg, dir, x.redactor.RedactPath(dir))
+}
@@ -0,0 +1,129 @@
+package xplatai
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const secretPrompt = "my card number is 4111 1111 1111 1111"
+
+// Server echoing the prompt back in every way it can end up in an artifact:
+// json and plain text errors, streamed text and a mid-stream error.
+func echoingServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case bytes.Contains(body, []byte("json error")):
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":{"code":400,"message":"invalid request: %s","type":"invalid_request_error"}}`, secretPrompt)
+		case bytes.Contains(body, []byte("text error")):
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "crashed while evaluating %s", secretPrompt)
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"You said %s\"}}]}\n\n", secretPrompt)
+			fmt.Fprintf(w, "data: {\"error\":{\"code\":500,\"message\":\"failed after %s\",\"type\":\"server_error\"}}\n\n", secretPrompt)
+		}
+	}))
+}
+
+// Runs requests failing in every way against a handle using redactor, and
+// returns every artifact they left behind by name.
+func redactionArtifacts(t *testing.T, redactor Redactor) map[string]string {
+	t.Helper()
+	fakeInstall(t)
+	srv := echoingServer()
+	defer srv.Close()
+
+	events := bytes.Buffer{}
+	eventWriter := NewJSONEventWriter(&events)
+	cfg := fakeConfig(newFakeClock(), &fakeProcs{})
+	cfg.Redactor = redactor
+	cfg.JournalDir = t.TempDir()
+	cfg.CircuitFailures = 2
+	cfg.OnLifecycle = eventWriter.Lifecycle
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.hosts = []string{srv.URL}
+
+	artifacts := map[string]string{}
+	user := func(tag string) []Message {
+		return []Message{{Role: "user", Content: tag + ": " + secretPrompt}}
+	}
+
+	_, err = x.ChatDetailed(user("json error"), GenOptions{})
+	artifacts["json server error"] = fmt.Sprint(err)
+	_, err = x.ChatDetailed(user("text error"), GenOptions{})
+	artifacts["text server error"] = fmt.Sprint(err)
+	_, err = x.ChatDetailedStream(user("stream"), GenOptions{}, func(string) {})
+	artifacts["stream error"] = fmt.Sprint(err)
+
+	ids, err := x.ListJournals()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("got journals %v and %v, want the failed stream's, artifacts %q", ids, err, artifacts)
+	}
+	for _, id := range ids {
+		b, err := os.ReadFile(filepath.Join(cfg.JournalDir, id+journalExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		artifacts["journal"] = string(b)
+	}
+
+	x.Close()
+	artifacts["events"] = events.String()
+	if !strings.Contains(artifacts["events"], EVENT_CIRCUIT_OPEN) {
+		t.Fatalf("circuit never opened, events:\n%s", artifacts["events"])
+	}
+	return artifacts
+}
+
+func TestRedactorKeepsPromptsOutOfArtifacts(t *testing.T) {
+	for name, artifact := range redactionArtifacts(t, HashingRedactor{Salt: "salt"}) {
+		if strings.Contains(artifact, secretPrompt) {
+			t.Errorf("%s holds the raw prompt:\n%s", name, artifact)
+		}
+		if name != "events" && !strings.Contains(artifact, "[redacted:") {
+			t.Errorf("%s holds no redacted text:\n%s", name, artifact)
+		}
+	}
+}
+
+// Without a redactor every artifact checked above does hold the prompt, so
+// the test can't pass by looking in the wrong place.
+func TestArtifactsHoldPromptsWithoutRedactor(t *testing.T) {
+	for name, artifact := range redactionArtifacts(t, nil) {
+		if !strings.Contains(artifact, secretPrompt) {
+			t.Errorf("%s doesn't hold the prompt:\n%s", name, artifact)
+		}
+	}
+}
+
+func TestHashingRedactor(t *testing.T) {
+	h := HashingRedactor{Salt: "a"}
+	if h.RedactPrompt("") != "" {
+		t.Error("empty text not kept empty")
+	}
+	if h.RedactPrompt("hello") != h.RedactResponse("hello") {
+		t.Error("equal inputs gave different outputs")
+	}
+	if h.RedactPrompt("hello") == h.RedactPrompt("world") {
+		t.Error("different inputs gave equal outputs")
+	}
+	if h.RedactPrompt("hello") == (HashingRedactor{Salt: "b"}).RedactPrompt("hello") {
+		t.Error("salt doesn't change the output")
+	}
+}
@@ -0,0 +1,162 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A model reference with the settings it should be run with.
+type ModelSpec struct {
+	// Hugging Face reference (user/repo[:quant]) or path to a local .gguf file
+	Source string `json:"source"`
+	Quant  string `json:"quant,omitempty"`
+	// Commit hash or tag to fetch the model at, empty tracks the main branch.
+	// Written as user/repo[:quant]@revision in model references.
+	Revision    string     `json:"revision,omitempty"`
+	ContextSize int        `json:"context_size,omitempty"`
+	GenOptions  GenOptions `json:"gen_options,omitempty"`
+}
+
+func (s ModelSpec) IsLocal() bool {
+	return strings.HasSuffix(strings.ToLower(s.Source), ".gguf")
+}
+
+// Reference in the form llama-server's -hf flag expects.
+func (s ModelSpec) HFRef() string {
+	if s.Quant == "" || strings.Contains(s.Source, ":") {
+		return s.Source
+	}
+	return s.Source + ":" + s.Quant
+}
+
+const registryFileName = "models.json"
+
+var registryMut sync.Mutex
+
+func registryPath() (string, error) {
+	return installPath(registryFileName)
+}
+
+func readRegistry() (map[string]ModelSpec, error) {
+	regPath, err := registryPath()
+	if err != nil {
+		return nil, err
+	}
+
+	models := map[string]ModelSpec{}
+
+	b, err := os.ReadFile(regPath)
+	if os.IsNotExist(err) {
+		return models, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &models)
+	if err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func writeRegistry(models map[string]ModelSpec) error {
+	regPath, err := registryPath()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(models, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(regPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated registry
+	tmpPath := regPath + ".tmp"
+	err = os.WriteFile(tmpPath, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, regPath)
+}
+
+// Registers alias as a name for spec, replacing any previous registration.
+func RegisterModel(alias string, spec ModelSpec) error {
+	if alias == "" || strings.ContainsAny(alias, ":/\\") {
+		return errors.New("invalid model alias: " + alias)
+	}
+	if spec.Source == "" {
+		return errors.New("model spec has no source")
+	}
+
+	registryMut.Lock()
+	defer registryMut.Unlock()
+
+	models, err := readRegistry()
+	if err != nil {
+		return err
+	}
+	models[alias] = spec
+	return writeRegistry(models)
+}
+
+func RemoveModel(alias string) error {
+	registryMut.Lock()
+	defer registryMut.Unlock()
+
+	models, err := readRegistry()
+	if err != nil {
+		return err
+	}
+	if _, ok := models[alias]; !ok {
+		return errors.New("unknown model alias: " + alias)
+	}
+	delete(models, alias)
+	return writeRegistry(models)
+}
+
+func ListModels() (map[string]ModelSpec, error) {
+	registryMut.Lock()
+	defer registryMut.Unlock()
+
+	return readRegistry()
+}
+
+// Looks up a registered alias, anything else is taken as a model reference.
+func ResolveModel(aliasOrRef string) (ModelSpec, error) {
+	if aliasOrRef == "" {
+		return ModelSpec{}, errors.New("model reference is empty")
+	}
+
+	registryMut.Lock()
+	models, err := readRegistry()
+	registryMut.Unlock()
+	if err != nil {
+		return ModelSpec{}, err
+	}
+
+	if spec, ok := models[aliasOrRef]; ok {
+		return spec, nil
+	}
+	return parseModelRef(aliasOrRef), nil
+}
+
+func parseModelRef(ref string) ModelSpec {
+	spec := ModelSpec{Source: ref}
+	if spec.IsLocal() {
+		return spec
+	}
+	if source, revision, ok := strings.Cut(ref, "@"); ok {
+		spec.Source = source
+		spec.Revision = revision
+	}
+	return spec
+}
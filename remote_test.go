@@ -0,0 +1,165 @@
+package xplatai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Backend whose replica drops connections while down, counting the health
+// probes and completions it receives.
+type flappingBackend struct {
+	down atomic.Bool
+	// Down again after this many more completions, when positive
+	failAfter atomic.Int32
+	// Comes back up after this many dropped connections
+	upAfter     atomic.Int32
+	health      atomic.Int32
+	completions atomic.Int32
+}
+
+func (b *flappingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if b.down.Load() {
+		if b.upAfter.Add(-1) <= 0 {
+			b.down.Store(false)
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	switch r.URL.Path {
+	case "/health":
+		b.health.Add(1)
+		w.Write([]byte(`{"status":"ok"}`))
+	case "/v1/chat/completions":
+		b.completions.Add(1)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	default:
+		w.Write([]byte(`{}`))
+	}
+}
+
+func TestRemoteOnceReprobesAfterReplicaDrops(t *testing.T) {
+	backend := &flappingBackend{}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.firstRequestWait = time.Minute
+	x.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Second})
+
+	send := func() error {
+		var err error
+		clk.runUntil(t, func() {
+			_, err = x.ChatDetailed([]Message{{Role: "user", Content: "hi"}}, GenOptions{})
+		})
+		return err
+	}
+
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if backend.health.Load() != 1 {
+		t.Fatalf("%d health probes for a steady replica, want 1", backend.health.Load())
+	}
+
+	// The replica goes away for one attempt, the retry reaches the next one
+	backend.upAfter.Store(1)
+	backend.down.Store(true)
+	if err := send(); err != nil {
+		t.Fatalf("request failed instead of retrying: %v", err)
+	}
+	if backend.completions.Load() != 3 {
+		t.Errorf("%d completions, want 3", backend.completions.Load())
+	}
+
+	// A replica gone for good fails the request and clears the latch, the
+	// next request checks readiness again
+	backend.upAfter.Store(100)
+	backend.down.Store(true)
+	if err := send(); err == nil {
+		t.Fatal("request succeeded against a replica that is down")
+	}
+	backend.down.Store(false)
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if backend.health.Load() != 2 {
+		t.Errorf("%d health probes after the replica dropped, want 2", backend.health.Load())
+	}
+}
+
+func TestRemoteRetriesRunOut(t *testing.T) {
+	backend := &flappingBackend{}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.SetProbeMode(PROBE_OFF)
+	x.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Second})
+
+	backend.upAfter.Store(10)
+	backend.down.Store(true)
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		err = x.doJSON(context.Background(), "POST", "/v1/chat/completions", map[string]any{}, nil)
+	})
+	if err == nil {
+		t.Fatal("request succeeded against a replica that is down")
+	}
+	if elapsed != 2*time.Second {
+		t.Errorf("retried for %v, want 2s", elapsed)
+	}
+	if backend.health.Load() != 0 {
+		t.Errorf("%d health probes with probing off", backend.health.Load())
+	}
+}
+
+func TestRemotePerRequestProbes(t *testing.T) {
+	backend := &flappingBackend{}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.firstRequestWait = time.Minute
+	x.SetProbeMode(PROBE_PER_REQUEST)
+
+	for range 3 {
+		clk.runUntil(t, func() {
+			_, err := x.waitReady(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	if backend.health.Load() != 3 {
+		t.Errorf("%d health probes for 3 requests, want 3", backend.health.Load())
+	}
+
+	// A replica dropping the probe is probed again until one answers
+	backend.upAfter.Store(2)
+	backend.down.Store(true)
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		_, err = x.waitReady(context.Background())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dropped probes may be retried by the transport, the count of waits varies
+	if elapsed < 250*time.Millisecond || backend.health.Load() != 4 {
+		t.Errorf("ready after %v and %d probes, want a wait and 4 probes", elapsed, backend.health.Load())
+	}
+}
@@ -0,0 +1,249 @@
+package xplatai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Sends a request with the captured settings' headers, retrying requests that
+// got no response at all according to the retry policy.
+func (x *XpltAI) send(ctx context.Context, method string, endpoint string, data any) (*http.Response, error) {
+	settings := x.settingsFrom(ctx)
+
+	var b []byte
+	if data != nil {
+		var err error
+		b, err = json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		var reqBody io.Reader
+		if b != nil {
+			reqBody = bytes.NewReader(b)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, x.url(endpoint), reqBody)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range settings.headers {
+			req.Header[k] = v
+		}
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := x.client.Do(req)
+		if err == nil {
+			if resp.StatusCode != 503 || !settings.retry.RetryOn503 || attempt >= settings.retry.MaxAttempts {
+				return resp, nil
+			}
+
+			// Busy rejections are 503s too, retryBusy waits for a slot instead
+			body, readErr := io.ReadAll(&cappedReader{r: resp.Body, remaining: x.responseLimit()})
+			resp.Body.Close()
+			if readErr != nil {
+				return nil, readErr
+			}
+			err = x.responseError(resp, body)
+			srvErr, ok := err.(*ServerError)
+			if ok && isBusyError(srvErr) {
+				resp.Body = io.NopCloser(bytes.NewReader(body))
+				return resp, nil
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+		} else if x.remote {
+			// Another replica may answer the next request, probe it again
+			x.isConn.Store(false)
+		}
+
+		if attempt >= settings.retry.MaxAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		err = x.sleep(ctx, settings.retry.delay(attempt))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Builds the error for a failed response, nil when the response is fine.
+func responseError(resp *http.Response, body []byte) error {
+	// Check if json
+	if len(body) > 0 && body[0] != '{' && body[0] != '[' {
+		return &ServerError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	errData := struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	json.Unmarshal(body, &errData)
+
+	srvErr := &ServerError{
+		StatusCode: resp.StatusCode,
+		Type:       errData.Error.Type,
+		Message:    errData.Error.Message,
+	}
+	if srvErr.Message == "" {
+		srvErr.Message = resp.Status
+	}
+	return srvErr
+}
+
+func (x *XpltAI) doJSON(ctx context.Context, method string, endpoint string, data any, out any) error {
+	return x.retryBusy(ctx, func() (bool, error) {
+		return true, x.doJSONOnce(ctx, method, endpoint, data, out)
+	})
+}
+
+func (x *XpltAI) doJSONOnce(ctx context.Context, method string, endpoint string, data any, out any) error {
+	resp, err := x.send(ctx, method, endpoint, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := bufio.NewReader(&cappedReader{r: resp.Body, remaining: x.responseLimit()})
+
+	// Errors and non-json bodies are small, anything else is decoded as it streams in
+	first, _ := body.Peek(1)
+	if resp.StatusCode >= 400 || (len(first) > 0 && first[0] != '{' && first[0] != '[') {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		return x.responseError(resp, b)
+	}
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, body)
+		return err
+	}
+
+	err = json.NewDecoder(body).Decode(out)
+	if errors.Is(err, ErrResponseTooLarge) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("json parsing failure, %w", err)
+	}
+	return nil
+}
+
+func (x *XpltAI) responseLimit() int64 {
+	if x.maxResponseBytes > 0 {
+		return x.maxResponseBytes
+	}
+	return DEFAULT_MAX_RESPONSE_BYTES
+}
+
+// Fails with ErrResponseTooLarge once more than remaining bytes were read.
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Only an error when there really is more to read
+		n, err := c.r.Read(make([]byte, 1))
+		if n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// Posts a streaming request and calls onEvent with the data of every server
+// sent event until the stream ends.
+func (x *XpltAI) doStream(ctx context.Context, endpoint string, data any, onEvent func(data []byte) error) error {
+	delivered := false
+	return x.retryBusy(ctx, func() (bool, error) {
+		err := x.doStreamOnce(ctx, endpoint, data, func(payload []byte) error {
+			delivered = true
+			return onEvent(payload)
+		})
+		// Events already reached the caller, a retry would repeat them
+		return !delivered, err
+	})
+}
+
+func (x *XpltAI) doStreamOnce(ctx context.Context, endpoint string, data any, onEvent func(data []byte) error) error {
+	resp, err := x.send(ctx, "POST", endpoint, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, err := io.ReadAll(&cappedReader{r: resp.Body, remaining: x.responseLimit()})
+		if err != nil {
+			return err
+		}
+		return x.responseError(resp, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	streamErr := &http.Response{StatusCode: 500, Status: "500 stream error"}
+
+	for scanner.Scan() {
+		// Lines already buffered are dropped, returning closes the connection
+		// which makes the server stop generating
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := scanner.Bytes()
+
+		if payload, ok := bytes.CutPrefix(line, []byte("error:")); ok {
+			return x.responseError(streamErr, bytes.TrimSpace(payload))
+		}
+
+		payload, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+
+		payload = bytes.TrimSpace(payload)
+		if string(payload) == "[DONE]" {
+			return nil
+		}
+
+		// Errors after the headers were sent come as an event of their own
+		if bytes.HasPrefix(payload, []byte(`{"error"`)) {
+			return x.responseError(streamErr, payload)
+		}
+
+		err := onEvent(payload)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
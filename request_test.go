@@ -0,0 +1,120 @@
+package xplatai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendRetriesDroppedConnections(t *testing.T) {
+	attempts := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			// Hang up without answering, like a server going away
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Second, Multiplier: 2})
+
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		err = x.doJSON(context.Background(), "POST", "/completion", map[string]any{}, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("%d attempts, want 3", attempts.Load())
+	}
+	// 1s then 2s of backoff
+	if elapsed != 3*time.Second {
+		t.Errorf("backed off for %v, want 3s", elapsed)
+	}
+}
+
+func TestSendGivesUpAfterMaxAttempts(t *testing.T) {
+	attempts := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"Loading model"}}`))
+	}))
+	defer srv.Close()
+
+	clk := newFakeClock()
+	x := newTestHandle(t, srv, clk)
+	x.SetRetryPolicy(RetryPolicy{MaxAttempts: 4, Backoff: 500 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, RetryOn503: true})
+
+	var err error
+	elapsed := clk.runUntil(t, func() {
+		err = x.doJSON(context.Background(), "POST", "/completion", map[string]any{}, nil)
+	})
+	if ClassifyError(err) != FAILURE_STARTING {
+		t.Fatalf("got %v, want a loading error", err)
+	}
+	if attempts.Load() != 4 {
+		t.Errorf("%d attempts, want 4", attempts.Load())
+	}
+	// 500ms, 1s, then 2s capped to 1s
+	if elapsed != 2500*time.Millisecond {
+		t.Errorf("backed off for %v, want 2.5s", elapsed)
+	}
+}
+
+// Reads the whole response then cancels the request's context, so the body
+// is already there when the cancellation is seen.
+type cancelAfterResponse struct {
+	cancel context.CancelFunc
+}
+
+func (c cancelAfterResponse) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	c.cancel()
+	return resp, nil
+}
+
+func TestSendCancelledDuring503Fails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"Loading model"}}`))
+	}))
+	defer srv.Close()
+
+	x := newTestHandle(t, srv, newFakeClock())
+	x.SetRetryPolicy(RetryPolicy{MaxAttempts: 4, Backoff: time.Second, RetryOn503: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	x.client = &http.Client{Transport: cancelAfterResponse{cancel: cancel}}
+
+	resp, err := x.send(ctx, "POST", "/completion", map[string]any{})
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if resp != nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v and %v, want no response and context.Canceled", resp, err)
+	}
+}
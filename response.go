@@ -0,0 +1,124 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Tools the assistant called
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Call a "tool" message answers
+	ToolCallId string `json:"tool_call_id,omitempty"`
+}
+
+type RequestTiming struct {
+	// Time spent waiting for the server to become available
+	Wait time.Duration
+	// Time spent on the request itself
+	Generation time.Duration
+}
+
+// One of several replies, see GenOptions.N.
+type ChatChoice struct {
+	Content string
+	// Content before the handle's post-processors ran
+	RawContent   string
+	FinishReason string
+	// Untouched message object of the choice
+	RawMessage json.RawMessage
+	// Functions the reply calls, see GenOptions.Tools
+	ToolCalls []ToolCall
+	// Only set with GenOptions.Logprobs
+	Logprobs []TokenLogprob
+}
+
+type ChatResponse struct {
+	RequestId string
+	Content   string
+	// Content before the handle's post-processors ran
+	RawContent   string
+	FinishReason string
+	Timing       RequestTiming
+	// Which engine served the request when going through Fallback
+	Backend string
+	// Prompt tokens taken from the server's KV cache instead of being
+	// evaluated, nonzero confirms cache_prompt or --cache-reuse took effect
+	CachedTokens int
+	// Prompt and generation speed as the server measured it
+	ServerTimings ServerTimings
+
+	// Untouched choices[0].message object, including role, tool calls and refusals
+	RawMessage json.RawMessage
+	// Every reply in order when GenOptions.N asked for several, the first
+	// one is also the response's own Content
+	Choices []ChatChoice
+	// Complete response body, only kept when Config.MaxRawResponseBytes is set
+	RawBody          []byte
+	RawBodyTruncated bool
+
+	// Prompt tokens the server processed, including cached ones
+	PromptTokens int
+	// Tokens generated for the reply
+	CompletionTokens int
+	// Usage of every request the call sent, retries, hedges and time slice
+	// continuations included, and their sum
+	AttemptsUsage []Usage
+	TotalUsage    Usage
+	// Generated tokens with their log probabilities, only set with
+	// GenOptions.Logprobs
+	Logprobs []TokenLogprob
+	// Prompt as rendered by the chat template, only set with Config.DebugPrompts
+	Prompt string
+	// Token cap the request was sent with, resolved from MaxTokensFraction
+	// when that was used
+	MaxTokens int
+}
+
+type CompleteResponse struct {
+	Content string
+	// Content before the handle's post-processors ran
+	RawContent   string
+	FinishReason string
+	Timing       RequestTiming
+	// Which engine served the request when going through Fallback
+	Backend string
+	// Prompt tokens taken from the server's KV cache instead of being
+	// evaluated, nonzero confirms cache_prompt or --cache-reuse took effect
+	CachedTokens int
+	// Prompt and generation speed as the server measured it
+	ServerTimings ServerTimings
+
+	// Prompt tokens the server processed, including cached ones
+	PromptTokens int
+	// Tokens generated for the reply
+	CompletionTokens int
+	// Usage of every request the call sent, retries and hedges included,
+	// and their sum
+	AttemptsUsage []Usage
+	TotalUsage    Usage
+	// Generated tokens with their log probabilities, only set with
+	// GenOptions.Logprobs
+	Logprobs []TokenLogprob
+	// Prompt the server evaluated, only set with Config.DebugPrompts
+	Prompt string
+	// Token cap the request was sent with, resolved from MaxTokensFraction
+	// when that was used
+	MaxTokens int
+}
+
+// Wraps a failed request with the time spent before it failed.
+type RequestError struct {
+	Timing RequestTiming
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,164 @@
+package xplatai
+
+import (
+	"context"
+	"sync"
+)
+
+// Round-robin scheduler handing out fixed size generation slices, so a long
+// reply can't starve other conversations on a single slot server.
+type sliceScheduler struct {
+	slice int
+
+	mu      sync.Mutex
+	busy    bool
+	waiting []*sliceTurn
+	closed  error
+}
+
+type sliceTurn struct {
+	key   any
+	ready chan struct{}
+	err   error
+}
+
+func newSliceScheduler(slice int) *sliceScheduler {
+	return &sliceScheduler{slice: slice}
+}
+
+func (s *sliceScheduler) acquire(ctx context.Context, key any) error {
+	s.mu.Lock()
+	if s.closed != nil {
+		s.mu.Unlock()
+		return s.closed
+	}
+	if !s.busy && len(s.waiting) == 0 {
+		s.busy = true
+		s.mu.Unlock()
+		return nil
+	}
+
+	turn := &sliceTurn{key: key, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, turn)
+	s.mu.Unlock()
+
+	select {
+	case <-turn.ready:
+		return turn.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for i, t := range s.waiting {
+			if t == turn {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Turn was handed over concurrently, pass it on
+		s.releaseLocked(key)
+		return ctx.Err()
+	}
+}
+
+func (s *sliceScheduler) release(lastKey any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(lastKey)
+}
+
+// Fails every queued turn and all future ones with err.
+func (s *sliceScheduler) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = err
+	for _, t := range s.waiting {
+		t.err = err
+		close(t.ready)
+	}
+	s.waiting = nil
+}
+
+func (s *sliceScheduler) releaseLocked(lastKey any) {
+	if len(s.waiting) == 0 {
+		s.busy = false
+		return
+	}
+
+	// Prefer another conversation than the one that just ran
+	next := 0
+	for i, t := range s.waiting {
+		if t.key != lastKey {
+			next = i
+			break
+		}
+	}
+
+	turn := s.waiting[next]
+	s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+	close(turn.ready)
+}
+
+func (s *sliceScheduler) chat(ctx context.Context, x *XpltAI, req chatRequest) (ChatResponse, error) {
+	// Standalone requests each get their own turn identity
+	key := req.key
+	if key == nil {
+		key = &sliceTurn{}
+	}
+
+	result := ChatResponse{}
+	text := req.prefill
+	remaining := req.opts.MaxTokens
+
+	for {
+		err := s.acquire(ctx, key)
+		if err != nil {
+			return result, err
+		}
+
+		sliceOpts := req.opts
+		sliceOpts.MaxTokens = min(remaining, s.slice)
+		// Several replies can't be continued together, they get one full turn
+		if req.opts.N > 1 {
+			sliceOpts.MaxTokens = remaining
+		}
+
+		msgs := req.messages
+		if text != "" {
+			msgs = withAssistantPrefill(req.messages, text)
+		}
+
+		resp, err := x.chatOnce(ctx, msgs, sliceOpts, req.onDelta)
+		s.release(key)
+
+		result.Timing.Wait += resp.Timing.Wait
+		result.Timing.Generation += resp.Timing.Generation
+		if err != nil {
+			return result, err
+		}
+
+		for i := range resp.Choices {
+			resp.Choices[i].Content = text + resp.Choices[i].Content
+		}
+		text += resp.Content
+		remaining -= sliceOpts.MaxTokens
+
+		resp.Content = text
+		resp.Timing = result.Timing
+		result = resp
+
+		// Only a slice cut short by its own token cap gets continued
+		if resp.FinishReason != "length" || remaining <= 0 {
+			return result, nil
+		}
+	}
+}
+
+// Appends a partial assistant message, which llama-server continues instead
+// of starting a new turn.
+func withAssistantPrefill(messages []Message, text string) []Message {
+	out := make([]Message, len(messages), len(messages)+1)
+	copy(out, messages)
+	return append(out, Message{Role: "assistant", Content: text})
+}
@@ -0,0 +1,127 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+)
+
+func (x *XpltAI) tokenize(ctx context.Context, content string, addSpecial bool) ([]int, error) {
+	return x.tokenizeSpecial(ctx, content, &addSpecial, nil)
+}
+
+func (x *XpltAI) contextSize(ctx context.Context) (int, error) {
+	respData := struct {
+		Settings struct {
+			NCtx int `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+	}{}
+
+	err := x.doJSON(ctx, "GET", "/props", nil, &respData)
+	if err != nil {
+		return 0, err
+	}
+	if respData.Settings.NCtx <= 0 {
+		return 0, errors.New("json parsing failure, missing n_ctx field")
+	}
+	return respData.Settings.NCtx, nil
+}
+
+// Returns how likely the model finds continuation after prompt, as the sum of
+// the log probabilities of the continuation tokens and each token's logprob.
+func (x *XpltAI) Score(prompt string, continuation string) (float64, []float64, error) {
+	return x.ScoreContext(context.Background(), prompt, continuation)
+}
+
+// Score with a context, the requests forcing each token hold a single queue
+// slot.
+func (x *XpltAI) ScoreContext(ctx context.Context, prompt string, continuation string) (sum float64, perToken []float64, err error) {
+	if continuation == "" {
+		return 0, nil, errors.New("continuation is empty")
+	}
+
+	ctx, end, err := x.beginRequest(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { err = end(err) }()
+
+	release, err := x.acquireSlot(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer release()
+
+	_, err = x.waitReady(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Tokenized jointly, tokens can merge across the prompt/continuation boundary
+	joint, err := x.tokenize(ctx, prompt+continuation, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	promptToks, err := x.tokenize(ctx, prompt, true)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	boundary := 0
+	for boundary < len(promptToks) && boundary < len(joint) && promptToks[boundary] == joint[boundary] {
+		boundary++
+	}
+	if boundary == 0 {
+		return 0, nil, errors.New("prompt is empty, nothing to condition the continuation on")
+	}
+	if boundary >= len(joint) {
+		return 0, nil, errors.New("continuation produced no tokens")
+	}
+
+	nCtx, err := x.contextSize(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(joint) > nCtx {
+		return 0, nil, errors.New("continuation does not fit in the remaining context")
+	}
+
+	perToken = make([]float64, 0, len(joint)-boundary)
+
+	// Force each continuation token in turn, the reported logprob is taken
+	// before sampling so the bias does not affect it.
+	for i := boundary; i < len(joint); i++ {
+		data := map[string]any{
+			"prompt":              joint[:i],
+			"n_predict":           1,
+			"n_probs":             1,
+			"temperature":         0,
+			"cache_prompt":        true,
+			"post_sampling_probs": false,
+			"logit_bias":          [][]any{{joint[i], 100}},
+		}
+
+		respData := struct {
+			Probs []struct {
+				Id      int     `json:"id"`
+				Logprob float64 `json:"logprob"`
+			} `json:"completion_probabilities"`
+		}{}
+
+		err := x.doJSON(ctx, "POST", "/completion", data, &respData)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if len(respData.Probs) == 0 {
+			return 0, nil, errors.New("json parsing failure, missing completion_probabilities field")
+		}
+		if respData.Probs[0].Id != joint[i] {
+			return 0, nil, errors.New("server did not sample the forced continuation token")
+		}
+
+		perToken = append(perToken, respData.Probs[0].Logprob)
+		sum += respData.Probs[0].Logprob
+	}
+
+	return sum, perToken, nil
+}
@@ -0,0 +1,97 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// Server tokenizing one token per byte after a BOS of 1, every forced token
+// has a logprob of -0.5.
+func scoreServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{}
+		json.NewDecoder(r.Body).Decode(&data)
+
+		switch r.URL.Path {
+		case "/tokenize":
+			tokens := []int{1}
+			for _, b := range []byte(data["content"].(string)) {
+				tokens = append(tokens, int(b))
+			}
+			json.NewEncoder(w).Encode(map[string]any{"tokens": tokens})
+		case "/props":
+			w.Write([]byte(`{"default_generation_settings":{"n_ctx":4096}}`))
+		case "/completion":
+			bias := data["logit_bias"].([]any)[0].([]any)
+			json.NewEncoder(w).Encode(map[string]any{
+				"completion_probabilities": []map[string]any{{"id": bias[0], "logprob": -0.5}},
+			})
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+}
+
+func TestScoreContextSumsForcedTokens(t *testing.T) {
+	srv := scoreServer()
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	sum, perToken, err := x.ScoreContext(context.Background(), "ab", "cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(sum+1) > 1e-9 || !slices.Equal(perToken, []float64{-0.5, -0.5}) {
+		t.Errorf("got %v %v, want -1 [-0.5 -0.5]", sum, perToken)
+	}
+}
+
+func TestScoreContextStopsWhenCanceled(t *testing.T) {
+	srv := scoreServer()
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := x.ScoreContext(ctx, "ab", "cd")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestScoreContextWaitsForQueueSlot(t *testing.T) {
+	srv := scoreServer()
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+	x.queue = make(chan struct{}, 1)
+	x.queue <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := x.ScoreContext(ctx, "ab", "cd")
+		done <- err
+	}()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v while the queue was full, want context.Canceled", err)
+	}
+}
+
+func TestScoreContextRefusedAfterShutdown(t *testing.T) {
+	srv := scoreServer()
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	x.Shutdown(context.Background())
+	_, _, err := x.ScoreContext(context.Background(), "ab", "cd")
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("got %v, want ErrShuttingDown", err)
+	}
+}
@@ -0,0 +1,231 @@
+package xplatai
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Fills the unset fields of cfg that have a package default.
+func fillConfigDefaults(cfg Config) Config {
+	defaults := DefaultConfig()
+
+	if cfg.HFModel == "" {
+		cfg.HFModel = defaults.HFModel
+	}
+	if cfg.Threads <= 0 {
+		cfg.Threads = defaults.Threads
+	}
+	if cfg.BindHost == "" {
+		cfg.BindHost = "127.0.0.1"
+	}
+	if cfg.ModelPolicy == nil {
+		cfg.ModelPolicy = defaults.ModelPolicy
+	}
+	return cfg
+}
+
+// Fills defaults and applies the settings of the resolved model, fields set
+// on cfg take precedence over the model's.
+func resolveConfig(cfg Config) (Config, ModelSpec, error) {
+	cfg = fillConfigDefaults(cfg)
+	if deterministic.Load() {
+		cfg.ParallelSlots = 1
+	}
+
+	err := validateConfig(cfg)
+	if err != nil {
+		return cfg, ModelSpec{}, err
+	}
+
+	spec, err := ResolveModel(cfg.HFModel)
+	if err != nil {
+		return cfg, spec, err
+	}
+
+	if cfg.ContextSize <= 0 {
+		cfg.ContextSize = spec.ContextSize
+	}
+	cfg.DefaultGenOptions = mergeGenOptions(spec.GenOptions, cfg.DefaultGenOptions)
+	return cfg, spec, nil
+}
+
+func validateConfig(cfg Config) error {
+	switch cfg.Numa {
+	case NUMA_NONE, NUMA_DISTRIBUTE, NUMA_ISOLATE, NUMA_NUMACTL:
+	default:
+		return errors.New("invalid numa strategy: " + cfg.Numa)
+	}
+
+	if cfg.ParallelSlots < 0 {
+		return errors.New("parallel slots is negative")
+	}
+	if cfg.ThreadsHTTP < 0 {
+		return errors.New("http thread count is negative")
+	}
+	if cfg.ContextSize < 0 {
+		return errors.New("context size is negative")
+	}
+	if cfg.RopeScale < 0 {
+		return errors.New("rope scale is negative")
+	}
+	if cfg.GPULayers != nil && *cfg.GPULayers < 0 {
+		return errors.New("gpu layer count is negative")
+	}
+	if cfg.Nice < -20 || cfg.Nice > 19 {
+		return errors.New("nice level must be in [-20, 19]")
+	}
+	for _, cpu := range cfg.CPUAffinity {
+		if cpu < 0 || cpu >= maxAffinityCPUs {
+			return errors.New("invalid cpu in affinity: " + strconv.Itoa(cpu))
+		}
+	}
+	if cfg.MemoryLimitBytes < 0 {
+		return errors.New("memory limit is negative")
+	}
+	if cfg.MaxConcurrentRequests < 0 {
+		return errors.New("max concurrent requests is negative")
+	}
+	if cfg.CircuitFailures < 0 {
+		return errors.New("circuit breaker failure threshold is negative")
+	}
+	if cfg.CircuitCooldown < 0 {
+		return errors.New("circuit breaker cooldown is negative")
+	}
+	if cfg.Retry.Backoff < 0 || cfg.Retry.MaxBackoff < 0 {
+		return errors.New("retry backoff is negative")
+	}
+	if cfg.Retry.Jitter < 0 || cfg.Retry.Jitter > 1 {
+		return errors.New("retry jitter is outside [0, 1]")
+	}
+	if cfg.ChatTemplate != "" && cfg.ChatTemplateFile != "" {
+		return errors.New("chat template and chat template file are mutually exclusive")
+	}
+	if cfg.ParallelSlots > 1 && cfg.ContextSize > 0 && cfg.ContextSize < cfg.ParallelSlots {
+		return errors.New("context size is smaller than the number of parallel slots")
+	}
+	return nil
+}
+
+// Returns the llama-server arguments NewWithConfig would spawn the server with.
+func ServerArgs(cfg Config) ([]string, error) {
+	cfg, spec, err := resolveConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err = negotiateContext(cfg, spec)
+	if err != nil {
+		return nil, err
+	}
+	return serverArgs(cfg, spec), nil
+}
+
+func serverArgs(cfg Config, spec ModelSpec) []string {
+	args := []string{}
+
+	if spec.IsLocal() {
+		modelPath := spec.Source
+		// Relative to the caller's working directory, not the server's
+		if abs, err := filepath.Abs(modelPath); err == nil && cfg.WorkDir != "" {
+			modelPath = abs
+		}
+		args = append(args, "--model", modelPath)
+	} else {
+		args = append(args, "-hf", spec.HFRef())
+	}
+
+	args = append(args,
+		"--host", cfg.BindHost,
+		"--port", cfg.Port,
+		"--threads", strconv.Itoa(cfg.Threads),
+	)
+
+	gpuLayers := 999
+	if cfg.GPULayers != nil {
+		gpuLayers = *cfg.GPULayers
+	}
+	args = append(args, "--gpu-layers", strconv.Itoa(gpuLayers))
+
+	if cfg.ContextSize > 0 {
+		args = append(args, "--ctx-size", strconv.Itoa(cfg.ContextSize))
+	}
+	if cfg.RopeScale > 0 {
+		args = append(args, "--rope-scale", strconv.FormatFloat(cfg.RopeScale, 'f', -1, 64))
+	}
+	if cfg.ParallelSlots > 0 {
+		args = append(args, "--parallel", strconv.Itoa(cfg.ParallelSlots))
+	}
+
+	contBatching := cfg.ContinuousBatching
+	if contBatching == nil && cfg.ParallelSlots > 1 {
+		contBatching = Ptr(true)
+	}
+	if contBatching != nil {
+		if *contBatching {
+			args = append(args, "--cont-batching")
+		} else {
+			args = append(args, "--no-cont-batching")
+		}
+	}
+
+	if cfg.CacheReuse > 0 {
+		args = append(args, "--cache-reuse", strconv.Itoa(cfg.CacheReuse))
+	}
+	if cfg.Numa != NUMA_NONE {
+		args = append(args, "--numa", cfg.Numa)
+	}
+	if cfg.ThreadsHTTP > 0 {
+		args = append(args, "--threads-http", strconv.Itoa(cfg.ThreadsHTTP))
+	}
+	if strings.Trim(cfg.BasePath, "/") != "" {
+		args = append(args, "--api-prefix", "/"+strings.Trim(cfg.BasePath, "/"))
+	}
+	if cfg.SlotSavePath != "" {
+		args = append(args, "--slot-save-path", cfg.SlotSavePath)
+	}
+	if cfg.Jinja {
+		args = append(args, "--jinja")
+	}
+	if cfg.ChatTemplate != "" {
+		args = append(args, "--chat-template", cfg.ChatTemplate)
+	}
+	if cfg.ChatTemplateFile != "" {
+		args = append(args, "--chat-template-file", cfg.ChatTemplateFile)
+	}
+	if cfg.Embeddings {
+		args = append(args, "--embeddings")
+	}
+	if cfg.DisableWebUI {
+		args = append(args, "--no-webui")
+	}
+	return append(args, cfg.ExtraArgs...)
+}
+
+// Addresses clients can reach a server bound to bindHost on, in order of
+// preference. Wildcard and localhost binds get both loopback addresses unless
+// loopback picks one, a loopback address only reaches itself.
+func clientHosts(bindHost string, loopback string) []string {
+	switch strings.Trim(bindHost, "[]") {
+	case "", "0.0.0.0", "localhost":
+		if loopback != "" {
+			return []string{loopback}
+		}
+		return []string{"127.0.0.1", "::1"}
+	case "::":
+		if loopback != "" {
+			return []string{loopback}
+		}
+		return []string{"::1", "127.0.0.1"}
+	}
+	return []string{bindHost}
+}
+
+func serverUrls(bindHost string, loopback string, port string) []string {
+	urls := []string{}
+	for _, host := range clientHosts(bindHost, loopback) {
+		urls = append(urls, "http://"+net.JoinHostPort(strings.Trim(host, "[]"), port))
+	}
+	return urls
+}
@@ -0,0 +1,169 @@
+package xplatai
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClientHosts(t *testing.T) {
+	tests := []struct {
+		bind     string
+		loopback string
+		want     []string
+	}{
+		{"127.0.0.1", "", []string{"127.0.0.1"}},
+		{"127.0.0.1", "::1", []string{"127.0.0.1"}},
+		{"::1", "", []string{"::1"}},
+		{"[::1]", "", []string{"[::1]"}},
+		{"", "", []string{"127.0.0.1", "::1"}},
+		{"0.0.0.0", "", []string{"127.0.0.1", "::1"}},
+		{"localhost", "", []string{"127.0.0.1", "::1"}},
+		{"::", "", []string{"::1", "127.0.0.1"}},
+		{"[::]", "", []string{"::1", "127.0.0.1"}},
+		{"0.0.0.0", "::1", []string{"::1"}},
+		{"192.168.1.20", "", []string{"192.168.1.20"}},
+	}
+	for _, tt := range tests {
+		got := clientHosts(tt.bind, tt.loopback)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("clientHosts(%q, %q) = %v, want %v", tt.bind, tt.loopback, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultBindOnlyReachesIPv4Loopback(t *testing.T) {
+	fakeInstall(t)
+	x, err := NewWithConfig(fakeConfig(newFakeClock(), &fakeProcs{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	want := []string{"http://127.0.0.1:18080"}
+	if !slices.Equal(x.hosts, want) {
+		t.Errorf("requests go to %v, want %v", x.hosts, want)
+	}
+}
+
+// Value following flag in args, false when the flag is missing.
+func argValue(args []string, flag string) (string, bool) {
+	i := slices.Index(args, flag)
+	if i < 0 || i+1 >= len(args) {
+		return "", false
+	}
+	return args[i+1], true
+}
+
+// Arguments the server would be started with for cfg.
+func resolvedArgs(t *testing.T, cfg Config) []string {
+	t.Helper()
+	cfg, spec, err := resolveConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serverArgs(cfg, spec)
+}
+
+func TestServerArgsHost(t *testing.T) {
+	tests := []struct {
+		bind string
+		want string
+	}{
+		{"", "127.0.0.1"},
+		{"0.0.0.0", "0.0.0.0"},
+		{"::1", "::1"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Port = "18080"
+		cfg.BindHost = tt.bind
+		args := resolvedArgs(t, cfg)
+		if host, _ := argValue(args, "--host"); host != tt.want {
+			t.Errorf("bind %q started with %v, want --host %s", tt.bind, args, tt.want)
+		}
+	}
+}
+
+func TestServerArgsThroughput(t *testing.T) {
+	tests := []struct {
+		name    string
+		apply   func(cfg *Config)
+		want    map[string]string
+		flags   []string
+		missing []string
+	}{
+		{
+			name:    "unset",
+			apply:   func(cfg *Config) {},
+			missing: []string{"--numa", "--threads-http", "--cont-batching", "--no-cont-batching"},
+		},
+		{
+			name: "numa and http threads",
+			apply: func(cfg *Config) {
+				cfg.Numa = NUMA_DISTRIBUTE
+				cfg.ThreadsHTTP = 4
+			},
+			want: map[string]string{"--numa": "distribute", "--threads-http": "4"},
+		},
+		{
+			name:    "parallel slots batch by default",
+			apply:   func(cfg *Config) { cfg.ParallelSlots = 4 },
+			want:    map[string]string{"--parallel": "4"},
+			flags:   []string{"--cont-batching"},
+			missing: []string{"--no-cont-batching"},
+		},
+		{
+			name: "batching disabled",
+			apply: func(cfg *Config) {
+				cfg.ParallelSlots = 4
+				cfg.ContinuousBatching = Ptr(false)
+			},
+			flags:   []string{"--no-cont-batching"},
+			missing: []string{"--cont-batching"},
+		},
+		{
+			name:    "batching with one slot",
+			apply:   func(cfg *Config) { cfg.ContinuousBatching = Ptr(true) },
+			flags:   []string{"--cont-batching"},
+			missing: []string{"--parallel"},
+		},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Port = "18080"
+		tt.apply(&cfg)
+		args := resolvedArgs(t, cfg)
+
+		for flag, value := range tt.want {
+			if got, ok := argValue(args, flag); !ok || got != value {
+				t.Errorf("%s: started with %v, want %s %s", tt.name, args, flag, value)
+			}
+		}
+		for _, flag := range tt.flags {
+			if !slices.Contains(args, flag) {
+				t.Errorf("%s: started with %v, want %s", tt.name, args, flag)
+			}
+		}
+		for _, flag := range tt.missing {
+			if slices.Contains(args, flag) {
+				t.Errorf("%s: started with %v, want no %s", tt.name, args, flag)
+			}
+		}
+	}
+}
+
+func TestThroughputConfigValidated(t *testing.T) {
+	tests := map[string]func(cfg *Config){
+		"unknown numa strategy": func(cfg *Config) { cfg.Numa = "everywhere" },
+		"negative http threads": func(cfg *Config) { cfg.ThreadsHTTP = -1 },
+		"negative slots":        func(cfg *Config) { cfg.ParallelSlots = -1 },
+	}
+	for name, apply := range tests {
+		cfg := DefaultConfig()
+		cfg.Port = "18080"
+		apply(&cfg)
+		if _, _, err := resolveConfig(cfg); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package xplatai
+
+import (
+	"context"
+	"slices"
+)
+
+// Conversation sending every message with the same options, for callers that
+// only send text and read replies. The embedded Conversation gives access to
+// the history, regeneration and branching.
+type Session struct {
+	*Conversation
+	opts     GenOptions
+	trim     TrimOptions
+	metadata map[string]string
+	// Renders prompts instead of the server's chat template when set
+	format *ChatFormat
+	lore   *Lorebook
+	memory *Memory
+
+	store   TurnStore
+	storeId string
+}
+
+// The system prompt is pinned, see SetSystemPrompt. Sessions drop the oldest
+// messages once the history outgrows the context, see SetTrimming.
+func (x *XpltAI) NewSession(systemPrompt string, opts GenOptions) *Session {
+	return x.newSession(x.NewConversation(systemPrompt), opts, TrimOptions{Strategy: TRIM_KEEP_SYSTEM})
+}
+
+func (x *XpltAI) newSession(c *Conversation, opts GenOptions, trim TrimOptions) *Session {
+	s := &Session{Conversation: c, opts: opts, trim: trim, metadata: map[string]string{}}
+	s.prepare = func(ctx context.Context, msgs []Message, opts GenOptions) ([]Message, error) {
+		msgs, err := x.injectLore(ctx, msgs, s.lore)
+		if err != nil {
+			return msgs, err
+		}
+		if s.memory != nil {
+			// Trimmed once to know which exchanges the prompt still holds
+			sent, err := x.trimHistory(ctx, msgs, opts, s.trim)
+			if err != nil {
+				return msgs, err
+			}
+			msgs, err = s.memory.inject(ctx, msgs, sent)
+			if err != nil {
+				return msgs, err
+			}
+		}
+		return x.trimHistory(ctx, msgs, opts, s.trim)
+	}
+	s.generate = func(ctx context.Context, req chatRequest) (ChatResponse, error) {
+		if s.format != nil {
+			return x.chatFormatted(ctx, *s.format, req)
+		}
+		return x.doChat(ctx, req)
+	}
+	return s
+}
+
+// Sends a user message and appends it and the reply to the history. A failed
+// request leaves the history unchanged.
+func (s *Session) Send(text string) (ChatResponse, error) {
+	return s.SendContext(context.Background(), text)
+}
+
+func (s *Session) SendContext(ctx context.Context, text string) (ChatResponse, error) {
+	resp, err := s.say(ctx, text, s.opts, nil)
+	if err != nil {
+		return resp, err
+	}
+	return resp, s.afterTurn(ctx, text, resp)
+}
+
+// Send streaming the reply, calling onToken with each piece of text as it is
+// generated.
+func (s *Session) SendStream(text string, onToken func(token string)) (ChatResponse, error) {
+	return s.SendStreamContext(context.Background(), text, onToken)
+}
+
+func (s *Session) SendStreamContext(ctx context.Context, text string, onToken func(token string)) (ChatResponse, error) {
+	if onToken == nil {
+		onToken = func(string) {}
+	}
+	resp, err := s.say(ctx, text, s.opts, onToken)
+	if err != nil {
+		return resp, err
+	}
+	return resp, s.afterTurn(ctx, text, resp)
+}
+
+// Clears the history, keeping the system prompt.
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := []Message{}
+	if len(s.messages) > 0 && s.messages[0].Role == "system" {
+		kept = append(kept, s.messages[0])
+	}
+	s.messages = slices.Clip(kept)
+	s.alternatives = map[int][]string{}
+}
+
+// The pinned system prompt, empty when the session has none.
+func (s *Session) SystemPrompt() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) > 0 && s.messages[0].Role == "system" {
+		return s.messages[0].Content
+	}
+	return ""
+}
+
+// Replaces the pinned system prompt, the next message is sent with it. An
+// empty prompt removes it. The system prompt is the first message of the
+// history and is never trimmed.
+func (s *Session) SetSystemPrompt(prompt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hasSystem := len(s.messages) > 0 && s.messages[0].Role == "system"
+	system := Message{Role: "system", Content: prompt}
+
+	// Branches may share the history, never modify it in place
+	switch {
+	case hasSystem && prompt != "":
+		s.messages = slices.Concat([]Message{system}, s.messages[1:])
+	case hasSystem:
+		s.messages = slices.Clone(s.messages[1:])
+		s.shiftAlternatives(-1)
+	case prompt != "":
+		s.messages = slices.Concat([]Message{system}, s.messages)
+		s.shiftAlternatives(1)
+	}
+}
+
+// Keeps the alternatives on their messages once the history shifted by delta.
+func (s *Session) shiftAlternatives(delta int) {
+	shifted := map[int][]string{}
+	for i, alts := range s.alternatives {
+		shifted[i+delta] = alts
+	}
+	s.alternatives = shifted
+}
@@ -0,0 +1,95 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+)
+
+// Version of the saved session format, bumped on any incompatible change.
+const SESSION_FORMAT_VERSION = 1
+
+// Saved session, Conversation holds the same fields Conversation.MarshalJSON
+// writes:
+//
+//	{"v":1,"saved_at":"2006-01-02T15:04:05Z","conversation":{"messages":[...]},
+//	 "options":{"max_tokens":256},"trimming":{"strategy":"keep_system"},
+//	 "metadata":{"character":"Aqua"}}
+type sessionState struct {
+	V            int               `json:"v"`
+	SavedAt      time.Time         `json:"saved_at"`
+	Conversation conversationState `json:"conversation"`
+	Options      GenOptions        `json:"options"`
+	Trimming     TrimOptions       `json:"trimming"`
+	Format       *ChatFormat       `json:"format,omitempty"`
+	Lorebook     *Lorebook         `json:"lorebook,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// Sets a value saved along with the session, an empty value removes the key.
+func (s *Session) SetMetadata(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value == "" {
+		delete(s.metadata, key)
+		return
+	}
+	s.metadata[key] = value
+}
+
+func (s *Session) Metadata() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.metadata)
+}
+
+// Writes the history, generation options, trimming, chat format, lorebook and
+// metadata as JSON, restore it with LoadSession.
+func (s *Session) Save(w io.Writer) error {
+	s.mu.Lock()
+	state := sessionState{
+		V:            SESSION_FORMAT_VERSION,
+		SavedAt:      time.Now().UTC(),
+		Conversation: s.stateLocked(),
+		Options:      s.opts,
+		Trimming:     s.trim,
+		Format:       s.format,
+		Lorebook:     s.lore,
+		Metadata:     s.metadata,
+	}
+	b, err := json.Marshal(state)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// Restores a session written by Session.Save.
+func (x *XpltAI) LoadSession(r io.Reader) (*Session, error) {
+	state := sessionState{}
+	err := json.NewDecoder(r).Decode(&state)
+	if err != nil {
+		return nil, fmt.Errorf("json parsing failure, %w", err)
+	}
+	if state.V > SESSION_FORMAT_VERSION {
+		return nil, fmt.Errorf("session format version %d is newer than supported %d", state.V, SESSION_FORMAT_VERSION)
+	}
+	err = state.Trimming.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s := x.newSession(x.restoreConversation(state.Conversation), state.Options, state.Trimming)
+	s.format = state.Format
+	s.lore = state.Lorebook
+	if state.Metadata != nil {
+		s.metadata = state.Metadata
+	}
+	return s, nil
+}
@@ -0,0 +1,143 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Retries requests that failed before reaching the server.
+type RetryPolicy struct {
+	// Total attempts including the first one, 0 or 1 disables retries
+	MaxAttempts int
+	// Wait before the first retry
+	Backoff time.Duration
+	// Growth of the wait after each retry, values up to 1 keep it constant
+	Multiplier float64
+	// Longest wait between attempts, 0 for no limit
+	MaxBackoff time.Duration
+	// Fraction of each wait in [0, 1] randomly taken off, spreading out
+	// clients that retry together
+	Jitter float64
+	// Also retries responses with status 503, which llama-server sends while
+	// it loads the model. Busy rejections follow BusyAttempts instead.
+	RetryOn503 bool
+
+	// Total attempts for requests the server rejected for lack of a free slot
+	// or KV cache space, 0 uses DEFAULT_BUSY_ATTEMPTS. Retries wait for a slot
+	// to free up rather than sleeping for Backoff.
+	BusyAttempts int
+}
+
+// Immutable snapshot of the runtime adjustable request settings, replaced as a
+// whole so a request never observes a partial update.
+type requestSettings struct {
+	genOptions GenOptions
+	headers    http.Header
+	retry      RetryPolicy
+}
+
+type settingsKey struct{}
+
+func (x *XpltAI) updateSettings(update func(s *requestSettings)) {
+	for {
+		old := x.settings.Load()
+		next := *old
+		update(&next)
+		if x.settings.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+// Pins the current settings snapshot to ctx, unless one already is.
+func (x *XpltAI) captureSettings(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(settingsKey{}).(*requestSettings); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, settingsKey{}, x.settings.Load())
+}
+
+func (x *XpltAI) settingsFrom(ctx context.Context) *requestSettings {
+	if s, ok := ctx.Value(settingsKey{}).(*requestSettings); ok {
+		return s
+	}
+	return x.settings.Load()
+}
+
+func cloneGenOptions(o GenOptions) GenOptions {
+	o.Temperature = clonePtr(o.Temperature)
+	o.TopK = clonePtr(o.TopK)
+	o.TopP = clonePtr(o.TopP)
+	o.MinP = clonePtr(o.MinP)
+	o.RepeatPenalty = clonePtr(o.RepeatPenalty)
+	o.Stop = slices.Clone(o.Stop)
+	o.LogitBias = maps.Clone(o.LogitBias)
+	o.JSONSchema = slices.Clone(o.JSONSchema)
+	o.CachePrompt = clonePtr(o.CachePrompt)
+	o.AddBOS = clonePtr(o.AddBOS)
+	o.ParseSpecial = clonePtr(o.ParseSpecial)
+	return o
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// Replaces the options applied to every request, in-flight requests keep
+// the options they started with.
+func (x *XpltAI) SetDefaultGenOptions(opts GenOptions) error {
+	err := opts.validateMaxTokens()
+	if err != nil {
+		return err
+	}
+	if opts.Preset != "" {
+		if _, ok := PresetOptions(opts.Preset); !ok {
+			return errors.New("unknown preset: " + opts.Preset)
+		}
+	}
+
+	opts = cloneGenOptions(opts)
+	x.updateSettings(func(s *requestSettings) {
+		s.genOptions = opts
+	})
+	return nil
+}
+
+// Replaces the headers sent with every request.
+func (x *XpltAI) SetDefaultHeaders(headers http.Header) {
+	headers = headers.Clone()
+	x.updateSettings(func(s *requestSettings) {
+		s.headers = headers
+	})
+}
+
+// Wait before the given retry, 1 for the first one.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := float64(p.Backoff)
+	if p.Multiplier > 1 {
+		d *= math.Pow(p.Multiplier, float64(retry-1))
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+func (x *XpltAI) SetRetryPolicy(policy RetryPolicy) {
+	x.updateSettings(func(s *requestSettings) {
+		s.retry = policy
+	})
+}
@@ -0,0 +1,81 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Changes the default options while requests are sent, each request must
+// carry the options of a single SetDefaultGenOptions call.
+func TestSettingsStress(t *testing.T) {
+	const snapshots = 200
+
+	mixed := atomic.Int32{}
+	requests := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		body := struct {
+			Temperature float64  `json:"temperature"`
+			MaxTokens   int      `json:"max_tokens"`
+			Stop        []string `json:"stop"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests.Add(1)
+
+		i := int(body.Temperature)
+		matches := body.MaxTokens == 100+i && len(body.Stop) == 1 && body.Stop[0] == fmt.Sprint("s", i)
+		if !matches || i < 0 || i > snapshots {
+			mixed.Add(1)
+			t.Errorf("body matches no snapshot: temperature %v, max_tokens %d, stop %v", body.Temperature, body.MaxTokens, body.Stop)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer srv.Close()
+
+	x := newTestHandle(t, srv, realClock{})
+	set := func(i int, stop []string) {
+		stop[0] = fmt.Sprint("s", i)
+		err := x.SetDefaultGenOptions(GenOptions{Temperature: Ptr(float64(i)), MaxTokens: 100 + i, Stop: stop})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	// Reused for every call, the handle must keep its own copy
+	stop := []string{""}
+	set(0, stop)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= snapshots; i++ {
+			set(i, stop)
+		}
+	}()
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				_, err := x.doChat(context.Background(), chatRequest{messages: []Message{{Role: "user", Content: "hi"}}})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requests.Load() != 200 || mixed.Load() != 0 {
+		t.Errorf("%d of %d requests mixed snapshots", mixed.Load(), requests.Load())
+	}
+}
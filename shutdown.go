@@ -0,0 +1,127 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrShuttingDown = errors.New("handle is shutting down")
+
+// Registers a request as in-flight. The returned context is cancelled when the
+// handle stops, end maps the resulting error to ErrShuttingDown.
+func (x *XpltAI) beginRequest(ctx context.Context) (context.Context, func(error) error, error) {
+	x.lifeMu.Lock()
+	if x.shuttingDown {
+		x.lifeMu.Unlock()
+		return ctx, nil, ErrShuttingDown
+	}
+	probe, err := x.admitRequest()
+	if err != nil {
+		x.lifeMu.Unlock()
+		return ctx, nil, err
+	}
+	x.inflight.Add(1)
+	x.lifeMu.Unlock()
+
+	reqCtx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(x.life, func() {
+		cancel(context.Cause(x.life))
+	})
+
+	end := func(err error) error {
+		stop()
+		x.recordOutcome(ctx, probe, err)
+		if err != nil && errors.Is(context.Cause(reqCtx), ErrShuttingDown) {
+			err = fmt.Errorf("%w: %w", ErrShuttingDown, err)
+		}
+		cancel(nil)
+		x.inflight.Done()
+		return err
+	}
+	return reqCtx, end, nil
+}
+
+// Closed once the handle stops accepting requests. Called without lifeMu.
+func (x *XpltAI) drainingCh() <-chan struct{} {
+	x.lifeMu.Lock()
+	defer x.lifeMu.Unlock()
+	if x.draining == nil {
+		x.draining = make(chan struct{})
+	}
+	return x.draining
+}
+
+// Stops accepting requests. Called with lifeMu held.
+func (x *XpltAI) startDraining() {
+	if x.shuttingDown {
+		return
+	}
+	x.shuttingDown = true
+	if x.draining == nil {
+		x.draining = make(chan struct{})
+	}
+	close(x.draining)
+}
+
+// Stops accepting requests, lets in-flight ones finish until ctx is done then
+// cancels them, saves slots when SlotSavePath is set and terminates the
+// server. Queued requests fail with ErrShuttingDown.
+func (x *XpltAI) Shutdown(ctx context.Context) error {
+	x.lifeMu.Lock()
+	if x.shuttingDown {
+		x.lifeMu.Unlock()
+		return ErrShuttingDown
+	}
+	x.startDraining()
+	x.lifeMu.Unlock()
+
+	if x.sched != nil {
+		x.sched.close(ErrShuttingDown)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		x.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		x.stopLife(ErrShuttingDown)
+		<-drained
+	}
+
+	var saveErr error
+	if x.slotSavePath != "" {
+		saveCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		saveErr = x.saveSlots(saveCtx)
+		cancel()
+	}
+
+	return errors.Join(saveErr, x.Close())
+}
+
+func (x *XpltAI) saveSlots(ctx context.Context) error {
+	props := struct {
+		TotalSlots int `json:"total_slots"`
+	}{}
+
+	err := x.doJSON(ctx, "GET", "/props", nil, &props)
+	if err != nil {
+		return err
+	}
+
+	for id := range props.TotalSlots {
+		data := map[string]any{
+			"filename": fmt.Sprintf("slot-%d.bin", id),
+		}
+		err := x.doJSON(ctx, "POST", fmt.Sprintf("/slots/%d?action=save", id), data, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Server streaming one token then holding the stream open until release is
+// closed or the client goes away.
+func holdingStreamServer(release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// Starts a stream on x, returning once its first token arrived.
+func startStream(t *testing.T, x *XpltAI) <-chan error {
+	t.Helper()
+	first := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		resp, err := x.ChatDetailedStream([]Message{{Role: "user", Content: "hi"}}, GenOptions{}, func(string) {
+			select {
+			case first <- struct{}{}:
+			default:
+			}
+		})
+		if err == nil && resp.Content != "Hello" {
+			err = fmt.Errorf("got reply %q", resp.Content)
+		}
+		done <- err
+	}()
+
+	select {
+	case <-first:
+	case err := <-done:
+		t.Fatalf("stream ended before its first token: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream never started")
+	}
+	return done
+}
+
+func TestShutdownLetsActiveStreamFinish(t *testing.T) {
+	release := make(chan struct{})
+	srv := holdingStreamServer(release)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	streamDone := startStream(t, x)
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- x.Shutdown(context.Background())
+	}()
+
+	// Shutdown waits for the stream
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("shutdown returned during an active stream: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err := x.ChatDetailed([]Message{{Role: "user", Content: "hi"}}, GenOptions{})
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("new request got %v, want ErrShuttingDown", err)
+	}
+
+	close(release)
+	err = <-streamDone
+	if err != nil {
+		t.Errorf("stream failed: %v", err)
+	}
+	err = <-shutdownDone
+	if err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+}
+
+func TestShutdownCancelsStreamPastDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := holdingStreamServer(release)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	streamDone := startStream(t, x)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := x.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+
+	select {
+	case err := <-streamDone:
+		if !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("stream got %v, want ErrShuttingDown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still running after shutdown returned")
+	}
+}
+
+func TestShutdownFailsQueuedRequests(t *testing.T) {
+	release := make(chan struct{})
+	srv := holdingStreamServer(release)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+	x.queue = make(chan struct{}, 1)
+
+	streamDone := startStream(t, x)
+	queuedDone := make(chan error, 1)
+	go func() {
+		_, err := x.ChatDetailed([]Message{{Role: "user", Content: "hi"}}, GenOptions{})
+		queuedDone <- err
+	}()
+	for x.stats.queued.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- x.Shutdown(context.Background())
+	}()
+
+	// Fails without waiting for the active stream to free its slot
+	select {
+	case err := <-queuedDone:
+		if !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("queued request got %v, want ErrShuttingDown", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("queued request still waiting during shutdown")
+	}
+
+	close(release)
+	err := <-streamDone
+	if err != nil {
+		t.Errorf("stream failed: %v", err)
+	}
+	err = <-shutdownDone
+	if err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+}
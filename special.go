@@ -0,0 +1,143 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Tokenizes text with the server's tokenizer. AddBOS and ParseSpecial are
+// left to the /tokenize defaults when unset: no BOS, special tokens parsed.
+func (x *XpltAI) Tokenize(text string, opts GenOptions) ([]int, error) {
+	return x.TokenizeContext(context.Background(), text, opts)
+}
+
+func (x *XpltAI) TokenizeContext(ctx context.Context, text string, opts GenOptions) ([]int, error) {
+	return x.tokenizeSpecial(ctx, text, opts.AddBOS, opts.ParseSpecial)
+}
+
+// Turns tokens back into text with the server's tokenizer, the inverse of
+// Tokenize.
+func (x *XpltAI) Detokenize(tokens []int) (string, error) {
+	return x.DetokenizeContext(context.Background(), tokens)
+}
+
+func (x *XpltAI) DetokenizeContext(ctx context.Context, tokens []int) (string, error) {
+	return x.detokenize(ctx, tokens)
+}
+
+// Completes a prompt given as tokens, sent to the server as is. AddBOS
+// prepends the BOS token when the prompt doesn't start with it, ParseSpecial
+// has no effect.
+func (x *XpltAI) CompleteTokens(tokens []int, opts GenOptions) (CompleteResponse, error) {
+	if len(tokens) == 0 {
+		return CompleteResponse{}, errors.New("prompt has no tokens")
+	}
+	return x.complete(context.Background(), tokens, opts)
+}
+
+func (x *XpltAI) tokenizeSpecial(ctx context.Context, content string, addBOS *bool, parseSpecial *bool) ([]int, error) {
+	data := map[string]any{
+		"content": content,
+	}
+	if addBOS != nil {
+		data["add_special"] = *addBOS
+	}
+	if parseSpecial != nil {
+		data["parse_special"] = *parseSpecial
+	}
+
+	respData := struct {
+		Tokens []int `json:"tokens"`
+	}{}
+
+	err := x.doJSON(ctx, "POST", "/tokenize", data, &respData)
+	if err != nil {
+		return nil, err
+	}
+	return respData.Tokens, nil
+}
+
+func (x *XpltAI) detokenize(ctx context.Context, tokens []int) (string, error) {
+	if tokens == nil {
+		tokens = []int{}
+	}
+
+	respData := struct {
+		Content string `json:"content"`
+	}{}
+	err := x.doJSON(ctx, "POST", "/detokenize", map[string]any{"tokens": tokens}, &respData)
+	if err != nil {
+		return "", err
+	}
+	return respData.Content, nil
+}
+
+// The completion endpoint always adds BOS to prompt text and parses special
+// tokens in it. When AddBOS or ParseSpecial is set, the prompt is tokenized
+// beforehand with those settings, unset ones keeping the endpoint's, and
+// sent as tokens.
+func (x *XpltAI) tokenizePrompt(ctx context.Context, prompt any, opts GenOptions) (any, error) {
+	if opts.AddBOS == nil && opts.ParseSpecial == nil {
+		return prompt, nil
+	}
+
+	if tokens, ok := prompt.([]int); ok {
+		if opts.AddBOS == nil || !*opts.AddBOS {
+			return tokens, nil
+		}
+		// Empty for models that don't use a BOS token
+		bos, err := x.tokenizeSpecial(ctx, "", Ptr(true), nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(bos) == 0 || tokens[0] == bos[0] {
+			return tokens, nil
+		}
+		return append(bos[:1:1], tokens...), nil
+	}
+
+	text, err := promptText(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	addBOS, parseSpecial := true, true
+	if opts.AddBOS != nil {
+		addBOS = *opts.AddBOS
+	}
+	if opts.ParseSpecial != nil {
+		parseSpecial = *opts.ParseSpecial
+	}
+	return x.tokenizeSpecial(ctx, text, &addBOS, &parseSpecial)
+}
+
+// Renders the generated tokens with special tokens included, the content
+// field always leaves them out. Cut at the stop string like content is.
+func (x *XpltAI) specialContent(ctx context.Context, jsonData map[string]any) (string, error) {
+	raw, ok := jsonData["tokens"].([]any)
+	if !ok {
+		return "", errors.New("json parsing failure, missing tokens field")
+	}
+
+	tokens := make([]int, 0, len(raw))
+	for _, t := range raw {
+		id, ok := t.(float64)
+		if !ok {
+			return "", errors.New("json parsing failure, invalid tokens field")
+		}
+		tokens = append(tokens, int(id))
+	}
+
+	content, err := x.detokenize(ctx, tokens)
+	if err != nil {
+		return "", err
+	}
+
+	if word, _ := jsonData["stopping_word"].(string); word != "" {
+		if i := strings.Index(content, word); i >= 0 {
+			content = content[:i]
+		}
+	}
+	return content, nil
+}
@@ -0,0 +1,178 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type recordedRequest struct {
+	path string
+	body string
+}
+
+// Server with a toy tokenizer, 1 is BOS and any text is token 42. Records
+// the tokenizer and completion requests in order.
+func tokenizerServer(requests *[]recordedRequest) *httptest.Server {
+	mu := sync.Mutex{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/tokenize", "/detokenize", "/completion":
+			mu.Lock()
+			*requests = append(*requests, recordedRequest{r.URL.Path, string(body)})
+			mu.Unlock()
+		}
+
+		switch r.URL.Path {
+		case "/tokenize":
+			req := struct {
+				Content    string `json:"content"`
+				AddSpecial bool   `json:"add_special"`
+			}{}
+			json.Unmarshal(body, &req)
+			tokens := []int{}
+			if req.AddSpecial {
+				tokens = append(tokens, 1)
+			}
+			if req.Content != "" {
+				tokens = append(tokens, 42)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"tokens": tokens})
+		case "/detokenize":
+			w.Write([]byte(`{"content":" world</s>"}`))
+		case "/completion":
+			w.Write([]byte(`{"content":" world","tokens":[7,2],"stopping_word":"","tokens_evaluated":2,"tokens_predicted":2}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+}
+
+// Fields of the json object body, failing the test when it isn't one.
+func bodyFields(t *testing.T, body string) map[string]any {
+	t.Helper()
+	fields := map[string]any{}
+	err := json.Unmarshal([]byte(body), &fields)
+	if err != nil {
+		t.Fatalf("%s: %v", body, err)
+	}
+	return fields
+}
+
+func TestTokenizeSpecialBodies(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GenOptions
+		want string
+	}{
+		{"defaults", GenOptions{}, `{"content":"Hi"}`},
+		{"add bos", GenOptions{AddBOS: Ptr(true)}, `{"add_special":true,"content":"Hi"}`},
+		{"no bos", GenOptions{AddBOS: Ptr(false)}, `{"add_special":false,"content":"Hi"}`},
+		{"no special", GenOptions{ParseSpecial: Ptr(false)}, `{"content":"Hi","parse_special":false}`},
+		{"both", GenOptions{AddBOS: Ptr(true), ParseSpecial: Ptr(true)}, `{"add_special":true,"content":"Hi","parse_special":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := []recordedRequest{}
+			srv := tokenizerServer(&requests)
+			defer srv.Close()
+			x := newTestHandle(t, srv, realClock{})
+
+			_, err := x.Tokenize("Hi", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []recordedRequest{{"/tokenize", tt.want}}
+			if !reflect.DeepEqual(requests, want) {
+				t.Errorf("sent %v, want %v", requests, want)
+			}
+		})
+	}
+}
+
+func TestCompleteSpecialBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []int
+		opts   GenOptions
+		// Tokenize request sent first, "" for none
+		tokenize string
+		prompt   any
+	}{
+		{"text", nil, GenOptions{}, "", "Hi"},
+		{"text without bos", nil, GenOptions{AddBOS: Ptr(false)}, `{"add_special":false,"content":"Hi","parse_special":true}`, []any{42.0}},
+		{"text without special", nil, GenOptions{ParseSpecial: Ptr(false)}, `{"add_special":true,"content":"Hi","parse_special":false}`, []any{1.0, 42.0}},
+		{"tokens", []int{42}, GenOptions{}, "", []any{42.0}},
+		{"tokens with bos", []int{42}, GenOptions{AddBOS: Ptr(true)}, `{"add_special":true,"content":""}`, []any{1.0, 42.0}},
+		{"tokens starting with bos", []int{1, 42}, GenOptions{AddBOS: Ptr(true)}, `{"add_special":true,"content":""}`, []any{1.0, 42.0}},
+		{"tokens ignore parse special", []int{42}, GenOptions{ParseSpecial: Ptr(false)}, "", []any{42.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := []recordedRequest{}
+			srv := tokenizerServer(&requests)
+			defer srv.Close()
+			x := newTestHandle(t, srv, realClock{})
+
+			var err error
+			if tt.tokens == nil {
+				_, err = x.CompleteDetailed("Hi", tt.opts)
+			} else {
+				_, err = x.CompleteTokens(tt.tokens, tt.opts)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := 1
+			if tt.tokenize != "" {
+				want = 2
+				if requests[0] != (recordedRequest{"/tokenize", tt.tokenize}) {
+					t.Errorf("tokenized with %v, want %s", requests[0], tt.tokenize)
+				}
+			}
+			if len(requests) != want || requests[want-1].path != "/completion" {
+				t.Fatalf("sent %v, want %d requests ending with the completion", requests, want)
+			}
+
+			fields := bodyFields(t, requests[want-1].body)
+			if !reflect.DeepEqual(fields["prompt"], tt.prompt) {
+				t.Errorf("sent prompt %v, want %v", fields["prompt"], tt.prompt)
+			}
+			for _, key := range []string{"add_special", "parse_special", "return_tokens"} {
+				if _, ok := fields[key]; ok {
+					t.Errorf("completion body %s holds %s", requests[want-1].body, key)
+				}
+			}
+		})
+	}
+}
+
+func TestReturnSpecialBodies(t *testing.T) {
+	requests := []recordedRequest{}
+	srv := tokenizerServer(&requests)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	resp, err := x.CompleteDetailed("Hi", GenOptions{ReturnSpecial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].path != "/completion" {
+		t.Fatalf("sent %v, want the completion then its detokenization", requests)
+	}
+	if fields := bodyFields(t, requests[0].body); fields["return_tokens"] != true || fields["prompt"] != "Hi" {
+		t.Errorf("sent %s, want the text prompt with return_tokens", requests[0].body)
+	}
+	if requests[1] != (recordedRequest{"/detokenize", `{"tokens":[7,2]}`}) {
+		t.Errorf("detokenized with %v", requests[1])
+	}
+	if resp.RawContent != "world</s>" {
+		t.Errorf("got content %q, want the special tokens kept", resp.RawContent)
+	}
+}
@@ -0,0 +1,103 @@
+package xplatai
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	STAGE_LOAD = "load model"
+	// Only run when Config.Warmup is set
+	STAGE_WARMUP = "warm up"
+)
+
+// How long one startup stage took, Err is set on the stage that failed.
+type PhaseTiming struct {
+	Phase    string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+type startupRecorder struct {
+	mu      sync.Mutex
+	timings []PhaseTiming
+	// Start of the stage in progress
+	current time.Time
+	loaded  bool
+}
+
+// Ends the stage in progress and starts the next one.
+func (r *startupRecorder) end(phase string, err error) PhaseTiming {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// time.Since uses the monotonic clock reading of current
+	pt := PhaseTiming{Phase: phase, Start: r.current, Duration: time.Since(r.current), Err: err}
+	r.timings = append(r.timings, pt)
+	r.current = time.Now()
+	return pt
+}
+
+// Startup stages of the handle in the order they completed: the
+// NewWithConfig stages, then STAGE_LOAD once the server first answered.
+// With Config.Warmup, NewWithConfig waits for STAGE_LOAD and STAGE_WARMUP.
+func (x *XpltAI) StartupTimings() []PhaseTiming {
+	x.startup.mu.Lock()
+	defer x.startup.mu.Unlock()
+	return slices.Clone(x.startup.timings)
+}
+
+func emitPhase(onLifecycle func(LifecycleEvent), pt PhaseTiming) {
+	if onLifecycle == nil {
+		return
+	}
+	detail := pt.Phase + " " + pt.Duration.String()
+	if pt.Err != nil {
+		detail += ": " + pt.Err.Error()
+	}
+	onLifecycle(LifecycleEvent{Type: EVENT_PHASE, Time: time.Now(), Detail: detail, Phase: &pt})
+}
+
+// Records the model load stage the first time the spawned server answers, and
+// every failed wait before that.
+func (x *XpltAI) recordLoad(err error) {
+	if x.proc == nil && x.adoptedPid == 0 {
+		return
+	}
+
+	x.startup.mu.Lock()
+	loaded := x.startup.loaded
+	if !loaded {
+		x.startup.loaded = err == nil
+	}
+	x.startup.mu.Unlock()
+
+	if !loaded {
+		emitPhase(x.onLifecycle, x.startup.end(STAGE_LOAD, err))
+	}
+}
+
+// Waits for the spawned server to load its model, then generates a token.
+// Ends STAGE_LOAD itself, see Config.Warmup.
+func (x *XpltAI) warmup(wait time.Duration, nextStage func(string)) error {
+	x.startup.mu.Lock()
+	x.startup.loaded = true
+	x.startup.mu.Unlock()
+
+	_, err := x.waitHealthy(x.life, wait)
+	if err != nil {
+		return err
+	}
+	x.isConn.Store(true)
+	x.emit(EVENT_READY, "")
+
+	nextStage(STAGE_WARMUP)
+	data := map[string]any{
+		"prompt":       "Hello",
+		"n_predict":    1,
+		"cache_prompt": false,
+	}
+	return x.doJSON(x.life, "POST", "/completion", data, nil)
+}
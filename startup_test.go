@@ -0,0 +1,140 @@
+package xplatai
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Config of a fake server answering through srv.
+func serverConfig(t *testing.T, srv *httptest.Server, clk *fakeClock, procs *fakeProcs) Config {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := fakeConfig(clk, procs)
+	cfg.Port = port
+	cfg.LoopbackHost = host
+	return cfg
+}
+
+// Server answering health checks once ready is set, and completions with
+// completionStatus.
+func warmupServer(ready *atomic.Bool, completionStatus int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if !ready.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/completion":
+			w.WriteHeader(completionStatus)
+			w.Write([]byte(`{"content":"!"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestWarmupRecordsEveryStage(t *testing.T) {
+	fakeInstall(t)
+	ready := atomic.Bool{}
+	ready.Store(true)
+	srv := warmupServer(&ready, http.StatusOK)
+	defer srv.Close()
+
+	procs := &fakeProcs{}
+	cfg := serverConfig(t, srv, newFakeClock(), procs)
+	cfg.Warmup = time.Minute
+	events := []string{}
+	cfg.OnLifecycle = func(ev LifecycleEvent) {
+		if ev.Type == EVENT_PHASE {
+			events = append(events, ev.Phase.Phase)
+		}
+	}
+
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	want := []string{STAGE_RESOLVE, STAGE_LOCATE, STAGE_MODEL, STAGE_SPAWN, STAGE_LOAD, STAGE_WARMUP}
+	phases := []string{}
+	for _, pt := range x.StartupTimings() {
+		phases = append(phases, pt.Phase)
+		if pt.Err != nil || pt.Duration < 0 {
+			t.Errorf("stage %+v failed", pt)
+		}
+	}
+	if !slices.Equal(phases, want) || !slices.Equal(events, want) {
+		t.Errorf("got timings %v and events %v, want %v", phases, events, want)
+	}
+}
+
+func TestWarmupFailuresKillServer(t *testing.T) {
+	tests := []struct {
+		name             string
+		ready            bool
+		completionStatus int
+		stage            string
+	}{
+		{"load timeout", false, http.StatusOK, STAGE_LOAD},
+		{"generation failure", true, http.StatusInternalServerError, STAGE_WARMUP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeInstall(t)
+			ready := atomic.Bool{}
+			ready.Store(tt.ready)
+			srv := warmupServer(&ready, tt.completionStatus)
+			defer srv.Close()
+
+			clk := newFakeClock()
+			procs := &fakeProcs{}
+			cfg := serverConfig(t, srv, clk, procs)
+			cfg.Warmup = 30 * time.Second
+
+			var x *XpltAI
+			var err error
+			clk.runUntil(t, func() {
+				x, err = NewWithConfig(cfg)
+			})
+			stageErr := &StageError{}
+			if x != nil || !errors.As(err, &stageErr) || stageErr.Stage != tt.stage {
+				t.Fatalf("got %v, want a failure at stage %q", err, tt.stage)
+			}
+			if len(procs.procs) != 1 || procs.running() != 0 {
+				t.Errorf("%d of %d servers left running", procs.running(), len(procs.procs))
+			}
+		})
+	}
+}
+
+func TestMissingLocalModelFailsBeforeSpawn(t *testing.T) {
+	fakeInstall(t)
+	procs := &fakeProcs{}
+	cfg := fakeConfig(newFakeClock(), procs)
+	cfg.HFModel = "missing.gguf"
+
+	_, err := NewWithConfig(cfg)
+	stageErr := &StageError{}
+	if !errors.As(err, &stageErr) || stageErr.Stage != STAGE_MODEL || ClassifyError(err) != FAILURE_MODEL_NOT_DOWNLOADED {
+		t.Fatalf("got %v, want a model cache failure", err)
+	}
+	if len(procs.procs) != 0 {
+		t.Error("server spawned without its model")
+	}
+}
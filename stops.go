@@ -0,0 +1,122 @@
+package xplatai
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Stops used when neither the model name nor its chat template is recognized.
+var genericStops = []string{"<|"}
+
+type stopFamily struct {
+	name string
+	// Substrings of the lowercased model reference
+	nameHints []string
+	// Markers found in the GGUF chat template
+	templateHints []string
+	stops         []string
+}
+
+var stopFamilies = []stopFamily{
+	{
+		name:          "pygmalion",
+		nameHints:     []string{"pygmalion", "metharme"},
+		templateHints: []string{"<|model|>"},
+		stops:         []string{"<|user|>", "<|model|>", "<|system|>", "\nYou:", "\nUser:"},
+	},
+	{
+		name:          "llama-3",
+		nameHints:     []string{"llama-3", "llama3"},
+		templateHints: []string{"<|start_header_id|>"},
+		stops:         []string{"<|eot_id|>", "<|start_header_id|>", "<|end_of_text|>"},
+	},
+	{
+		name:          "chatml",
+		nameHints:     []string{"chatml", "qwen", "hermes", "dolphin"},
+		templateHints: []string{"<|im_start|>"},
+		stops:         []string{"<|im_end|>", "<|im_start|>"},
+	},
+	{
+		name:          "alpaca",
+		nameHints:     []string{"alpaca"},
+		templateHints: []string{"### Instruction"},
+		stops:         []string{"### Instruction:", "### Response:", "\n### "},
+	},
+}
+
+// Default stop strings for a model reference, based on its name.
+func DefaultStopsFor(model string) []string {
+	stops, ok := stopsForName(model)
+	if !ok {
+		return slices.Clone(genericStops)
+	}
+	return stops
+}
+
+func stopsForName(model string) ([]string, bool) {
+	model = strings.ToLower(model)
+
+	for _, family := range stopFamilies {
+		for _, hint := range family.nameHints {
+			if strings.Contains(model, hint) {
+				return slices.Clone(family.stops), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func stopsForTemplate(template string) ([]string, bool) {
+	for _, family := range stopFamilies {
+		for _, hint := range family.templateHints {
+			if strings.Contains(template, hint) {
+				return slices.Clone(family.stops), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Stops for the handle's model, the chat template is only fetched when the
+// model name isn't recognized.
+func (x *XpltAI) defaultStops(ctx context.Context) []string {
+	x.stopsMut.Lock()
+	defer x.stopsMut.Unlock()
+
+	if x.stops != nil {
+		return x.stops
+	}
+
+	stops, ok := stopsForName(x.model)
+	if !ok {
+		props := struct {
+			ChatTemplate string `json:"chat_template"`
+		}{}
+		if x.doJSON(ctx, "GET", "/props", nil, &props) != nil {
+			// Don't cache, the server may just not be up yet
+			return genericStops
+		}
+
+		stops, ok = stopsForTemplate(props.ChatTemplate)
+		if !ok {
+			stops = genericStops
+		}
+	}
+
+	x.stops = stops
+	return stops
+}
+
+// Resolves request options against the captured settings and fills the
+// model specific defaults.
+func (x *XpltAI) resolveOptions(ctx context.Context, opts GenOptions) (GenOptions, error) {
+	opts, err := resolveGenOptions(x.settingsFrom(ctx).genOptions, opts)
+	if err != nil {
+		return opts, err
+	}
+	if opts.Stop == nil {
+		opts.Stop = x.defaultStops(ctx)
+	}
+	return pinDeterministic(opts), nil
+}
@@ -0,0 +1,91 @@
+package xplatai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDefaultStopsFor(t *testing.T) {
+	tests := []struct {
+		model string
+		want  []string
+	}{
+		{"PygmalionAI/pygmalion-2-7b-GGUF:Q4_K_M", []string{"<|user|>", "<|model|>", "<|system|>", "\nYou:", "\nUser:"}},
+		{"TheBloke/Metharme-13B-GGUF", []string{"<|user|>", "<|model|>", "<|system|>", "\nYou:", "\nUser:"}},
+		{"bartowski/Meta-Llama-3-8B-Instruct-GGUF:Q4_K_M", []string{"<|eot_id|>", "<|start_header_id|>", "<|end_of_text|>"}},
+		{"models/llama3-8b.gguf", []string{"<|eot_id|>", "<|start_header_id|>", "<|end_of_text|>"}},
+		{"Qwen/Qwen2.5-7B-Instruct-GGUF", []string{"<|im_end|>", "<|im_start|>"}},
+		{"NousResearch/Hermes-2-Pro-Mistral-7B-GGUF", []string{"<|im_end|>", "<|im_start|>"}},
+		{"cognitivecomputations/dolphin-2.9-GGUF", []string{"<|im_end|>", "<|im_start|>"}},
+		{"someone/alpaca-7b-GGUF", []string{"### Instruction:", "### Response:", "\n### "}},
+		{"unknown/model-GGUF", []string{"<|"}},
+		{"", []string{"<|"}},
+	}
+	for _, tt := range tests {
+		got := DefaultStopsFor(tt.model)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("DefaultStopsFor(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultStopsForReturnsCopies(t *testing.T) {
+	stops := DefaultStopsFor("qwen")
+	stops[0] = "changed"
+	if DefaultStopsFor("qwen")[0] != "<|im_end|>" {
+		t.Error("table modified through a returned slice")
+	}
+	generic := DefaultStopsFor("unknown")
+	generic[0] = "changed"
+	if DefaultStopsFor("unknown")[0] != "<|" {
+		t.Error("generic stops modified through a returned slice")
+	}
+}
+
+func TestStopsForTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{"{{ '<|im_start|>' + message['role'] }}", []string{"<|im_end|>", "<|im_start|>"}},
+		{"{{ '<|start_header_id|>' + message['role'] + '<|end_header_id|>' }}", []string{"<|eot_id|>", "<|start_header_id|>", "<|end_of_text|>"}},
+		{"{{ '<|model|>' + content }}", []string{"<|user|>", "<|model|>", "<|system|>", "\nYou:", "\nUser:"}},
+		{"### Instruction:\n{{ content }}", []string{"### Instruction:", "### Response:", "\n### "}},
+	}
+	for _, tt := range tests {
+		got, ok := stopsForTemplate(tt.template)
+		if !ok || !slices.Equal(got, tt.want) {
+			t.Errorf("stopsForTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+	if _, ok := stopsForTemplate("{{ content }}"); ok {
+		t.Error("unknown template matched a family")
+	}
+}
+
+// Unknown model names fall back on the chat template, fetched once.
+func TestDefaultStopsFromTemplate(t *testing.T) {
+	props := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/props" {
+			props.Add(1)
+		}
+		w.Write([]byte(`{"chat_template":"{{ '<|im_start|>' }}"}`))
+	}))
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+	x.model = "custom/finetune-GGUF"
+
+	for range 2 {
+		stops := x.defaultStops(t.Context())
+		if !slices.Equal(stops, []string{"<|im_end|>", "<|im_start|>"}) {
+			t.Errorf("got stops %q", stops)
+		}
+	}
+	if props.Load() != 1 {
+		t.Errorf("template fetched %d times, want once", props.Load())
+	}
+}
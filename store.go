@@ -0,0 +1,41 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+)
+
+// Persists the turns of sessions, see the store/sqlite package.
+type TurnStore interface {
+	// Appends messages to the history of the session, in order
+	AppendTurns(ctx context.Context, sessionId string, messages []Message) error
+}
+
+// Records every turn Send completes in store under sessionId, nil stops
+// recording. Turns are recorded after the history changed, a failing store
+// returns its error along with the reply.
+func (s *Session) SetStore(store TurnStore, sessionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	s.storeId = sessionId
+}
+
+// Records and remembers an exchange Send completed.
+func (s *Session) afterTurn(ctx context.Context, user string, resp ChatResponse) error {
+	s.mu.Lock()
+	store, id, memory := s.store, s.storeId, s.memory
+	s.mu.Unlock()
+
+	errs := []error{}
+	if store != nil {
+		errs = append(errs, store.AppendTurns(ctx, id, []Message{
+			{Role: "user", Content: user},
+			{Role: "assistant", Content: resp.Content},
+		}))
+	}
+	if memory != nil {
+		errs = append(errs, memory.RememberContext(ctx, exchangeText(user, resp.Content)))
+	}
+	return errors.Join(errs...)
+}
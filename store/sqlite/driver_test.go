@@ -0,0 +1,9 @@
+//go:build cgo && sqlite_fts5
+
+package sqlite
+
+import _ "github.com/mattn/go-sqlite3"
+
+func init() {
+	testDriver = "sqlite3"
+}
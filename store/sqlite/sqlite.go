@@ -0,0 +1,220 @@
+// Package sqlite records the turns of sessions in a SQLite database, see
+// xplatai.Session.SetStore. It works with any database/sql SQLite driver, the
+// caller opens the database and keeps ownership of it.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	xplatai "github.com/wAIfu-DEV/Xplat-AI"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS xplatai_turns (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id   TEXT    NOT NULL,
+	role         TEXT    NOT NULL,
+	content      TEXT    NOT NULL,
+	tool_calls   TEXT,
+	tool_call_id TEXT,
+	created_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS xplatai_turns_session ON xplatai_turns (session_id, id);
+`
+
+// Full-text index kept in sync with the turns, only created when the SQLite
+// build has FTS5.
+const ftsSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS xplatai_turns_fts USING fts5(
+	content, content='xplatai_turns', content_rowid='id'
+);
+CREATE TRIGGER IF NOT EXISTS xplatai_turns_ai AFTER INSERT ON xplatai_turns BEGIN
+	INSERT INTO xplatai_turns_fts (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS xplatai_turns_ad AFTER DELETE ON xplatai_turns BEGIN
+	INSERT INTO xplatai_turns_fts (xplatai_turns_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+`
+
+type Store struct {
+	db *sql.DB
+	// Whether Search uses the FTS5 index rather than LIKE
+	fts bool
+}
+
+// One recorded message.
+type Turn struct {
+	Id        int64
+	SessionId string
+	Message   xplatai.Message
+	Time      time.Time
+}
+
+// Creates the tables the store needs in db when missing. Search falls back to
+// substring matching when the SQLite build lacks FTS5.
+func New(db *sql.DB) (*Store, error) {
+	return NewContext(context.Background(), db)
+}
+
+func NewContext(ctx context.Context, db *sql.DB) (*Store, error) {
+	_, err := db.ExecContext(ctx, schema)
+	if err != nil {
+		return nil, fmt.Errorf("creating tables: %w", err)
+	}
+
+	s := &Store{db: db}
+	hadFts := false
+	err = db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'xplatai_turns_fts')`).Scan(&hadFts)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.ExecContext(ctx, ftsSchema)
+	s.fts = err == nil
+
+	// Turns recorded without FTS5 are indexed once it is available
+	if s.fts && !hadFts {
+		_, err = db.ExecContext(ctx, `INSERT INTO xplatai_turns_fts (xplatai_turns_fts) VALUES ('rebuild')`)
+		if err != nil {
+			return nil, fmt.Errorf("indexing turns: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Appends messages to the history of the session, timestamped now.
+func (s *Store) AppendTurns(ctx context.Context, sessionId string, messages []xplatai.Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	for _, m := range messages {
+		var toolCalls, toolCallId sql.NullString
+		if len(m.ToolCalls) > 0 {
+			b, err := json.Marshal(m.ToolCalls)
+			if err != nil {
+				return err
+			}
+			toolCalls = sql.NullString{String: string(b), Valid: true}
+		}
+		if m.ToolCallId != "" {
+			toolCallId = sql.NullString{String: m.ToolCallId, Valid: true}
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO xplatai_turns (session_id, role, content, tool_calls, tool_call_id, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			sessionId, m.Role, m.Content, toolCalls, toolCallId, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Last n turns of the session, oldest first.
+func (s *Store) Last(ctx context.Context, sessionId string, n int) ([]Turn, error) {
+	turns, err := s.query(ctx,
+		`SELECT id, session_id, role, content, tool_calls, tool_call_id, created_at FROM xplatai_turns
+		WHERE session_id = ? ORDER BY id DESC LIMIT ?`,
+		sessionId, n)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(turns)-1; i < j; i, j = i+1, j-1 {
+		turns[i], turns[j] = turns[j], turns[i]
+	}
+	return turns, nil
+}
+
+// Turns containing every word of query, best matches first with FTS5 and
+// newest first otherwise. An empty sessionId searches all sessions, limit <= 0
+// returns every match.
+func (s *Store) Search(ctx context.Context, sessionId string, query string, limit int) ([]Turn, error) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil, errors.New("search query is empty")
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+
+	if s.fts {
+		// Quoted, words are matched as text rather than FTS5 query syntax
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+		}
+		return s.query(ctx,
+			`SELECT t.id, t.session_id, t.role, t.content, t.tool_calls, t.tool_call_id, t.created_at
+			FROM xplatai_turns_fts f JOIN xplatai_turns t ON t.id = f.rowid
+			WHERE xplatai_turns_fts MATCH ? AND (? = '' OR t.session_id = ?)
+			ORDER BY f.rank LIMIT ?`,
+			strings.Join(quoted, " "), sessionId, sessionId, limit)
+	}
+
+	where := []string{"(? = '' OR session_id = ?)"}
+	args := []any{sessionId, sessionId}
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for _, w := range words {
+		where = append(where, `content LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaper.Replace(w)+"%")
+	}
+	args = append(args, limit)
+	return s.query(ctx,
+		`SELECT id, session_id, role, content, tool_calls, tool_call_id, created_at FROM xplatai_turns
+		WHERE `+strings.Join(where, " AND ")+` ORDER BY id DESC LIMIT ?`,
+		args...)
+}
+
+// Deletes the turns of the session.
+func (s *Store) DeleteSession(ctx context.Context, sessionId string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM xplatai_turns WHERE session_id = ?`, sessionId)
+	return err
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]Turn, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	turns := []Turn{}
+	for rows.Next() {
+		t := Turn{}
+		var toolCalls, toolCallId sql.NullString
+		var createdAt int64
+		err := rows.Scan(&t.Id, &t.SessionId, &t.Message.Role, &t.Message.Content, &toolCalls, &toolCallId, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if toolCalls.Valid {
+			err := json.Unmarshal([]byte(toolCalls.String), &t.Message.ToolCalls)
+			if err != nil {
+				return nil, fmt.Errorf("json parsing failure, %w", err)
+			}
+		}
+		t.Message.ToolCallId = toolCallId.String
+		t.Time = time.UnixMilli(createdAt)
+		turns = append(turns, t)
+	}
+	return turns, rows.Err()
+}
+
+// Messages of turns, to restore a history.
+func Messages(turns []Turn) []xplatai.Message {
+	msgs := make([]xplatai.Message, len(turns))
+	for i, t := range turns {
+		msgs[i] = t.Message
+	}
+	return msgs
+}
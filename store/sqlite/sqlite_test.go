@@ -0,0 +1,174 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	xplatai "github.com/wAIfu-DEV/Xplat-AI"
+)
+
+// Driver the tests open databases with, registered by driver_test.go when
+// built with the sqlite_fts5 tag.
+var testDriver string
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	if testDriver == "" {
+		t.Skip("no SQLite driver, run with -tags sqlite_fts5")
+	}
+	db, err := sql.Open(testDriver, filepath.Join(t.TempDir(), "turns.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appendContents(t *testing.T, s *Store, sessionId string, contents ...string) {
+	t.Helper()
+	msgs := []xplatai.Message{}
+	for _, c := range contents {
+		msgs = append(msgs, xplatai.Message{Role: "user", Content: c})
+	}
+	err := s.AppendTurns(context.Background(), sessionId, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func contentsOf(turns []Turn) []string {
+	contents := []string{}
+	for _, t := range turns {
+		contents = append(contents, t.Message.Content)
+	}
+	return contents
+}
+
+func TestLastReturnsOldestFirst(t *testing.T) {
+	s, err := New(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendContents(t, s, "a", "one", "two", "three", "four")
+	appendContents(t, s, "b", "other")
+
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{2, []string{"three", "four"}},
+		{3, []string{"two", "three", "four"}},
+		{10, []string{"one", "two", "three", "four"}},
+	}
+	for _, tt := range tests {
+		turns, err := s.Last(context.Background(), "a", tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := contentsOf(turns); !slices.Equal(got, tt.want) {
+			t.Errorf("Last(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSearchEscapesLikePatterns(t *testing.T) {
+	s, err := New(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As on SQLite builds without FTS5
+	s.fts = false
+	appendContents(t, s, "a", "100% sure", "1000 sure", "snake_case", "snakeXcase", `back\slash`, "backslash")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"100%", []string{"100% sure"}},
+		{"snake_case", []string{"snake_case"}},
+		{`back\slash`, []string{`back\slash`}},
+		{"sure 100", []string{"1000 sure", "100% sure"}},
+		{"%", []string{"100% sure"}},
+	}
+	for _, tt := range tests {
+		turns, err := s.Search(context.Background(), "", tt.query, 0)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		if got := contentsOf(turns); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchQuotesFtsSyntax(t *testing.T) {
+	s, err := New(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.fts {
+		t.Skip("SQLite build without FTS5")
+	}
+	appendContents(t, s, "a", `she said "not now"`, "content: none", "prefix match", "OR else")
+	appendContents(t, s, "b", "not mine")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`"not`, []string{`she said "not now"`}},
+		{"NOT", []string{`she said "not now"`}},
+		{"content:", []string{"content: none"}},
+		{"pre*", []string{}},
+		{"OR", []string{"OR else"}},
+	}
+	for _, tt := range tests {
+		turns, err := s.Search(context.Background(), "a", tt.query, 0)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		if got := contentsOf(turns); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFtsIndexesTurnsRecordedWithout(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	_, err := db.ExecContext(ctx, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendContents(t, &Store{db: db}, "a", "recorded before the index")
+
+	s, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.fts {
+		t.Skip("SQLite build without FTS5")
+	}
+	appendContents(t, s, "a", "recorded after the index")
+
+	turns, err := s.Search(ctx, "a", "recorded", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(turns) != 2 {
+		t.Errorf("got %q, want both turns", contentsOf(turns))
+	}
+
+	// Opening again leaves the index as is
+	s, err = New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turns, err = s.Search(ctx, "a", "before", 0)
+	if err != nil || len(turns) != 1 {
+		t.Errorf("got %q and %v after reopening, want one turn", contentsOf(turns), err)
+	}
+}
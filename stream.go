@@ -0,0 +1,291 @@
+package xplatai
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+)
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Streams the reply, calling onToken with each piece of text as it is
+// generated. The returned response holds the assembled reply.
+func (x *XpltAI) ChatDetailedStream(messages []Message, opts GenOptions, onToken func(token string)) (ChatResponse, error) {
+	if onToken == nil {
+		onToken = func(string) {}
+	}
+	return x.doChat(context.Background(), chatRequest{messages: messages, opts: opts, onDelta: onToken})
+}
+
+// ChatDetailedStream with a context, see WithInterrupt to stop it on Ctrl+C.
+func (x *XpltAI) ChatDetailedStreamContext(ctx context.Context, messages []Message, opts GenOptions, onToken func(token string)) (ChatResponse, error) {
+	if onToken == nil {
+		onToken = func(string) {}
+	}
+	return x.doChat(ctx, chatRequest{messages: messages, opts: opts, onDelta: onToken})
+}
+
+// Streams the reply over a channel, closed once generation ended. The error
+// channel then receives the error the request failed with, if any, and is
+// closed too. Keep reading tokens until the channel closes, generation waits
+// for the reader.
+func (x *XpltAI) ChatStream(messages []Message, maxTokens int) (<-chan string, <-chan error) {
+	return x.ChatStreamContext(context.Background(), messages, maxTokens)
+}
+
+// ChatStream that stops generating when ctx is done, the error channel then
+// receives the context's error.
+func (x *XpltAI) ChatStreamContext(ctx context.Context, messages []Message, maxTokens int) (<-chan string, <-chan error) {
+	return x.ChatStreamWithOptionsContext(ctx, messages, GenOptions{MaxTokens: maxTokens})
+}
+
+func (x *XpltAI) ChatStreamWithOptions(messages []Message, opts GenOptions) (<-chan string, <-chan error) {
+	return x.ChatStreamWithOptionsContext(context.Background(), messages, opts)
+}
+
+func (x *XpltAI) ChatStreamWithOptionsContext(ctx context.Context, messages []Message, opts GenOptions) (<-chan string, <-chan error) {
+	tokens := make(chan string, 64)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(tokens)
+
+		_, err := x.doChat(ctx, chatRequest{messages: messages, opts: opts, onDelta: func(token string) {
+			select {
+			case tokens <- token:
+			case <-ctx.Done():
+			}
+		}})
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return tokens, errs
+}
+
+// Continues a partial assistant reply, e.g. one recovered with RecoverJournal.
+// The returned content includes partial.
+func (x *XpltAI) ContinueChat(messages []Message, partial string, opts GenOptions) (ChatResponse, error) {
+	return x.doChat(context.Background(), chatRequest{messages: messages, opts: opts, prefill: partial})
+}
+
+func (x *XpltAI) chatStreamOnce(ctx context.Context, messages []Message, opts GenOptions, onDelta func(string)) (ChatResponse, error) {
+	result := ChatResponse{}
+
+	data := map[string]any{
+		"messages": messages,
+		"stream":   true,
+		// Timings on every chunk keep LastTimings current
+		"timings_per_token": true,
+	}
+	opts.apply(data, _ENDPOINT_OPENAI)
+
+	text := strings.Builder{}
+	assembler := utf8Assembler{}
+	toolCalls := []ToolCall{}
+
+	// Replies past the first when opts.N asked for several, by index
+	others := map[int]*streamedChoice{}
+
+	err := x.doStream(ctx, "/v1/chat/completions", data, func(payload []byte) error {
+		chunk := struct {
+			Choices []struct {
+				Index int `json:"index"`
+				Delta struct {
+					Content   json.RawMessage `json:"content"`
+					ToolCalls []toolCallDelta `json:"tool_calls"`
+				} `json:"delta"`
+				Logprobs     *choiceLogprobs `json:"logprobs"`
+				FinishReason *string         `json:"finish_reason"`
+			} `json:"choices"`
+			Timings *struct {
+				ServerTimings
+				CacheN int `json:"cache_n"`
+			} `json:"timings"`
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}{}
+
+		err := json.Unmarshal(payload, &chunk)
+		if err != nil {
+			return err
+		}
+
+		if chunk.Timings != nil {
+			result.CachedTokens = chunk.Timings.CacheN
+			result.ServerTimings = chunk.Timings.ServerTimings
+			x.recordTimings(chunk.Timings.ServerTimings)
+		}
+		if chunk.Usage != nil {
+			result.PromptTokens = chunk.Usage.PromptTokens
+			result.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index > 0 {
+				other := others[choice.Index]
+				if other == nil {
+					other = &streamedChoice{}
+					others[choice.Index] = other
+				}
+				if choice.Logprobs != nil {
+					other.logprobs = append(other.logprobs, choice.Logprobs.Content...)
+				}
+				delta, err := other.assembler.push(choice.Delta.Content)
+				if err != nil {
+					return errors.New("json parsing failure, invalid content field")
+				}
+				if int64(other.text.Len()+len(delta)) > x.responseLimit() {
+					return ErrResponseTooLarge
+				}
+				other.text.WriteString(delta)
+				other.toolCalls = appendToolCallDeltas(other.toolCalls, choice.Delta.ToolCalls)
+				if choice.FinishReason != nil {
+					other.finishReason = *choice.FinishReason
+				}
+				continue
+			}
+
+			if choice.Logprobs != nil {
+				result.Logprobs = append(result.Logprobs, choice.Logprobs.Content...)
+			}
+			delta, err := assembler.push(choice.Delta.Content)
+			if err != nil {
+				return errors.New("json parsing failure, invalid content field")
+			}
+			if delta != "" {
+				if int64(text.Len()+len(delta)) > x.responseLimit() {
+					return ErrResponseTooLarge
+				}
+				text.WriteString(delta)
+				onDelta(delta)
+			}
+			toolCalls = appendToolCallDeltas(toolCalls, choice.Delta.ToolCalls)
+			if choice.FinishReason != nil {
+				result.FinishReason = *choice.FinishReason
+			}
+		}
+		return nil
+	})
+
+	if rest := assembler.flush(); rest != "" {
+		text.WriteString(rest)
+		onDelta(rest)
+	}
+	result.Content = text.String()
+
+	// Streams cut short never get to report usage, generation still ran
+	if result.CompletionTokens == 0 && err != nil && result.Content != "" {
+		tokens, _ := x.tokenize(context.WithoutCancel(ctx), result.Content, false)
+		result.CompletionTokens = len(tokens)
+	}
+	recordUsage(ctx, Usage{PromptTokens: result.PromptTokens, CompletionTokens: result.CompletionTokens})
+
+	result.RawMessage = streamedMessage(result.Content, toolCalls)
+
+	// Only the first reply is passed to onDelta, the others are collected
+	if len(others) > 0 {
+		result.Choices = []ChatChoice{{
+			Content:      result.Content,
+			FinishReason: result.FinishReason,
+			RawMessage:   result.RawMessage,
+			ToolCalls:    toolCalls,
+			Logprobs:     result.Logprobs,
+		}}
+		for i := 1; i <= slices.Max(slices.Collect(maps.Keys(others))); i++ {
+			c := ChatChoice{}
+			if other := others[i]; other != nil {
+				other.text.WriteString(other.assembler.flush())
+				c.Content = other.text.String()
+				c.FinishReason = other.finishReason
+				c.Logprobs = other.logprobs
+				c.ToolCalls = other.toolCalls
+			}
+			c.RawMessage = streamedMessage(c.Content, c.ToolCalls)
+			result.Choices = append(result.Choices, c)
+		}
+	}
+	return result, err
+}
+
+type streamedChoice struct {
+	text         strings.Builder
+	assembler    utf8Assembler
+	finishReason string
+	logprobs     []TokenLogprob
+	toolCalls    []ToolCall
+}
+
+// Piece of a tool call, the id and name come once and the arguments are
+// split across chunks.
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	Id       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func appendToolCallDeltas(calls []ToolCall, deltas []toolCallDelta) []ToolCall {
+	for _, d := range deltas {
+		if d.Index < 0 || d.Index > len(calls) {
+			continue
+		}
+		if d.Index == len(calls) {
+			calls = append(calls, ToolCall{})
+		}
+		call := &calls[d.Index]
+		if d.Id != "" {
+			call.Id = d.Id
+		}
+		call.Name += d.Function.Name
+		call.Arguments += d.Function.Arguments
+	}
+	return calls
+}
+
+// Reassembles the message object a non-streaming response would hold.
+func streamedMessage(content string, toolCalls []ToolCall) json.RawMessage {
+	msg, _ := json.Marshal(Message{Role: "assistant", Content: content, ToolCalls: toolCalls})
+	return msg
+}
+
+// Renders messages through the model's chat template for debugging, empty
+// when the server can't.
+func (x *XpltAI) renderPrompt(ctx context.Context, messages []Message, prefill string) string {
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		return ""
+	}
+
+	prompt, _ := x.applyTemplate(ctx, messages, prefill)
+	return prompt
+}
+
+func (x *XpltAI) applyTemplate(ctx context.Context, messages []Message, prefill string) (string, error) {
+	if prefill != "" {
+		messages = withAssistantPrefill(messages, prefill)
+	}
+
+	rendered := struct {
+		Prompt string `json:"prompt"`
+	}{}
+	err := x.doJSON(ctx, "POST", "/apply-template", map[string]any{"messages": messages}, &rendered)
+	return rendered.Prompt, err
+}
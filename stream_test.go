@@ -0,0 +1,111 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Server answering chat completions with the given stream chunks.
+func streamServer(chunks []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func TestStreamAssemblesToolCalls(t *testing.T) {
+	srv := streamServer([]string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":null,"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"index":1,"delta":{"tool_calls":[{"index":0,"id":"call_c","type":"function","function":{"name":"get_time","arguments":"{\"tz\":\"UTC\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"},{"index":1,"delta":{},"finish_reason":"tool_calls"}]}`,
+	})
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	resp, err := x.ChatDetailedStream([]Message{{Role: "user", Content: "weather?"}}, GenOptions{N: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ToolCall{
+		{Id: "call_a", Name: "get_weather", Arguments: `{"city":"Paris"}`},
+		{Id: "call_b", Name: "get_time", Arguments: `{}`},
+	}
+	msg := Message{}
+	err = json.Unmarshal(resp.RawMessage, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Role != "assistant" || !slices.Equal(msg.ToolCalls, want) {
+		t.Errorf("got message %s", resp.RawMessage)
+	}
+
+	if len(resp.Choices) != 2 {
+		t.Fatalf("got %d choices, want 2", len(resp.Choices))
+	}
+	if !slices.Equal(resp.Choices[0].ToolCalls, want) {
+		t.Errorf("got first choice calls %+v", resp.Choices[0].ToolCalls)
+	}
+	second := []ToolCall{{Id: "call_c", Name: "get_time", Arguments: `{"tz":"UTC"}`}}
+	if !slices.Equal(resp.Choices[1].ToolCalls, second) || resp.Choices[1].FinishReason != "tool_calls" {
+		t.Errorf("got second choice %+v", resp.Choices[1])
+	}
+	msg = Message{}
+	json.Unmarshal(resp.Choices[1].RawMessage, &msg)
+	if !slices.Equal(msg.ToolCalls, second) {
+		t.Errorf("got second message %s", resp.Choices[1].RawMessage)
+	}
+}
+
+func TestStreamWithoutToolCallsKeepsMessageShape(t *testing.T) {
+	srv := streamServer([]string{
+		`{"choices":[{"index":0,"delta":{"content":"Hel"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+	})
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	resp, err := x.ChatDetailedStream([]Message{{Role: "user", Content: "hi"}}, GenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.RawMessage) != `{"role":"assistant","content":"Hello"}` {
+		t.Errorf("got message %s", resp.RawMessage)
+	}
+}
+
+func TestStreamErrorBodyIsCapped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"` + strings.Repeat("a", 4096) + `"}}`))
+	}))
+	defer srv.Close()
+
+	x := newTestHandle(t, srv, realClock{})
+	x.maxResponseBytes = 1024
+	_, err := x.ChatDetailedStream([]Message{{Role: "user", Content: "hi"}}, GenOptions{}, func(string) {})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("got %v, want ErrResponseTooLarge", err)
+	}
+}
@@ -0,0 +1,61 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrOptionIgnored = errors.New("options not supported by the server")
+
+// Request fields llama-server accepts without echoing them in its settings.
+var unechoedOptions = []string{"cache_prompt", "tools", "json_schema"}
+
+// With StrictOptions, checks once per handle that the server knows every
+// option the request sets.
+func (x *XpltAI) checkStrictOptions(ctx context.Context, opts GenOptions) error {
+	if !x.strictOptions || x.strictChecked.Load() {
+		return nil
+	}
+
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		return err
+	}
+
+	respData := struct {
+		Settings struct {
+			Params map[string]any `json:"params"`
+		} `json:"default_generation_settings"`
+	}{}
+
+	err = x.doJSON(ctx, "GET", "/props", nil, &respData)
+	if err != nil {
+		return err
+	}
+
+	// Servers too old to report their settings can't be checked
+	if len(respData.Settings.Params) == 0 {
+		x.strictChecked.Store(true)
+		return nil
+	}
+
+	data := map[string]any{}
+	opts.apply(data, _ENDPOINT_NATIVE)
+
+	ignored := []string{}
+	for key := range data {
+		if _, ok := respData.Settings.Params[key]; !ok && !slices.Contains(unechoedOptions, key) {
+			ignored = append(ignored, key)
+		}
+	}
+	x.strictChecked.Store(true)
+
+	if len(ignored) > 0 {
+		slices.Sort(ignored)
+		return fmt.Errorf("%w: %s", ErrOptionIgnored, strings.Join(ignored, ", "))
+	}
+	return nil
+}
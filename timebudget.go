@@ -0,0 +1,105 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Finish reason of replies cut short by GenOptions.TimeBudget.
+const FINISH_TIME_BUDGET = "time_budget"
+
+var errTimeBudget = errors.New("time budget elapsed")
+
+// Context ending once budget elapsed, 0 means no budget.
+func withTimeBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, budget, errTimeBudget)
+}
+
+// Whether ctx ended because of its time budget rather than its parent.
+func timeBudgetElapsed(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errTimeBudget)
+}
+
+// Streams a /completion style request for at most budget, returning the final
+// event with the accumulated content and whether the budget cut it short.
+// onDelta, when set, is called with each piece of content as it arrives.
+func (x *XpltAI) completeStream(ctx context.Context, endpoint string, data map[string]any, budget time.Duration, onDelta func(string)) (map[string]any, bool, error) {
+	ctx, cancel := withTimeBudget(ctx, budget)
+	defer cancel()
+
+	data["stream"] = true
+	data["timings_per_token"] = true
+
+	final := map[string]any{}
+	content := []byte{}
+	tokens := []any{}
+	probs := []any{}
+	assembler := utf8Assembler{}
+
+	err := x.doStream(ctx, endpoint, data, func(payload []byte) error {
+		event := map[string]any{}
+		err := json.Unmarshal(payload, &event)
+		if err != nil {
+			return err
+		}
+
+		raw := struct {
+			Content json.RawMessage `json:"content"`
+		}{}
+		json.Unmarshal(payload, &raw)
+		delta, err := assembler.push(raw.Content)
+		if err != nil {
+			return errors.New("json parsing failure, invalid content field")
+		}
+		if int64(len(content)+len(delta)) > x.responseLimit() {
+			return ErrResponseTooLarge
+		}
+		content = append(content, delta...)
+		if onDelta != nil && len(delta) > 0 {
+			onDelta(delta)
+		}
+		if t, ok := event["tokens"].([]any); ok {
+			tokens = append(tokens, t...)
+		}
+		if p, ok := event["completion_probabilities"].([]any); ok {
+			probs = append(probs, p...)
+		}
+
+		if t, ok := parseTimings(event); ok {
+			x.recordTimings(t)
+		}
+
+		final = event
+		return nil
+	})
+
+	rest := assembler.flush()
+	content = append(content, rest...)
+	if onDelta != nil && len(rest) > 0 {
+		onDelta(rest)
+	}
+	final["content"] = string(content)
+	if len(tokens) > 0 {
+		final["tokens"] = tokens
+	}
+	if len(probs) > 0 {
+		final["completion_probabilities"] = probs
+	}
+
+	usage := completionUsage(final)
+	if usage.CompletionTokens == 0 && len(content) > 0 {
+		tokens, _ := x.tokenize(context.WithoutCancel(ctx), string(content), false)
+		usage.CompletionTokens = len(tokens)
+	}
+	recordUsage(ctx, usage)
+
+	if err != nil && timeBudgetElapsed(ctx) {
+		return final, true, nil
+	}
+	return final, false, err
+}
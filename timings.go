@@ -0,0 +1,47 @@
+package xplatai
+
+import "encoding/json"
+
+// Speed figures llama-server reports for a request, zero when it didn't.
+type ServerTimings struct {
+	// Prompt tokens evaluated, cached ones excluded
+	PromptTokens    int     `json:"prompt_n"`
+	PromptMs        float64 `json:"prompt_ms"`
+	PromptPerSecond float64 `json:"prompt_per_second"`
+	// Tokens generated so far
+	PredictedTokens    int     `json:"predicted_n"`
+	PredictedMs        float64 `json:"predicted_ms"`
+	PredictedPerSecond float64 `json:"predicted_per_second"`
+}
+
+// Reads the timings object of a llama-server response.
+func parseTimings(jsonData map[string]any) (ServerTimings, bool) {
+	raw, ok := jsonData["timings"]
+	if !ok {
+		return ServerTimings{}, false
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return ServerTimings{}, false
+	}
+	t := ServerTimings{}
+	if json.Unmarshal(b, &t) != nil {
+		return ServerTimings{}, false
+	}
+	return t, true
+}
+
+func (x *XpltAI) recordTimings(t ServerTimings) {
+	x.lastTimings.Store(&t)
+}
+
+// Timings of the latest chat or completion request, updated while replies
+// stream for a live tokens per second readout. Zero before any request.
+func (x *XpltAI) LastTimings() ServerTimings {
+	t := x.lastTimings.Load()
+	if t == nil {
+		return ServerTimings{}
+	}
+	return *t
+}
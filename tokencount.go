@@ -0,0 +1,145 @@
+package xplatai
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Message texts whose token counts are kept, the cache restarts when full.
+const tokenCountCacheSize = 4096
+
+type tokenCountCache struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	framing *templateFraming
+}
+
+// Tokens the chat template adds around the messages.
+type templateFraming struct {
+	// BOS and generation prompt, counted once per prompt
+	fixed int
+	// Role header and end of turn, counted once per message
+	perMessage int
+}
+
+// Prompt tokens a chat request with messages would use, chat template
+// included. Counts are cached per message text, so counting a growing or
+// trimmed history only tokenizes new messages. Exact for templates framing
+// every role alike, like ChatML or Llama 3, close for the others.
+func (x *XpltAI) CountTokens(messages []Message) (int, error) {
+	return x.CountTokensContext(context.Background(), messages)
+}
+
+func (x *XpltAI) CountTokensContext(ctx context.Context, messages []Message) (int, error) {
+	framing, err := x.templateFraming(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total := framing.fixed
+	for _, m := range messages {
+		n, err := x.countTextTokens(ctx, messageText(m))
+		if err != nil {
+			return 0, err
+		}
+		total += n + framing.perMessage
+	}
+	return total, nil
+}
+
+// Text of a message the template renders besides its framing.
+func messageText(m Message) string {
+	if len(m.ToolCalls) == 0 {
+		return m.Content
+	}
+	b := strings.Builder{}
+	b.WriteString(m.Content)
+	for _, call := range m.ToolCalls {
+		b.WriteString(call.Name)
+		b.WriteString(call.Arguments)
+	}
+	return b.String()
+}
+
+func (x *XpltAI) countTextTokens(ctx context.Context, text string) (int, error) {
+	if text == "" {
+		return 0, nil
+	}
+
+	x.tokenCounts.mu.Lock()
+	n, ok := x.tokenCounts.counts[text]
+	x.tokenCounts.mu.Unlock()
+	if ok {
+		return n, nil
+	}
+
+	tokens, err := x.tokenize(ctx, text, false)
+	if err != nil {
+		return 0, err
+	}
+
+	x.tokenCounts.mu.Lock()
+	if x.tokenCounts.counts == nil || len(x.tokenCounts.counts) >= tokenCountCacheSize {
+		x.tokenCounts.counts = map[string]int{}
+	}
+	x.tokenCounts.counts[text] = len(tokens)
+	x.tokenCounts.mu.Unlock()
+	return len(tokens), nil
+}
+
+// Measures the template framing once per handle, by rendering a one and a
+// three message conversation.
+func (x *XpltAI) templateFraming(ctx context.Context) (templateFraming, error) {
+	x.tokenCounts.mu.Lock()
+	framing := x.tokenCounts.framing
+	x.tokenCounts.mu.Unlock()
+	if framing != nil {
+		return *framing, nil
+	}
+
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		return templateFraming{}, err
+	}
+
+	user := Message{Role: "user", Content: "x"}
+	reply := Message{Role: "assistant", Content: "y"}
+
+	one, err := x.renderedTokenCount(ctx, []Message{user})
+	if err != nil {
+		return templateFraming{}, err
+	}
+	three, err := x.renderedTokenCount(ctx, []Message{user, reply, user})
+	if err != nil {
+		return templateFraming{}, err
+	}
+	userTokens, err := x.countTextTokens(ctx, user.Content)
+	if err != nil {
+		return templateFraming{}, err
+	}
+	replyTokens, err := x.countTextTokens(ctx, reply.Content)
+	if err != nil {
+		return templateFraming{}, err
+	}
+
+	perMessage := (three - one - userTokens - replyTokens) / 2
+	framing = &templateFraming{
+		fixed:      one - userTokens - perMessage,
+		perMessage: perMessage,
+	}
+
+	x.tokenCounts.mu.Lock()
+	x.tokenCounts.framing = framing
+	x.tokenCounts.mu.Unlock()
+	return *framing, nil
+}
+
+func (x *XpltAI) renderedTokenCount(ctx context.Context, messages []Message) (int, error) {
+	prompt, err := x.applyTemplate(ctx, messages, "")
+	if err != nil {
+		return 0, err
+	}
+	tokens, err := x.tokenize(ctx, prompt, true)
+	return len(tokens), err
+}
@@ -0,0 +1,82 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+var ErrToolExists = errors.New("a tool with this name is already registered")
+
+// Registry of the tools passed to ChatWithTools. The zero value is empty and
+// ready to use.
+type Tools struct {
+	mu    sync.Mutex
+	tools []Tool
+}
+
+// Registers a tool, names must be unique.
+func (t *Tools) Add(tool Tool) error {
+	if tool.Name == "" {
+		return errors.New("tool name is empty")
+	}
+	if tool.Call == nil {
+		return errors.New("tool " + tool.Name + " has no Call function")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, existing := range t.tools {
+		if existing.Name == tool.Name {
+			return fmt.Errorf("%w: %s", ErrToolExists, tool.Name)
+		}
+	}
+	t.tools = append(t.tools, tool)
+	return nil
+}
+
+// Registered tools in registration order, nil on a nil registry.
+func (t *Tools) List() []Tool {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.tools)
+}
+
+// Registers fn as a tool taking its arguments as an A, typically a struct.
+// The parameter schema is generated from A like JSONSchemaFor does, arguments
+// that fail to decode are reported back to the model.
+func RegisterTool[A any](t *Tools, name string, description string, fn func(args A) (string, error)) error {
+	argsType := reflect.TypeFor[A]()
+	for argsType.Kind() == reflect.Pointer {
+		argsType = argsType.Elem()
+	}
+	if argsType.Kind() != reflect.Struct && argsType.Kind() != reflect.Map {
+		return errors.New("tool " + name + " arguments must be a struct or a map")
+	}
+
+	params, err := schemaForType(argsType)
+	if err != nil {
+		return fmt.Errorf("tool %s: %w", name, err)
+	}
+
+	return t.Add(Tool{
+		ToolDefinition: ToolDefinition{Name: name, Description: description, Parameters: params},
+		Call: func(arguments string) (string, error) {
+			var args A
+			if arguments != "" {
+				err := json.Unmarshal([]byte(arguments), &args)
+				if err != nil {
+					return "", fmt.Errorf("invalid arguments: %w", err)
+				}
+			}
+			return fn(args)
+		},
+	})
+}
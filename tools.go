@@ -0,0 +1,185 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrToolRoundLimit = errors.New("tool call round limit reached")
+	ErrToolNotAllowed = errors.New("tool not allowed")
+)
+
+const DEFAULT_MAX_TOOL_ROUNDS = 8
+
+// A function the model may call, Parameters is its json schema.
+type ToolDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type Tool struct {
+	ToolDefinition
+	// Runs the call, the returned text or error is sent back to the model.
+	Call func(arguments string) (string, error)
+}
+
+type ToolCall struct {
+	Id   string
+	Name string
+	// Json encoded arguments as the model wrote them
+	Arguments string
+}
+
+type toolCallJSON struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func (t ToolCall) MarshalJSON() ([]byte, error) {
+	j := toolCallJSON{Id: t.Id, Type: "function"}
+	j.Function.Name = t.Name
+	j.Function.Arguments = t.Arguments
+	return json.Marshal(j)
+}
+
+func (t *ToolCall) UnmarshalJSON(b []byte) error {
+	j := toolCallJSON{}
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	*t = ToolCall{Id: j.Id, Name: j.Function.Name, Arguments: j.Function.Arguments}
+	return nil
+}
+
+// Guards of the tool loop against runaway or injected calls.
+type ToolLoopOptions struct {
+	// Rounds of tool calls per SayWithTools, 0 uses DEFAULT_MAX_TOOL_ROUNDS.
+	MaxRounds int
+
+	// Names of the tools that may run, nil allows every tool passed in.
+	// Enforced whatever the model asks for.
+	Allowed []string
+
+	// Called before each tool runs, may rewrite its arguments. A non-nil error
+	// vetoes it, the model is told the call was rejected.
+	Review func(call ToolCall) (ToolCall, error)
+}
+
+type VetoedToolCall struct {
+	Call   ToolCall
+	Reason string
+}
+
+type ToolResponse struct {
+	ChatResponse
+	// Rounds of tool calls made before the final reply
+	Rounds int
+	// Calls that ran, as reviewed
+	Calls  []ToolCall
+	Vetoed []VetoedToolCall
+}
+
+// Sends a user message, running the tools the model calls and sending their
+// results back until it replies with text. Tool rounds don't stream. The
+// history is only updated when the loop completes.
+func (c *Conversation) SayWithTools(text string, tools []Tool, opts GenOptions, loop ToolLoopOptions) (ToolResponse, error) {
+	return c.SayWithToolsContext(context.Background(), text, tools, opts, loop)
+}
+
+// SayWithTools that stops when ctx is done, tools already run are not undone.
+func (c *Conversation) SayWithToolsContext(ctx context.Context, text string, tools []Tool, opts GenOptions, loop ToolLoopOptions) (ToolResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msgs := append(slices.Clone(c.messages), Message{Role: "user", Content: text})
+
+	result, msgs, err := c.ai.runToolLoop(ctx, c, msgs, tools, opts, loop)
+	if err == nil {
+		c.messages = msgs
+	}
+	return result, err
+}
+
+// Chat that runs the tools the model calls, see Conversation.SayWithTools.
+func (x *XpltAI) ChatWithTools(ctx context.Context, messages []Message, tools *Tools, opts GenOptions, loop ToolLoopOptions) (ToolResponse, error) {
+	result, _, err := x.runToolLoop(ctx, nil, messages, tools.List(), opts, loop)
+	return result, err
+}
+
+// Chats until the model replies with text, returning the history with the
+// tool rounds and final reply appended.
+func (x *XpltAI) runToolLoop(ctx context.Context, key any, msgs []Message, tools []Tool, opts GenOptions, loop ToolLoopOptions) (ToolResponse, []Message, error) {
+	result := ToolResponse{}
+
+	maxRounds := loop.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = DEFAULT_MAX_TOOL_ROUNDS
+	}
+
+	byName := map[string]Tool{}
+	opts.Tools = nil
+	for _, t := range tools {
+		byName[t.Name] = t
+		opts.Tools = append(opts.Tools, t.ToolDefinition)
+	}
+	opts.TimeBudget = 0
+
+	msgs = slices.Clip(msgs)
+
+	for {
+		resp, err := x.doChat(ctx, chatRequest{key: key, messages: msgs, opts: opts})
+		result.ChatResponse = resp
+		if err != nil {
+			return result, nil, err
+		}
+
+		reply := Message{}
+		json.Unmarshal(resp.RawMessage, &reply)
+		if len(reply.ToolCalls) == 0 {
+			return result, append(msgs, Message{Role: "assistant", Content: resp.Content}), nil
+		}
+
+		if result.Rounds >= maxRounds {
+			return result, nil, fmt.Errorf("%w: %d rounds", ErrToolRoundLimit, maxRounds)
+		}
+		result.Rounds++
+
+		msgs = append(msgs, Message{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls})
+
+		for _, call := range reply.ToolCalls {
+			tool, ok := byName[call.Name]
+			if !ok || (loop.Allowed != nil && !slices.Contains(loop.Allowed, call.Name)) {
+				return result, nil, fmt.Errorf("%w: %s", ErrToolNotAllowed, call.Name)
+			}
+
+			if loop.Review != nil {
+				reviewed, err := loop.Review(call)
+				if err != nil {
+					result.Vetoed = append(result.Vetoed, VetoedToolCall{Call: call, Reason: err.Error()})
+					msgs = append(msgs, Message{Role: "tool", ToolCallId: call.Id, Content: "call rejected: " + err.Error()})
+					continue
+				}
+				// Only the arguments may change, the reply must answer the call
+				// the model made
+				call.Arguments = reviewed.Arguments
+			}
+
+			out, err := tool.Call(call.Arguments)
+			if err != nil {
+				out = "error: " + err.Error()
+			}
+			result.Calls = append(result.Calls, call)
+			msgs = append(msgs, Message{Role: "tool", ToolCallId: call.Id, Content: out})
+		}
+	}
+}
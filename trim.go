@@ -0,0 +1,206 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// How a Session drops old messages so its prompt fits the context size.
+type TrimStrategy int
+
+// The session's system prompt is pinned, no strategy drops it.
+const (
+	// Sends the whole history
+	TRIM_NONE TrimStrategy = iota
+	// Drops the oldest messages
+	TRIM_DROP_OLDEST
+	// Drops the oldest messages, keeping the system messages among them
+	TRIM_KEEP_SYSTEM
+	// Sends at most TrimOptions.Window latest messages, dropping older ones
+	// while they still don't fit
+	TRIM_SLIDING_WINDOW
+)
+
+var trimNames = map[TrimStrategy]string{
+	TRIM_NONE:           "none",
+	TRIM_DROP_OLDEST:    "drop_oldest",
+	TRIM_KEEP_SYSTEM:    "keep_system",
+	TRIM_SLIDING_WINDOW: "sliding_window",
+}
+
+func (t TrimStrategy) String() string {
+	name, ok := trimNames[t]
+	if !ok {
+		return fmt.Sprintf("TrimStrategy(%d)", int(t))
+	}
+	return name
+}
+
+// Saved by name, so saved sessions don't depend on the constants' values.
+func (t TrimStrategy) MarshalText() ([]byte, error) {
+	name, ok := trimNames[t]
+	if !ok {
+		return nil, errors.New("unknown trim strategy")
+	}
+	return []byte(name), nil
+}
+
+func (t *TrimStrategy) UnmarshalText(text []byte) error {
+	for strategy, name := range trimNames {
+		if name == string(text) {
+			*t = strategy
+			return nil
+		}
+	}
+	return errors.New("unknown trim strategy: " + string(text))
+}
+
+type TrimOptions struct {
+	Strategy TrimStrategy `json:"strategy"`
+	// Messages TRIM_SLIDING_WINDOW keeps besides the system prompt
+	Window int `json:"window,omitempty"`
+	// Tokens kept free for the reply, 0 keeps the request's MaxTokens free
+	ReserveTokens int `json:"reserve_tokens,omitempty"`
+}
+
+// Changes how old messages are dropped from the prompt. Only the prompt is
+// trimmed, Messages keeps the whole history.
+func (s *Session) SetTrimming(t TrimOptions) error {
+	err := t.validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trim = t
+	return nil
+}
+
+func (t TrimOptions) validate() error {
+	if _, ok := trimNames[t.Strategy]; !ok {
+		return errors.New("unknown trim strategy")
+	}
+	if t.Strategy == TRIM_SLIDING_WINDOW && t.Window <= 0 {
+		return errors.New("sliding window needs a positive Window")
+	}
+	if t.ReserveTokens < 0 {
+		return errors.New("ReserveTokens is negative")
+	}
+	return nil
+}
+
+func (s *Session) Trimming() TrimOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trim
+}
+
+// Drops old messages until msgs fit the context with the reply's tokens left
+// free. A leading system message is pinned. Whole turns are dropped, what is
+// sent after the system prompt starts with a user message. The last message is
+// always sent.
+func (x *XpltAI) trimHistory(ctx context.Context, msgs []Message, opts GenOptions, t TrimOptions) ([]Message, error) {
+	if t.Strategy == TRIM_NONE || len(msgs) == 0 {
+		return msgs, nil
+	}
+
+	pinned := 0
+	if msgs[0].Role == "system" {
+		pinned = 1
+	}
+	// Messages from start on are sent, older ones only when kept
+	start := pinned
+	if t.Strategy == TRIM_SLIDING_WINDOW && len(msgs)-pinned > t.Window {
+		start = startOfTurn(msgs, len(msgs)-t.Window)
+	}
+	kept := func(i int) bool {
+		return i < pinned || t.Strategy == TRIM_KEEP_SYSTEM && msgs[i].Role == "system"
+	}
+
+	budget, err := x.promptBudget(ctx, opts, t.ReserveTokens)
+	if err != nil {
+		return msgs, err
+	}
+
+	framing, err := x.templateFraming(ctx)
+	if err != nil {
+		return msgs, err
+	}
+	counts := make([]int, len(msgs))
+	total := framing.fixed
+	for i, m := range msgs {
+		n, err := x.countTextTokens(ctx, messageText(m))
+		if err != nil {
+			return msgs, err
+		}
+		counts[i] = n + framing.perMessage
+		if i >= start || kept(i) {
+			total += counts[i]
+		}
+	}
+
+	for total > budget {
+		if start >= len(msgs)-1 {
+			return msgs, &ServerError{
+				Type:    "exceed_context_size_error",
+				Message: "last message does not fit the context size",
+			}
+		}
+		next := startOfTurn(msgs, start+1)
+		for i := start; i < next; i++ {
+			if !kept(i) {
+				total -= counts[i]
+			}
+		}
+		start = next
+	}
+
+	if start == pinned {
+		return msgs, nil
+	}
+	sent := []Message{}
+	for i, m := range msgs {
+		if i >= start || kept(i) {
+			sent = append(sent, m)
+		}
+	}
+	return sent, nil
+}
+
+// Index of the first user message from i on, at most the last message.
+func startOfTurn(msgs []Message, i int) int {
+	for i < len(msgs)-1 && msgs[i].Role != "user" {
+		i++
+	}
+	return i
+}
+
+// Prompt tokens a request with opts may use.
+func (x *XpltAI) promptBudget(ctx context.Context, opts GenOptions, reserve int) (int, error) {
+	_, err := x.waitReady(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	nCtx, err := x.contextSize(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if reserve <= 0 {
+		resolved, err := x.resolveOptions(ctx, opts)
+		if err != nil {
+			return 0, err
+		}
+		// MaxTokensFraction takes its share of whatever the prompt leaves
+		reserve = max(resolved.MaxTokens, 1)
+	}
+
+	budget := nCtx - reserve
+	if budget <= 0 {
+		return 0, errors.New("context size is too small for the reply tokens")
+	}
+	return budget, nil
+}
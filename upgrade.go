@@ -0,0 +1,223 @@
+package xplatai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Returned by installs that could not replace binaries a running server holds
+// open. The new version stays staged and is activated by the next New once
+// the server exited, the installed version keeps working until then.
+var ErrUpgradePending = errors.New("llama.cpp binaries are in use, the new version activates once the running server exits")
+
+// Windows error codes for files another process holds open
+const (
+	_ERROR_ACCESS_DENIED     syscall.Errno = 5
+	_ERROR_SHARING_VIOLATION syscall.Errno = 32
+	_ERROR_LOCK_VIOLATION    syscall.Errno = 33
+)
+
+// Installs are extracted next to the flavor's directory and swapped in once
+// complete, so a failed or blocked install never leaves a partial one active.
+const (
+	stagedSuffix  = ".staged"
+	retiredSuffix = ".old"
+)
+
+// Version extracted to a staging directory but not active yet.
+type stagedInstall struct {
+	Version string `json:"version"`
+	// Extracted files relative to the flavor directory, slash separated
+	Files []string `json:"files"`
+}
+
+// Windows refuses to move or delete binaries of a running process, other
+// platforms let running processes keep their unlinked files.
+func isLockedError(err error) bool {
+	if runtime.GOOS != "windows" {
+		return false
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == _ERROR_ACCESS_DENIED || errno == _ERROR_SHARING_VIOLATION || errno == _ERROR_LOCK_VIOLATION
+}
+
+// Empties the flavor's staging directory, forgetting any version pending there.
+func prepareStagingDir(hardware HostHardware) (string, error) {
+	flavor := flavorName(hardware)
+
+	forgetStaged(flavor)
+
+	dirPath, err := installPath(flavor + stagedSuffix)
+	if err != nil {
+		return "", err
+	}
+	err = os.RemoveAll(dirPath)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return "", err
+	}
+	return dirPath, nil
+}
+
+// Records the staged install then swaps it in, see activateStaged. Must hold
+// the install lock.
+func activateFlavor(clk clock, hardware HostHardware, staged stagedInstall, stopForUpgrade bool) error {
+	flavor := flavorName(hardware)
+
+	manifest, _ := readManifest()
+	if manifest.Staged == nil {
+		manifest.Staged = map[string]stagedInstall{}
+	}
+	manifest.Staged[flavor] = staged
+
+	err := writeManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = activateStaged(clk, flavor, stopForUpgrade)
+	if err != nil {
+		return err
+	}
+	return quarantineError(hardware, "llama-server.exe", nil)
+}
+
+// Replaces the flavor's directory with its staging directory and updates the
+// manifest. When a running server holds the installed binaries, stops it if
+// it was spawned from the install dir and stopForUpgrade is set, otherwise
+// leaves the install staged and returns ErrUpgradePending. Must hold the
+// install lock.
+func activateStaged(clk clock, flavor string, stopForUpgrade bool) error {
+	dirPath, err := installPath(flavor)
+	if err != nil {
+		return err
+	}
+	stagedPath := dirPath + stagedSuffix
+	retiredPath := dirPath + retiredSuffix
+
+	os.RemoveAll(retiredPath)
+	err = os.Rename(dirPath, retiredPath)
+	if err != nil && isLockedError(err) && stopForUpgrade && stopOwnServer(clk) {
+		err = os.Rename(dirPath, retiredPath)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		if isLockedError(err) {
+			return fmt.Errorf("%w: %w", ErrUpgradePending, err)
+		}
+		return err
+	}
+
+	err = os.Rename(stagedPath, dirPath)
+	if err != nil {
+		os.Rename(retiredPath, dirPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			forgetStaged(flavor)
+		}
+		return err
+	}
+	os.RemoveAll(retiredPath)
+
+	manifest, _ := readManifest()
+	staged := manifest.Staged[flavor]
+	delete(manifest.Staged, flavor)
+
+	if manifest.Flavors == nil {
+		manifest.Flavors = map[string]string{}
+	}
+	manifest.Flavors[flavor] = staged.Version
+	manifest.Files = slices.DeleteFunc(manifest.Files, func(f string) bool {
+		return strings.HasPrefix(f, flavor+"/")
+	})
+	for _, f := range staged.Files {
+		manifest.Files = append(manifest.Files, relInstallPath(flavor, f))
+	}
+	return writeManifest(manifest)
+}
+
+// Kills the servers recorded in the pid files, the only ones known to run
+// binaries from the install dir. Servers of other handles of this process are
+// not touched. Returns whether they are all gone.
+func stopOwnServer(clk clock) bool {
+	running := []pidFile{}
+	for _, pf := range readPidFiles() {
+		if !pf.alive() {
+			continue
+		}
+		if pf.ownedBySelf() {
+			return false
+		}
+		running = append(running, pf)
+	}
+	if len(running) == 0 {
+		return false
+	}
+
+	for _, pf := range running {
+		proc, err := os.FindProcess(pf.Pid)
+		if err != nil {
+			return false
+		}
+		proc.Kill()
+
+		if !pf.waitExit(clk, previousServerWait) {
+			return false
+		}
+		removePidFile(pf.Port, pf.Pid)
+	}
+	return true
+}
+
+// Activates the versions left staged by installs that found their binaries
+// in use. Returns a warning for each one still blocked.
+func activatePendingUpgrades(clk clock, stopForUpgrade bool) []string {
+	manifest, err := readManifest()
+	if err != nil || len(manifest.Staged) == 0 {
+		return nil
+	}
+
+	lock, err := acquireInstallLock(context.Background(), clk, nil)
+	if err != nil {
+		return []string{"could not activate staged llama.cpp upgrade: " + err.Error()}
+	}
+	defer lock.release()
+
+	// Another process may have activated them while we waited for the lock
+	manifest, err = readManifest()
+	if err != nil {
+		return nil
+	}
+
+	warnings := []string{}
+	for flavor, staged := range manifest.Staged {
+		err = activateStaged(clk, flavor, stopForUpgrade)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s flavor upgrade to %s is still pending: %v", flavor, staged.Version, err))
+		}
+	}
+	return warnings
+}
+
+// Drops the flavor's staged install from the manifest.
+func forgetStaged(flavor string) {
+	manifest, err := readManifest()
+	if err != nil {
+		return
+	}
+	if _, ok := manifest.Staged[flavor]; ok {
+		delete(manifest.Staged, flavor)
+		writeManifest(manifest)
+	}
+}
@@ -0,0 +1,149 @@
+package xplatai
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Installs content as the CPU flavor's server binary at version, with the
+// manifest recording it.
+func installTestFlavor(t *testing.T, version string, content string) {
+	t.Helper()
+	serverPath, err := flavorPath(cpuFlavor(), "llama-server.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(serverPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(serverPath, []byte(content), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flavor := flavorName(cpuFlavor())
+	err = writeManifest(installManifest{
+		Flavors: map[string]string{flavor: version},
+		Files:   []string{relInstallPath(flavor, "llama-server.exe")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Extracts content as the CPU flavor's server binary to the staging dir.
+func stageTestFlavor(t *testing.T, version string, content string) stagedInstall {
+	t.Helper()
+	dirPath, err := prepareStagingDir(cpuFlavor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dirPath, "llama-server.exe"), []byte(content), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stagedInstall{Version: version, Files: []string{"llama-server.exe"}}
+}
+
+// Fails unless the installed binary and the manifest both are at version.
+func checkActiveFlavor(t *testing.T, version string, content string) {
+	t.Helper()
+	serverPath, err := flavorPath(cpuFlavor(), "llama-server.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(serverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flavor := flavorName(cpuFlavor())
+	if string(b) != content || manifest.Flavors[flavor] != version {
+		t.Errorf("installed %q at version %q, want %q at %q", b, manifest.Flavors[flavor], content, version)
+	}
+	if !slices.Contains(manifest.Files, relInstallPath(flavor, "llama-server.exe")) {
+		t.Errorf("manifest files %v miss the server binary", manifest.Files)
+	}
+}
+
+func stagedVersion(t *testing.T) string {
+	t.Helper()
+	manifest, err := readManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest.Staged[flavorName(cpuFlavor())].Version
+}
+
+func TestActivateFlavorSwapsInStagedInstall(t *testing.T) {
+	t.Chdir(t.TempDir())
+	installTestFlavor(t, "b1000", "old")
+	staged := stageTestFlavor(t, "b2000", "new")
+
+	err := activateFlavor(realClock{}, cpuFlavor(), staged, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkActiveFlavor(t, "b2000", "new")
+	if stagedVersion(t) != "" {
+		t.Error("activated version still staged")
+	}
+
+	dirPath, _ := flavorPath(cpuFlavor())
+	for _, leftover := range []string{dirPath + stagedSuffix, dirPath + retiredSuffix} {
+		if _, err := os.Stat(leftover); err == nil {
+			t.Errorf("%s left behind", leftover)
+		}
+	}
+}
+
+// A staging dir that went missing leaves the installed version active.
+func TestMissingStagedInstallKeepsInstalledVersion(t *testing.T) {
+	t.Chdir(t.TempDir())
+	installTestFlavor(t, "b1000", "old")
+	manifest, _ := readManifest()
+	manifest.Staged = map[string]stagedInstall{flavorName(cpuFlavor()): {Version: "b2000", Files: []string{"llama-server.exe"}}}
+	err := writeManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := activatePendingUpgrades(realClock{}, false)
+	if len(warnings) != 1 {
+		t.Errorf("got warnings %q, want one", warnings)
+	}
+	checkActiveFlavor(t, "b1000", "old")
+	if stagedVersion(t) != "" {
+		t.Error("missing install still staged")
+	}
+}
+
+func TestNewActivatesPendingUpgrade(t *testing.T) {
+	t.Chdir(t.TempDir())
+	installTestFlavor(t, "b1000", "old")
+	staged := stageTestFlavor(t, "b2000", "new")
+	manifest, _ := readManifest()
+	manifest.Staged = map[string]stagedInstall{flavorName(cpuFlavor()): staged}
+	err := writeManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	procs := &fakeProcs{}
+	x, err := NewWithConfig(fakeConfig(newFakeClock(), procs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	checkActiveFlavor(t, "b2000", "new")
+	if stagedVersion(t) != "" || len(x.warnings) != 0 {
+		t.Errorf("got warnings %q with %q staged, want the upgrade activated", x.warnings, stagedVersion(t))
+	}
+}
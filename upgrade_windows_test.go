@@ -0,0 +1,57 @@
+package xplatai
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// An open handle on the installed binary stands in for the running server.
+func TestLockedBinaryLeavesUpgradeStaged(t *testing.T) {
+	t.Chdir(t.TempDir())
+	installTestFlavor(t, "b1000", "old")
+	staged := stageTestFlavor(t, "b2000", "new")
+
+	serverPath, err := flavorPath(cpuFlavor(), "llama-server.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := os.Open(serverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	err = activateFlavor(realClock{}, cpuFlavor(), staged, false)
+	if !errors.Is(err, ErrUpgradePending) || ClassifyError(err) != FAILURE_UPGRADE_PENDING {
+		t.Fatalf("got %v, want ErrUpgradePending", err)
+	}
+
+	// Nothing of the new version is active, all of it is staged
+	checkActiveFlavor(t, "b1000", "old")
+	if stagedVersion(t) != "b2000" {
+		t.Errorf("staged version %q, want b2000", stagedVersion(t))
+	}
+	dirPath, _ := flavorPath(cpuFlavor())
+	b, err := os.ReadFile(dirPath + stagedSuffix + `\llama-server.exe`)
+	if err != nil || string(b) != "new" {
+		t.Errorf("staged binary %q and %v, want the new one", b, err)
+	}
+
+	// Still blocked on the next New
+	warnings := activatePendingUpgrades(realClock{}, false)
+	if len(warnings) != 1 {
+		t.Errorf("got warnings %q, want the upgrade still pending", warnings)
+	}
+	checkActiveFlavor(t, "b1000", "old")
+
+	held.Close()
+	warnings = activatePendingUpgrades(realClock{}, false)
+	if len(warnings) != 0 {
+		t.Fatalf("got warnings %q once released", warnings)
+	}
+	checkActiveFlavor(t, "b2000", "new")
+	if stagedVersion(t) != "" {
+		t.Error("activated version still staged")
+	}
+}
@@ -0,0 +1,103 @@
+package xplatai
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+)
+
+// Tokens one request consumed server-side.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Collects the usage of every request sent for one call, including retries,
+// hedges and time slice continuations.
+type usageRecorder struct {
+	mu       sync.Mutex
+	attempts []Usage
+}
+
+type usageKey struct{}
+
+func withUsageRecorder(ctx context.Context) (context.Context, *usageRecorder) {
+	r := &usageRecorder{}
+	return context.WithValue(ctx, usageKey{}, r), r
+}
+
+func recordUsage(ctx context.Context, u Usage) {
+	r, ok := ctx.Value(usageKey{}).(*usageRecorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	r.attempts = append(r.attempts, u)
+	r.mu.Unlock()
+}
+
+func (r *usageRecorder) result() ([]Usage, Usage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := Usage{}
+	for _, u := range r.attempts {
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+	}
+	return slices.Clone(r.attempts), total
+}
+
+// Usage an OpenAI-compatible response body reports.
+func bodyUsage(body []byte) Usage {
+	usage := struct {
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}{}
+	json.Unmarshal(body, &usage)
+	return Usage{PromptTokens: usage.Usage.PromptTokens, CompletionTokens: usage.Usage.CompletionTokens}
+}
+
+// Usage a native /completion response reports.
+func completionUsage(jsonData map[string]any) Usage {
+	prompt, _ := jsonData["tokens_evaluated"].(float64)
+	predicted, _ := jsonData["tokens_predicted"].(float64)
+	return Usage{PromptTokens: int(prompt), CompletionTokens: int(predicted)}
+}
+
+// Running total of the usage of a handle's chat and completion calls.
+type usageCounter struct {
+	mu    sync.Mutex
+	total Usage
+}
+
+func (c *usageCounter) add(u Usage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total.PromptTokens += u.PromptTokens
+	c.total.CompletionTokens += u.CompletionTokens
+}
+
+// Tokens consumed by every chat and completion call of the handle since it
+// was created or ResetUsage was called, retries and failed calls included.
+func (x *XpltAI) Usage() Usage {
+	x.usage.mu.Lock()
+	defer x.usage.mu.Unlock()
+	return x.usage.total
+}
+
+// Restarts the running total of Usage, returning the total so far.
+func (x *XpltAI) ResetUsage() Usage {
+	x.usage.mu.Lock()
+	defer x.usage.mu.Unlock()
+	total := x.usage.total
+	x.usage.total = Usage{}
+	return total
+}
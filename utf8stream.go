@@ -0,0 +1,121 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Reassembles streamed text so callbacks only ever see whole characters, even
+// when a character's bytes or UTF-16 surrogates arrive in different chunks.
+type utf8Assembler struct {
+	pending []byte
+	// High surrogate of a \u escaped pair still waiting for its low half
+	surrogate rune
+}
+
+// Decodes the raw json string of a chunk and returns the complete characters
+// available so far.
+func (a *utf8Assembler) push(raw json.RawMessage) (string, error) {
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		if string(raw) == "null" || len(raw) == 0 {
+			return "", nil
+		}
+		return "", strconv.ErrSyntax
+	}
+
+	s := raw[1 : len(raw)-1]
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			a.flushSurrogate()
+			a.pending = append(a.pending, c)
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			return "", strconv.ErrSyntax
+		}
+
+		switch s[i] {
+		case 'b':
+			a.writeRune('\b')
+		case 'f':
+			a.writeRune('\f')
+		case 'n':
+			a.writeRune('\n')
+		case 'r':
+			a.writeRune('\r')
+		case 't':
+			a.writeRune('\t')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", strconv.ErrSyntax
+			}
+			v, err := strconv.ParseUint(string(s[i+1:i+5]), 16, 32)
+			if err != nil {
+				return "", err
+			}
+			i += 4
+			a.writeUTF16(rune(v))
+		default:
+			a.writeRune(rune(s[i]))
+		}
+	}
+	return a.complete(), nil
+}
+
+func (a *utf8Assembler) writeRune(r rune) {
+	a.flushSurrogate()
+	a.pending = utf8.AppendRune(a.pending, r)
+}
+
+func (a *utf8Assembler) writeUTF16(r rune) {
+	switch {
+	case utf16.IsSurrogate(r) && r < 0xDC00:
+		a.flushSurrogate()
+		a.surrogate = r
+	case utf16.IsSurrogate(r) && a.surrogate != 0:
+		a.pending = utf8.AppendRune(a.pending, utf16.DecodeRune(a.surrogate, r))
+		a.surrogate = 0
+	default:
+		a.writeRune(r)
+	}
+}
+
+// A high surrogate not followed by its low half is invalid.
+func (a *utf8Assembler) flushSurrogate() {
+	if a.surrogate != 0 {
+		a.pending = utf8.AppendRune(a.pending, utf8.RuneError)
+		a.surrogate = 0
+	}
+}
+
+// Returns the pending bytes up to the last complete character.
+func (a *utf8Assembler) complete() string {
+	end := len(a.pending)
+
+	// A character is at most utf8.UTFMax bytes, only the tail can be partial
+	for i := len(a.pending) - 1; i >= 0 && i >= len(a.pending)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(a.pending[i]) {
+			if !utf8.FullRune(a.pending[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+
+	out := string(a.pending[:end])
+	a.pending = append(a.pending[:0], a.pending[end:]...)
+	return out
+}
+
+// Returns whatever is left at the end of the stream.
+func (a *utf8Assembler) flush() string {
+	a.flushSurrogate()
+	out := string(a.pending)
+	a.pending = a.pending[:0]
+	return out
+}
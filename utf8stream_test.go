@@ -0,0 +1,115 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var multiByteTexts = []string{
+	"你好，世界。今日は良い天気ですね。",
+	"안녕하세요 👋",
+	"emoji 😀🎉 and flags 🇫🇷",
+	// Family emoji joined with zero width joiners
+	"👨‍👩‍👧‍👦",
+	// e followed by a combining acute accent
+	"café",
+}
+
+// Pushes every chunk to a fresh assembler, checking each output is valid on
+// its own, and returns everything it put out.
+func assemble(t *testing.T, chunks []string) string {
+	t.Helper()
+	a := utf8Assembler{}
+	out := strings.Builder{}
+	for _, c := range chunks {
+		s, err := a.push(json.RawMessage(`"` + c + `"`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.ValidString(s) {
+			t.Fatalf("chunk %q put out invalid text %q", c, s)
+		}
+		out.WriteString(s)
+	}
+	out.WriteString(a.flush())
+	return out.String()
+}
+
+func TestAssemblerSplitsAtEveryByte(t *testing.T) {
+	for _, text := range multiByteTexts {
+		for i := 0; i <= len(text); i++ {
+			got := assemble(t, []string{text[:i], text[i:]})
+			if got != text {
+				t.Errorf("split at byte %d: got %q, want %q", i, got, text)
+			}
+		}
+	}
+}
+
+func TestAssemblerByteByByte(t *testing.T) {
+	for _, text := range multiByteTexts {
+		chunks := []string{}
+		for i := range len(text) {
+			chunks = append(chunks, text[i:i+1])
+		}
+		got := assemble(t, chunks)
+		if got != text {
+			t.Errorf("got %q, want %q", got, text)
+		}
+	}
+}
+
+func TestAssemblerSurrogatePairs(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole pair", []string{`\ud83d\ude00`}, "😀"},
+		{"split pair", []string{`\ud83d`, `\ude00`}, "😀"},
+		{"split pair with text", []string{`a\ud83d`, `\ude00b`}, "a😀b"},
+		{"lone high surrogate", []string{`\ud83d`, `x`}, "�x"},
+		{"lone high surrogate at the end", []string{`x\ud83d`}, "x�"},
+		{"cjk escapes", []string{`\u4f60`, `\u597d`}, "你好"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := assemble(t, tt.chunks)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Stream chunks cut mid-character reach the callback as whole characters.
+func TestStreamDeliversWholeCharacters(t *testing.T) {
+	text := "你好 😀 世界"
+	chunks := []string{}
+	for i := 0; i < len(text); i += 2 {
+		end := min(i+2, len(text))
+		chunks = append(chunks, `{"choices":[{"index":0,"delta":{"content":"`+text[i:end]+`"}}]}`)
+	}
+	chunks = append(chunks, `{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`)
+	srv := streamServer(chunks)
+	defer srv.Close()
+	x := newTestHandle(t, srv, realClock{})
+
+	tokens := []string{}
+	resp, err := x.ChatDetailedStream([]Message{{Role: "user", Content: "hi"}}, GenOptions{}, func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range tokens {
+		if !utf8.ValidString(tok) {
+			t.Errorf("callback got invalid text %q", tok)
+		}
+	}
+	if strings.Join(tokens, "") != text || resp.Content != text {
+		t.Errorf("got tokens %q and content %q, want %q", tokens, resp.Content, text)
+	}
+}
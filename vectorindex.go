@@ -0,0 +1,389 @@
+package xplatai
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Vectors searched exhaustively by cosine similarity, in memory or kept in a
+// file, see OpenVectorIndex. Fits memories and documents up to a few hundred
+// thousand vectors. Implements MemoryStore.
+type VectorIndex struct {
+	mu    sync.RWMutex
+	items []VectorItem
+	norms []float64
+	// Index in items by id
+	byId map[string]int
+
+	// Append-only log of the changes, nil when kept in memory only
+	file *os.File
+	path string
+	// Records in the file, Compact drops the outdated ones
+	records int
+}
+
+type VectorItem struct {
+	Id       string
+	Text     string
+	Metadata map[string]string
+	Vector   []float32
+}
+
+type VectorHit struct {
+	VectorItem
+	// Cosine similarity to the query
+	Score float64
+}
+
+// One line of the index file, deleting the item when Deleted is set.
+type vectorRecord struct {
+	Id       string            `json:"id"`
+	Text     string            `json:"text,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Little-endian float32 values, base64 encoded
+	Vector  string `json:"vector,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Index kept in memory only.
+func NewVectorIndex() *VectorIndex {
+	return &VectorIndex{byId: map[string]int{}}
+}
+
+// Index kept in the file at path, created when missing. Every change is
+// appended to the file as it happens, see Compact to shrink it. A record cut
+// short by a crash is dropped, a file mixing vector sizes fails to open.
+func OpenVectorIndex(path string) (*VectorIndex, error) {
+	v := NewVectorIndex()
+	v.path = path
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	v.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(v.file)
+	for {
+		r := vectorRecord{}
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// Appends would land after the partial record, cut it off
+			err = v.file.Truncate(dec.InputOffset())
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			v.file.Close()
+			return nil, fmt.Errorf("json parsing failure, %w", err)
+		}
+		v.records++
+
+		if r.Deleted {
+			v.delete(r.Id)
+			continue
+		}
+		item, err := r.item()
+		if err == nil {
+			err = checkVector(item, v.dims())
+		}
+		if err != nil {
+			v.file.Close()
+			return nil, err
+		}
+		v.insert(item)
+	}
+
+	_, err = v.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		v.file.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+func (r vectorRecord) item() (VectorItem, error) {
+	b, err := base64.StdEncoding.DecodeString(r.Vector)
+	if err != nil || len(b)%4 != 0 {
+		return VectorItem{}, errors.New("invalid vector of item " + r.Id)
+	}
+	vector := make([]float32, len(b)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return VectorItem{Id: r.Id, Text: r.Text, Metadata: r.Metadata, Vector: vector}, nil
+}
+
+func itemRecord(item VectorItem) vectorRecord {
+	b := make([]byte, len(item.Vector)*4)
+	for i, f := range item.Vector {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(f))
+	}
+	return vectorRecord{
+		Id:       item.Id,
+		Text:     item.Text,
+		Metadata: item.Metadata,
+		Vector:   base64.StdEncoding.EncodeToString(b),
+	}
+}
+
+// Adds items, replacing the ones with the same Id. Every vector must have the
+// size of the vectors already in the index.
+func (v *VectorIndex) Insert(items ...VectorItem) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	dims := v.dims()
+	added := make([]VectorItem, 0, len(items))
+	records := []vectorRecord{}
+	for _, item := range items {
+		if item.Id == "" {
+			return errors.New("vector item has no id")
+		}
+		err := checkVector(item, dims)
+		if err != nil {
+			return err
+		}
+		dims = len(item.Vector)
+
+		// The caller keeps ownership of what it passed
+		item.Vector = slices.Clone(item.Vector)
+		item.Metadata = maps.Clone(item.Metadata)
+		added = append(added, item)
+		records = append(records, itemRecord(item))
+	}
+
+	err := v.append(records)
+	if err != nil {
+		return err
+	}
+	for _, item := range added {
+		v.insert(item)
+	}
+	return nil
+}
+
+// Size of the vectors in the index, 0 when it is empty.
+func (v *VectorIndex) dims() int {
+	if len(v.items) == 0 {
+		return 0
+	}
+	return len(v.items[0].Vector)
+}
+
+// Checks the vector of item has dims dimensions, any non zero number when
+// dims is 0.
+func checkVector(item VectorItem, dims int) error {
+	if len(item.Vector) == 0 {
+		return errors.New("vector of item " + item.Id + " is empty")
+	}
+	if dims != 0 && len(item.Vector) != dims {
+		return fmt.Errorf("vector of item %s has %d dimensions, the index %d", item.Id, len(item.Vector), dims)
+	}
+	return nil
+}
+
+func (v *VectorIndex) insert(item VectorItem) {
+	norm := 0.0
+	for _, f := range item.Vector {
+		norm += float64(f) * float64(f)
+	}
+	norm = math.Sqrt(norm)
+
+	if i, ok := v.byId[item.Id]; ok {
+		v.items[i], v.norms[i] = item, norm
+		return
+	}
+	v.byId[item.Id] = len(v.items)
+	v.items = append(v.items, item)
+	v.norms = append(v.norms, norm)
+}
+
+// Removes the items with these ids, unknown ids are ignored.
+func (v *VectorIndex) Delete(ids ...string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	records := []vectorRecord{}
+	for _, id := range ids {
+		if _, ok := v.byId[id]; ok {
+			records = append(records, vectorRecord{Id: id, Deleted: true})
+		}
+	}
+
+	err := v.append(records)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		v.delete(id)
+	}
+	return nil
+}
+
+func (v *VectorIndex) delete(id string) {
+	i, ok := v.byId[id]
+	if !ok {
+		return
+	}
+	last := len(v.items) - 1
+	v.items[i], v.norms[i] = v.items[last], v.norms[last]
+	v.byId[v.items[i].Id] = i
+	v.items, v.norms = v.items[:last], v.norms[:last]
+	delete(v.byId, id)
+}
+
+func (v *VectorIndex) append(records []vectorRecord) error {
+	if v.file == nil || len(records) == 0 {
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		err := enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := v.file.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	v.records += len(records)
+	return nil
+}
+
+// The k items most similar to vector, best first.
+func (v *VectorIndex) Query(vector []float32, k int) []VectorHit {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	norm := 0.0
+	for _, f := range vector {
+		norm += float64(f) * float64(f)
+	}
+	norm = math.Sqrt(norm)
+
+	hits := make([]VectorHit, 0, len(v.items))
+	for i, item := range v.items {
+		score := 0.0
+		if len(item.Vector) == len(vector) && norm > 0 && v.norms[i] > 0 {
+			dot := 0.0
+			for j, f := range vector {
+				dot += float64(f) * float64(item.Vector[j])
+			}
+			score = dot / (norm * v.norms[i])
+		}
+		hits = append(hits, VectorHit{VectorItem: item, Score: score})
+	}
+	slices.SortFunc(hits, func(a, b VectorHit) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return hits[:min(max(k, 0), len(hits))]
+}
+
+func (v *VectorIndex) Get(id string) (VectorItem, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	i, ok := v.byId[id]
+	if !ok {
+		return VectorItem{}, false
+	}
+	return v.items[i], true
+}
+
+func (v *VectorIndex) Len() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.items)
+}
+
+// Adds text under a random id, for Memory.
+func (v *VectorIndex) Add(ctx context.Context, text string, vector []float32) error {
+	return v.Insert(VectorItem{Id: newRequestId(), Text: text, Vector: vector})
+}
+
+// Query for Memory.
+func (v *VectorIndex) Search(ctx context.Context, vector []float32, k int) ([]MemoryHit, error) {
+	hits := []MemoryHit{}
+	for _, hit := range v.Query(vector, k) {
+		hits = append(hits, MemoryHit{Text: hit.Text, Score: hit.Score})
+	}
+	return hits, nil
+}
+
+// Rewrites the file with only the current items, dropping replaced and
+// deleted ones. Does nothing for an index kept in memory.
+func (v *VectorIndex) Compact() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.file == nil || v.records == len(v.items) {
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, item := range v.items {
+		err := enc.Encode(itemRecord(item))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write then rename so a crash never leaves a truncated index
+	tmpPath := v.path + ".tmp"
+	err := os.WriteFile(tmpPath, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	// Windows refuses to replace an open file
+	v.file.Close()
+	err = os.Rename(tmpPath, v.path)
+	f, openErr := os.OpenFile(v.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if openErr != nil {
+		v.file = nil
+		return errors.Join(err, openErr)
+	}
+	v.file = f
+	if err != nil {
+		return err
+	}
+	v.records = len(v.items)
+	return nil
+}
+
+// Closes the file of the index, changes after that are kept in memory only.
+func (v *VectorIndex) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.file == nil {
+		return nil
+	}
+	err := v.file.Close()
+	v.file = nil
+	return err
+}
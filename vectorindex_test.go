@@ -0,0 +1,119 @@
+package xplatai
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes records to a new index file, one per line like the index does.
+func writeVectorRecords(t *testing.T, records ...vectorRecord) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "index.jsonl")
+	lines := []string{}
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(b))
+	}
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenVectorIndexRejectsMixedDimensions(t *testing.T) {
+	path := writeVectorRecords(t,
+		itemRecord(VectorItem{Id: "a", Vector: []float32{1, 0, 0}}),
+		itemRecord(VectorItem{Id: "b", Vector: []float32{0, 1}}),
+	)
+
+	v, err := OpenVectorIndex(path)
+	if err == nil || !strings.Contains(err.Error(), "dimensions") {
+		t.Fatalf("got %v, want a dimension mismatch", err)
+	}
+	if v != nil {
+		t.Error("got an index along with the error")
+	}
+
+	// The file was closed, Windows refuses to remove open files
+	err = os.Remove(path)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpenVectorIndexRejectsEmptyVectors(t *testing.T) {
+	path := writeVectorRecords(t, vectorRecord{Id: "a"})
+
+	_, err := OpenVectorIndex(path)
+	if err == nil {
+		t.Fatal("opened an index holding an empty vector")
+	}
+}
+
+// Like Insert, a new size is fine once every item of the old one is deleted.
+func TestOpenVectorIndexAfterDeletingEveryItem(t *testing.T) {
+	path := writeVectorRecords(t,
+		itemRecord(VectorItem{Id: "a", Vector: []float32{1, 0, 0}}),
+		vectorRecord{Id: "a", Deleted: true},
+		itemRecord(VectorItem{Id: "b", Vector: []float32{0, 1}}),
+	)
+
+	v, err := OpenVectorIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if v.Len() != 1 || v.dims() != 2 {
+		t.Errorf("got %d items of %d dimensions, want 1 of 2", v.Len(), v.dims())
+	}
+
+	err = v.Insert(VectorItem{Id: "c", Vector: []float32{1, 0, 0}})
+	if err == nil {
+		t.Error("inserted a vector of another size")
+	}
+}
+
+func TestVectorIndexPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.jsonl")
+	v, err := OpenVectorIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Insert(
+		VectorItem{Id: "a", Text: "apple", Vector: []float32{1, 0}},
+		VectorItem{Id: "b", Text: "banana", Vector: []float32{0, 1}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Delete("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+
+	// A crash cut the last record short
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"c","vec`)
+	f.Close()
+
+	v, err = OpenVectorIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	hits := v.Query([]float32{0, 1}, 5)
+	if len(hits) != 1 || hits[0].Text != "banana" || hits[0].Score != 1 {
+		t.Errorf("got hits %+v, want banana alone", hits)
+	}
+}
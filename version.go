@@ -0,0 +1,95 @@
+package xplatai
+
+import "maps"
+
+// Set at build time with -ldflags "-X github.com/wAIfu-DEV/Xplat-AI.version=v1.2.3"
+var version = "dev"
+
+// Version of the package, "dev" for builds without a version stamp.
+func Version() string {
+	return version
+}
+
+// Keep in sync with the exported API, false marks features known to be
+// missing so host apps can gate them explicitly.
+var features = map[string]bool{
+	"streaming":          true,
+	"stream_journal":     true,
+	"conversations":      true,
+	"time_slicing":       true,
+	"presets":            true,
+	"model_registry":     true,
+	"model_policy":       true,
+	"remote":             true,
+	"proxy":              true,
+	"fallback":           true,
+	"score":              true,
+	"choice":             true,
+	"openai_completions": true,
+	"post_processors":    true,
+	"strict_options":     true,
+	"download_plans":     true,
+	"benchmark":          true,
+	"startup_timings":    true,
+	"warmup":             true,
+	"binary_flavors":     true,
+	"preflight":          true,
+	"reader_prompts":     true,
+	"time_budget":        true,
+	"revision_pinning":   true,
+	"hedging":            true,
+	"batch":              true,
+	"redaction":          true,
+	"resource_limits":    true,
+	"branching":          true,
+	"download_cache":     true,
+	"interrupt":          true,
+	"json_events":        true,
+	"capabilities":       true,
+	"load_progress":      true,
+	"model_info":         true,
+	"shutdown":           true,
+	"quarantine":         true,
+	"download_limit":     true,
+	"staged_upgrades":    true,
+	"special_tokens":     true,
+	"circuit_breaker":    true,
+	"quantize":           true,
+	"deterministic":      true,
+	"logit_bias":         true,
+	"logprobs":           true,
+	"multiple_choices":   true,
+	"json_output":        true,
+	"grammars":           true,
+	"structured_output":  true,
+	"tool_registry":      true,
+	"cancel":             true,
+	"retry_backoff":      true,
+	"request_queue":      true,
+	"usage_totals":       true,
+	"server_timings":     true,
+	"tokenize":           true,
+	"count_tokens":       true,
+	"session":            true,
+	"history_trimming":   true,
+	"session_save":       true,
+	"turn_store":         true,
+	"system_prompt":      true,
+	"chat_template":      true,
+	"prompt_formats":     true,
+	"character_cards":    true,
+	"lorebook":           true,
+	"memory":             true,
+	"vector_index":       true,
+	"chunking":           true,
+	"tools":              true,
+	"embeddings":         true,
+	"rerank":             false,
+	"vision":             false,
+	"infill":             true,
+}
+
+// Features the linked package supports, by name.
+func Features() map[string]bool {
+	return maps.Clone(features)
+}
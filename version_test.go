@@ -0,0 +1,360 @@
+package xplatai
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Feature each exported function and method belongs to, "" for the base API.
+// Methods are listed on their type, Context variants belong to "cancel".
+var apiFeatures = map[string]string{
+	"AllowModels":                      "model_policy",
+	"BenchmarkHardware":                "benchmark",
+	"ChatFormatByName":                 "prompt_formats",
+	"ClassifyError":                    "",
+	"CollapseWhitespace":               "post_processors",
+	"DefaultConfig":                    "",
+	"DefaultFallbackPolicy":            "fallback",
+	"DefaultStopsFor":                  "",
+	"DenyNSFW":                         "model_policy",
+	"Deterministic":                    "deterministic",
+	"DownloadRequirements":             "",
+	"DownloadRequirementsWithProgress": "",
+	"ExecutePlan":                      "download_plans",
+	"Fallback":                         "fallback",
+	"Features":                         "",
+	"FetchModel":                       "revision_pinning",
+	"InstalledFlavors":                 "binary_flavors",
+	"IsRequDownloaded":                 "",
+	"JSONSchemaFor":                    "structured_output",
+	"ListCachedModels":                 "revision_pinning",
+	"ListModels":                       "model_registry",
+	"LorebookFromCard":                 "lorebook",
+	"MergeShards":                      "quantize",
+	"New":                              "",
+	"NewJSONEventWriter":               "json_events",
+	"NewRemote":                        "remote",
+	"NewVectorIndex":                   "vector_index",
+	"NewWithConfig":                    "",
+	"NewWithConfigUnblocked":           "quarantine",
+	"NormalizeNewlines":                "reader_prompts",
+	"OpenVectorIndex":                  "vector_index",
+	"PreFetchModel":                    "",
+	"Preflight":                        "preflight",
+	"PresetOptions":                    "presets",
+	"Ptr":                              "",
+	"PurgeDownloadCache":               "download_cache",
+	"QuantizeModel":                    "quantize",
+	"RegisterChatFormat":               "prompt_formats",
+	"RegisterModel":                    "model_registry",
+	"RegisterPreset":                   "presets",
+	"RegisterTool":                     "tool_registry",
+	"RemoveModel":                      "model_registry",
+	"ResolveDownloadPlan":              "download_plans",
+	"ResolveModel":                     "model_registry",
+	"ServerArgs":                       "",
+	"SetDefaults":                      "",
+	"SetDeterministic":                 "deterministic",
+	"SetGitHubToken":                   "",
+	"SetInstallLockWait":               "",
+	"SetMaxDownloadBytesPerSec":        "download_limit",
+	"SplitMarkdown":                    "chunking",
+	"SplitSentences":                   "chunking",
+	"StripPrefix":                      "post_processors",
+	"TrimIncompleteSentence":           "post_processors",
+	"UnblockBinaries":                  "quarantine",
+	"Version":                          "",
+	"WithChatTemplate":                 "chat_template",
+	"WithConfig":                       "",
+	"WithContextSize":                  "",
+	"WithEmbeddings":                   "embeddings",
+	"WithExtraArgs":                    "",
+	"WithGPULayers":                    "",
+	"WithGrammar":                      "grammars",
+	"WithJSONOutput":                   "json_output",
+	"WithJinja":                        "chat_template",
+	"WithParallelSlots":                "",
+	"WithPreset":                       "presets",
+	"WithRetryPolicy":                  "retry_backoff",
+	"WithSeed":                         "deterministic",
+	"WithThreads":                      "",
+	"WithWorkDir":                      "",
+
+	"XpltAI.Capabilities":              "capabilities",
+	"XpltAI.Chat":                      "",
+	"XpltAI.ChatChoice":                "choice",
+	"XpltAI.ChatDetailed":              "",
+	"XpltAI.ChatDetailedStream":        "streaming",
+	"XpltAI.ChatInto":                  "structured_output",
+	"XpltAI.ChatStream":                "streaming",
+	"XpltAI.ChatStreamWithOptions":     "streaming",
+	"XpltAI.ChatWithOptions":           "",
+	"XpltAI.ChatWithTools":             "tool_registry",
+	"XpltAI.CircuitState":              "circuit_breaker",
+	"XpltAI.Close":                     "",
+	"XpltAI.Complete":                  "",
+	"XpltAI.CompleteBatch":             "batch",
+	"XpltAI.CompleteBatchWithProgress": "batch",
+	"XpltAI.CompleteDetailed":          "",
+	"XpltAI.CompleteFrom":              "reader_prompts",
+	"XpltAI.CompleteOpenAI":            "openai_completions",
+	"XpltAI.CompleteTokens":            "special_tokens",
+	"XpltAI.CompleteWithOptions":       "",
+	"XpltAI.ContinueChat":              "stream_journal",
+	"XpltAI.CountTokens":               "count_tokens",
+	"XpltAI.Detokenize":                "tokenize",
+	"XpltAI.Embed":                     "embeddings",
+	"XpltAI.Infill":                    "infill",
+	"XpltAI.LastTimings":               "server_timings",
+	"XpltAI.ListJournals":              "stream_journal",
+	"XpltAI.LoadConversation":          "branching",
+	"XpltAI.LoadSession":               "session_save",
+	"XpltAI.LogitBiasFor":              "logit_bias",
+	"XpltAI.ModelInfo":                 "model_info",
+	"XpltAI.NewConversation":           "conversations",
+	"XpltAI.NewMemory":                 "memory",
+	"XpltAI.NewSession":                "session",
+	"XpltAI.Proxy":                     "proxy",
+	"XpltAI.RecoverJournal":            "stream_journal",
+	"XpltAI.RemoveJournal":             "stream_journal",
+	"XpltAI.ResetUsage":                "usage_totals",
+	"XpltAI.Score":                     "score",
+	"XpltAI.SetDefaultGenOptions":      "",
+	"XpltAI.SetDefaultHeaders":         "",
+	"XpltAI.SetProbeMode":              "remote",
+	"XpltAI.SetRetryPolicy":            "retry_backoff",
+	"XpltAI.Shutdown":                  "shutdown",
+	"XpltAI.SplitTokens":               "chunking",
+	"XpltAI.StartupTimings":            "startup_timings",
+	"XpltAI.Stats":                     "hedging",
+	"XpltAI.SummarizeFrom":             "reader_prompts",
+	"XpltAI.Tokenize":                  "tokenize",
+	"XpltAI.Usage":                     "usage_totals",
+	"XpltAI.WaitUntilLoaded":           "",
+	"XpltAI.WaitUntilLoadedProgress":   "load_progress",
+	"XpltAI.WithInterrupt":             "interrupt",
+
+	"Session.ApplyCharacter":   "character_cards",
+	"Session.ApplyCharacterAs": "character_cards",
+	"Session.ChatFormat":       "prompt_formats",
+	"Session.Lorebook":         "lorebook",
+	"Session.Metadata":         "session_save",
+	"Session.Reset":            "session",
+	"Session.Save":             "session_save",
+	"Session.Send":             "session",
+	"Session.SendStream":       "session",
+	"Session.SetChatFormat":    "prompt_formats",
+	"Session.SetLorebook":      "lorebook",
+	"Session.SetMemory":        "memory",
+	"Session.SetMetadata":      "session_save",
+	"Session.SetStore":         "turn_store",
+	"Session.SetSystemPrompt":  "system_prompt",
+	"Session.SetTrimming":      "history_trimming",
+	"Session.SystemPrompt":     "system_prompt",
+	"Session.Trimming":         "history_trimming",
+
+	"Conversation.Alternatives": "branching",
+	"Conversation.Branch":       "branching",
+	"Conversation.Branches":     "branching",
+	"Conversation.MarshalJSON":  "branching",
+	"Conversation.Messages":     "conversations",
+	"Conversation.Regenerate":   "branching",
+	"Conversation.Say":          "conversations",
+	"Conversation.SayWithTools": "tools",
+
+	"Config.BasePath":               "remote",
+	"Config.BindHost":               "",
+	"Config.CacheReuse":             "",
+	"Config.ChatTemplate":           "chat_template",
+	"Config.ChatTemplateFile":       "chat_template",
+	"Config.CircuitCooldown":        "circuit_breaker",
+	"Config.CircuitFailures":        "circuit_breaker",
+	"Config.ContextSize":            "",
+	"Config.ContinuousBatching":     "",
+	"Config.CPUAffinity":            "resource_limits",
+	"Config.DebugPrompts":           "",
+	"Config.DefaultGenOptions":      "",
+	"Config.DisableWebUI":           "",
+	"Config.Embeddings":             "embeddings",
+	"Config.ExtraArgs":              "",
+	"Config.FirstRequestWait":       "",
+	"Config.GPULayers":              "",
+	"Config.Hardware":               "binary_flavors",
+	"Config.HedgeAfter":             "hedging",
+	"Config.HFModel":                "",
+	"Config.Jinja":                  "chat_template",
+	"Config.JournalDir":             "stream_journal",
+	"Config.LoopbackHost":           "",
+	"Config.MaxConcurrentRequests":  "request_queue",
+	"Config.MaxDownloadBytesPerSec": "download_limit",
+	"Config.MaxPromptBytes":         "",
+	"Config.MaxRawResponseBytes":    "",
+	"Config.MaxResponseBytes":       "",
+	"Config.MemoryLimitBytes":       "resource_limits",
+	"Config.ModelPolicy":            "model_policy",
+	"Config.Nice":                   "resource_limits",
+	"Config.Numa":                   "",
+	"Config.OnLifecycle":            "startup_timings",
+	"Config.ParallelSlots":          "",
+	"Config.Port":                   "",
+	"Config.PostProcessors":         "post_processors",
+	"Config.Redactor":               "redaction",
+	"Config.Retry":                  "retry_backoff",
+	"Config.RopeScale":              "",
+	"Config.SlotSavePath":           "shutdown",
+	"Config.StopForUpgrade":         "staged_upgrades",
+	"Config.StrictContext":          "model_info",
+	"Config.StrictOptions":          "strict_options",
+	"Config.TakeOver":               "",
+	"Config.Threads":                "",
+	"Config.ThreadsHTTP":            "",
+	"Config.TimeSlice":              "time_slicing",
+	"Config.Warmup":                 "warmup",
+	"Config.WorkDir":                "",
+
+	"GenOptions.AddBOS":            "special_tokens",
+	"GenOptions.CachePrompt":       "",
+	"GenOptions.Grammar":           "grammars",
+	"GenOptions.Idempotent":        "hedging",
+	"GenOptions.JSONOutput":        "json_output",
+	"GenOptions.JSONSchema":        "structured_output",
+	"GenOptions.LogitBias":         "logit_bias",
+	"GenOptions.Logprobs":          "logprobs",
+	"GenOptions.MaxTokens":         "",
+	"GenOptions.MaxTokensFraction": "",
+	"GenOptions.MinP":              "",
+	"GenOptions.N":                 "multiple_choices",
+	"GenOptions.ParseSpecial":      "special_tokens",
+	"GenOptions.Preset":            "presets",
+	"GenOptions.RepeatPenalty":     "",
+	"GenOptions.ReturnSpecial":     "special_tokens",
+	"GenOptions.Seed":              "deterministic",
+	"GenOptions.Stop":              "",
+	"GenOptions.Temperature":       "",
+	"GenOptions.Tools":             "tools",
+	"GenOptions.TimeBudget":        "time_budget",
+	"GenOptions.TopK":              "",
+	"GenOptions.TopP":              "",
+}
+
+// Types whose exported methods and fields are part of the feature table.
+var featureTypes = []string{"XpltAI", "Session", "Conversation"}
+var featureStructs = []string{"Config", "GenOptions"}
+
+// Exported functions, methods of featureTypes and fields of featureStructs
+// declared by the package, Context variants mapped to "cancel".
+func exportedAPI(t *testing.T) map[string]string {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := map[string]string{}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !d.Name.IsExported() {
+					continue
+				}
+				if d.Recv == nil {
+					api[d.Name.Name] = ""
+					continue
+				}
+				recv := d.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				ident, ok := recv.(*ast.Ident)
+				if ok && slices.Contains(featureTypes, ident.Name) {
+					api[ident.Name+"."+d.Name.Name] = ""
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || !slices.Contains(featureStructs, ts.Name.Name) {
+						continue
+					}
+					for _, field := range ts.Type.(*ast.StructType).Fields.List {
+						for _, n := range field.Names {
+							if n.IsExported() {
+								api[ts.Name.Name+"."+n.Name] = ""
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for name := range api {
+		base, ok := strings.CutSuffix(name, "Context")
+		if _, hasBase := api[base]; ok && hasBase {
+			api[name] = "cancel"
+			continue
+		}
+		api[name] = apiFeatures[name]
+	}
+	return api
+}
+
+// Fails when an exported capability lands without an entry in apiFeatures,
+// or when a feature in the table has nothing backing it.
+func TestFeatureTableCoversExportedAPI(t *testing.T) {
+	api := exportedAPI(t)
+
+	for name := range api {
+		_, listed := apiFeatures[name]
+		if !listed && api[name] != "cancel" {
+			t.Errorf("%s is not in apiFeatures, map it to its entry in the feature table or to \"\" for the base API", name)
+		}
+	}
+	for name := range apiFeatures {
+		if _, ok := api[name]; !ok {
+			t.Errorf("%s is in apiFeatures but no longer exported", name)
+		}
+	}
+
+	claimed := map[string]bool{}
+	for name, feature := range api {
+		if feature == "" {
+			continue
+		}
+		supported, ok := features[feature]
+		if !ok {
+			t.Errorf("%s belongs to feature %q missing from the feature table", name, feature)
+		} else if !supported {
+			t.Errorf("%s belongs to feature %q marked unsupported", name, feature)
+		}
+		claimed[feature] = true
+	}
+	for feature, supported := range features {
+		if supported && !claimed[feature] {
+			t.Errorf("feature %q is supported but nothing exported belongs to it", feature)
+		}
+	}
+}
+
+func TestFeaturesReturnsACopy(t *testing.T) {
+	f := Features()
+	f["streaming"] = false
+	delete(f, "tools")
+	if !Features()["streaming"] || !Features()["tools"] {
+		t.Error("changing the returned map changed the feature table")
+	}
+}
@@ -1,24 +1,31 @@
 package xplatai
 
 import (
-	"archive/zip"
-	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const lcp_VERSION = "b6209" // commit version
 const DEFAULT_HF_MODEL = "tensorblock/pygmalion-2-7b-GGUF:Q4_K_M"
+const DEFAULT_FIRST_REQUEST_WAIT = 45 * time.Second
+const DEFAULT_MAX_RESPONSE_BYTES = 64 << 20
+const DEFAULT_MAX_PROMPT_BYTES = 16 << 20
 
 type hostOs = int
 
@@ -71,226 +78,870 @@ type hostInfo struct {
 }
 
 type XpltAI struct {
-	proc   *exec.Cmd
-	client *http.Client
-	port   string
-	isConn bool
+	proc  procHandle
+	clock clock
+	// Server spawned by a previous process and adopted by this handle
+	adoptedPid int
+	client     *http.Client
+	port       string
+	// Candidate base urls, hostIdx picks the one that answered the health check
+	hosts      []string
+	hostIdx    atomic.Int32
+	hostLocked atomic.Bool
+	basePath   string
+	isConn     atomic.Bool
+	remote     bool
+	loads      *loadTracker
+
+	firstRequestWait time.Duration
+	probeMode        atomic.Int32
+	settings         atomic.Pointer[requestSettings]
+
+	maxRawResponseBytes int
+	maxResponseBytes    int64
+	maxPromptBytes      int64
+	hedgeAfter          time.Duration
+	parallelSlots       int
+	stats               handleStats
+	usage               usageCounter
+	lastTimings         atomic.Pointer[ServerTimings]
+	tokenCounts         tokenCountCache
+	redactor            Redactor
+	sched               *sliceScheduler
+	breaker             *circuitBreaker
+	onLifecycle         func(LifecycleEvent)
+	caps                capsCache
+	startup             *startupRecorder
+	slotSavePath        string
+	journalDir          string
+	postProcessors      []func(string) string
+	debugPrompts        bool
+	strictOptions       bool
+	strictChecked       atomic.Bool
+	model               string
+	ropeScaled          bool
+	// Adjustments made to the configuration, reported by ModelInfo
+	warnings []string
+
+	stopsMut sync.Mutex
+	stops    []string
+
+	life         context.Context
+	stopLife     context.CancelCauseFunc
+	lifeMu       sync.Mutex
+	shuttingDown bool
+	// Closed along with shuttingDown being set, see drainingCh
+	draining chan struct{}
+	inflight sync.WaitGroup
+
+	// Holds a token per generation request in flight, nil when unlimited
+	queue chan struct{}
 }
 
-func New(hfModelName string, port string) (*XpltAI, error) {
-	if hfModelName == "" {
-		hfModelName = DEFAULT_HF_MODEL
+// Starts a server for the model on port with the package defaults, adjusted
+// by opts.
+func New(hfModelName string, port string, opts ...Option) (*XpltAI, error) {
+	cfg := DefaultConfig()
+	cfg.HFModel = hfModelName
+	cfg.Port = port
+	return NewWithConfig(applyOptions(cfg, opts))
+}
+
+func NewWithConfig(cfg Config) (xai *XpltAI, err error) {
+	handlesCreated.Store(true)
+
+	stage := STAGE_RESOLVE
+	// Set once the server runs, xai is nil by the time the guard runs
+	var spawned procHandle
+
+	startup := &startupRecorder{current: time.Now()}
+	nextStage := func(next string) {
+		emitPhase(cfg.OnLifecycle, startup.end(stage, nil))
+		stage = next
 	}
 
-	xai := &XpltAI{}
+	// Never leak a spawned server or hand out a half-initialized handle
+	defer func() {
+		if err == nil {
+			return
+		}
+		if spawned != nil {
+			spawned.Kill()
+			spawned.Wait()
+		}
+		emitPhase(cfg.OnLifecycle, startup.end(stage, err))
+		xai = nil
+		err = &StageError{Stage: stage, Err: err, Timings: startup.timings}
+	}()
+
+	cfg, spec, err := resolveConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkModelPolicy(cfg.ModelPolicy, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchCtx := context.Background()
+	if cfg.MaxDownloadBytesPerSec > 0 {
+		fetchCtx = withDownloadLimiter(fetchCtx, newRateLimiter(cfg.MaxDownloadBytesPerSec))
+	}
+
+	nextStage(STAGE_LOCATE)
+	xai = &XpltAI{}
+	xai.startup = startup
+	xai.clock = cfg.clock
+	if xai.clock == nil {
+		xai.clock = realClock{}
+	}
 
 	xai.client = &http.Client{}
-	xai.port = port
+	xai.port = cfg.Port
+	xai.hosts = serverUrls(cfg.BindHost, cfg.LoopbackHost, cfg.Port)
+	xai.basePath = cfg.BasePath
+	xai.firstRequestWait = cfg.FirstRequestWait
+	xai.settings.Store(&requestSettings{genOptions: cloneGenOptions(cfg.DefaultGenOptions), retry: cfg.Retry})
+	xai.maxRawResponseBytes = cfg.MaxRawResponseBytes
+	xai.maxResponseBytes = cfg.MaxResponseBytes
+	xai.maxPromptBytes = cfg.MaxPromptBytes
+	xai.hedgeAfter = cfg.HedgeAfter
+	xai.redactor = cfg.Redactor
+	xai.parallelSlots = cfg.ParallelSlots
+	if limit := cmp.Or(cfg.MaxConcurrentRequests, cfg.ParallelSlots); limit > 0 {
+		xai.queue = make(chan struct{}, limit)
+	}
+	xai.onLifecycle = cfg.OnLifecycle
+	xai.slotSavePath = cfg.SlotSavePath
+	xai.journalDir = cfg.JournalDir
+	xai.postProcessors = slices.Clone(cfg.PostProcessors)
+	xai.debugPrompts = cfg.DebugPrompts
+	xai.strictOptions = cfg.StrictOptions
+	xai.ropeScaled = cfg.RopeScale > 0
+	xai.life, xai.stopLife = context.WithCancelCause(context.Background())
+	if cfg.TimeSlice > 0 {
+		xai.sched = newSliceScheduler(cfg.TimeSlice)
+	}
+	if cfg.CircuitFailures > 0 {
+		xai.breaker = newCircuitBreaker(cfg.CircuitFailures, cfg.CircuitCooldown)
+	}
+
+	for _, warning := range activatePendingUpgrades(xai.clock, cfg.StopForUpgrade) {
+		xai.warnings = append(xai.warnings, xai.redactInstallPaths(warning))
+	}
+
+	flavor := pickFlavor(cfg.Hardware)
+	serverPath, err := flavorBinary(flavor, "llama-server.exe")
+	if err != nil && flavor != cpuFlavor() {
+		if cpuPath, cpuErr := flavorBinary(cpuFlavor(), "llama-server.exe"); cpuErr == nil {
+			xai.downgrade(flavor, cpuFlavor(), err)
+			flavor, serverPath, err = cpuFlavor(), cpuPath, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	cwd, err := os.Getwd()
+	nextStage(STAGE_MODEL)
+	spec, err = pinRevision(fetchCtx, spec)
 	if err != nil {
-		return xai, err
+		return nil, err
+	}
+	if spec.IsLocal() {
+		if exists, _ := isPathExist(spec.Source); !exists {
+			return nil, fmt.Errorf("%w: %s does not exist", ErrModelFetch, xai.redact().RedactPath(spec.Source))
+		}
 	}
 
-	serverPath := path.Join(cwd, "llamacpp", "llama-server.exe")
-	exists, _ := isPathExist(serverPath)
-	if !exists {
-		return xai, errors.New("could not find llama.cpp binaries, run DownloadRequirements to fix")
+	cfg, ctxWarning, err := negotiateContext(cfg, spec)
+	if err != nil {
+		return nil, err
+	}
+	if ctxWarning != "" {
+		xai.warnings = append(xai.warnings, ctxWarning)
 	}
+	xai.model = spec.Source
 
-	xai.proc = exec.Command(
-		serverPath,
-		"-hf", hfModelName,
-		"--port", port,
-		"--threads", "6",
-		"--gpu-layers", "999",
-	)
+	nextStage(STAGE_SPAWN)
 
-	err = xai.proc.Start()
+	previous, err := xai.handlePreviousServer(cfg, spec.HFRef())
 	if err != nil {
-		return xai, err
+		return nil, err
+	}
+	if previous != nil {
+		// Already loaded, it answered its health check
+		xai.adoptedPid = previous.Pid
+		// Now owned by this process, other handles must not adopt it too
+		writePidFile(*previous)
+		nextStage(STAGE_LOAD)
+		xai.emit(EVENT_ADOPTED, strconv.Itoa(previous.Pid))
+		return xai, nil
+	}
+
+	xai.loads = newLoadTracker()
+	newProc := cfg.newProc
+	if newProc == nil {
+		newProc = newExecProc
+	}
+	limits := procLimits{nice: cfg.Nice, cpuAffinity: cfg.CPUAffinity, memoryLimitBytes: cfg.MemoryLimitBytes}
+	spawn := func(serverPath string) error {
+		xai.proc = newProc(serverPath, serverArgs(cfg, spec), cfg.WorkDir, xai.loads, limits)
+		return xai.proc.Start()
+	}
+
+	err = spawn(serverPath)
+	// Limits fail the same with every flavor and are no sign of quarantine
+	if err != nil && !errors.Is(err, errLimits) && flavor != cpuFlavor() {
+		if cpuPath, cpuErr := flavorBinary(cpuFlavor(), "llama-server.exe"); cpuErr == nil {
+			xai.downgrade(flavor, cpuFlavor(), err)
+			flavor = cpuFlavor()
+			err = spawn(cpuPath)
+		}
+	}
+	if errors.Is(err, errLimits) {
+		return nil, err
+	}
+	if err != nil {
+		if qErr := quarantineError(flavor, "llama-server.exe", err); qErr != nil {
+			return nil, qErr
+		}
+		return nil, err
+	}
+	spawned = xai.proc
+
+	pid := xai.proc.Pid()
+	startTime, err := processStartTime(pid)
+	if err == nil {
+		writePidFile(pidFile{Pid: pid, StartTime: startTime, Port: cfg.Port, Model: spec.HFRef(), Url: xai.hosts[0]})
+	}
+	err = nil
+
+	nextStage(STAGE_LOAD)
+	xai.emit(EVENT_SPAWNED, strconv.Itoa(xai.proc.Pid()))
+
+	if cfg.Warmup > 0 {
+		err = xai.warmup(cfg.Warmup, nextStage)
+		if err != nil {
+			return nil, err
+		}
+		emitPhase(cfg.OnLifecycle, startup.end(STAGE_WARMUP, nil))
 	}
 	return xai, nil
 }
 
+func NewRemote(baseUrl string) (*XpltAI, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("remote url must use http or https")
+	}
+	if u.Host == "" {
+		return nil, errors.New("remote url is missing a host")
+	}
+
+	handlesCreated.Store(true)
+
+	xai := &XpltAI{}
+	xai.startup = &startupRecorder{}
+	xai.clock = realClock{}
+	xai.client = &http.Client{}
+	xai.port = u.Port()
+	xai.hosts = []string{u.Scheme + "://" + u.Host}
+	xai.basePath = u.Path
+	xai.remote = true
+	xai.firstRequestWait = DefaultConfig().FirstRequestWait
+	// Replicas behind a load balancer can go away between requests
+	xai.settings.Store(&requestSettings{retry: RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     500 * time.Millisecond,
+		Multiplier:  2,
+		Jitter:      0.2,
+		RetryOn503:  true,
+	}})
+	xai.life, xai.stopLife = context.WithCancelCause(context.Background())
+	return xai, nil
+}
+
+// Kills the server immediately, in-flight requests fail with ErrShuttingDown.
+// See Shutdown for a draining variant.
 func (x *XpltAI) Close() error {
-	return x.proc.Process.Kill()
+	x.lifeMu.Lock()
+	x.startDraining()
+	x.lifeMu.Unlock()
+	x.stopLife(ErrShuttingDown)
+
+	if x.adoptedPid != 0 {
+		defer removePidFile(x.port, x.adoptedPid)
+		proc, err := os.FindProcess(x.adoptedPid)
+		if err != nil {
+			return err
+		}
+		err = proc.Kill()
+		x.emit(EVENT_CLOSED, "")
+		return err
+	}
+
+	if x.proc == nil {
+		return nil
+	}
+	defer removePidFile(x.port, x.proc.Pid())
+	err := x.proc.Kill()
+	x.emit(EVENT_CLOSED, "")
+	return err
+}
+
+// Builds the url of a server endpoint relative to the handle's base path.
+func (x *XpltAI) url(endpoint string) string {
+	return x.hostUrl(int(x.hostIdx.Load()), endpoint)
+}
+
+func (x *XpltAI) hostUrl(idx int, endpoint string) string {
+	base := strings.Trim(x.basePath, "/")
+	endpoint = strings.TrimLeft(endpoint, "/")
+
+	if base == "" {
+		return x.hosts[idx] + "/" + endpoint
+	}
+	return x.hosts[idx] + "/" + base + "/" + endpoint
 }
 
 func (x *XpltAI) WaitUntilLoaded(timeout time.Duration) error {
+	return x.WaitUntilLoadedContext(context.Background(), timeout)
+}
+
+// WaitUntilLoaded that also gives up when ctx is done.
+func (x *XpltAI) WaitUntilLoadedContext(ctx context.Context, timeout time.Duration) error {
 	if timeout.Milliseconds() <= 0 {
 		timeout = 99 * time.Minute
 	}
 
-	timeoutTime := time.Now().Add(timeout)
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrLoadTimeout)
+	defer cancel()
 
-	for time.Now().Before(timeoutTime) {
-		resp, err := x.client.Head("http://127.0.0.1:" + x.port + "/health")
-		if err == nil && resp.StatusCode < 500 {
-			return nil
-		}
-		time.Sleep(time.Second * 1)
+	err := x.WaitUntilLoadedProgress(ctx, nil)
+	if errors.Is(context.Cause(ctx), ErrLoadTimeout) {
+		return ErrLoadTimeout
 	}
-	return errors.New("time out")
+	return err
 }
 
-func (x *XpltAI) Chat(messages []map[string]string, maxTokens int) (string, error) {
-	if maxTokens <= 0 {
-		maxTokens = 150
-	}
+func (x *XpltAI) Chat(messages []Message, maxTokens int) (ChatResponse, error) {
+	return x.ChatWithOptions(messages, GenOptions{MaxTokens: maxTokens})
+}
 
-	if !x.isConn {
-		// Test for availability
-		for i := range 10 {
-			time.Sleep(time.Second * time.Duration(i))
-			resp, err := x.client.Head("http://127.0.0.1:" + x.port + "/health")
-			if err == nil && resp.StatusCode < 500 {
-				break
-			}
+func (x *XpltAI) ChatWithOptions(messages []Message, opts GenOptions) (ChatResponse, error) {
+	return x.chat(context.Background(), messages, opts)
+}
 
-			if i == 9 {
-				return "", errors.New("timed out while waiting for llama.cpp")
-			}
-		}
+// Deprecated: ChatWithOptions returns the same response.
+func (x *XpltAI) ChatDetailed(messages []Message, opts GenOptions) (ChatResponse, error) {
+	return x.chat(context.Background(), messages, opts)
+}
+
+// Chat that stops waiting for the server and generating when ctx is done.
+func (x *XpltAI) ChatContext(ctx context.Context, messages []Message, maxTokens int) (ChatResponse, error) {
+	return x.ChatWithOptionsContext(ctx, messages, GenOptions{MaxTokens: maxTokens})
+}
+
+func (x *XpltAI) ChatWithOptionsContext(ctx context.Context, messages []Message, opts GenOptions) (ChatResponse, error) {
+	return x.chat(ctx, messages, opts)
+}
+
+func (x *XpltAI) chat(ctx context.Context, messages []Message, opts GenOptions) (ChatResponse, error) {
+	return x.doChat(ctx, chatRequest{messages: messages, opts: opts})
+}
+
+type chatRequest struct {
+	// Conversation the request belongs to for the scheduler, nil for
+	// standalone requests
+	key      any
+	messages []Message
+	opts     GenOptions
+	// Streams the reply when set
+	onDelta func(delta string)
+	// Partial assistant reply to continue from
+	prefill string
+}
+
+func (x *XpltAI) doChat(ctx context.Context, req chatRequest) (result ChatResponse, err error) {
+	ctx, end, err := x.beginRequest(ctx)
+	if err != nil {
+		return result, err
 	}
+	defer func() { err = end(err) }()
 
-	data := map[string]any{
-		"messages":   messages,
-		"max_tokens": maxTokens,
-		"stop": []string{
-			"<|",
-		},
+	ctx, usage := withUsageRecorder(ctx)
+	defer func() {
+		result.AttemptsUsage, result.TotalUsage = usage.result()
+		x.usage.add(result.TotalUsage)
+	}()
+
+	ctx = x.captureSettings(ctx)
+
+	req.opts, err = x.resolveOptions(ctx, req.opts)
+	if err != nil {
+		return result, err
 	}
 
-	b, err := json.Marshal(data)
+	err = x.checkStrictOptions(ctx, req.opts)
 	if err != nil {
-		return "", err
+		return result, err
 	}
-	reqBody := bytes.NewBuffer(b)
 
-	req, err := http.NewRequest("POST", "http://127.0.0.1:"+x.port+"/v1/chat/completions", reqBody)
+	req.opts, err = x.resolveMaxTokens(ctx, req.opts, func() ([]int, error) {
+		prompt, err := x.applyTemplate(ctx, req.messages, req.prefill)
+		if err != nil {
+			return nil, err
+		}
+		return x.tokenize(ctx, prompt, true)
+	})
 	if err != nil {
-		return "", err
+		return result, err
+	}
+
+	requestId := newRequestId()
+
+	if req.onDelta != nil && x.journalDir != "" {
+		// Not err, finish needs the request's own error
+		j, journalErr := openJournal(x.journalDir, requestId, req.messages, req.prefill, x.redact())
+		if journalErr != nil {
+			return result, journalErr
+		}
+		onDelta := req.onDelta
+		req.onDelta = func(delta string) {
+			j.append(delta)
+			onDelta(delta)
+		}
+		defer func() { j.finish(err == nil) }()
+	}
+
+	prompt := ""
+	if x.debugPrompts {
+		prompt = x.renderPrompt(ctx, req.messages, req.prefill)
+	}
+
+	generate := func() (ChatResponse, error) {
+		if x.sched != nil {
+			return x.sched.chat(ctx, x, req)
+		}
+		messages := req.messages
+		if req.prefill != "" {
+			messages = withAssistantPrefill(messages, req.prefill)
+		}
+		result, err := x.chatOnce(ctx, messages, req.opts, req.onDelta)
+		result.Content = req.prefill + result.Content
+		for i := range result.Choices {
+			result.Choices[i].Content = req.prefill + result.Choices[i].Content
+		}
+		return result, err
 	}
 
-	resp, err := x.client.Do(req)
+	// The slice scheduler already sends one request at a time
+	if x.sched == nil {
+		release, err := x.acquireSlot(ctx)
+		if err != nil {
+			return result, err
+		}
+		defer release()
+	}
+
+	result, err = generate()
+	if err == nil && req.opts.JSONOutput && req.onDelta == nil && !validJSONReply(result.Content) {
+		result, err = generate()
+	}
+	if err == nil && req.opts.JSONOutput && !validJSONReply(result.Content) {
+		err = ErrInvalidJSON
+	}
+	result.RequestId = requestId
+	result.Prompt = prompt
+	result.MaxTokens = req.opts.MaxTokens
+	result.RawContent = strings.TrimSpace(result.Content)
+	result.Content = x.postProcess(result.RawContent)
+	for i := range result.Choices {
+		c := &result.Choices[i]
+		c.RawContent = strings.TrimSpace(c.Content)
+		c.Content = x.postProcess(c.RawContent)
+	}
+	return result, err
+}
+
+// Sends a single chat request with already resolved options, the content is
+// returned untrimmed.
+func (x *XpltAI) chatOnce(ctx context.Context, messages []Message, opts GenOptions, onDelta func(string)) (ChatResponse, error) {
+	result := ChatResponse{}
+
+	var err error
+	result.Timing.Wait, err = x.waitReady(ctx)
 	if err != nil {
-		return "", err
+		return result, &RequestError{Timing: result.Timing, Err: err}
+	}
+
+	// Time budgets cut the stream short, keeping what was generated
+	if opts.TimeBudget > 0 && onDelta == nil {
+		onDelta = func(string) {}
+	}
+
+	if onDelta != nil {
+		streamCtx, cancel := withTimeBudget(ctx, opts.TimeBudget)
+		defer cancel()
+
+		timing := result.Timing
+		genStart := time.Now()
+		result, err = x.chatStreamOnce(streamCtx, messages, opts, onDelta)
+		timing.Generation = time.Since(genStart)
+		result.Timing = timing
+		if err != nil && timeBudgetElapsed(streamCtx) {
+			result.FinishReason = FINISH_TIME_BUDGET
+			err = nil
+		} else if err != nil && interrupted(ctx) {
+			result.FinishReason = FINISH_CANCELLED
+			err = nil
+		}
+		if err != nil {
+			return result, &RequestError{Timing: result.Timing, Err: err}
+		}
+		x.isConn.Store(true)
+		return result, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	data := map[string]any{
+		"messages": messages,
+	}
+	opts.apply(data, _ENDPOINT_OPENAI)
+
+	genStart := time.Now()
+	body, err := hedge(x, ctx, opts, func(ctx context.Context) (json.RawMessage, error) {
+		var body json.RawMessage
+		err := x.doJSON(ctx, "POST", "/v1/chat/completions", data, &body)
+		if err == nil {
+			recordUsage(ctx, bodyUsage(body))
+		}
+		return body, err
+	})
+	result.Timing.Generation = time.Since(genStart)
 	if err != nil {
-		return "", err
+		return result, &RequestError{Timing: result.Timing, Err: err}
 	}
 
-	// Check if json
-	if len(body) > 0 && body[0] != '{' {
-		return "", errors.New(string(body))
+	if x.maxRawResponseBytes > 0 {
+		result.RawBody = body[:min(len(body), x.maxRawResponseBytes)]
+		result.RawBodyTruncated = len(body) > x.maxRawResponseBytes
 	}
 
 	jsonData := make(map[string]any, 8)
 
 	err = json.Unmarshal(body, &jsonData)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
 	choices, ok := jsonData["choices"].([]any)
 	if !ok {
-		return "", errors.New("json parsing failure, missing choices field")
+		return result, errors.New("json parsing failure, missing choices field")
 	}
 
 	if len(choices) == 0 {
-		return "", errors.New("json parsing failure, field choices is empty")
+		return result, errors.New("json parsing failure, field choices is empty")
 	}
 
 	choice, ok := choices[0].(map[string]any)
 	if !ok {
-		return "", errors.New("json parsing failure, failed to get choice")
+		return result, errors.New("json parsing failure, failed to get choice")
 	}
 
 	message, ok := choice["message"].(map[string]any)
 	if !ok {
-		return "", errors.New("json parsing failure, missing message field")
+		return result, errors.New("json parsing failure, missing message field")
 	}
 
+	// Replies that only call tools have no content
 	content, ok := message["content"].(string)
-	if !ok {
-		return "", errors.New("json parsing failure, missing content field")
+	if _, calls := message["tool_calls"]; !ok && !calls {
+		return result, errors.New("json parsing failure, missing content field")
 	}
 
-	x.isConn = true
-	return strings.TrimSpace(content), nil
-}
-
-func (x *XpltAI) Complete(prompt string, maxTokens int) (string, error) {
-	if maxTokens <= 0 {
-		maxTokens = 150
+	rawChoices := struct {
+		Choices []struct {
+			Message      json.RawMessage `json:"message"`
+			FinishReason string          `json:"finish_reason"`
+			Logprobs     *choiceLogprobs `json:"logprobs"`
+		} `json:"choices"`
+	}{}
+	json.Unmarshal(body, &rawChoices)
+	result.RawMessage = rawChoices.Choices[0].Message
+	if lp := rawChoices.Choices[0].Logprobs; lp != nil {
+		result.Logprobs = lp.Content
 	}
 
-	if !x.isConn {
-		// Test for availability
-		for i := range 10 {
-			time.Sleep(time.Second * time.Duration(i))
-			resp, err := x.client.Head("http://127.0.0.1:" + x.port + "/health")
-			if err == nil && resp.StatusCode < 500 {
-				break
-			}
+	if len(rawChoices.Choices) > 1 {
+		for _, raw := range rawChoices.Choices {
+			msg := Message{}
+			json.Unmarshal(raw.Message, &msg)
 
-			if i == 9 {
-				return "", errors.New("timed out while waiting for llama.cpp")
+			c := ChatChoice{Content: msg.Content, FinishReason: raw.FinishReason, RawMessage: raw.Message, ToolCalls: msg.ToolCalls}
+			if raw.Logprobs != nil {
+				c.Logprobs = raw.Logprobs.Content
 			}
+			result.Choices = append(result.Choices, c)
 		}
 	}
 
-	data := map[string]any{
-		"prompt":    prompt,
-		"n_predict": maxTokens,
-		"stop": []string{
-			"<|",
-		},
+	result.FinishReason, _ = choice["finish_reason"].(string)
+	result.CachedTokens = cachedTokens(jsonData)
+	if t, ok := parseTimings(jsonData); ok {
+		result.ServerTimings = t
+		x.recordTimings(t)
 	}
+	usage := bodyUsage(body)
+	result.PromptTokens = usage.PromptTokens
+	result.CompletionTokens = usage.CompletionTokens
+	result.Content = content
+
+	x.isConn.Store(true)
+	return result, nil
+}
+
+func (x *XpltAI) Complete(prompt string, maxTokens int) (string, error) {
+	return x.CompleteWithOptions(prompt, GenOptions{MaxTokens: maxTokens})
+}
+
+func (x *XpltAI) CompleteWithOptions(prompt string, opts GenOptions) (string, error) {
+	return x.CompleteWithOptionsContext(context.Background(), prompt, opts)
+}
+
+func (x *XpltAI) CompleteDetailed(prompt string, opts GenOptions) (CompleteResponse, error) {
+	return x.complete(context.Background(), prompt, opts)
+}
+
+// Complete that stops waiting for the server and generating when ctx is done.
+func (x *XpltAI) CompleteContext(ctx context.Context, prompt string, maxTokens int) (string, error) {
+	return x.CompleteWithOptionsContext(ctx, prompt, GenOptions{MaxTokens: maxTokens})
+}
 
-	b, err := json.Marshal(data)
+func (x *XpltAI) CompleteWithOptionsContext(ctx context.Context, prompt string, opts GenOptions) (string, error) {
+	resp, err := x.complete(ctx, prompt, opts)
 	if err != nil {
 		return "", err
 	}
-	reqBody := bytes.NewBuffer(b)
+	return resp.Content, nil
+}
+
+func (x *XpltAI) CompleteDetailedContext(ctx context.Context, prompt string, opts GenOptions) (CompleteResponse, error) {
+	return x.complete(ctx, prompt, opts)
+}
 
-	req, err := http.NewRequest("POST", "http://127.0.0.1:"+x.port+"/completion", reqBody)
+// prompt is a string, a json.RawMessage holding an encoded json string or
+// prompt tokens.
+func (x *XpltAI) complete(ctx context.Context, prompt any, opts GenOptions) (CompleteResponse, error) {
+	return x.doComplete(ctx, completionRequest{endpoint: "/completion", prompt: prompt}, opts)
+}
+
+// Request to /completion or an endpoint answering like it.
+type completionRequest struct {
+	endpoint string
+	// See complete
+	prompt any
+	// Sent along with the prompt
+	extra map[string]any
+	// Text in extra the server evaluates too, counted for MaxTokensFraction
+	extraText string
+	// Returns the content untrimmed and without post-processing
+	raw bool
+	// Streams the content when set
+	onDelta func(string)
+}
+
+func (x *XpltAI) doComplete(ctx context.Context, req completionRequest, opts GenOptions) (result CompleteResponse, err error) {
+	prompt := req.prompt
+
+	ctx, end, err := x.beginRequest(ctx)
 	if err != nil {
-		return "", err
+		return result, err
 	}
+	defer func() { err = end(err) }()
 
-	resp, err := x.client.Do(req)
+	ctx, usage := withUsageRecorder(ctx)
+	defer func() {
+		result.AttemptsUsage, result.TotalUsage = usage.result()
+		x.usage.add(result.TotalUsage)
+	}()
+
+	ctx = x.captureSettings(ctx)
+
+	opts, err = x.resolveOptions(ctx, opts)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	result.Timing.Wait, err = x.waitReady(ctx)
 	if err != nil {
-		return "", err
+		return result, &RequestError{Timing: result.Timing, Err: err}
 	}
 
-	// Check if json
-	if len(body) > 0 && body[0] != '{' {
-		return "", errors.New(string(body))
+	err = x.checkStrictOptions(ctx, opts)
+	if err != nil {
+		return result, err
 	}
 
-	jsonData := make(map[string]any, 8)
+	prompt, err = x.tokenizePrompt(ctx, prompt, opts)
+	if err != nil {
+		return result, err
+	}
 
-	err = json.Unmarshal(body, &jsonData)
+	opts, err = x.resolveMaxTokens(ctx, opts, func() ([]int, error) {
+		if tokens, ok := prompt.([]int); ok {
+			return tokens, nil
+		}
+		text, err := promptText(prompt)
+		if err != nil {
+			return nil, err
+		}
+		return x.tokenize(ctx, text+req.extraText, true)
+	})
 	if err != nil {
-		return "", err
+		return result, err
+	}
+	result.MaxTokens = opts.MaxTokens
+
+	data := map[string]any{
+		"prompt": prompt,
+	}
+	maps.Copy(data, req.extra)
+	opts.apply(data, _ENDPOINT_NATIVE)
+	if opts.ReturnSpecial {
+		data["return_tokens"] = true
+	}
+
+	release, err := x.acquireSlot(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	jsonData := make(map[string]any, 8)
+	budgetElapsed := false
+
+	genStart := time.Now()
+	if opts.TimeBudget > 0 || req.onDelta != nil {
+		jsonData, budgetElapsed, err = x.completeStream(ctx, req.endpoint, data, opts.TimeBudget, req.onDelta)
+	} else {
+		jsonData, err = hedge(x, ctx, opts, func(ctx context.Context) (map[string]any, error) {
+			jsonData := make(map[string]any, 8)
+			err := x.doJSON(ctx, "POST", req.endpoint, data, &jsonData)
+			if err == nil {
+				recordUsage(ctx, completionUsage(jsonData))
+			}
+			return jsonData, err
+		})
+	}
+	result.Timing.Generation = time.Since(genStart)
+	if err != nil {
+		return result, &RequestError{Timing: result.Timing, Err: err}
 	}
 
 	content, ok := jsonData["content"].(string)
 	if !ok {
-		return "", errors.New("json parsing failure, missing content field")
+		return result, errors.New("json parsing failure, missing content field")
 	}
 
-	x.isConn = true
-	return strings.TrimSpace(content), nil
+	result.FinishReason = "stop"
+	if limit, _ := jsonData["stopped_limit"].(bool); limit {
+		result.FinishReason = "length"
+	} else if budgetElapsed {
+		result.FinishReason = FINISH_TIME_BUDGET
+	}
+	result.CachedTokens = cachedTokens(jsonData)
+	if t, ok := parseTimings(jsonData); ok {
+		result.ServerTimings = t
+		x.recordTimings(t)
+	}
+	n, _ := jsonData["tokens_evaluated"].(float64)
+	result.PromptTokens = int(n)
+	n, _ = jsonData["tokens_predicted"].(float64)
+	result.CompletionTokens = int(n)
+	result.Logprobs = completionLogprobs(jsonData)
+	if x.debugPrompts {
+		// Older servers don't echo the prompt back
+		result.Prompt, ok = jsonData["prompt"].(string)
+		if !ok {
+			result.Prompt, _ = promptText(prompt)
+		}
+	}
+	if opts.ReturnSpecial {
+		content, err = x.specialContent(ctx, jsonData)
+		if err != nil {
+			return result, err
+		}
+	}
+	if req.raw {
+		result.RawContent, result.Content = content, content
+	} else {
+		result.RawContent = strings.TrimSpace(content)
+		result.Content = x.postProcess(result.RawContent)
+	}
+
+	x.isConn.Store(true)
+	return result, nil
+}
+
+// Reads timings.cache_n from a llama-server response.
+func cachedTokens(jsonData map[string]any) int {
+	timings, ok := jsonData["timings"].(map[string]any)
+	if !ok {
+		return 0
+	}
+	n, _ := timings["cache_n"].(float64)
+	return int(n)
+}
+
+// Waits for the server to answer its health check, for at most the handle's
+// first request wait. Returns how long was spent waiting.
+func (x *XpltAI) waitReady(ctx context.Context) (time.Duration, error) {
+	switch ProbeMode(x.probeMode.Load()) {
+	case PROBE_OFF:
+		return 0, nil
+	case PROBE_ONCE:
+		if x.isConn.Load() {
+			return 0, nil
+		}
+	}
+	return x.waitHealthy(ctx, x.firstRequestWait)
+}
+
+// Probes the server's health until it answers, for at most wait.
+func (x *XpltAI) waitHealthy(ctx context.Context, wait time.Duration) (time.Duration, error) {
+	start := x.clock.Now()
+	deadline := start.Add(wait)
+
+	for {
+		status, err := x.healthStatus(ctx)
+		if err == nil && status < 500 {
+			x.recordLoad(nil)
+			return x.since(start), nil
+		}
+
+		// Requests still waiting for the server count as queued
+		x.lifeMu.Lock()
+		shuttingDown := x.shuttingDown
+		x.lifeMu.Unlock()
+		if shuttingDown {
+			return x.since(start), ErrShuttingDown
+		}
+
+		remaining := deadline.Sub(x.clock.Now())
+		if remaining <= 0 {
+			x.recordLoad(ErrServerNotReady)
+			return x.since(start), ErrServerNotReady
+		}
+
+		err = x.sleep(ctx, min(remaining, 250*time.Millisecond))
+		if err != nil {
+			return x.since(start), err
+		}
+	}
 }
 
 func isPathExist(entPath string) (bool, error) {
@@ -305,13 +956,8 @@ func isPathExist(entPath string) (bool, error) {
 }
 
 func isLlamCppServerExist() (bool, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return false, err
-	}
-
-	serverPath := path.Join(cwd, "llamacpp", "llama-server.exe")
-	return isPathExist(serverPath)
+	_, err := flavorBinary(pickFlavor(nil), "llama-server.exe")
+	return err == nil, nil
 }
 
 func IsRequDownloaded() bool {
@@ -319,7 +965,16 @@ func IsRequDownloaded() bool {
 	return exists
 }
 
-func getDownloadUrl(hardware HostHardware) (string, error) {
+func getDownloadUrl(ctx context.Context, hardware HostHardware) (string, error) {
+	assetName, err := releaseAssetName(hardware, lcp_VERSION)
+	if err != nil {
+		return "", err
+	}
+	return resolveAssetUrl(ctx, lcp_VERSION, assetName)
+}
+
+// Name of the release asset matching the host and hardware, without extension.
+func releaseAssetName(hardware HostHardware, version string) (string, error) {
 	host := hostInfo{}
 
 	switch runtime.GOOS {
@@ -330,7 +985,7 @@ func getDownloadUrl(hardware HostHardware) (string, error) {
 	case "linux":
 		host.opSys = _OS_UBUNTU
 	default:
-		return "", errors.New("unsupported operating system")
+		return "", fmt.Errorf("%w: %s operating system", ErrUnsupportedPlatform, runtime.GOOS)
 	}
 
 	switch runtime.GOARCH {
@@ -339,156 +994,106 @@ func getDownloadUrl(hardware HostHardware) (string, error) {
 	case "arm64":
 		host.arch = _ARCH_ARM
 	default:
-		return "", errors.New("unsupported cpu architecture")
+		return "", fmt.Errorf("%w: %s cpu architecture", ErrUnsupportedPlatform, runtime.GOARCH)
 	}
 
-	switch host.opSys {
-	case _OS_WIN:
-		if hardware == HW_NONE {
-			host.hardware = HW_CPU
-		} else {
-			host.hardware = hardware
-		}
-	case _OS_MAC:
-		host.hardware = HW_NONE
-	case _OS_UBUNTU:
-		if hardware == HW_VULKAN {
-			host.hardware = HW_VULKAN
-		} else {
-			host.hardware = HW_NONE
-		}
-	}
+	host.hardware = platformHardware(hardware)
 
-	releaseName := "https://github.com/ggml-org/llama.cpp/releases/download/" +
-		lcp_VERSION + "/llama-" + lcp_VERSION + "-bin-" +
-		osNames[host.opSys] + "-"
+	assetName := "llama-" + version + "-bin-" + osNames[host.opSys] + "-"
 
 	if host.hardware != HW_NONE {
-		releaseName += hwNames[host.hardware] + "-"
+		assetName += hwNames[host.hardware] + "-"
 	}
 
-	releaseName += archNames[host.arch] + ".zip"
-	return releaseName, nil
+	assetName += archNames[host.arch]
+	return assetName, nil
 }
 
 func DownloadRequirements(hardware HostHardware) error {
-	url, err := getDownloadUrl(hardware)
+	return DownloadRequirementsWithProgress(hardware, nil)
+}
+
+// progress is called with the bytes downloaded so far and the total size,
+// total is -1 when the server doesn't report it and PROGRESS_INSTALL_LOCKED
+// while another process is installing.
+func DownloadRequirementsWithProgress(hardware HostHardware, progress func(done int64, total int64)) error {
+	return DownloadRequirementsContext(context.Background(), hardware, progress)
+}
+
+// DownloadRequirementsWithProgress that gives up when ctx is done, keeping
+// the installed version. Interrupted downloads resume on the next attempt.
+func DownloadRequirementsContext(ctx context.Context, hardware HostHardware, progress func(done int64, total int64)) error {
+	url, err := getDownloadUrl(ctx, hardware)
 	if err != nil {
 		return err
 	}
 	fmt.Println("Downloading llama.cpp from:", url)
 
-	cwd, err := os.Getwd()
+	lock, err := acquireInstallLock(ctx, realClock{}, progress)
 	if err != nil {
 		return err
 	}
+	defer lock.release()
 
-	dirPath := path.Join(cwd, "llamacpp")
-	err = os.RemoveAll(dirPath)
-	if err != nil {
-		return err
-	}
-	err = os.MkdirAll(dirPath, os.ModeDir)
+	hardware = platformHardware(hardware)
+	dirPath, err := prepareStagingDir(hardware)
 	if err != nil {
 		return err
 	}
 
-	client := http.Client{}
-	resp, err := client.Get(url)
+	zipPath := filepath.Join(dirPath, "llamacpp"+archiveExt(url))
+	_, _, err = downloadFile(ctx, url, zipPath, "", func(done int64, total int64) {
+		if progress != nil {
+			progress(done, total)
+		}
+	})
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode >= 300 {
-		errMsg, _ := io.ReadAll(resp.Body)
-		return errors.New(string(errMsg))
-	}
-	defer resp.Body.Close()
 
-	zipPath := path.Join(cwd, "llamacpp", "llamacpp.zip")
-	zipf, err := os.OpenFile(zipPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	files, err := extractArchive(zipPath, dirPath)
 	if err != nil {
 		return err
 	}
-	defer zipf.Close()
-
-	buff := make([]byte, 512)
+	return activateFlavor(realClock{}, hardware, stagedInstall{Version: lcp_VERSION, Files: files}, DefaultConfig().StopForUpgrade)
+}
 
-	for {
-		n, err := resp.Body.Read(buff)
-		if n > 0 {
-			_, err2 := zipf.Write(buff[:n])
-			if err2 != nil {
-				return err2
-			}
-		}
+func PreFetchModel(hfModelName string) error {
+	return PreFetchModelContext(context.Background(), hfModelName)
+}
 
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
+// PreFetchModel that stops downloading when ctx is done.
+func PreFetchModelContext(ctx context.Context, hfModelName string) error {
+	if hfModelName == "" {
+		hfModelName = DefaultConfig().HFModel
 	}
-	zipf.Close()
 
-	archive, err := zip.OpenReader(zipPath)
+	spec, err := ResolveModel(hfModelName)
 	if err != nil {
 		return err
 	}
-	defer archive.Close()
-
-	for _, f := range archive.File {
-		fPath := path.Join(dirPath, f.Name)
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fPath, os.ModePerm)
-			continue
-		}
-
-		err := os.MkdirAll(filepath.Dir(fPath), os.ModePerm)
-		if err != nil {
-			return err
-		}
-
-		dstFile, err := os.OpenFile(fPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return err
-		}
-		defer dstFile.Close()
-
-		fileInArchive, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer fileInArchive.Close()
-
-		_, err = io.Copy(dstFile, fileInArchive)
-		if err != nil {
-			return err
-		}
+	if spec.IsLocal() {
+		return nil
 	}
 
-	return nil
-}
-
-func PreFetchModel(hfModelName string) error {
-	if hfModelName == "" {
-		hfModelName = DEFAULT_HF_MODEL
+	err = checkModelPolicy(DefaultConfig().ModelPolicy, spec)
+	if err != nil {
+		return err
 	}
 
-	cwd, err := os.Getwd()
-	if err != nil {
+	if spec.Revision != "" {
+		_, err = fetchModel(ctx, spec, func(int64, int64) {})
 		return err
 	}
 
-	cliPath := path.Join(cwd, "llamacpp", "llama-cli.exe")
-	exists, _ := isPathExist(cliPath)
-	if !exists {
-		return errors.New("could not find llama.cpp binaries, run DownloadRequirements to fix")
+	cliPath, err := flavorBinary(pickFlavor(nil), "llama-cli.exe")
+	if err != nil {
+		return err
 	}
 
-	proc := exec.Command(
+	proc := exec.CommandContext(ctx,
 		cliPath,
-		"-hf", hfModelName,
+		"-hf", spec.HFRef(),
 		"-n", "1",
 		"-no-cnv",
 	)
@@ -496,7 +1101,7 @@ func PreFetchModel(hfModelName string) error {
 	out, err := proc.CombinedOutput()
 	os.Stdout.Write(out)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrModelFetch, err)
 	}
 	return nil
 }
@@ -0,0 +1,126 @@
+package xplatai
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+// Fails NewWithConfig at each stage and checks no server is left running.
+func TestNewWithConfigCleansUpFailedStages(t *testing.T) {
+	tests := []struct {
+		name    string
+		install bool
+		setup   func(cfg *Config, procs *fakeProcs)
+		stage   string
+		err     error
+	}{
+		{
+			name:    "invalid config",
+			install: true,
+			setup:   func(cfg *Config, procs *fakeProcs) { cfg.Numa = "everywhere" },
+			stage:   STAGE_RESOLVE,
+		},
+		{
+			name:  "missing binaries",
+			setup: func(cfg *Config, procs *fakeProcs) {},
+			stage: STAGE_LOCATE,
+			err:   ErrNotInstalled,
+		},
+		{
+			name:    "start failure",
+			install: true,
+			setup:   func(cfg *Config, procs *fakeProcs) { procs.startErr = os.ErrPermission },
+			stage:   STAGE_SPAWN,
+			err:     os.ErrPermission,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.install {
+				fakeInstall(t)
+			} else {
+				t.Chdir(t.TempDir())
+			}
+			procs := &fakeProcs{}
+			cfg := fakeConfig(newFakeClock(), procs)
+			tt.setup(&cfg, procs)
+
+			x, err := NewWithConfig(cfg)
+			if x != nil {
+				t.Error("got a handle along with the error")
+			}
+			stageErr := &StageError{}
+			if !errors.As(err, &stageErr) || stageErr.Stage != tt.stage {
+				t.Fatalf("got %v, want a failure at stage %q", err, tt.stage)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want it to wrap %v", err, tt.err)
+			}
+			if last := stageErr.Timings[len(stageErr.Timings)-1]; last.Phase != tt.stage || last.Err == nil {
+				t.Errorf("last timing is %+v, want the failed stage", last)
+			}
+			if procs.running() != 0 {
+				t.Errorf("%d servers left running", procs.running())
+			}
+		})
+	}
+}
+
+func TestNewWithConfigStartsServerWithLimits(t *testing.T) {
+	fakeInstall(t)
+	procs := &fakeProcs{}
+	cfg := fakeConfig(newFakeClock(), procs)
+	cfg.Nice = 5
+	cfg.CPUAffinity = []int{0, 1}
+	cfg.MemoryLimitBytes = 1 << 30
+
+	x, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	limits := procs.last().limits
+	if limits.nice != 5 || !slices.Equal(limits.cpuAffinity, []int{0, 1}) || limits.memoryLimitBytes != 1<<30 {
+		t.Errorf("server started with limits %+v", limits)
+	}
+}
+
+func TestNewWithConfigReturnsLimitErrors(t *testing.T) {
+	fakeInstall(t)
+	procs := &fakeProcs{startErr: fmt.Errorf("%w: %w", errLimits, os.ErrPermission)}
+	cfg := fakeConfig(newFakeClock(), procs)
+	cfg.Nice = -5
+
+	x, err := NewWithConfig(cfg)
+	if x != nil || !errors.Is(err, errLimits) || !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("got %v, want the limits error", err)
+	}
+	if errors.Is(err, ErrBinaryQuarantined) {
+		t.Error("limits error reported as a quarantined binary")
+	}
+	if len(procs.procs) != 1 || procs.running() != 0 {
+		t.Errorf("%d servers created, %d left running", len(procs.procs), procs.running())
+	}
+}
+
+func TestNewWithConfigSpawnsServer(t *testing.T) {
+	fakeInstall(t)
+	procs := &fakeProcs{}
+	x, err := NewWithConfig(fakeConfig(newFakeClock(), procs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if procs.running() != 1 {
+		t.Fatalf("%d servers running, want 1", procs.running())
+	}
+
+	x.Close()
+	if procs.running() != 0 {
+		t.Error("server still running after Close")
+	}
+}